@@ -12,24 +12,26 @@ import (
 )
 
 func createVoting(keys *evote.CryptoKeysData, n *evote.Network) {
-	var typeVote, amountPerParticipant, duration uint32
-	prompt := promptui.Select{
-		Label: "Select vote type",
-		Items: []string{"Majority", "Percentage"},
+	var amountPerParticipant uint64
+	var duration uint32
+	typeVote, err := selectVoteType("Select vote type", false)
+	if err != nil {
+		fmt.Printf("Fail %v\n", err)
+		return
 	}
 
-	_, result, err := prompt.Run()
+	promptAnonymous := promptui.Select{
+		Label: "Select voting privacy",
+		Items: []string{"Open", "Anonymous"},
+	}
+
+	_, privacy, err := promptAnonymous.Run()
 
 	if err != nil {
 		fmt.Printf("Fail %v\n", err)
 		return
 	}
-
-	if result == "Majority" {
-		typeVote = evote.OneVoteType
-	} else if result == "Percentage" {
-		typeVote = evote.PercentVoteType
-	}
+	anonymous := privacy == "Anonymous"
 
 	validateAmount := func(input string) error {
 		_, err := strconv.ParseInt(input, 10, 64)
@@ -52,7 +54,7 @@ func createVoting(keys *evote.CryptoKeysData, n *evote.Network) {
 	}
 
 	amount64, _ := strconv.ParseInt(amountStr, 10, 64)
-	amountPerParticipant = uint32(amount64)
+	amountPerParticipant = uint64(amount64)
 
 	promptDuration := promptui.Prompt{
 		Label:    "Voting duration (seconds)",
@@ -68,15 +70,90 @@ func createVoting(keys *evote.CryptoKeysData, n *evote.Network) {
 	duration64, _ := strconv.ParseInt(durationStr, 10, 32)
 	duration = uint32(duration64)
 
+	var params *golosovaniepb.VotingParams
+	if typeVote == evote.StvVoteType {
+		seats, err := promptNumber("Number of seats")
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+		params = &golosovaniepb.VotingParams{Seats: seats}
+	} else if typeVote == evote.MultiQuestionVoteType {
+		questions, err := promptQuestions()
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+		params = &golosovaniepb.VotingParams{Questions: questions}
+	} else if typeVote == evote.EncryptedVoteType {
+		options, err := promptPkeys("List options")
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+		params = &golosovaniepb.VotingParams{Options: options}
+	}
+	params, err = promptOutcomeRules(typeVote, params)
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+
+	promptRevotable := promptui.Select{
+		Label: "Can participants change their votes before the end",
+		Items: []string{"No", "Yes"},
+	}
+	revotableItem, _, err := promptRevotable.Run()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+	if revotableItem == 1 {
+		if params == nil {
+			params = &golosovaniepb.VotingParams{}
+		}
+		params.Revotable = true
+	}
+
+	// в голосовании commit-reveal голос тоже можно изменить, поэтому флаг revotable не нужен.
+	// Зашифрованные бюллетени и так скрыты, раскрывать их не нужно
+	var revealDuration uint32
+	if typeVote != evote.EncryptedVoteType {
+		revealDuration, err = promptNumber("Reveal phase duration in seconds, ballots are hidden until the end (0 for open tally)")
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+	}
+	if revealDuration != 0 {
+		if params == nil {
+			params = &golosovaniepb.VotingParams{}
+		}
+		params.RevealDuration = revealDuration
+	}
+
+	metadata, err := promptMetadata()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+
+	// участник анонимного голосования задается парой ключей spend:scan (см. команду keys)
 	validateParticipants := func(input string) error {
 		pkeyStrings := strings.Split(input, " ")
-		for _, pkeyStr := range pkeyStrings {
-			pkey, err := hex.DecodeString(pkeyStr)
-			if err != nil {
-				return errors.New("invalid hex")
+		for _, participantStr := range pkeyStrings {
+			pkeys := strings.Split(participantStr, ":")
+			if anonymous != (len(pkeys) == 2) || len(pkeys) > 2 {
+				return errors.New("invalid participant format")
 			}
-			if len(pkey) != evote.PkeySize {
-				return errors.New("invalid pkey size")
+			for _, pkeyStr := range pkeys {
+				pkey, err := hex.DecodeString(pkeyStr)
+				if err != nil {
+					return errors.New("invalid hex")
+				}
+				if len(pkey) != evote.PkeySize {
+					return errors.New("invalid pkey size")
+				}
 			}
 		}
 		if len(pkeyStrings) == 0 {
@@ -85,8 +162,12 @@ func createVoting(keys *evote.CryptoKeysData, n *evote.Network) {
 		return nil
 	}
 
+	participantsLabel := "List participants"
+	if anonymous {
+		participantsLabel = "List participants as spend:scan"
+	}
 	promptParticipants := promptui.Prompt{
-		Label: "List participants",
+		Label: participantsLabel,
 		//Validate: validateParticipants,
 	}
 
@@ -107,13 +188,27 @@ func createVoting(keys *evote.CryptoKeysData, n *evote.Network) {
 	}
 
 	pkeyStrings := strings.Split(partisipantsStr, " ")
-	outputs := make(map[[evote.PkeySize]byte]uint32)
+	outputs := make(map[[evote.PkeySize]byte]uint64)
+	var scanPkeys map[[evote.PkeySize]byte][]byte
+	if anonymous {
+		scanPkeys = make(map[[evote.PkeySize]byte][]byte)
+	}
 
-	for _, pkeyStr := range pkeyStrings {
+	for _, participantStr := range pkeyStrings {
+		pkeys := strings.Split(participantStr, ":")
 		var pkey [evote.PkeySize]byte
-		pkeySlice, _ := hex.DecodeString(pkeyStr)
+		pkeySlice, _ := hex.DecodeString(pkeys[0])
 		copy(pkey[:], pkeySlice)
 		outputs[pkey] = amountPerParticipant
+		if anonymous {
+			scanPkeys[pkey], _ = hex.DecodeString(pkeys[1])
+		}
+	}
+	// комиссию платит каждая транзакция создания и дополнения голосования
+	fee, err := promptFee()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
 	}
 	var utxos []*golosovaniepb.Utxo
 	for {
@@ -122,10 +217,14 @@ func createVoting(keys *evote.CryptoKeysData, n *evote.Network) {
 			break
 		}
 	}
-	tx, err := evote.CreateTx(utxos, outputs, nil, keys, typeVote, duration, false)
+	txs, err := evote.CreateVoteTxs(utxos, outputs, scanPkeys, keys, typeVote, duration, params, metadata, fee)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	sendTx(tx, n)
+	// транзакции дополнения ссылаются на предыдущие, поэтому отправляются по порядку
+	for _, tx := range txs {
+		sendTx(tx, n)
+	}
+	fmt.Printf("voting %v created\n", hex.EncodeToString(txs[0].Hash))
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/manifoldco/promptui"
+)
+
+// delegate delegates votes of the user in the voting votingHash or, if votingHash is nil, in all votings.
+// Votes are counted for the delegate only if the user doesn't vote. The user pays the fee in coins
+func delegate(keys *evote.CryptoKeysData, n *evote.Network, votingHash []byte) {
+	validateDelegate := func(input string) error {
+		if input == "" {
+			return nil
+		}
+		pkey, err := hex.DecodeString(input)
+		if err != nil {
+			return errors.New("invalid hex")
+		}
+		if len(pkey) != evote.PkeySize {
+			return errors.New("invalid pkey size")
+		}
+		return nil
+	}
+	prompt := promptui.Prompt{
+		Label:    "Delegate pkey (empty to revoke delegation)",
+		Validate: validateDelegate,
+	}
+	delegateStr, err := prompt.Run()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+	delegatePkey, _ := hex.DecodeString(delegateStr)
+	// комиссия делегирования платится монетами делегирующего
+	fee, err := promptFee()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+	utxos, err := n.GetUtxosByPkey(keys.PkeyByte[:])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tx, err := evote.CreateDelegationTx(delegatePkey, votingHash, utxos, fee, keys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sendTx(tx, n)
+}
@@ -30,11 +30,11 @@ func faucet(keys *evote.CryptoKeysData, n *evote.Network) {
 	}
 
 	amount64, _ := strconv.ParseInt(amountStr, 10, 64)
-	amount := uint32(amount64)
+	amount := uint64(amount64)
 	sendFaucet(amount, keys.PkeyByte, n)
 }
 
-func sendFaucet(amount uint32, pkey [evote.PkeySize]byte, n *evote.Network) {
+func sendFaucet(amount uint64, pkey [evote.PkeySize]byte, n *evote.Network) {
 	err := n.Faucet(amount, pkey[:])
 	if retryQuestion(err, n) {
 		sendFaucet(amount, pkey, n)
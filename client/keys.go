@@ -0,0 +1,18 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"fmt"
+)
+
+// showKeys prints keys, which voter gives to the creator of anonymous voting
+func showKeys(keys *evote.CryptoKeysData) {
+	scanKeys := keys.ScanKeys()
+	fmt.Printf(
+		"spend pkey: %s\nscan pkey:  %s\nparticipant of anonymous voting: %s:%s\n",
+		pkeyHex(keys.PkeyByte),
+		pkeyHex(scanKeys.PkeyByte),
+		pkeyHex(keys.PkeyByte),
+		pkeyHex(scanKeys.PkeyByte),
+	)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"GO_LOSOVANIE/evote"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 
 var pathToValidatorsConfig = flag.String("v", "", "path to validators config")
 var pathToKeyPair = flag.String("k", "", "path to key pair")
+var trustHeight = flag.Int64("trust-height", 0, "height of trusted block, enables verification of validator responses")
+var trustHash = flag.String("trust-hash", "", "hex hash of trusted block, required with -trust-height")
 
 const (
 	BALANCE      = "balance"
@@ -17,6 +20,9 @@ const (
 	SEND         = "send"
 	FAUCET       = "faucet"
 	VOTE         = "vote"
+	KEYS         = "keys"
+	DELEGATE     = "delegate"
+	SUPPLY       = "supply"
 )
 
 func main() {
@@ -42,12 +48,24 @@ func main() {
 	keys.SetupKeys(prv)
 	n.Init(hosts)
 	n.PingAll()
+	if *trustHeight != 0 {
+		hash, err := hex.DecodeString(*trustHash)
+		if err != nil {
+			panic(err)
+		}
+		err = n.EnableVerification(*trustHeight, hash)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("verification of validator responses enabled")
+	}
 	fmt.Println("available commands: " +
-		BALANCE + ", " + TRANSACTIONS + ", " + SEND + ", " + FAUCET + ", " + VOTE)
+		BALANCE + ", " + TRANSACTIONS + ", " + SEND + ", " + FAUCET + ", " + VOTE + ", " + KEYS + ", " + DELEGATE + ", " + SUPPLY)
 
 	validate := func(input string) error {
 		if input == BALANCE || input == TRANSACTIONS ||
-			input == SEND || input == FAUCET || input == VOTE {
+			input == SEND || input == FAUCET || input == VOTE || input == KEYS || input == DELEGATE ||
+			input == SUPPLY {
 			return nil
 		} else {
 			return errors.New("invalid command")
@@ -78,6 +96,13 @@ func main() {
 			faucet(&keys, &n)
 		case VOTE:
 			vote(&keys, &n)
+		case KEYS:
+			showKeys(&keys)
+		case DELEGATE:
+			// постоянное делегирование во всех голосованиях
+			delegate(&keys, &n, nil)
+		case SUPPLY:
+			supply(&n)
 		}
 	}
 
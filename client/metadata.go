@@ -0,0 +1,93 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/manifoldco/promptui"
+	"strings"
+)
+
+// promptMetadata asks description of the voting, nil if the title is empty
+func promptMetadata() (*golosovaniepb.VotingMetadata, error) {
+	promptTitle := promptui.Prompt{
+		Label: "Voting title (empty for no description)",
+	}
+	title, err := promptTitle.Run()
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		return nil, nil
+	}
+	metadata := golosovaniepb.VotingMetadata{Title: title}
+
+	promptDescription := promptui.Prompt{
+		Label: "Description",
+	}
+	metadata.Description, err = promptDescription.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	promptDocument := promptui.Prompt{
+		Label: "Hash of the document (optional)",
+	}
+	documentStr, err := promptDocument.Run()
+	if err != nil {
+		return nil, err
+	}
+	if documentStr != "" {
+		metadata.DocumentHash, err = hex.DecodeString(documentStr)
+		if err != nil || len(metadata.DocumentHash) != evote.HashSize {
+			return nil, errors.New("invalid document hash")
+		}
+	}
+
+	// имя кандидата может содержать пробелы, поэтому кандидаты разделяются ';'
+	promptLabels := promptui.Prompt{
+		Label: "Candidate names as pkey:name separated by ; (optional)",
+	}
+	labelsStr, err := promptLabels.Run()
+	if err != nil {
+		return nil, err
+	}
+	for _, labelStr := range strings.Split(labelsStr, ";") {
+		if strings.TrimSpace(labelStr) == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(labelStr), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid candidate name %v", labelStr)
+		}
+		pkey, err := hex.DecodeString(parts[0])
+		if err != nil || len(pkey) != evote.PkeySize {
+			return nil, fmt.Errorf("invalid candidate pkey %v", parts[0])
+		}
+		metadata.Candidates = append(metadata.Candidates, &golosovaniepb.CandidateLabel{
+			Pkey:  pkey,
+			Label: strings.TrimSpace(parts[1]),
+		})
+	}
+	return &metadata, nil
+}
+
+// candidateLabels returns names of candidates by hex of their pkeys
+func candidateLabels(metadata *golosovaniepb.VotingMetadata) map[string]string {
+	labels := make(map[string]string)
+	for _, candidate := range metadata.GetCandidates() {
+		labels[bToHex(candidate.Pkey)] = candidate.Label
+	}
+	return labels
+}
+
+// candidateName returns hex of the pkey with the name of the candidate, if it is known
+func candidateName(pkey []byte, labels map[string]string) string {
+	pkeyStr := bToHex(pkey)
+	if label, ok := labels[pkeyStr]; ok {
+		return fmt.Sprintf("%v (%v)", pkeyStr, label)
+	}
+	return pkeyStr
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/manifoldco/promptui"
+	"strconv"
+	"strings"
+)
+
+// voteTypes - типы голосования в порядке пунктов меню
+var voteTypes = []struct {
+	voteType uint32
+	name     string
+}{
+	{evote.OneVoteType, "Majority"},
+	{evote.PercentVoteType, "Percentage"},
+	{evote.RankedVoteType, "Ranked choice"},
+	{evote.ApprovalVoteType, "Approval"},
+	{evote.StvVoteType, "Single transferable vote"},
+	{evote.MultiQuestionVoteType, "Multiple questions"},
+	{evote.EncryptedVoteType, "Encrypted tally"},
+}
+
+// voteTypeName returns name of the vote type, empty string for unknown type
+func voteTypeName(voteType uint32) string {
+	for _, t := range voteTypes {
+		if t.voteType == voteType {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// selectVoteType asks vote type, question of multi question voting can't have multiple questions itself
+// and can't be encrypted
+func selectVoteType(label string, question bool) (uint32, error) {
+	var items []string
+	var itemTypes []uint32
+	for _, t := range voteTypes {
+		if !question || t.voteType != evote.MultiQuestionVoteType && t.voteType != evote.EncryptedVoteType {
+			items = append(items, t.name)
+			itemTypes = append(itemTypes, t.voteType)
+		}
+	}
+	prompt := promptui.Select{
+		Label: label,
+		Items: items,
+	}
+	i, _, err := prompt.Run()
+	if err != nil {
+		return 0, err
+	}
+	return itemTypes[i], nil
+}
+
+func promptNumber(label string) (uint32, error) {
+	validate := func(input string) error {
+		_, err := strconv.ParseUint(input, 10, 32)
+		if err != nil {
+			return errors.New("invalid number")
+		}
+		return nil
+	}
+	prompt := promptui.Prompt{
+		Label:    label,
+		Validate: validate,
+	}
+	str, err := prompt.Run()
+	if err != nil {
+		return 0, err
+	}
+	number, _ := strconv.ParseUint(str, 10, 32)
+	return uint32(number), nil
+}
+
+// promptFraction asks fraction as num/den, nil if the input is empty
+func promptFraction(label string) (*golosovaniepb.Fraction, error) {
+	prompt := promptui.Prompt{
+		Label: label,
+	}
+	str, err := prompt.Run()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(str) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimSpace(str), "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid fraction %v", str)
+	}
+	numerator, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fraction %v", str)
+	}
+	denominator, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || denominator == 0 || numerator > denominator {
+		return nil, fmt.Errorf("invalid fraction %v", str)
+	}
+	return &golosovaniepb.Fraction{Numerator: uint32(numerator), Denominator: uint32(denominator)}, nil
+}
+
+// promptOutcomeRules asks quorum and pass threshold of the voting and sets them in params
+func promptOutcomeRules(voteType uint32, params *golosovaniepb.VotingParams) (*golosovaniepb.VotingParams, error) {
+	quorum, err := promptFraction("Quorum as part of all votes, e.g. 1/2 (empty for no quorum)")
+	if err != nil {
+		return nil, err
+	}
+	var threshold *golosovaniepb.Fraction
+	var strict bool
+	// в STV избираются кандидаты, набравшие квоту, доля голосов не задается
+	if voteType != evote.StvVoteType {
+		threshold, err = promptFraction("Pass threshold as part of votes, e.g. 2/3 (empty for plurality)")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if threshold != nil {
+		prompt := promptui.Select{
+			Label: "Winner must get",
+			Items: []string{"At least threshold", "More than threshold"},
+		}
+		i, _, err := prompt.Run()
+		if err != nil {
+			return nil, err
+		}
+		strict = i == 1
+	}
+	if quorum == nil && threshold == nil {
+		return params, nil
+	}
+	if params == nil {
+		params = &golosovaniepb.VotingParams{}
+	}
+	params.Quorum, params.Threshold, params.StrictThreshold = quorum, threshold, strict
+	return params, nil
+}
+
+// promptPkeys asks space separated list of pkeys
+func promptPkeys(label string) ([][]byte, error) {
+	prompt := promptui.Prompt{
+		Label: label,
+	}
+	str, err := prompt.Run()
+	if err != nil {
+		return nil, err
+	}
+	var pkeys [][]byte
+	for _, pkeyStr := range strings.Fields(str) {
+		pkey, err := hex.DecodeString(pkeyStr)
+		if err != nil || len(pkey) != evote.PkeySize {
+			return nil, fmt.Errorf("invalid pkey %v", pkeyStr)
+		}
+		pkeys = append(pkeys, pkey)
+	}
+	if len(pkeys) == 0 || len(pkeys) > evote.MaxBallotCandidates {
+		return nil, errors.New("invalid number of pkeys")
+	}
+	return pkeys, nil
+}
+
+// promptQuestions asks questions of multi question voting
+func promptQuestions() ([]*golosovaniepb.Question, error) {
+	number, err := promptNumber("Number of questions")
+	if err != nil {
+		return nil, err
+	}
+	if number == 0 || number > evote.MaxQuestions {
+		return nil, fmt.Errorf("number of questions must be from 1 to %v", evote.MaxQuestions)
+	}
+	questions := make([]*golosovaniepb.Question, number)
+	for i := range questions {
+		var q golosovaniepb.Question
+		q.VoteType, err = selectVoteType(fmt.Sprintf("Select type of question %v", i+1), true)
+		if err != nil {
+			return nil, err
+		}
+		q.Options, err = promptPkeys(fmt.Sprintf("List options of question %v", i+1))
+		if err != nil {
+			return nil, err
+		}
+		if q.VoteType == evote.StvVoteType {
+			q.Seats, err = promptNumber("Number of seats")
+			if err != nil {
+				return nil, err
+			}
+		}
+		questions[i] = &q
+	}
+	return questions, nil
+}
+
+// promptAnswer asks ballot of the voting or answer to the question with voteType
+func promptAnswer(voteType uint32, label string) (*golosovaniepb.Ballot, error) {
+	var ballot golosovaniepb.Ballot
+	var err error
+	switch voteType {
+	case evote.ApprovalVoteType:
+		ballot.Approved, err = promptPkeys(label + "list approved candidates")
+	case evote.RankedVoteType, evote.StvVoteType:
+		ballot.Ranking, err = promptPkeys(label + "list candidates from the most preferred")
+	default:
+		ballot.Ranking, err = promptPkeys(label + "option")
+		if err == nil && len(ballot.Ranking) != 1 {
+			err = errors.New("exactly one option required")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ballot, nil
+}
+
+// promptBallot asks ballot of the voting with votingBody, for multi question voting asks answers to all questions
+func promptBallot(votingBody *golosovaniepb.TxBody) (*golosovaniepb.Ballot, error) {
+	if votingBody.VoteType != evote.MultiQuestionVoteType {
+		return promptAnswer(votingBody.VoteType, "")
+	}
+	var ballot golosovaniepb.Ballot
+	for i, q := range votingBody.VotingParams.GetQuestions() {
+		answer, err := promptAnswer(q.VoteType, fmt.Sprintf("Question %v: ", i+1))
+		if err != nil {
+			return nil, err
+		}
+		ballot.Answers = append(ballot.Answers, answer)
+	}
+	return &ballot, nil
+}
+
+// promptEncryptedBallot asks option of encrypted voting and encrypts the choice with the election key,
+// which is generated by validators after creation of the voting. voter is the pkey, which sends the vote
+func promptEncryptedBallot(
+	n *evote.Network,
+	typeValue [evote.HashSize]byte,
+	votingBody *golosovaniepb.TxBody,
+	voter []byte,
+) (*golosovaniepb.Ballot, error) {
+	electionKey, err := n.GetElectionKey(typeValue[:])
+	if err != nil {
+		return nil, err
+	}
+	labels := candidateLabels(votingBody.VotingMetadata)
+	options := votingBody.VotingParams.GetOptions()
+	items := make([]string, len(options))
+	for i, option := range options {
+		items[i] = candidateName(option, labels)
+	}
+	prompt := promptui.Select{
+		Label: "Select option, the choice is encrypted and only the total is decrypted",
+		Items: items,
+	}
+	i, _, err := prompt.Run()
+	if err != nil {
+		return nil, err
+	}
+	return evote.EncryptBallot(electionKey, len(options), i, voter)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/manifoldco/promptui"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// revealFile returns path of the file with the ballot and the salt of commit-reveal voting,
+// the file is stored next to the key pair of the voter
+func revealFile(typeValue [evote.HashSize]byte) string {
+	return fmt.Sprintf("%v.%v.reveal", *pathToKeyPair, bToHex(typeValue[:]))
+}
+
+// saveReveal saves hex of the commit tx hash, the salt and the ballot, one per line
+func saveReveal(typeValue [evote.HashSize]byte, commitTxHash []byte, salt []byte, ballot *golosovaniepb.Ballot) error {
+	ballotBytes, err := proto.Marshal(ballot)
+	if err != nil {
+		return err
+	}
+	data := strings.Join([]string{bToHex(commitTxHash), bToHex(salt), bToHex(ballotBytes)}, "\n")
+	return ioutil.WriteFile(revealFile(typeValue), []byte(data), 0600)
+}
+
+func loadReveal(typeValue [evote.HashSize]byte) (commitTxHash []byte, salt []byte, ballot *golosovaniepb.Ballot, err error) {
+	data, err := ioutil.ReadFile(revealFile(typeValue))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		return nil, nil, nil, errors.New("invalid reveal file")
+	}
+	commitTxHash, err = hex.DecodeString(lines[0])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	salt, err = hex.DecodeString(lines[1])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ballotBytes, err := hex.DecodeString(lines[2])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ballot = new(golosovaniepb.Ballot)
+	err = proto.Unmarshal(ballotBytes, ballot)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return commitTxHash, salt, ballot, nil
+}
+
+// sendCommitReveal sends commitment to the ballot before the end of commit-reveal voting
+// and reveals the saved ballot in the reveal phase
+func sendCommitReveal(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte, votingBody *golosovaniepb.TxBody) {
+	info, err := n.GetVoteInfo(typeValue[:])
+	if retryQuestion(err, n) {
+		sendCommitReveal(keys, n, typeValue, votingBody)
+		return
+	}
+	if err != nil {
+		return
+	}
+	voteKeys, err := findVoteKeys(keys, n, typeValue)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if time.Now().UnixNano() >= int64(info.EndTime) {
+		sendReveal(voteKeys, n, typeValue)
+		return
+	}
+
+	prompt := promptui.Select{
+		Label: "Ballot is hidden until the end of the voting, your previous vote will be replaced",
+		Items: []string{"Vote", "Revoke vote"},
+	}
+	i, _, err := prompt.Run()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+	// транзакция без обязательства отзывает голос
+	var ballot *golosovaniepb.Ballot
+	if i == 0 {
+		ballot, err = promptBallot(votingBody)
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+	}
+	pkey := voteKeys.PkeyByte
+	utxos, err := n.GetUtxosByPkey(pkey[:])
+	if retryQuestion(err, n) {
+		sendCommitReveal(keys, n, typeValue, votingBody)
+		return
+	}
+	tx, salt, err := evote.CreateCommitTx(utxos, ballot, typeValue[:], voteKeys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if ballot != nil {
+		// без соли бюллетень нельзя будет раскрыть, поэтому она сохраняется до отправки
+		err = saveReveal(typeValue, tx.Hash, salt, ballot)
+		if err != nil {
+			fmt.Println("cannot save the ballot for the reveal:", err)
+			return
+		}
+		fmt.Println("ballot is saved to", revealFile(typeValue), "send vote again after the end of the voting to reveal it")
+	} else {
+		_ = os.Remove(revealFile(typeValue))
+	}
+	sendTx(tx, n)
+}
+
+func sendReveal(voteKeys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) {
+	commitTxHash, salt, ballot, err := loadReveal(typeValue)
+	if err != nil {
+		fmt.Println("no saved ballot to reveal:", err)
+		return
+	}
+	txs, err := n.GetTxsByHashes([][]byte{commitTxHash})
+	if retryQuestion(err, n) {
+		sendReveal(voteKeys, n, typeValue)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if len(txs) != 1 {
+		fmt.Println("commit tx", bToHex(commitTxHash), "is not found")
+		return
+	}
+	tx, err := evote.CreateRevealTx(txs[0], ballot, salt, typeValue[:], voteKeys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sendTx(tx, n)
+	fmt.Println("ballot revealed")
+}
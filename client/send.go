@@ -33,7 +33,7 @@ func send(keys *evote.CryptoKeysData, n *evote.Network) {
 	}
 
 	amount64, _ := strconv.ParseInt(amountStr, 10, 64)
-	amount := uint32(amount64)
+	amount := uint64(amount64)
 
 	validateReceiver := func(input string) error {
 		pkey, err := hex.DecodeString(input)
@@ -62,15 +62,21 @@ func send(keys *evote.CryptoKeysData, n *evote.Network) {
 	var receiver [evote.PkeySize]byte
 	copy(receiver[:], receiverSlice)
 
-	outputs := make(map[[evote.PkeySize]byte]uint32)
+	outputs := make(map[[evote.PkeySize]byte]uint64)
 	outputs[receiver] = amount
 
+	fee, err := promptFee()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+
 	pkey := keys.PkeyByte
 	utxos, err := n.GetUtxosByPkey(pkey[:])
 	if retryQuestion(err, n) {
 		send(keys, n)
 	}
-	tx, err := evote.CreateTx(utxos, outputs, nil, keys, 0, 0, false)
+	tx, err := evote.CreateTx(utxos, outputs, nil, keys, 0, 0, false, fee)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -78,6 +84,30 @@ func send(keys *evote.CryptoKeysData, n *evote.Network) {
 	sendTx(tx, n)
 }
 
+// promptFee asks for the fee, which the block proposer receives for the transaction
+func promptFee() (uint64, error) {
+	validateFee := func(input string) error {
+		fee, err := strconv.ParseUint(input, 10, 64)
+		if err != nil {
+			return errors.New("invalid number")
+		}
+		if fee < evote.MinTxFee || fee > evote.MaxAmount {
+			return fmt.Errorf("fee must be from %v to %v", evote.MinTxFee, evote.MaxAmount)
+		}
+		return nil
+	}
+	prompt := promptui.Prompt{
+		Label:    "Fee",
+		Validate: validateFee,
+		Default:  strconv.Itoa(evote.MinTxFee),
+	}
+	feeStr, err := prompt.Run()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(feeStr, 10, 64)
+}
+
 func sendTx(tx *golosovaniepb.Transaction, n *evote.Network) {
 	txBytes, err := proto.Marshal(tx)
 	if err != nil {
@@ -2,14 +2,30 @@ package main
 
 import (
 	"GO_LOSOVANIE/evote"
+	"GO_LOSOVANIE/evote/golosovaniepb"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/golang/protobuf/proto"
 	"github.com/manifoldco/promptui"
 	"strconv"
 )
 
 func sendVote(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) {
+	votingBody, err := getVotingBody(n, typeValue)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if votingBody.VotingParams.GetRevealDuration() != 0 {
+		sendCommitReveal(keys, n, typeValue, votingBody)
+		return
+	}
+	if evote.IsBallotVoting(votingBody) {
+		sendBallot(keys, n, typeValue, votingBody)
+		return
+	}
+
 	validateAmount := func(input string) error {
 		_, err := strconv.ParseInt(input, 10, 64)
 		if err != nil {
@@ -31,7 +47,7 @@ func sendVote(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.Has
 	}
 
 	amount64, _ := strconv.ParseInt(amountStr, 10, 64)
-	amount := uint32(amount64)
+	amount := uint64(amount64)
 
 	validateReceiver := func(input string) error {
 		pkey, err := hex.DecodeString(input)
@@ -60,18 +76,117 @@ func sendVote(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.Has
 	var receiver [evote.PkeySize]byte
 	copy(receiver[:], receiverSlice)
 
-	outputs := make(map[[evote.PkeySize]byte]uint32)
+	outputs := make(map[[evote.PkeySize]byte]uint64)
 	outputs[receiver] = amount
 
-	pkey := keys.PkeyByte
+	voteKeys, err := findVoteKeys(keys, n, typeValue)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	pkey := voteKeys.PkeyByte
 	utxos, err := n.GetUtxosByPkey(pkey[:])
 	if retryQuestion(err, n) {
 		send(keys, n)
 	}
-	tx, err := evote.CreateTx(utxos, outputs, typeValue[:], keys, 0, 0, false)
+	tx, err := evote.CreateTx(utxos, outputs, typeValue[:], voteKeys, 0, 0, false, 0)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	sendTx(tx, n)
 }
+
+// sendBallot sends all votes of the voter with the ballot, which is filled according to the vote type.
+// In revotable voting the voter can also revoke the vote
+func sendBallot(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte, votingBody *golosovaniepb.TxBody) {
+	revotable := votingBody.VotingParams.GetRevotable()
+	revoke := false
+	if revotable {
+		prompt := promptui.Select{
+			Label: "Your previous vote will be replaced",
+			Items: []string{"Vote", "Revoke vote"},
+		}
+		i, _, err := prompt.Run()
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+		revoke = i == 1
+	}
+	voteKeys, err := findVoteKeys(keys, n, typeValue)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// транзакция без бюллетеня отзывает голос
+	var ballot *golosovaniepb.Ballot
+	if !revoke {
+		if votingBody.VoteType == evote.EncryptedVoteType {
+			// доказательства зашифрованного бюллетеня привязаны к ключу, с которого отправляется голос
+			ballot, err = promptEncryptedBallot(n, typeValue, votingBody, voteKeys.PkeyByte[:])
+		} else {
+			ballot, err = promptBallot(votingBody)
+		}
+		if err != nil {
+			fmt.Printf("Fail: %v\n", err)
+			return
+		}
+	}
+
+	pkey := voteKeys.PkeyByte
+	utxos, err := n.GetUtxosByPkey(pkey[:])
+	if retryQuestion(err, n) {
+		sendBallot(keys, n, typeValue, votingBody)
+		return
+	}
+	tx, err := evote.CreateBallotTx(utxos, ballot, typeValue[:], revotable, voteKeys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sendTx(tx, n)
+}
+
+func getVotingBody(n *evote.Network, typeValue [evote.HashSize]byte) (*golosovaniepb.TxBody, error) {
+	txs, err := n.GetTxsByHashes([][]byte{typeValue[:]})
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) != 1 {
+		return nil, fmt.Errorf("no voting with id %v", bToHex(typeValue[:]))
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(txs[0].TxBody, &body)
+	if err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// findVoteKeys returns keys, which own votes of the voter. In anonymous voting these are keys
+// of the one-time address from the init vote tx, otherwise the keys of the voter
+func findVoteKeys(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) (*evote.CryptoKeysData, error) {
+	body, err := getVotingBody(n, typeValue)
+	if err != nil {
+		return nil, err
+	}
+	if !evote.IsAnonymousVoting(body) {
+		return keys, nil
+	}
+	initTx, err := n.GetInitVoteTx(typeValue[:])
+	if err != nil {
+		return nil, err
+	}
+	if initTx == nil {
+		return nil, fmt.Errorf("anonymous voting is not initialized by validator yet, try later")
+	}
+	voteKeys, err := evote.FindStealthKeys(keys, initTx)
+	if err != nil {
+		return nil, err
+	}
+	if voteKeys == nil {
+		return nil, fmt.Errorf("you are not a participant of the voting")
+	}
+	return voteKeys, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"fmt"
+)
+
+func supply(n *evote.Network) {
+	res, err := n.GetSupply()
+	if retryQuestion(err, n) {
+		supply(n)
+		return
+	}
+	if err != nil {
+		return
+	}
+	maxSupply := "unlimited"
+	if res.MaxSupply != 0 {
+		maxSupply = fmt.Sprint(res.MaxSupply)
+	}
+	fmt.Printf("issued:      %v\ngenesis:     %v\nmax supply:  %v\nnext reward: %v\n",
+		res.Issued, res.Genesis, maxSupply, res.NextReward)
+}
@@ -34,7 +34,7 @@ func retryQuestion(err error, n *evote.Network) bool {
 	}
 }
 
-func calcBalance(utxos []*golosovaniepb.Utxo, valueType []byte) (balanceOfValueType, otherBalance uint32) {
+func calcBalance(utxos []*golosovaniepb.Utxo, valueType []byte) (balanceOfValueType, otherBalance uint64) {
 	for _, utxo := range utxos {
 		if bytes.Equal(utxo.ValueType, valueType) {
 			balanceOfValueType += utxo.Value
@@ -43,7 +43,7 @@ func enterVotingId() (*[evote.HashSize]byte, error) {
 func voteMenu(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) {
 	prompt := promptui.Select{
 		Label: "Select vote type",
-		Items: []string{"Info", "See results", "Send vote"},
+		Items: []string{"Info", "See results", "See results at height", "Send vote", "Delegate vote"},
 	}
 
 	_, result, err := prompt.Run()
@@ -57,8 +57,12 @@ func voteMenu(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.Has
 		voteInfo(keys, n, typeValue)
 	} else if result == "See results" {
 		voteResults(keys, n, typeValue)
+	} else if result == "See results at height" {
+		voteResultsAtHeight(keys, n, typeValue)
 	} else if result == "Send vote" {
 		sendVote(keys, n, typeValue)
+	} else if result == "Delegate vote" {
+		delegate(keys, n, typeValue[:])
 	}
 }
 
@@ -74,7 +78,7 @@ func vote(keys *evote.CryptoKeysData, n *evote.Network) {
 		vote(keys, n)
 	}
 	//key - typeValue, value - outputs sum
-	votings := make(map[[evote.HashSize]byte]uint32)
+	votings := make(map[[evote.HashSize]byte]uint64)
 
 	for _, tx := range txs {
 		var body golosovaniepb.TxBody
@@ -5,36 +5,95 @@ import (
 	"GO_LOSOVANIE/evote/golosovaniepb"
 	"fmt"
 	"github.com/golang/protobuf/proto"
+	"time"
 )
 
 func voteInfo(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) {
-	txs, err := n.GetTxsByHashes([][]byte{typeValue[:]})
+	info, err := n.GetVoteInfo(typeValue[:])
 	if retryQuestion(err, n) {
 		voteInfo(keys, n, typeValue)
 	}
-	if len(txs) != 1 {
-		fmt.Println("Error: no transactions with hash", typeValue)
+	if err != nil {
+		return
 	}
-	tx := txs[0]
 	var body golosovaniepb.TxBody
-	err = proto.Unmarshal(tx.TxBody, &body)
+	err = proto.Unmarshal(info.Tx.TxBody, &body)
 	if err != nil {
-		fmt.Println("error during parsing tx", tx.TxBody, err)
+		fmt.Println("error during parsing tx", info.Tx.TxBody, err)
 		return
 	}
-	var typeVote string
-	if body.VoteType == evote.OneVoteType {
-		typeVote = "Majority"
-	} else if body.VoteType == evote.PercentVoteType {
-		typeVote = "Percentage"
-	} else {
+	typeVote := voteTypeName(body.VoteType)
+	if typeVote == "" {
 		fmt.Println("It is not a voting")
 		return
 	}
+	metadata := body.VotingMetadata
+	labels := candidateLabels(metadata)
+	if metadata != nil {
+		fmt.Println("Title:", metadata.Title)
+		if metadata.Description != "" {
+			fmt.Println("Description:", metadata.Description)
+		}
+		if len(metadata.DocumentHash) != 0 {
+			fmt.Println("Document hash:", bToHex(metadata.DocumentHash))
+		}
+	}
 	fmt.Println("Voting type:", typeVote)
+	fmt.Println("Anonymous:", evote.IsAnonymousVoting(&body))
+	fmt.Println("Votes can be changed:", body.VotingParams.GetRevotable())
+	if body.VoteType == evote.StvVoteType {
+		fmt.Println("Seats:", body.VotingParams.GetSeats())
+	}
+	if quorum := body.VotingParams.GetQuorum(); quorum != nil {
+		fmt.Printf("Quorum: %v/%v of all votes\n", quorum.Numerator, quorum.Denominator)
+	}
+	if threshold := body.VotingParams.GetThreshold(); threshold != nil {
+		condition := "at least"
+		if body.VotingParams.StrictThreshold {
+			condition = "more than"
+		}
+		fmt.Printf("Pass threshold: %v %v/%v of votes\n", condition, threshold.Numerator, threshold.Denominator)
+	}
+	for i, q := range body.VotingParams.GetQuestions() {
+		fmt.Printf("Question %v: %v\n", i+1, voteTypeName(q.VoteType))
+		if q.VoteType == evote.StvVoteType {
+			fmt.Println("  Seats:", q.Seats)
+		}
+		for _, option := range q.Options {
+			fmt.Printf("  option: %v\n", candidateName(option, labels))
+		}
+	}
+	for _, option := range body.VotingParams.GetOptions() {
+		fmt.Printf("Option: %v\n", candidateName(option, labels))
+	}
+	if body.VoteType == evote.EncryptedVoteType {
+		fmt.Println("Key shares of validators:", len(info.KeyShareTxs))
+	}
 	fmt.Println("Duration:", body.Duration, "seconds")
+	fmt.Println("Ends at:", time.Unix(0, int64(info.EndTime)))
+	if revealDuration := body.VotingParams.GetRevealDuration(); revealDuration != 0 {
+		fmt.Println("Ballots are hidden until the end, reveal phase ends at:",
+			time.Unix(0, int64(evote.RevealEndTime(info.EndTime, body.VotingParams))))
+	}
+	if len(metadata.GetCandidates()) != 0 && len(body.VotingParams.GetQuestions()) == 0 &&
+		len(body.VotingParams.GetOptions()) == 0 {
+		fmt.Println("Candidates:")
+		for _, candidate := range metadata.Candidates {
+			fmt.Printf("  %v\n", candidateName(candidate.Pkey, labels))
+		}
+	}
 	fmt.Println("Participants:")
-	for _, output := range body.Outputs {
+	outputs := body.Outputs
+	for _, chainTx := range info.ChainTxs {
+		var chainBody golosovaniepb.TxBody
+		err = proto.Unmarshal(chainTx.TxBody, &chainBody)
+		if err != nil {
+			fmt.Println("error during parsing tx", chainTx.TxBody, err)
+			return
+		}
+		outputs = append(outputs, chainBody.Outputs...)
+	}
+	for _, output := range outputs {
 		fmt.Printf("  %v votes: %v\n", bToHex(output.ReceiverSpendPkey), output.Value)
 	}
 }
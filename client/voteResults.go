@@ -2,18 +2,97 @@ package main
 
 import (
 	"GO_LOSOVANIE/evote"
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"errors"
 	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/manifoldco/promptui"
+	"strconv"
 )
 
 func voteResults(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) {
-	results, err := n.VoteResults(typeValue[:])
+	results, err := n.VoteResultAtHeight(typeValue[:], 0)
 	if retryQuestion(err, n) {
 		voteResults(keys, n, typeValue)
 	}
-	for candidate, val := range results {
-		fmt.Printf("pkey: %v votes: %v\n", pkeyHex(candidate), val)
+	printVoteResults(results, votingLabels(n, typeValue))
+}
+
+func voteResultsAtHeight(keys *evote.CryptoKeysData, n *evote.Network, typeValue [evote.HashSize]byte) {
+	validateHeight := func(input string) error {
+		height, err := strconv.ParseInt(input, 10, 64)
+		if err != nil || height <= 0 {
+			return errors.New("invalid height")
+		}
+		return nil
+	}
+	prompt := promptui.Prompt{
+		Label:    "Enter block height",
+		Validate: validateHeight,
+	}
+	heightStr, err := prompt.Run()
+	if err != nil {
+		fmt.Printf("Fail: %v\n", err)
+		return
+	}
+	height, _ := strconv.ParseInt(heightStr, 10, 64)
+	results, err := n.VoteResultAtHeight(typeValue[:], height)
+	if retryQuestion(err, n) {
+		voteResultsAtHeight(keys, n, typeValue)
+	}
+	printVoteResults(results, votingLabels(n, typeValue))
+}
+
+// votingLabels returns names of candidates of the voting, results are printed without names, if they are unavailable
+func votingLabels(n *evote.Network, typeValue [evote.HashSize]byte) map[string]string {
+	info, err := n.GetVoteInfo(typeValue[:])
+	if err != nil {
+		return nil
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(info.Tx.TxBody, &body)
+	if err != nil {
+		return nil
 	}
-	if len(results) == 0 {
+	return candidateLabels(body.VotingMetadata)
+}
+
+func printVoteResults(results *golosovaniepb.ResponseVoteResult, labels map[string]string) {
+	// раунды есть только у голосования с ранжированием
+	for i, round := range results.GetRounds() {
+		fmt.Printf("round %v:\n", i+1)
+		for _, v := range round.Counts {
+			fmt.Printf("  pkey: %v votes: %v\n", candidateName(v.Pkey, labels), v.Value)
+		}
+		for _, pkey := range round.Eliminated {
+			fmt.Printf("  eliminated: %v\n", candidateName(pkey, labels))
+		}
+	}
+	if len(results.GetRounds()) == 0 {
+		for _, v := range results.GetRes() {
+			fmt.Printf("pkey: %v votes: %v\n", candidateName(v.Pkey, labels), v.Value)
+		}
+	}
+	for _, pkey := range results.GetElected() {
+		fmt.Printf("elected: %v\n", candidateName(pkey, labels))
+	}
+	for i, question := range results.GetQuestions() {
+		fmt.Printf("question %v:\n", i+1)
+		printVoteResults(question, labels)
+	}
+	if len(results.GetRes()) == 0 && len(results.GetQuestions()) == 0 {
 		fmt.Println("no votes no results")
 	}
+	for _, v := range results.GetDelegated() {
+		fmt.Printf("delegated to %v: %v votes\n", bToHex(v.Pkey), v.Value)
+	}
+	// голоса участников известны только для всего голосования, не для вопросов
+	if results.GetEligible() != 0 {
+		fmt.Printf("turnout: %v of %v votes, quorum met: %v\n", results.Turnout, results.Eligible, results.QuorumMet)
+	}
+	if results.GetOpen() {
+		fmt.Println("voting is still open, results may change")
+	} else if results.GetOutcome() != golosovaniepb.Outcome_UNDECIDED {
+		fmt.Println("outcome:", results.Outcome)
+	}
 }
@@ -57,4 +57,6 @@ fi &&
       go run utils/gen_key_pair/main.go >>config/client$num/golosovanie_private_key.json &&
       printf " go run GO_LOSOVANIE/client -k ./client$num/golosovanie_private_key.json -v validators.json" >>./config/client$num.sh &&
       chmod +x ./config/client$num.sh
-  done
+  done &&
+  # initial coins of clients in app_state of genesis.json
+  go run utils/gen_genesis/main.go -t config/ -b 100000
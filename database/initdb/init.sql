@@ -21,15 +21,48 @@ create table transaction
     duration            integer      null,
     senderEphemeralPkey bytea        null,
     votersSumPkey       bytea        null,
+    ballot              bytea        null, -- serialized Ballot
+    votingParams        bytea        null, -- serialized VotingParams
+    commitment          bytea        null,
+    salt                bytea        null,
+    keyShare            bytea        null, -- serialized KeyShare
+    partialDecryption   bytea        null, -- serialized PartialDecryption
+    delegation          bytea        null, -- serialized Delegation
+    delegationVoting    bytea        null, -- voting_hash of Delegation, null for standing delegation
+    revealEndTime       bigint       null, -- end of voting or of its reveal phase in nanoseconds, only for votings
+    coinbase            bytea        null, -- serialized Coinbase
     signature           bytea        not null
 );
 
+-- votings ended between two blocks are selected in every block
+create index transaction_revealEndTime on transaction (revealEndTime);
+
+-- VotingMetadata of create vote transaction
+create table voting_metadata
+(
+    txId         integer primary key references transaction (txId) on delete cascade on update no action,
+    title        text  not null,
+    description  text  not null,
+    documentHash bytea null
+);
+
+create table candidate_label
+(
+    txId  integer not null references transaction (txId) on delete cascade on update no action,
+    index integer not null, -- index in candidates array of metadata
+    pkey  bytea   not null,
+    label text    not null,
+    primary key (txId, index)
+);
+
 create table input
 (
     txId        integer not null references transaction (txId) on delete cascade on update no action,
     index       integer not null, -- index in input array of transaction
     -- coinbase transactions have no input, so columns below are really not null
-    prevTxId    integer not null references transaction (txId),
+    prevTxHash  bytea   not null,
+    -- null, if previous transaction is not stored: it was spent before the snapshot, the chain was restored from
+    prevTxId    integer null references transaction (txId),
     outputIndex integer not null,
     primary key (txId, index)
 );
@@ -38,10 +71,12 @@ create table output
 (
     txId              integer not null references transaction (txId) on delete cascade on update no action,
     index             integer not null, -- index in input array of transaction
-    value             integer not null,
+    value             bigint  not null,
     receiverSpendPkey bytea   not null,
     receiverScanPkey  bytea   null,
     isSpentByTx       integer null references transaction (txId),
+    -- isSpentByTx is null for output, spent by transaction, which is not stored (see input.prevTxId)
+    isSpent           boolean not null default false,
     primary key (txId, index)
 );
 
@@ -67,7 +102,17 @@ create trigger transaction_prohibitUpdate
     on transaction
 execute function prohibitUpdate();
 
--- in outputs only column isSpentByTx might be updated
+create trigger voting_metadata_prohibitUpdate
+    before update
+    on voting_metadata
+execute function prohibitUpdate();
+
+create trigger candidate_label_prohibitUpdate
+    before update
+    on candidate_label
+execute function prohibitUpdate();
+
+-- in outputs only columns isSpentByTx and isSpent might be updated
 
 create function prohibitUpdateOutput()
     returns trigger as
@@ -80,7 +125,7 @@ begin
             old.receiverSpendPkey != new.receiverSpendPkey or
             old.receiverScanPkey != new.receiverScanPkey
         ) then
-        raise exception 'illegal column update in output table, only updating isSepntByTx is allowed';
+        raise exception 'illegal column update in output table, only updating isSpentByTx and isSpent is allowed';
         return null;
     else
         return new;
@@ -123,7 +168,8 @@ create function updateOutputSpendingsInsert()
     returns trigger as
 $$
 begin
-    update output set isSpentByTx = new.txid where output.txId = new.prevTxId and output.index = new.outputIndex;
+    update output set isSpentByTx = new.txid, isSpent = true
+    where output.txId = new.prevTxId and output.index = new.outputIndex;
     return null;
 end
 $$ language plpgsql;
@@ -139,7 +185,8 @@ create function updateOutputSpendingsDelete()
     returns trigger as
 $$
 begin
-    update output set isSpentByTx = null where output.txId = old.prevTxId and output.index = old.outputIndex;
+    update output set isSpentByTx = null, isSpent = false
+    where output.txId = old.prevTxId and output.index = old.outputIndex;
 end
 $$ language plpgsql;
 
@@ -148,3 +195,88 @@ create trigger input_updateOutputSpendingsDelete
     on input
     for each row
 execute function updateOutputSpendingsDelete();
+
+
+-- authenticated state, see evote/state_tree.go
+-- nodes of sparse merkle tree are addressed by their hashes and never removed,
+-- so tree of any committed block can be read by its root
+create table state_node
+(
+    nodeHash  bytea primary key,
+    leftHash  bytea null, -- inner node
+    rightHash bytea null, -- inner node
+    keyHash   bytea null, -- leaf node
+    valueHash bytea null  -- leaf node
+);
+
+create table state_root
+(
+    blockId  integer primary key references block (blockId) on delete cascade on update no action,
+    rootHash bytea not null
+);
+
+-- decrypted result of encrypted voting, see evote/elgamal.go.
+-- Computed once in the block, where the voting gets DecryptionThreshold partial decryptions
+create table encrypted_tally
+(
+    votingTxId integer primary key references transaction (txId) on delete cascade on update no action,
+    blockId    integer not null references block (blockId) on delete cascade on update no action,
+    tally      bytea   not null -- serialized EncryptedTally
+);
+
+create trigger encrypted_tally_prohibitUpdate
+    before update
+    on encrypted_tally
+execute function prohibitUpdate();
+
+-- final result of voting, see evote/state.go.
+-- Counted once in the block, where the voting ends or where its encrypted tally is decrypted
+create table vote_result
+(
+    votingTxId integer primary key references transaction (txId) on delete cascade on update no action,
+    blockId    integer not null references block (blockId) on delete cascade on update no action,
+    result     bytea   not null -- serialized ResponseVoteResult
+);
+
+create trigger vote_result_prohibitUpdate
+    before update
+    on vote_result
+execute function prohibitUpdate();
+
+-- app_state of tendermint genesis.json, see evote/genesis.go
+-- at most one row, chains without app state have no rows.
+-- Balances and votings of app state are transactions of the genesis block with height -1
+create table genesis
+(
+    appState bytea not null
+);
+
+create trigger genesis_prohibitUpdate
+    before update
+    on genesis
+execute function prohibitUpdate();
+
+-- keys of the state tree of transactions and spent outputs, which are not stored,
+-- because the chain was restored from a snapshot (see evote/snapshot.go)
+create table state_key
+(
+    key bytea primary key
+);
+
+-- snapshots for state sync, taken by this node, see evote/snapshot.go
+create table snapshot
+(
+    height   integer primary key,
+    format   integer not null,
+    chunks   integer not null,
+    hash     bytea   not null,
+    metadata bytea   not null
+);
+
+create table snapshot_chunk
+(
+    height integer not null, -- chunks are saved before their snapshot
+    index  integer not null,
+    chunk  bytea   not null,
+    primary key (height, index)
+);
@@ -2,10 +2,14 @@ package evote
 
 import (
 	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+	"sync"
+	"time"
 )
 
 type ValidatorNode struct {
@@ -26,9 +30,19 @@ type BlockchainApp struct {
 	pkeyToValidator           map[[PkeySize]byte]*ValidatorNode
 	tendermintAddrToValidator map[[TmAddrSize]byte]*ValidatorNode // map form consensus keys into validators
 	appBlockHash              []byte                              // hash of the last committed block
+	appHash                   []byte                              // root of the state tree after the last committed block
 	appHeight                 int64                               // number of the last committed block
 	checkTxState              *TxExecutor                         // TODO: move transaction execution logic into separate struct
 	deliverTxState            *TxExecutor
+	snapshots                 *SnapshotStore
+	snapshotRestore           *SnapshotRestore // snapshot being applied during state sync
+	broadcaster               *Broadcaster     // sends txs of this validator
+	rewards                   *RewardSchedule  // from genesis app state
+	params                    *ChainParams     // from genesis app state
+	genesisAllocation         uint64           // sum of outputs of the genesis block
+	// Query is called concurrently with block execution, stateMu protects DB changes made by Commit,
+	// so query responses and their proofs always correspond to appHash
+	stateMu sync.RWMutex
 
 	version    string
 	appVersion uint64
@@ -78,18 +92,68 @@ func (bc *BlockchainApp) setup(
 		panic(fmt.Errorf("no validator with pkey %v", bc.thisKey.PkeyByte))
 	}
 
-	//load prev from DB
-	bc.appBlockHash = nil
-
-	bc.appHeight = 0
-
 	bc.db = new(Database)
 	err := bc.db.Init(DbName, DbUser, DbPassword, DbHost, dbPort)
 	if err != nil {
 		panic(err)
 	}
+
+	// load last committed block from DB, so tendermint can replay only missing blocks after restart
+	lastBlockHash, lastBlockHeight, err := bc.db.GetLastBlockHashAndHeight()
+	if err != nil {
+		panic(err)
+	}
+	bc.appBlockHash = lastBlockHash
+	// heights in DB start from 0, tendermint heights start from 1 (see InitChain)
+	bc.appHeight = lastBlockHeight + 1
+	bc.appHash, err = LoadState(bc.db, bc.appBlockHash)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("loaded last block", hex.EncodeToString(bc.appBlockHash), "height", bc.appHeight,
+		"app hash", hex.EncodeToString(bc.appHash))
+
 	bc.checkTxState = NewTxExecutor(bc.db)
 	bc.deliverTxState = NewTxExecutor(bc.db)
+	bc.checkTxState.SetValidators(validators)
+	bc.deliverTxState.SetValidators(validators)
+	if bc.appBlockHash != nil {
+		lastBlock, err := bc.db.GetBlockByHash(bc.appBlockHash)
+		if err != nil {
+			panic(err)
+		}
+		bc.checkTxState.Timestamp = time.Unix(0, int64(lastBlock.BlockHeader.Timestamp))
+	}
+	bc.loadGenesis()
+	bc.snapshots, err = NewSnapshotStore(bc.db)
+	if err != nil {
+		panic(err)
+	}
+	// InitChain is called only once for a chain, so network must be initialized here,
+	// otherwise it won't be available after restart
+	bc.initNetwork()
+	bc.broadcaster = NewBroadcaster(bc.nw.Copy())
+	go bc.broadcaster.Run()
+}
+
+// loadGenesis reads parameters of the chain from genesis app state saved in DB
+func (bc *BlockchainApp) loadGenesis() {
+	appState, err := bc.db.GetGenesis()
+	if err != nil {
+		panic(err)
+	}
+	genesis, err := ParseGenesisState(appState)
+	if err != nil {
+		panic(err)
+	}
+	bc.rewards = genesis.Reward
+	bc.params = genesis.Params
+	bc.genesisAllocation = genesis.Allocation()
+	bc.checkTxState.SetRewardSchedule(bc.rewards)
+	bc.deliverTxState.SetRewardSchedule(bc.rewards)
+	bc.checkTxState.MaxTxSize = bc.params.MaxTxSize
+	bc.deliverTxState.MaxTxSize = bc.params.MaxTxSize
+	bc.checkTxState.MinFee = bc.params.MinTxFee
 }
 
 func (bc *BlockchainApp) initNetwork() {
@@ -118,15 +182,16 @@ func (bc *BlockchainApp) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo
 		Version:          bc.version,
 		AppVersion:       bc.appVersion,
 		LastBlockHeight:  bc.appHeight,
-		LastBlockAppHash: bc.appBlockHash[:],
+		LastBlockAppHash: bc.appHash,
 	}
 }
 
 func (bc *BlockchainApp) CheckTx(req abcitypes.RequestCheckTx) abcitypes.ResponseCheckTx {
 	code := bc.checkTxState.AppendTx(req.Tx, true)
-	return abcitypes.ResponseCheckTx{
-		Code: code,
-	}
+	// Упорядочить мемпул по комиссии на Tendermint v0.34.10 нельзя: в ResponseCheckTx нет поля Priority
+	// (оно появилось в v0.34.20), мемпул - очередь в порядке получения. Поэтому комиссия не влияет на порядок
+	// транзакций, от спама защищает только минимальная комиссия. Газ не задается, MaxGas в genesis не ограничен
+	return abcitypes.ResponseCheckTx{Code: code}
 }
 
 func (bc *BlockchainApp) BeginBlock(req abcitypes.RequestBeginBlock) abcitypes.ResponseBeginBlock {
@@ -147,44 +212,145 @@ func (bc *BlockchainApp) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.Res
 func (bc *BlockchainApp) EndBlock(req abcitypes.RequestEndBlock) abcitypes.ResponseEndBlock {
 	// no changes to validator set during runtime
 	//fmt.Println("end block")
+	// награда за блок добавляется в блок одинаково всеми валидаторами, высоты в бд начинаются с 0
+	err := bc.deliverTxState.AppendReward(req.Height - 1)
+	if err != nil {
+		panic(err)
+	}
 	return abcitypes.ResponseEndBlock{}
 }
 
-// BroadcastTxUntilSuccess function blocks thread, until success or error broadcast
-func BroadcastTxUntilSuccess(nw *Network, tx []byte) {
-	maxErrors := len(nw.allHosts)
-	errors := 0
-	// Network has internal state, so for thread safety copy it
-	for {
-		_, err := nw.BroadcastTxSync(tx)
-		if err == nil {
-			//fmt.Println("reward tx broadcast success")
-			return
-		}
-		nw.SelectNextHost()
-		errors++
-		if errors >= maxErrors {
-			panic(
-				fmt.Sprintf(
-					"impossible to broadcast reward tx, err %v",
-					err.Error(),
-				),
-			)
-		}
+// initVoteTask initializes anonymous voting createVoteTx
+func (bc *BlockchainApp) initVoteTask(createVoteTx *golosovaniepb.Transaction) *broadcastTask {
+	return &broadcastTask{
+		name:       "init vote",
+		votingHash: createVoteTx.Hash,
+		onChain: func() (bool, error) {
+			initTx, err := bc.db.GetInitVoteTx(createVoteTx.Hash)
+			return initTx != nil, err
+		},
+		create: func() (*golosovaniepb.Transaction, error) {
+			return CreateInitVoteTx(createVoteTx, bc.thisKey)
+		},
 	}
 }
 
-// function blocks thread
-func (bc *BlockchainApp) broadcastRewardForMe(blockHash []byte) {
-	t, err := CreateMiningReward(bc.thisKey, blockHash)
-	if err != nil {
-		panic(err)
+// keyShareTask sends the key share of this validator for encrypted voting votingHash
+func (bc *BlockchainApp) keyShareTask(votingHash []byte) *broadcastTask {
+	return &broadcastTask{
+		name:       "key share",
+		votingHash: votingHash,
+		onChain: func() (bool, error) {
+			keyShares, err := bc.db.GetKeyShares(votingHash)
+			if err != nil {
+				return false, err
+			}
+			// ключ собирается из первых долей, остальные не принимаются
+			if len(keyShares) >= DecryptionThreshold(len(bc.validators)) {
+				return true, nil
+			}
+			for _, keyShare := range keyShares {
+				if bytes.Equal(keyShare.ValidatorPkey, bc.thisKey.PkeyByte[:]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		create: func() (*golosovaniepb.Transaction, error) {
+			return CreateKeyShareTx(votingHash, SortedValidatorPkeys(bc.validators), bc.thisKey)
+		},
+	}
+}
+
+// partialDecryptionTask decrypts result of encrypted voting votingHash, which has ended
+func (bc *BlockchainApp) partialDecryptionTask(votingHash []byte) *broadcastTask {
+	return &broadcastTask{
+		name:       "partial decryption",
+		votingHash: votingHash,
+		onChain: func() (bool, error) {
+			tally, err := bc.db.GetEncryptedTallyAtHeight(votingHash, DbHeightLatest)
+			if err != nil || tally != nil {
+				return tally != nil, err
+			}
+			decryptions, err := bc.db.GetPartialDecryptions(votingHash)
+			if err != nil {
+				return false, err
+			}
+			for _, decryption := range decryptions {
+				if bytes.Equal(decryption.ValidatorPkey, bc.thisKey.PkeyByte[:]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		create: func() (*golosovaniepb.Transaction, error) {
+			createVoteTx, timestamp, err := bc.db.GetTxAndTimeByHash(votingHash)
+			if err != nil {
+				return nil, err
+			}
+			var body golosovaniepb.TxBody
+			err = proto.Unmarshal(createVoteTx.TxBody, &body)
+			if err != nil {
+				return nil, err
+			}
+			keyShares, err := bc.db.GetKeyShares(votingHash)
+			if err != nil {
+				return nil, err
+			}
+			counted, _, err := CountedBallotsAtHeight(bc.db, votingHash, &body, timestamp, DbHeightLatest, true)
+			if err != nil || len(counted) == 0 {
+				return nil, err
+			}
+			t, err := CreatePartialDecryptionTx(votingHash, keyShares, counted, len(body.VotingParams.GetOptions()),
+				SortedValidatorPkeys(bc.validators), bc.thisKey)
+			if err != nil {
+				// например, один из валидаторов прислал неверную долю ключа, результат расшифруют остальные
+				fmt.Println("cannot decrypt result of voting", hex.EncodeToString(votingHash), err)
+				return nil, nil
+			}
+			return t, nil
+		},
 	}
-	txBytes, err := proto.Marshal(t)
+}
+
+// queueValidatorTxs queues txs, which this validator sends after already saved block b
+func (bc *BlockchainApp) queueValidatorTxs(b *golosovaniepb.Block, proposer bool) error {
+	for _, tx := range b.Transactions {
+		var body golosovaniepb.TxBody
+		err := proto.Unmarshal(tx.TxBody, &body)
+		if err != nil {
+			return err
+		}
+		if proposer && IsAnonymousVoting(&body) {
+			// only the proposer of the block can initialize anonymous votings, created in the block,
+			// see the trust assumption in dksap.go
+			bc.broadcaster.Add(bc.initVoteTask(tx), 0)
+		}
+		if body.VoteType == EncryptedVoteType {
+			// every validator takes part in key generation of encrypted votings, created in the block
+			bc.broadcaster.Add(bc.keyShareTask(tx.Hash), 0)
+		}
+	}
+	// and decrypts results of encrypted votings, ended in the block
+	ended, err := bc.db.GetVotingsEndedInBlock(b.Hash)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	for _, votingHash := range ended {
+		createVoteTx, err := bc.db.GetTxByHash(votingHash)
+		if err != nil {
+			return err
+		}
+		var body golosovaniepb.TxBody
+		err = proto.Unmarshal(createVoteTx.TxBody, &body)
+		if err != nil {
+			return err
+		}
+		if body.VoteType == EncryptedVoteType {
+			bc.broadcaster.Add(bc.partialDecryptionTask(votingHash), 0)
+		}
 	}
-	BroadcastTxUntilSuccess(bc.nw.Copy(), txBytes)
+	return nil
 }
 
 func (bc *BlockchainApp) Commit() abcitypes.ResponseCommit {
@@ -198,38 +364,130 @@ func (bc *BlockchainApp) Commit() abcitypes.ResponseCommit {
 	if err != nil {
 		panic(err)
 	}
-	if bc.deliverTxState.BlockProposer == bc.thisValidator.Pkey {
-		// this validator is proposer of the block, reward tx will be added in some of the next blocks
-		go bc.broadcastRewardForMe(b.Hash)
-	}
+	bc.stateMu.Lock()
 	err = bc.db.SaveNextBlock(b)
 	if err != nil {
 		panic(err)
 	}
+	bc.appHash, err = CommitState(bc.db, b, bc.appHash)
+	if err != nil {
+		panic(err)
+	}
 	bc.appBlockHash = b.Hash
 	bc.appHeight++
+	bc.stateMu.Unlock()
+	// txs are sent by the broadcaster, not here, so Commit is not blocked by the network
+	err = bc.queueValidatorTxs(b, bc.deliverTxState.BlockProposer == bc.thisValidator.Pkey)
+	if err != nil {
+		panic(err)
+	}
+	// mempool transactions are checked against the time of the last block
+	bc.checkTxState.Timestamp = bc.deliverTxState.Timestamp
 	bc.checkTxState.Reset()
 	bc.deliverTxState.Reset()
-	fmt.Println("block committed", hex.EncodeToString(b.Hash), "txCount", len(b.Transactions))
+	fmt.Println("block committed", hex.EncodeToString(b.Hash), "txCount", len(b.Transactions),
+		"app hash", hex.EncodeToString(bc.appHash))
+	if bc.appHeight%SnapshotInterval == 0 {
+		go bc.takeSnapshot(bc.appHeight)
+	}
 	return abcitypes.ResponseCommit{
-		Data: bc.appBlockHash,
+		Data: bc.appHash,
+	}
+}
+
+// function blocks thread
+func (bc *BlockchainApp) takeSnapshot(height int64) {
+	err := bc.snapshots.Take(height)
+	if err != nil {
+		fmt.Println("cannot take snapshot at height", height, err)
+		return
 	}
+	fmt.Println("snapshot taken at height", height)
 }
 
 func (bc *BlockchainApp) ListSnapshots(req abcitypes.RequestListSnapshots) abcitypes.ResponseListSnapshots {
-	return abcitypes.ResponseListSnapshots{}
+	return abcitypes.ResponseListSnapshots{
+		Snapshots: bc.snapshots.List(),
+	}
 }
 
-func (bc *BlockchainApp) OfferSnapshot(snapshot abcitypes.RequestOfferSnapshot) abcitypes.ResponseOfferSnapshot {
-	return abcitypes.ResponseOfferSnapshot{}
+func (bc *BlockchainApp) OfferSnapshot(req abcitypes.RequestOfferSnapshot) abcitypes.ResponseOfferSnapshot {
+	if req.Snapshot == nil {
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT}
+	}
+	if bc.appHeight != 0 {
+		// snapshots can be restored only into empty DB
+		fmt.Println("err: snapshot offered, but app already has blocks")
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_ABORT}
+	}
+	if req.Snapshot.Format != SnapshotFormat {
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT_FORMAT}
+	}
+	restore, err := NewSnapshotRestore(req.Snapshot, req.AppHash)
+	if err != nil {
+		fmt.Println("err: invalid snapshot offered", err)
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT}
+	}
+	bc.snapshotRestore = restore
+	fmt.Println("restoring snapshot at height", req.Snapshot.Height, "chunks", req.Snapshot.Chunks)
+	return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_ACCEPT}
 }
 
 func (bc *BlockchainApp) LoadSnapshotChunk(req abcitypes.RequestLoadSnapshotChunk) abcitypes.ResponseLoadSnapshotChunk {
-	return abcitypes.ResponseLoadSnapshotChunk{}
+	return abcitypes.ResponseLoadSnapshotChunk{
+		Chunk: bc.snapshots.LoadChunk(req.Height, req.Format, req.Chunk),
+	}
+}
+
+// rejectSnapshotRestore removes everything, that has already been restored from the snapshot
+func (bc *BlockchainApp) rejectSnapshotRestore() {
+	err := bc.db.Clear()
+	if err != nil {
+		panic(err)
+	}
+	bc.snapshotRestore = nil
+	bc.appBlockHash = nil
+	bc.appHash = EmptyStateRoot
+	bc.appHeight = 0
+	bc.loadGenesis()
 }
 
-func (bc *BlockchainApp) ApplySnapshotChunk(chunk abcitypes.RequestApplySnapshotChunk) abcitypes.ResponseApplySnapshotChunk {
-	return abcitypes.ResponseApplySnapshotChunk{}
+func (bc *BlockchainApp) ApplySnapshotChunk(req abcitypes.RequestApplySnapshotChunk) abcitypes.ResponseApplySnapshotChunk {
+	restore := bc.snapshotRestore
+	if restore == nil {
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_ABORT}
+	}
+	bc.stateMu.Lock()
+	defer bc.stateMu.Unlock()
+	resp := restore.ApplyChunk(bc.db, req.Index, req.Chunk, req.Sender)
+	if resp.Result == abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT ||
+		resp.Result == abcitypes.ResponseApplySnapshotChunk_RETRY_SNAPSHOT {
+		bc.rejectSnapshotRestore()
+		return resp
+	}
+	if resp.Result == abcitypes.ResponseApplySnapshotChunk_ACCEPT && restore.Done() {
+		appHash, err := RebuildState(bc.db, restore.lastBlockHash)
+		if err != nil {
+			fmt.Println("err: cannot build state of the restored snapshot", err)
+			bc.rejectSnapshotRestore()
+			return abcitypes.ResponseApplySnapshotChunk{
+				Result:        abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT,
+				RejectSenders: []string{req.Sender},
+			}
+		}
+		bc.appBlockHash = restore.lastBlockHash
+		bc.appHash = appHash
+		bc.appHeight = int64(restore.snapshot.Height)
+		if !bytes.Equal(bc.appHash, restore.appHash) {
+			fmt.Println("err: restored app hash does not match trusted app hash")
+			bc.rejectSnapshotRestore()
+			return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}
+		}
+		bc.snapshotRestore = nil
+		bc.loadGenesis()
+		fmt.Println("snapshot restored, height", bc.appHeight, "last block", hex.EncodeToString(bc.appBlockHash))
+	}
+	return resp
 }
 
 func respondAbciQuery(code uint32, err error, resp *golosovaniepb.Response) abcitypes.ResponseQuery {
@@ -253,6 +511,83 @@ func respondAbciQuery(code uint32, err error, resp *golosovaniepb.Response) abci
 	}
 }
 
+// stateRootAtHeight returns app hash after block height, height is not greater than appHeight
+func (bc *BlockchainApp) stateRootAtHeight(height int64) ([]byte, error) {
+	if height == bc.appHeight {
+		return bc.appHash, nil
+	}
+	var root []byte
+	var err error
+	if height == 0 {
+		root, err = initialState(bc.db)
+	} else {
+		root, err = bc.db.GetStateRootByHeight(height - 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		// e.g. blocks before the height were restored from a snapshot
+		return nil, fmt.Errorf("state at height %v is not available", height)
+	}
+	return root, nil
+}
+
+// proveState returns proof operation for each key at height, in the same order
+func (bc *BlockchainApp) proveState(keys [][]byte, height int64) (*tmcrypto.ProofOps, error) {
+	root, err := bc.stateRootAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	tree := NewStateTree(bc.db)
+	var proofOps tmcrypto.ProofOps
+	for _, key := range keys {
+		proof, err := tree.Prove(root, key)
+		if err != nil {
+			return nil, err
+		}
+		proofOps.Ops = append(proofOps.Ops, NewStateProofOp(key, proof).ProofOp())
+	}
+	return &proofOps, nil
+}
+
+// queryProvable returns true for paths, which responses can be proven by stateKeysOfQuery
+func queryProvable(path string) bool {
+	return path == "getTxs" || path == "getUtxosByPubKey" || path == "getVoteResult"
+}
+
+// stateKeysOfQuery returns keys of the state, which values are returned by the query with response value.
+// Utxos of a pkey are proven one by one, so the proof doesn't show, that no utxo is missing
+func stateKeysOfQuery(path string, req *golosovaniepb.Request, value []byte) ([][]byte, error) {
+	var keys [][]byte
+	switch path {
+	case "getTxs":
+		for _, hash := range req.GetTxsByHashes().GetHashes() {
+			keys = append(keys, StateKey(StateKeyTx, hash))
+		}
+	case "getUtxosByPubKey":
+		var resp golosovaniepb.Response
+		err := proto.Unmarshal(value, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range resp.GetUtxosByPkey().GetUtxos() {
+			keys = append(keys, UtxoStateKey(utxo.TxHash, utxo.Index))
+		}
+	case "getVoteResult":
+		keys = append(keys, StateKey(StateKeyVoteResult, req.GetVoteResult().GetVoteTxHash()))
+	}
+	return keys, nil
+}
+
+// queryAtHeightSupported returns true for paths, which can be answered with a state after some previous block
+func queryAtHeightSupported(path string) bool {
+	return path == "getUtxosByPubKey" || path == "getVoteResult" || path == "getVoteInfo"
+}
+
+// Query answers client requests. If reqQuery.Prove is set, response contains proof of the response
+// against app hash at height resp.Height, one proof operation for every key from stateKeysOfQuery.
+// reqQuery.Height is the height of the last block, which state is requested, 0 means the latest block
 func (bc *BlockchainApp) Query(reqQuery abcitypes.RequestQuery) abcitypes.ResponseQuery {
 	fmt.Println("query", reqQuery.Path, reqQuery.Data)
 	var req golosovaniepb.Request
@@ -264,40 +599,109 @@ func (bc *BlockchainApp) Query(reqQuery abcitypes.RequestQuery) abcitypes.Respon
 			nil,
 		)
 	}
+	if reqQuery.Prove && !queryProvable(reqQuery.Path) {
+		return abcitypes.ResponseQuery{
+			Code: CodeNotSupported,
+			Log:  "proofs are not supported for path " + reqQuery.Path,
+		}
+	}
+	bc.stateMu.RLock()
+	defer bc.stateMu.RUnlock()
+	height := reqQuery.Height
+	if height == 0 {
+		height = bc.appHeight
+	}
+	if height < 0 || height > bc.appHeight {
+		return abcitypes.ResponseQuery{
+			Code: CodeInvalidHeight,
+			Log:  fmt.Sprintf("height must be from 1 to %v", bc.appHeight),
+		}
+	}
+	if height != bc.appHeight && !queryAtHeightSupported(reqQuery.Path) {
+		return abcitypes.ResponseQuery{
+			Code: CodeNotSupported,
+			Log:  "queries at previous heights are not supported for path " + reqQuery.Path,
+		}
+	}
+	// heights in DB start from 0
+	dbHeight := height - 1
+	var resp abcitypes.ResponseQuery
 	switch reqQuery.Path {
 	case "getTxs":
-		return respondAbciQuery(
+		resp = respondAbciQuery(
 			OnGetTxsByHashes(bc.db, req.GetTxsByHashes()),
 		)
 	case "getTxsByPubKey":
-		return respondAbciQuery(
+		resp = respondAbciQuery(
 			OnGetTxsByPkey(bc.db, req.GetTxsByPkey()),
 		)
 	case "getUtxosByPubKey":
-		return respondAbciQuery(
-			OnGetUtxosByPkey(bc.db, req.GetUtxosByPkey()),
+		resp = respondAbciQuery(
+			OnGetUtxosByPkey(bc.db, req.GetUtxosByPkey(), dbHeight),
 		)
 	case "faucet":
-		return respondAbciQuery(
-			OnFaucet(bc.db, bc.nw, bc.thisKey, req.GetFaucet()),
+		resp = respondAbciQuery(
+			OnFaucet(bc.db, bc.nw, bc.thisKey, bc.params.MinTxFee, req.GetFaucet()),
 		)
 	case "getVoteResult":
-		return respondAbciQuery(
-			OnGetVoteResult(bc.db, req.GetVoteResult()),
+		resp = respondAbciQuery(
+			OnGetVoteResult(bc.db, req.GetVoteResult(), dbHeight),
+		)
+	case "getInitVoteTx":
+		resp = respondAbciQuery(
+			OnGetInitVoteTx(bc.db, req.GetInitVoteTx()),
+		)
+	case "getVoteInfo":
+		resp = respondAbciQuery(
+			OnGetVoteInfo(bc.db, req.GetVoteInfo(), dbHeight),
+		)
+	case "getSupply":
+		resp = respondAbciQuery(
+			OnGetSupply(bc.rewards, bc.genesisAllocation, dbHeight),
 		)
+	default:
+		return abcitypes.ResponseQuery{
+			Code: CodeUnknownPath,
+			Log:  "no such path, check in request is correct",
+		}
 	}
-
-	return abcitypes.ResponseQuery{
-		Code: CodeUnknownPath,
-		Log:  "no such path, check in request is correct",
+	resp.Height = height
+	if reqQuery.Prove && resp.Code == CodeOk {
+		proofKeys, err := stateKeysOfQuery(reqQuery.Path, &req, resp.Value)
+		if err != nil {
+			return respondAbciQuery(CodeParseErr, err, nil)
+		}
+		resp.ProofOps, err = bc.proveState(proofKeys, height)
+		if err != nil {
+			return respondAbciQuery(CodeDatabaseFailed, err, nil)
+		}
+		if len(proofKeys) == 1 {
+			resp.Key = proofKeys[0]
+		}
 	}
+	return resp
 }
 
 func (bc *BlockchainApp) InitChain(req abcitypes.RequestInitChain) abcitypes.ResponseInitChain {
-	bc.appHeight = req.InitialHeight
-	fmt.Println("init chain, appStateBytes", req.AppStateBytes)
-	go bc.initNetwork() // init in background, to not to block response
+	if req.InitialHeight != 1 {
+		// blocks in DB are numbered from 0 and the first block is expected to be block 1 in tendermint
+		panic(fmt.Errorf("initial height %v is not supported, only 1 is", req.InitialHeight))
+	}
+	// no blocks are committed yet, first committed block will have height InitialHeight
+	bc.appHeight = req.InitialHeight - 1
+	fmt.Println("init chain, appStateBytes", string(req.AppStateBytes))
+	// неверный genesis нужно исправить до запуска цепочки
+	_, err := ParseGenesisState(req.AppStateBytes)
+	if err != nil {
+		panic(err)
+	}
+	// balances and votings from genesis are saved as the genesis block, the first block references it
+	bc.appBlockHash, bc.appHash, err = InitState(bc.db, req.AppStateBytes)
+	if err != nil {
+		panic(err)
+	}
+	bc.loadGenesis()
 	return abcitypes.ResponseInitChain{
-		AppHash: bc.appBlockHash[:],
+		AppHash: bc.appHash,
 	}
 }
@@ -0,0 +1,26 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"math"
+)
+
+// MaxAmount - максимальная сумма выхода и суммы входов или выходов транзакции,
+// суммы хранятся в бд в столбце bigint
+const MaxAmount uint64 = math.MaxInt64
+
+// AddAmounts returns a + b, ok is false, if the sum exceeds MaxAmount
+func AddAmounts(a, b uint64) (sum uint64, ok bool) {
+	if a > MaxAmount || b > MaxAmount-a {
+		return 0, false
+	}
+	return a + b, true
+}
+
+func newOutput(spendPkey []byte, scanPkey []byte, amount uint64) *golosovaniepb.Output {
+	return &golosovaniepb.Output{
+		ReceiverSpendPkey: spendPkey,
+		ReceiverScanPkey:  scanPkey,
+		Value:             amount,
+	}
+}
@@ -0,0 +1,19 @@
+package evote
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestAmounts(t *testing.T) {
+	t.Run("add", func(t *testing.T) {
+		sum, ok := AddAmounts(math.MaxUint32, 1)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(math.MaxUint32+1), sum)
+		_, ok = AddAmounts(MaxAmount, 1)
+		assert.False(t, ok)
+		_, ok = AddAmounts(MaxAmount+1, 0)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,160 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	"sync"
+	"time"
+)
+
+// broadcastTask - транзакция валидатора, которую нужно отправить после блока
+type broadcastTask struct {
+	name       string
+	votingHash []byte
+	// onChain returns true, if the tx of this validator is already saved in DB or is not needed anymore
+	onChain func() (bool, error)
+	// create returns the tx to send, nil if there is nothing to send
+	create  func() (*golosovaniepb.Transaction, error)
+	attempt int
+	delay   time.Duration
+	startAt time.Time
+}
+
+// Broadcaster sends validator txs (init votes, key shares, partial decryptions) in its own goroutine,
+// so Commit doesn't wait for the network and a failed broadcast doesn't stop the validator.
+// A task is dropped, when its tx is on chain, so blocks replayed after restart don't send txs twice.
+// A failed task is retried with growing delay, a sent one is checked again after BroadcastConfirmDelay,
+// because the tx can be rejected or dropped from the mempool
+type Broadcaster struct {
+	nw    *Network // used only by Run
+	mu    sync.Mutex
+	tasks []*broadcastTask
+	wake  chan struct{}
+}
+
+func NewBroadcaster(nw *Network) *Broadcaster {
+	return &Broadcaster{
+		nw:   nw,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Add queues the task, it is started not earlier than after delay. Doesn't block
+func (b *Broadcaster) Add(task *broadcastTask, delay time.Duration) {
+	task.startAt = time.Now().Add(delay)
+	b.mu.Lock()
+	b.tasks = append(b.tasks, task)
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes queued tasks one by one, function blocks thread
+func (b *Broadcaster) Run() {
+	for {
+		task, wait := b.next()
+		if task == nil {
+			select {
+			case <-b.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		b.process(task)
+	}
+}
+
+// next removes from the queue a task, which can be started now, otherwise returns time until the nearest task
+func (b *Broadcaster) next() (*broadcastTask, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	wait := BroadcastMaxDelay
+	for i, task := range b.tasks {
+		if !task.startAt.After(now) {
+			b.tasks = append(b.tasks[:i], b.tasks[i+1:]...)
+			return task, 0
+		}
+		if task.startAt.Sub(now) < wait {
+			wait = task.startAt.Sub(now)
+		}
+	}
+	return nil, wait
+}
+
+func (b *Broadcaster) process(task *broadcastTask) {
+	task.attempt++
+	done, err := b.try(task)
+	if done {
+		return
+	}
+	votingHash := hex.EncodeToString(task.votingHash)
+	if task.attempt >= BroadcastMaxAttempts {
+		fmt.Println("giving up broadcasting", task.name, "of voting", votingHash, "err", err)
+		return
+	}
+	if err != nil {
+		task.delay *= 2
+		if task.delay < BroadcastRetryDelay {
+			task.delay = BroadcastRetryDelay
+		}
+		if task.delay > BroadcastMaxDelay {
+			task.delay = BroadcastMaxDelay
+		}
+		fmt.Println("cannot broadcast", task.name, "of voting", votingHash, "attempt", task.attempt,
+			"retry in", task.delay, "err", err)
+		b.Add(task, task.delay)
+		return
+	}
+	// транзакция принята в мемпул, но может не попасть в блок, поэтому проверяется еще раз
+	task.delay = 0
+	b.Add(task, BroadcastConfirmDelay)
+}
+
+// try sends the tx of the task, if it is not on chain yet. Returns true, if the task is done
+func (b *Broadcaster) try(task *broadcastTask) (done bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			done, err = false, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	done, err = task.onChain()
+	if err != nil || done {
+		return done, err
+	}
+	t, err := task.create()
+	if err != nil || t == nil {
+		return err == nil, err
+	}
+	txBytes, err := proto.Marshal(t)
+	if err != nil {
+		return false, err
+	}
+	return false, broadcastTx(b.nw, txBytes)
+}
+
+// broadcastTx sends tx to the first available host. During block replay after restart
+// tendermint rpc (including one of this validator) is not started yet, so all hosts can fail
+func broadcastTx(nw *Network, tx []byte) error {
+	err := fmt.Errorf("no hosts")
+	for range nw.allHosts {
+		var result []byte
+		result, err = nw.BroadcastTxSync(tx)
+		if err == nil {
+			var res ctypes.ResultBroadcastTx
+			err = tmjson.Unmarshal(result, &res)
+			if err == nil && res.Code != CodeOk {
+				err = fmt.Errorf("tx rejected with code %v: %v", res.Code, res.Log)
+			}
+			return err
+		}
+		nw.selectNextHostNoPing()
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBroadcaster(t *testing.T) {
+	newTask := func(onChain func() (bool, error), create func() (*golosovaniepb.Transaction, error)) *broadcastTask {
+		return &broadcastTask{name: "test", votingHash: randHash(), onChain: onChain, create: create}
+	}
+	notOnChain := func() (bool, error) {
+		return false, nil
+	}
+	mustNotCreate := func(t *testing.T) func() (*golosovaniepb.Transaction, error) {
+		return func() (*golosovaniepb.Transaction, error) {
+			t.Error("tx is created for done task")
+			return nil, nil
+		}
+	}
+
+	t.Run("on_chain", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		b.process(newTask(func() (bool, error) { return true, nil }, mustNotCreate(t)))
+		if len(b.tasks) != 0 {
+			t.Error("task with tx on chain is queued again")
+		}
+	})
+
+	t.Run("nothing_to_send", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		b.process(newTask(notOnChain, func() (*golosovaniepb.Transaction, error) { return nil, nil }))
+		if len(b.tasks) != 0 {
+			t.Error("task without tx is queued again")
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		task := newTask(func() (bool, error) { return false, fmt.Errorf("db failed") }, mustNotCreate(t))
+		for _, delay := range []time.Duration{BroadcastRetryDelay, 2 * BroadcastRetryDelay, 4 * BroadcastRetryDelay} {
+			b.process(task)
+			if len(b.tasks) != 1 || task.delay != delay {
+				t.Fatalf("failed task is queued %v times with delay %v, expected %v", len(b.tasks), task.delay, delay)
+			}
+			if next, _ := b.next(); next != nil {
+				t.Fatal("failed task is retried without delay")
+			}
+			b.tasks = nil
+		}
+	})
+
+	t.Run("max_delay", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		task := newTask(func() (bool, error) { return false, fmt.Errorf("db failed") }, mustNotCreate(t))
+		task.delay = BroadcastMaxDelay
+		b.process(task)
+		if task.delay != BroadcastMaxDelay {
+			t.Errorf("delay %v exceeds max delay", task.delay)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		b.process(newTask(notOnChain, func() (*golosovaniepb.Transaction, error) { panic("no key") }))
+		if len(b.tasks) != 1 {
+			t.Error("panicked task is not retried")
+		}
+	})
+
+	t.Run("give_up", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		task := newTask(func() (bool, error) { return false, fmt.Errorf("db failed") }, mustNotCreate(t))
+		task.attempt = BroadcastMaxAttempts - 1
+		b.process(task)
+		if len(b.tasks) != 0 {
+			t.Error("task is retried after max attempts")
+		}
+	})
+
+	t.Run("next", func(t *testing.T) {
+		b := NewBroadcaster(nil)
+		later := newTask(notOnChain, nil)
+		now := newTask(notOnChain, nil)
+		b.Add(later, time.Hour)
+		b.Add(now, 0)
+		next, _ := b.next()
+		if next != now {
+			t.Fatal("task, that can be started, is not returned")
+		}
+		next, wait := b.next()
+		if next != nil || wait > BroadcastMaxDelay {
+			t.Errorf("delayed task is returned or wait %v is too long", wait)
+		}
+	})
+}
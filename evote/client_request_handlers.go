@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/golang/protobuf/proto"
-	"time"
 )
 
 func OnGetTxsByHashes(db *Database, req *golosovaniepb.RequestTxsByHashes) (code uint32, err error, resp *golosovaniepb.Response) {
@@ -43,14 +42,15 @@ func OnGetTxsByPkey(db *Database, req *golosovaniepb.RequestTxsByPkey) (code uin
 	}
 }
 
-func OnGetUtxosByPkey(db *Database, req *golosovaniepb.RequestUtxosByPkey) (code uint32, err error, resp *golosovaniepb.Response) {
+// OnGetUtxosByPkey height - высота блока в бд, после которого нужны выходы
+func OnGetUtxosByPkey(db *Database, req *golosovaniepb.RequestUtxosByPkey, height int64) (code uint32, err error, resp *golosovaniepb.Response) {
 	if req == nil || len(req.Pkey) == 0 {
 		return CodeRequestEmpty, fmt.Errorf("request fields are empty"), nil
 	}
 	if len(req.Pkey) != PkeySize {
 		return CodeInvalidDataLen, fmt.Errorf("pkey must be exactly %d bytes", PkeySize), nil
 	}
-	utxos, err := db.GetUTXOSByPkey(req.Pkey)
+	utxos, err := db.GetUTXOSByPkeyAtHeight(req.Pkey, height)
 	if err != nil {
 		return CodeDatabaseFailed, err, nil
 	}
@@ -67,21 +67,21 @@ OnFaucet
 input: uint32_t moneyRequest + pkey_bytes_str
 output: ok/false + err_msg
 */
-func OnFaucet(db *Database, n *Network, key *CryptoKeysData, req *golosovaniepb.RequestFaucet) (code uint32, err error, resp *golosovaniepb.Response) {
+func OnFaucet(db *Database, n *Network, key *CryptoKeysData, fee uint64, req *golosovaniepb.RequestFaucet) (code uint32, err error, resp *golosovaniepb.Response) {
 	if req == nil || len(req.Pkey) != PkeySize {
 		return CodeInvalidDataLen, fmt.Errorf("pkey must be exactly %d bytes", PkeySize), nil
 	}
-	if req.Value <= 0 {
-		return CodeInvalidValue, fmt.Errorf("value must be greater than zero"), nil
+	if req.Value <= 0 || req.Value > MaxAmount {
+		return CodeInvalidValue, fmt.Errorf("value must be greater than zero and not greater than %d", MaxAmount), nil
 	}
 
 	utxos, err := db.GetUTXOSByPkey(key.PkeyByte[:])
 	if err != nil {
 		return CodeDatabaseFailed, err, nil
 	}
-	var outputs = make(map[[PkeySize]byte]uint32, 0)
+	var outputs = make(map[[PkeySize]byte]uint64, 0)
 	outputs[SliceToPkey(req.Pkey)] = req.Value
-	tx, err := CreateTx(utxos, outputs, nil, key, 0, 0, false)
+	tx, err := CreateTx(utxos, outputs, nil, key, 0, 0, false, fee)
 	if err != nil {
 		return CodeCannotCreateTx, fmt.Errorf("error while creating transaction"), nil
 	}
@@ -103,84 +103,328 @@ func OnFaucet(db *Database, n *Network, key *CryptoKeysData, req *golosovaniepb.
 	}
 }
 
-func getVoteValue(value, typeVote uint32) uint32 {
+// getVoteValue returns votes of the output: one vote for OneVoteType, the output value for other types
+func getVoteValue(value uint64, typeVote uint32) uint64 {
 	if typeVote == OneVoteType {
 		return 1
 	}
-	if typeVote == PercentVoteType {
-		return value
-	}
 	return value
 }
 
-func OnGetVoteResult(db *Database, req *golosovaniepb.RequestVoteResult) (code uint32, err error, resp *golosovaniepb.Response) {
+func OnGetInitVoteTx(db *Database, req *golosovaniepb.RequestInitVoteTx) (code uint32, err error, resp *golosovaniepb.Response) {
 	if req == nil || len(req.VoteTxHash) != HashSize {
 		return CodeInvalidDataLen, fmt.Errorf("incorrect transaction hash length"), nil
 	}
-	t, timeStart, err := db.GetTxAndTimeByHash(req.VoteTxHash)
+	tx, err := db.GetInitVoteTx(req.VoteTxHash)
 	if err != nil {
 		return CodeDatabaseFailed, err, nil
 	}
+	return CodeOk, nil, &golosovaniepb.Response{
+		Data: &golosovaniepb.Response_InitVoteTx{
+			InitVoteTx: &golosovaniepb.ResponseInitVoteTx{Tx: tx},
+		},
+	}
+}
+
+// OnGetSupply возвращает монеты genesis блока и выпуск монет наградами за блоки. genesisAllocation - сумма
+// выходов genesis блока, height - высота блока в бд
+func OnGetSupply(rewards *RewardSchedule, genesisAllocation uint64, height int64) (code uint32, err error, resp *golosovaniepb.Response) {
+	// награда есть в каждом блоке, поэтому выпуск зависит только от высоты. Награды ограничены так,
+	// что вместе с genesis блоком не превышают MaxAmount
+	issued := genesisAllocation + rewards.SupplyBefore(height+1)
+	return CodeOk, nil, &golosovaniepb.Response{
+		Data: &golosovaniepb.Response_Supply{
+			Supply: &golosovaniepb.ResponseSupply{
+				Issued:     issued,
+				MaxSupply:  rewards.MaxSupply,
+				NextReward: rewards.RewardAt(height + 1),
+				Genesis:    genesisAllocation,
+			},
+		},
+	}
+}
+
+// OnGetVoteInfo возвращает транзакции, описывающие голосование. height - высота блока в бд,
+// после которого нужно состояние голосования
+func OnGetVoteInfo(db *Database, req *golosovaniepb.RequestVoteInfo, height int64) (code uint32, err error, resp *golosovaniepb.Response) {
+	if req == nil || len(req.VoteTxHash) != HashSize {
+		return CodeInvalidDataLen, fmt.Errorf("incorrect transaction hash length"), nil
+	}
+	tx, timeStart, err := db.GetTxAndTimeByHashAtHeight(req.VoteTxHash, height)
+	if err != nil {
+		return CodeDatabaseFailed, err, nil
+	}
+	if tx == nil {
+		return CodeValueTypeInvalid, fmt.Errorf("no voting with hash %x", req.VoteTxHash), nil
+	}
 	var body golosovaniepb.TxBody
-	err = proto.Unmarshal(t.TxBody, &body)
+	err = proto.Unmarshal(tx.TxBody, &body)
 	if err != nil {
 		return CodeParseErr, err, nil
 	}
-	endTime := timeStart + uint64(time.Second)*uint64(body.Duration)
-	utxos, err := db.GetUTXOSByTypeValue(req.VoteTxHash)
+	if body.VoteType == 0 {
+		return CodeValueTypeInvalid, fmt.Errorf("transaction %x is not a voting", req.VoteTxHash), nil
+	}
+	info := golosovaniepb.ResponseVoteInfo{
+		Tx:        tx,
+		StartTime: timeStart,
+		EndTime:   VotingEndTime(timeStart, body.Duration),
+	}
+	for chainTx := tx; ; {
+		chainTx, err = db.GetTxByHashLinkAtHeight(chainTx.Hash, height)
+		if err != nil {
+			return CodeDatabaseFailed, err, nil
+		}
+		if chainTx == nil {
+			break
+		}
+		info.ChainTxs = append(info.ChainTxs, chainTx)
+	}
+	if body.VoteType == EncryptedVoteType {
+		info.KeyShareTxs, err = db.GetKeyShareTxsAtHeight(req.VoteTxHash, height)
+		if err != nil {
+			return CodeDatabaseFailed, err, nil
+		}
+	}
+	return CodeOk, nil, &golosovaniepb.Response{
+		Data: &golosovaniepb.Response_VoteInfo{VoteInfo: &info},
+	}
+}
+
+// OnGetVoteResult height - высота блока в бд, после которого нужны результаты
+func OnGetVoteResult(db *Database, req *golosovaniepb.RequestVoteResult, height int64) (code uint32, err error, resp *golosovaniepb.Response) {
+	if req == nil || len(req.VoteTxHash) != HashSize {
+		return CodeInvalidDataLen, fmt.Errorf("incorrect transaction hash length"), nil
+	}
+	res, code, err := GetVoteResultAtHeight(db, req.VoteTxHash, height)
 	if err != nil {
-		return CodeDatabaseFailed, err, nil
+		return code, err, nil
+	}
+	if res.Undecryptable {
+		return CodeVoteResultHidden, fmt.Errorf("votes for an option exceed %v, results cannot be decrypted",
+			uint64(MaxEncryptedTally)), nil
+	}
+	if res.Hidden {
+		return CodeVoteResultHidden, fmt.Errorf("results are not available until the reveal phase or decryption"), nil
+	}
+	return CodeOk, nil, &golosovaniepb.Response{
+		Data: &golosovaniepb.Response_VoteResult{VoteResult: res},
+	}
+}
+
+// GetVoteResult подсчитывает результаты голосования. Результат входит в хеш состояния,
+// поэтому он должен зависеть только от содержимого бд, а кандидаты упорядочены по ключу
+func GetVoteResult(db *Database, voteTxHash []byte) (*golosovaniepb.ResponseVoteResult, uint32, error) {
+	return GetVoteResultAtHeight(db, voteTxHash, DbHeightLatest)
+}
+
+// GetVoteResultAtHeight подсчитывает результаты голосования после блока с высотой height в бд
+func GetVoteResultAtHeight(db *Database, voteTxHash []byte, height int64) (*golosovaniepb.ResponseVoteResult, uint32, error) {
+	// окончательный результат подсчитан один раз в блоке, в котором голосование закончилось (см. saveVoteResults)
+	saved, err := db.GetSavedVoteResultAtHeight(voteTxHash, height)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
+	}
+	if saved != nil {
+		return saved, CodeOk, nil
+	}
+	t, timeStart, err := db.GetTxAndTimeByHashAtHeight(voteTxHash, height)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
+	}
+	if t == nil {
+		return nil, CodeValueTypeInvalid, fmt.Errorf("no voting with hash %x", voteTxHash)
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(t.TxBody, &body)
+	if err != nil {
+		return nil, CodeParseErr, err
+	}
+	endTime := VotingEndTime(timeStart, body.Duration)
+	revealEndTime := RevealEndTime(endTime, body.VotingParams)
+	participants, code, err := getVotingParticipants(db, t, &body, height)
+	if err != nil {
+		return nil, code, err
+	}
+	// время последнего блока, а не текущее время, чтобы результат зависел только от бд
+	now, err := db.GetBlockTimestampAtHeight(height)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
+	}
+	var res *golosovaniepb.ResponseVoteResult
+	if body.VotingParams.GetRevealDuration() != 0 && now < endTime {
+		res = &golosovaniepb.ResponseVoteResult{Hidden: true}
+	} else if IsBallotVoting(&body) {
+		res, code, err = getBallotVoteResult(db, &body, voteTxHash, timeStart, height, now >= endTime)
+	} else {
+		res, code, err = getTransferVoteResult(db, &body, voteTxHash, participants, height, endTime)
+	}
+	if err != nil {
+		return nil, code, err
+	}
+	for _, out := range participants {
+		res.Eligible += out.Value
+	}
+	decideOutcome(res, body.VoteType, body.VotingParams, now < revealEndTime || res.Hidden)
+	return res, CodeOk, nil
+}
+
+// getVotingParticipants возвращает выходы транзакции создания голосования и цепочки транзакций дополнения
+func getVotingParticipants(
+	db *Database,
+	t *golosovaniepb.Transaction,
+	body *golosovaniepb.TxBody,
+	height int64,
+) ([]*golosovaniepb.Output, uint32, error) {
+	participants := body.Outputs
+	for {
+		var err error
+		t, err = db.GetTxByHashLinkAtHeight(t.Hash, height)
+		if err != nil {
+			return nil, CodeDatabaseFailed, err
+		}
+		if t == nil {
+			return participants, CodeOk, nil
+		}
+		var chainBody golosovaniepb.TxBody
+		err = proto.Unmarshal(t.TxBody, &chainBody)
+		if err != nil {
+			return nil, CodeParseErr, err
+		}
+		participants = append(participants, chainBody.Outputs...)
+	}
+}
+
+// getTransferVoteResult подсчитывает голоса, переведенные кандидатам до окончания голосования
+func getTransferVoteResult(
+	db *Database,
+	body *golosovaniepb.TxBody,
+	voteTxHash []byte,
+	participants []*golosovaniepb.Output,
+	height int64,
+	endTime uint64,
+) (*golosovaniepb.ResponseVoteResult, uint32, error) {
+	utxos, err := db.GetUTXOSByTypeValueAtHeight(voteTxHash, height)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
 	}
 
 	//блок подсчета голосов надо разбить на разные функции, так как
 	//в при некоторых случаях один и тот же избиратель может голосовать дважды,
 	//может голосовать "за", "против", "воздержался" и т.п.
 	//так же может происходит сортировка результатов гослования в зависимости от его типа
-	result := make(map[[PkeySize]byte]uint32, 0)
+	result := make(map[[PkeySize]byte]uint64, 0)
+	// переведенные голоса без учета типа голосования, для явки
+	cast := make(map[[PkeySize]byte]uint64, 0)
 	for _, utxo := range utxos {
 		pkey := SliceToPkey(utxo.ReceiverSpendPkey)
-		_, contains := result[pkey]
-		if bytes.Equal(utxo.ValueType, t.Hash) && utxo.Timestamp < endTime {
-			if contains {
-				result[pkey] += getVoteValue(utxo.Value, body.VoteType)
-			} else {
-				result[pkey] = getVoteValue(utxo.Value, body.VoteType)
-			}
+		if bytes.Equal(utxo.ValueType, voteTxHash) && utxo.Timestamp < endTime {
+			result[pkey] += getVoteValue(utxo.Value, body.VoteType)
+			cast[pkey] += utxo.Value
 		}
 	}
 
-	for {
-		for _, out := range body.Outputs {
-			pkey := SliceToPkey(out.ReceiverSpendPkey)
-			_, contains := result[pkey]
-			if contains {
-				delete(result, pkey)
-			}
-		}
-		t, err = db.GetTxByHashLink(t.Hash)
+	// голоса, которые остались у участников, еще не отданы
+	for _, out := range participants {
+		pkey := SliceToPkey(out.ReceiverSpendPkey)
+		delete(result, pkey)
+		delete(cast, pkey)
+	}
+	// в анонимном голосовании голоса участников переведены на одноразовые адреса
+	initTx, err := db.GetInitVoteTxAtHeight(voteTxHash, height)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
+	}
+	if initTx != nil {
+		var initBody golosovaniepb.TxBody
+		err = proto.Unmarshal(initTx.TxBody, &initBody)
 		if err != nil {
-			return CodeDatabaseFailed, err, nil
+			return nil, CodeParseErr, err
 		}
-		if t == nil {
-			break
+		for _, out := range initBody.Outputs {
+			delete(result, SliceToPkey(out.ReceiverSpendPkey))
+			delete(cast, SliceToPkey(out.ReceiverSpendPkey))
 		}
 	}
 
-	//create bytes result
-	fmt.Println("result voting with voteTxHash: ", req.VoteTxHash)
-	var res golosovaniepb.ResponseVoteResult
-	for pkey, val := range result {
-		fmt.Println(pkey, val)
-		res.Res = append(
-			res.Res,
-			&golosovaniepb.ResponseVoteResult_PkeyValue{
-				Pkey:  pkey[:],
-				Value: val,
-			},
-		)
+	res := golosovaniepb.ResponseVoteResult{Res: pkeyValues(result)}
+	res.Elected = topCandidate(res.Res)
+	for _, value := range cast {
+		res.Turnout += value
 	}
+	return &res, CodeOk, nil
+}
 
-	return CodeOk, nil, &golosovaniepb.Response{
-		Data: &golosovaniepb.Response_VoteResult{VoteResult: &res},
+// getBallotVoteResult подсчитывает бюллетени, отправленные до окончания голосования
+// или фазы раскрытия голосования commit-reveal, после окончания голосования - с делегированными голосами.
+// Выходы в урну потратить нельзя, поэтому замененными бывают только бюллетени голосования с изменением голоса
+func getBallotVoteResult(
+	db *Database,
+	body *golosovaniepb.TxBody,
+	voteTxHash []byte,
+	timeStart uint64,
+	height int64,
+	ended bool,
+) (*golosovaniepb.ResponseVoteResult, uint32, error) {
+	counted, delegated, err := CountedBallotsAtHeight(db, voteTxHash, body, timeStart, height, ended)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
+	}
+	var res *golosovaniepb.ResponseVoteResult
+	var code uint32
+	if body.VoteType == EncryptedVoteType {
+		res, code, err = getEncryptedVoteResult(db, body, voteTxHash, counted, height)
+		if err != nil {
+			return nil, code, err
+		}
+	} else {
+		res = TallyBallots(counted, body.VoteType, body.VotingParams)
+	}
+	if len(delegated) != 0 {
+		res.Delegated = pkeyValues(delegated)
+	}
+	return res, CodeOk, nil
+}
+
+// getEncryptedVoteResult возвращает результат, расшифрованный в блоке, в котором валидаторы опубликовали
+// достаточно частичных расшифровок (см. saveEncryptedTallies), до этого результат скрыт
+func getEncryptedVoteResult(
+	db *Database,
+	body *golosovaniepb.TxBody,
+	voteTxHash []byte,
+	counted []*VoteBallot,
+	height int64,
+) (*golosovaniepb.ResponseVoteResult, uint32, error) {
+	var res golosovaniepb.ResponseVoteResult
+	for _, b := range counted {
+		res.Turnout += b.Value
+	}
+	options := body.VotingParams.GetOptions()
+	counts := make(map[[PkeySize]byte]uint64, len(options))
+	for _, option := range options {
+		counts[SliceToPkey(option)] = 0
+	}
+	if len(counted) == 0 {
+		// расшифровывать нечего, все варианты получили 0 голосов
+		res.Res = pkeyValues(counts)
+		return &res, CodeOk, nil
+	}
+	tally, err := db.GetEncryptedTallyAtHeight(voteTxHash, height)
+	if err != nil {
+		return nil, CodeDatabaseFailed, err
+	}
+	if tally == nil || tally.Undecryptable {
+		res.Hidden = true
+		res.Undecryptable = tally.GetUndecryptable()
+		return &res, CodeOk, nil
+	}
+	if len(tally.Counts) != len(options) {
+		return nil, CodeParseErr, fmt.Errorf("tally of voting %x has %v counts", voteTxHash, len(tally.Counts))
+	}
+	for i, option := range options {
+		counts[SliceToPkey(option)] = tally.Counts[i]
 	}
+	res.Res = pkeyValues(counts)
+	res.Elected = topCandidate(res.Res)
+	return &res, CodeOk, nil
 }
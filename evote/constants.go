@@ -1,6 +1,11 @@
 package evote
 
-//err codes
+import (
+	"math"
+	"time"
+)
+
+// err codes
 const (
 	OK              = 0
 	ErrTransSize    = -1
@@ -54,9 +59,39 @@ const (
 	CodeNotSupported
 	CodeValueTypeInvalid
 	CodeInvalidVoteParticipantNumber
+	CodeInvalidHeight
+	CodeVotingClosed
+	CodeHashLinkTargetNotFound
+	CodeVoteChainInvalid
+	CodeVoteChainFork
+	CodeVoteChainSenderMismatch
+	CodeVotingStarted
+	CodeAnonymousVoteNotInitialized
+	CodeInitVoteInvalidInputs
+	CodeInitVoteValuesMismatch
+	CodeInvalidVotersSumPkey
+	CodeInitVoteNotSignedByValidator
+	CodeUnknownVoteType
+	CodeBallotInvalid
+	CodeUnexpectedBallot
+	CodeInvalidVotingParams
+	CodeUnexpectedVotingParams
+	CodeInvalidVotingMetadata
+	CodeUnexpectedVotingMetadata
+	CodeInvalidCommitment
+	CodeUnexpectedCommitment
+	CodeInvalidReveal
+	CodeVoteResultHidden
+	CodeInvalidKeyShare
+	CodeInvalidPartialDecryption
+	CodeElectionKeyNotReady
+	CodeInvalidDelegation
+	CodeInsufficientFee
+	CodeUnexpectedCoinbase
+	CodeNonCanonicalTx
 )
 
-//size consts
+// size consts
 const (
 	Int32Size       = 4
 	SigSize         = 64 + 1 // one bit for pkey recovery
@@ -68,26 +103,112 @@ const (
 	TransInputSize  = HashSize + Int32Size
 	MinTransSize    = Int32Size*4 + TransOutputSize + SigSize + HashSize*2
 	MinBlockSize    = HashSize*2 + PkeySize + Int32Size*3
-	MaxTxSize       = 512 * 1024 // 0.5 mb
+	MaxTxSize       = 512 * 1024 // 0.5 mb, наибольший max_tx_size в genesis
 	RewardCoins     = 1000
 	UtxoSize        = HashSize*2 + 4*Int32Size + PkeySize
+	// MinTxFee - минимальная комиссия транзакции, которая тратит монеты, проверяется только в CheckTx
+	MinTxFee = 1
+	// MaxVoteTxOutputs - число участников в одной транзакции голосования, чтобы она поместилась в MaxTxSize,
+	// остальные участники переносятся в транзакции дополнения голосования
+	MaxVoteTxOutputs = 8192
+	// MaxBallotCandidates - максимальная длина списка кандидатов в бюллетене
+	MaxBallotCandidates = 64
+	// MaxQuestions - максимальное число вопросов в голосовании MultiQuestionVoteType
+	MaxQuestions = 16
+	// размеры описания голосования в байтах
+	MaxVotingTitleSize       = 256
+	MaxVotingDescriptionSize = 16 * 1024
+	MaxCandidateLabelSize    = 256
+	MaxCandidateLabels       = MaxBallotCandidates * MaxQuestions
+	// MaxRevealDuration - максимальная длительность фазы раскрытия голосования commit-reveal в секундах
+	MaxRevealDuration = 7 * 24 * 60 * 60
+	SaltSize          = HashSize
+	// MaxEncryptedTally - наибольшая сумма голосов за вариант зашифрованного голосования, которую можно
+	// расшифровать. Логарифм ищется за sqrt(MaxEncryptedTally) сложений точек с таблицей того же размера
+	MaxEncryptedTally = 1 << 32
+)
+
+// tx broadcasting by validators, see Broadcaster
+const (
+	BroadcastRetryDelay   = 3 * time.Second // delay after the first failure, doubled after every next one
+	BroadcastMaxDelay     = 5 * time.Minute
+	BroadcastConfirmDelay = 30 * time.Second // sent tx is checked to be in a block after this delay
+	BroadcastMaxAttempts  = 100
+)
+
+// state sync snapshots, see snapshot.go
+const (
+	SnapshotFormat     = 2
+	SnapshotInterval   = 1000    // snapshot is taken every SnapshotInterval blocks
+	SnapshotChunkSize  = 4 << 20 // bytes in a snapshot chunk, tendermint accepts chunks up to 16 MB
+	SnapshotBlockBatch = 100     // blocks read from DB at once, when a snapshot is taken
+	SnapshotKeyBatch   = 10000   // state keys in a snapshot record
+	// SnapshotMaxRecordSize - наибольшая длина записи снапшота, запись может занимать несколько частей
+	SnapshotMaxRecordSize = 64 << 20
+	SnapshotKeepRecent    = 2
+)
+
+// light client verification of validator responses, see light_client.go
+const (
+	LightTrustingPeriod  = 7 * 24 * time.Hour // trusted header must be not older than this
+	LightBlockWaitRounds = 10
+	LightBlockWaitDelay  = time.Second
 )
 
 const (
 	OneVoteType     = 0x01
 	PercentVoteType = 0x02
+	// RankedVoteType - голос содержит бюллетень с кандидатами в порядке предпочтения,
+	// победитель определяется по системе instant-runoff
+	RankedVoteType = 0x03
+	// ApprovalVoteType - бюллетень содержит любое число одобренных кандидатов, каждый получает весь вес бюллетеня
+	ApprovalVoteType = 0x04
+	// StvVoteType - single transferable vote: бюллетень как в RankedVoteType,
+	// избирается VotingParams.seats кандидатов
+	StvVoteType = 0x05
+	// MultiQuestionVoteType - голосование из нескольких вопросов VotingParams.questions со своими типами,
+	// бюллетень содержит ответы на все вопросы
+	MultiQuestionVoteType = 0x06
+	// EncryptedVoteType - бюллетень содержит зашифрованный общим ключом валидаторов выбор одного
+	// из VotingParams.options, расшифровывается только сумма бюллетеней (см. elgamal.go)
+	EncryptedVoteType = 0x07
 )
 
+// IsKnownVoteType returns true, if voteType is one of the vote types above
+func IsKnownVoteType(voteType uint32) bool {
+	switch voteType {
+	case OneVoteType, PercentVoteType, RankedVoteType, ApprovalVoteType, StvVoteType, MultiQuestionVoteType,
+		EncryptedVoteType:
+		return true
+	}
+	return false
+}
+
+// IsBallotVoteType returns true, if vote txs of the voting with voteType carry ballots
+func IsBallotVoteType(voteType uint32) bool {
+	switch voteType {
+	case RankedVoteType, ApprovalVoteType, StvVoteType, MultiQuestionVoteType, EncryptedVoteType:
+		return true
+	}
+	return false
+}
+
 var ZeroArrayHash = [HashSize]byte{}
 
 var ZeroArraySig = [SigSize]byte{}
 
 var ZeroArrayPkey = [PkeySize]byte{}
 
-//database fields
+// BallotBoxPkey - получатель голосов с бюллетенем. Это не точка кривой, поэтому такие выходы нельзя потратить
+var BallotBoxPkey = ZeroArrayPkey
+
+// database fields
 const (
 	DbName     = "blockchain"
 	DbUser     = "blockchain"
 	DbPassword = "ffff"
 	DbHost     = "localhost"
 )
+
+// DbHeightLatest is the height of block in database queries at height, that means the latest state
+const DbHeightLatest = math.MaxInt32
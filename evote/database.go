@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	_ "github.com/lib/pq"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
 	"strconv"
 	"strings"
 )
@@ -32,13 +33,9 @@ func getTxInputsAndOutputs(
 ) {
 	var inputs []*golosovaniepb.Input
 	var outputs []*golosovaniepb.Output
+	// хеш предыдущей транзакции хранится во входе, потому что её может не быть в бд после восстановления из снапшота
 	inputRows, err := dbTx.Query(
-		`SELECT txs.txHash, ins.outputIndex 
-		FROM (
-		    SELECT input.prevTxId, input.outputIndex, input.Index FROM input WHERE input.txId = $1
-		    ) as ins 
-		JOIN transaction as txs ON ins.prevTxId = txs.txId 
-		ORDER BY ins.Index`,
+		`SELECT input.prevTxHash, input.outputIndex FROM input WHERE input.txId = $1 ORDER BY input.Index`,
 		txId,
 	)
 	if err != nil {
@@ -68,15 +65,13 @@ func getTxInputsAndOutputs(
 		return nil, nil, err
 	}
 	for outputRows.Next() {
-		var output golosovaniepb.Output
+		var value uint64
 		var receiverSpendPkey, receiverScanPkey []byte
-		err := outputRows.Scan(&output.Value, &receiverSpendPkey, &receiverScanPkey)
+		err := outputRows.Scan(&value, &receiverSpendPkey, &receiverScanPkey)
 		if err != nil {
 			return nil, nil, err
 		}
-		output.ReceiverSpendPkey = receiverSpendPkey
-		output.ReceiverScanPkey = receiverScanPkey
-		outputs = append(outputs, &output)
+		outputs = append(outputs, newOutput(receiverSpendPkey, receiverScanPkey, value))
 	}
 	err = outputRows.Close()
 	if err != nil {
@@ -85,7 +80,175 @@ func getTxInputsAndOutputs(
 	return inputs, outputs, nil
 }
 
-// rows MUST be with columns: txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature
+// getTxParts заполняет части транзакции, которые хранятся в отдельных таблицах: входы, выходы, описание голосования.
+// Не откатывает транзу при ошибке
+func getTxParts(dbTx *sql.Tx, txId int, txBody *golosovaniepb.TxBody) error {
+	var err error
+	txBody.Inputs, txBody.Outputs, err = getTxInputsAndOutputs(dbTx, txId)
+	if err != nil {
+		return err
+	}
+	txBody.VotingMetadata, err = getVotingMetadata(dbTx, txId)
+	return err
+}
+
+// getVotingMetadata возвращает nil, если у транзакции нет описания голосования.
+// Не откатывает транзу при ошибке
+func getVotingMetadata(dbTx *sql.Tx, txId int) (*golosovaniepb.VotingMetadata, error) {
+	var metadata golosovaniepb.VotingMetadata
+	err := dbTx.QueryRow(
+		`SELECT title, description, documentHash FROM voting_metadata WHERE txId = $1`,
+		txId,
+	).Scan(&metadata.Title, &metadata.Description, &metadata.DocumentHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dbTx.Query(
+		`SELECT pkey, label FROM candidate_label WHERE txId = $1 ORDER BY index`,
+		txId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var candidate golosovaniepb.CandidateLabel
+		err = rows.Scan(&candidate.Pkey, &candidate.Label)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		metadata.Candidates = append(metadata.Candidates, &candidate)
+	}
+	return &metadata, rows.Close()
+}
+
+// saveVotingMetadata не откатывает транзу при ошибке
+func saveVotingMetadata(dbTx *sql.Tx, txId int, metadata *golosovaniepb.VotingMetadata) error {
+	_, err := dbTx.Exec(
+		`INSERT INTO voting_metadata(txId, title, description, documentHash) VALUES ($1, $2, $3, $4)`,
+		txId,
+		metadata.Title,
+		metadata.Description,
+		metadata.DocumentHash,
+	)
+	if err != nil {
+		return err
+	}
+	for i, candidate := range metadata.Candidates {
+		_, err = dbTx.Exec(
+			`INSERT INTO candidate_label(txId, index, pkey, label) VALUES ($1, $2, $3, $4)`,
+			txId,
+			i,
+			candidate.Pkey,
+			candidate.Label,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txColumns - столбцы таблицы transaction, которые читает scanTx
+const txColumns = `transaction.txId, transaction.txHash, transaction.hashLink, transaction.valueType, 
+	transaction.voteType, transaction.duration, transaction.senderEphemeralPkey, transaction.votersSumPkey, 
+	transaction.ballot, transaction.votingParams, transaction.commitment, transaction.salt, 
+	transaction.keyShare, transaction.partialDecryption, transaction.delegation, transaction.coinbase, 
+	transaction.signature`
+
+// scanTx читает строку со столбцами txColumns, за которыми следуют столбцы extra.
+// Входы и выходы транзакции не заполняются
+func scanTx(rows *sql.Rows, extra ...interface{}) (txId int, txBody *golosovaniepb.TxBody, hash, sig []byte, err error) {
+	var ballot, votingParams, keyShare, partialDecryption, delegation, coinbase []byte
+	txBody = new(golosovaniepb.TxBody)
+	dest := []interface{}{
+		&txId,
+		&hash,
+		&txBody.HashLink,
+		&txBody.ValueType,
+		&txBody.VoteType,
+		&txBody.Duration,
+		&txBody.SenderEphemeralPkey,
+		&txBody.VotersSumPkey,
+		&ballot,
+		&votingParams,
+		&txBody.Commitment,
+		&txBody.Salt,
+		&keyShare,
+		&partialDecryption,
+		&delegation,
+		&coinbase,
+		&sig,
+	}
+	err = rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return 0, nil, nil, nil, err
+	}
+	if ballot != nil {
+		txBody.Ballot = new(golosovaniepb.Ballot)
+		err = proto.Unmarshal(ballot, txBody.Ballot)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+	if votingParams != nil {
+		txBody.VotingParams = new(golosovaniepb.VotingParams)
+		err = proto.Unmarshal(votingParams, txBody.VotingParams)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+	if keyShare != nil {
+		txBody.KeyShare = new(golosovaniepb.KeyShare)
+		err = proto.Unmarshal(keyShare, txBody.KeyShare)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+	if partialDecryption != nil {
+		txBody.PartialDecryption = new(golosovaniepb.PartialDecryption)
+		err = proto.Unmarshal(partialDecryption, txBody.PartialDecryption)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+	if delegation != nil {
+		txBody.Delegation = new(golosovaniepb.Delegation)
+		err = proto.Unmarshal(delegation, txBody.Delegation)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+	if coinbase != nil {
+		txBody.Coinbase = new(golosovaniepb.Coinbase)
+		err = proto.Unmarshal(coinbase, txBody.Coinbase)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+	return txId, txBody, hash, sig, nil
+}
+
+// marshalOptional сериализует необязательное поле транзакции, nil сохраняется в бд как NULL.
+// Пустое сообщение сохраняется как пустая строка, иначе при чтении из бд поле потеряется и хэш транзакции изменится
+func marshalOptional(m proto.Message, isNil bool) ([]byte, error) {
+	if isNil {
+		return nil, nil
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// rows MUST be with columns txColumns
 // функция не делает RollBack при ошибке
 func scanTxs(txRows *sql.Rows, dbTx *sql.Tx) ([]*golosovaniepb.Transaction, error) {
 	txIds := make([]int, 0)
@@ -93,29 +256,12 @@ func scanTxs(txRows *sql.Rows, dbTx *sql.Tx) ([]*golosovaniepb.Transaction, erro
 	hashes := make([][]byte, 0)
 	sigs := make([][]byte, 0)
 	for txRows.Next() {
-		var txHash, hashLink, valueType, senderEphemeralPkey, votersSumPkey, signature []byte
-		var txBody golosovaniepb.TxBody
-		var txId int
-		err := txRows.Scan(
-			&txId,
-			&txHash,
-			&hashLink,
-			&valueType,
-			&txBody.VoteType,
-			&txBody.Duration,
-			&senderEphemeralPkey,
-			&votersSumPkey,
-			&signature,
-		)
+		txId, txBody, txHash, signature, err := scanTx(txRows)
 		if err != nil {
 			return nil, err
 		}
-		txBody.HashLink = hashLink
-		txBody.ValueType = valueType
-		txBody.SenderEphemeralPkey = senderEphemeralPkey
-		txBody.VotersSumPkey = votersSumPkey
 		txIds = append(txIds, txId)
-		txs = append(txs, &txBody)
+		txs = append(txs, txBody)
 		hashes = append(hashes, txHash)
 		sigs = append(sigs, signature)
 	}
@@ -125,7 +271,7 @@ func scanTxs(txRows *sql.Rows, dbTx *sql.Tx) ([]*golosovaniepb.Transaction, erro
 	}
 	txsFull := make([]*golosovaniepb.Transaction, len(txs))
 	for i, tx := range txs {
-		tx.Inputs, tx.Outputs, err = getTxInputsAndOutputs(dbTx, txIds[i])
+		err = getTxParts(dbTx, txIds[i], tx)
 		if err != nil {
 			return nil, err
 		}
@@ -157,6 +303,13 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
+// Clear удаляет все блоки и транзакции, используется при неудачном восстановлении из снапшота
+func (d *Database) Clear() error {
+	_, err := d.db.Exec(`TRUNCATE block, transaction, input, output, voting_metadata, candidate_label, state_node, state_root, 
+		genesis, encrypted_tally, vote_result, state_key RESTART IDENTITY`)
+	return err
+}
+
 func (d *Database) SaveNextBlock(block *golosovaniepb.Block) error {
 	dbTx, err := d.db.Begin()
 	if err != nil {
@@ -190,18 +343,68 @@ func (d *Database) SaveNextBlock(block *golosovaniepb.Block) error {
 		_ = dbTx.Rollback()
 		return err
 	}
-	for i, tx := range block.Transactions {
+	err = saveBlockTxs(dbTx, blockId, block.BlockHeader.Timestamp, block.Transactions)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	err = dbTx.Commit()
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	return err
+}
+
+// saveBlockTxs сохраняет транзакции блока blockId с временем timestamp вместе с входами и выходами
+func saveBlockTxs(dbTx *sql.Tx, blockId int, timestamp uint64, txs []*golosovaniepb.Transaction) error {
+	for i, tx := range txs {
 		var txBody golosovaniepb.TxBody
-		err = proto.Unmarshal(tx.TxBody, &txBody)
+		err := proto.Unmarshal(tx.TxBody, &txBody)
+		if err != nil {
+			return err
+		}
+		ballot, err := marshalOptional(txBody.Ballot, txBody.Ballot == nil)
+		if err != nil {
+			return err
+		}
+		votingParams, err := marshalOptional(txBody.VotingParams, txBody.VotingParams == nil)
+		if err != nil {
+			return err
+		}
+		keyShare, err := marshalOptional(txBody.KeyShare, txBody.KeyShare == nil)
+		if err != nil {
+			return err
+		}
+		partialDecryption, err := marshalOptional(txBody.PartialDecryption, txBody.PartialDecryption == nil)
+		if err != nil {
+			return err
+		}
+		delegation, err := marshalOptional(txBody.Delegation, txBody.Delegation == nil)
 		if err != nil {
-			_ = dbTx.Rollback()
 			return err
 		}
+		coinbase, err := marshalOptional(txBody.Coinbase, txBody.Coinbase == nil)
+		if err != nil {
+			return err
+		}
+		// время окончания хранится с индексом, чтобы не перебирать все голосования в каждом блоке
+		var revealEndTime interface{}
+		if txBody.VoteType != 0 {
+			revealEndTime = RevealEndTime(VotingEndTime(timestamp, txBody.Duration), txBody.VotingParams)
+		}
+		// coinbase транзакция не подписана
+		sig := tx.Sig
+		if sig == nil {
+			sig = []byte{}
+		}
 		var txId int
 		err = dbTx.QueryRow(
 			`INSERT INTO 
-			Transaction (blockId, index, txHash, hashLink, valueType, voteType, duration, senderEphemeralPkey, votersSumPkey, signature) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			Transaction (blockId, index, txHash, hashLink, valueType, voteType, duration, senderEphemeralPkey, votersSumPkey, 
+			             ballot, votingParams, commitment, salt, keyShare, partialDecryption, delegation, delegationVoting,
+			             revealEndTime, coinbase, signature) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
 			RETURNING txId`,
 			blockId,
 			i,
@@ -212,23 +415,37 @@ func (d *Database) SaveNextBlock(block *golosovaniepb.Block) error {
 			txBody.Duration,
 			txBody.SenderEphemeralPkey,
 			txBody.VotersSumPkey,
-			tx.Sig,
+			ballot,
+			votingParams,
+			txBody.Commitment,
+			txBody.Salt,
+			keyShare,
+			partialDecryption,
+			delegation,
+			txBody.Delegation.GetVotingHash(),
+			revealEndTime,
+			coinbase,
+			sig,
 		).Scan(&txId)
 		if err != nil {
-			_ = dbTx.Rollback()
 			return err
 		}
+		if txBody.VotingMetadata != nil {
+			err = saveVotingMetadata(dbTx, txId, txBody.VotingMetadata)
+			if err != nil {
+				return err
+			}
+		}
 		for inputIndex, input := range txBody.Inputs {
 			_, err = dbTx.Exec(
-				`INSERT INTO input(txId, index, prevTxId, outputIndex) 
-						SELECT $1, $2, transaction.txId, $4 FROM transaction WHERE transaction.txHash = $3`,
+				`INSERT INTO input(txId, index, prevTxHash, prevTxId, outputIndex) 
+				VALUES ($1, $2, $3, (SELECT transaction.txId FROM transaction WHERE transaction.txHash = $3), $4)`,
 				txId,
 				inputIndex,
 				input.PrevTxHash,
 				input.OutputIndex,
 			)
 			if err != nil {
-				_ = dbTx.Rollback()
 				return err
 			}
 		}
@@ -242,32 +459,58 @@ func (d *Database) SaveNextBlock(block *golosovaniepb.Block) error {
 				output.ReceiverScanPkey,
 			)
 			if err != nil {
-				_ = dbTx.Rollback()
 				return err
 			}
 		}
 	}
-	err = dbTx.Commit()
+	return nil
+}
+
+// GetLastBlockHashAndHeight возвращает хеш и высоту последнего сохраненного блока.
+// Высоты в бд считаются с нуля, у genesis блока высота -1. Если блоков еще нет, вернется nil, -1, nil
+func (d *Database) GetLastBlockHashAndHeight() ([]byte, int64, error) {
+	var blockHash []byte
+	var height int64
+	err := d.db.QueryRow(
+		`SELECT block.blockHash, block.height FROM block ORDER BY block.height DESC LIMIT 1`,
+	).Scan(&blockHash, &height)
+	if err == sql.ErrNoRows {
+		return nil, -1, nil
+	}
 	if err != nil {
-		_ = dbTx.Rollback()
-		return err
+		return nil, -1, err
 	}
-	return err
+	return blockHash, height, nil
 }
 
 // GetBlocksByHashes функция может не найти некоторые блоки (если их нет), но ошибки не будет
 // так же эти блоке не появятся в возращаемом срезе
 func (d *Database) GetBlocksByHashes(blockHashes [][]byte) ([]*golosovaniepb.Block, error) {
-	dbTx, err := d.db.Begin()
-	if err != nil {
-		return nil, err
-	}
 	blocksQuery := "SELECT b.blockId, b.blockHash, pb.blockHash, b.merkleTree, b.proposerPkey, b.Timestamp " +
 		"FROM block as b LEFT JOIN block as pb ON pb.blockId = b.prevBlockId WHERE b.blockHash in (" + buildInLookup(1, len(blockHashes)+1) + ")"
 	blockQueryArgs := make([]interface{}, len(blockHashes))
 	for i := range blockHashes {
 		blockQueryArgs[i] = blockHashes[i]
 	}
+	return d.getBlocks(blocksQuery, blockQueryArgs)
+}
+
+// GetBlocksByHeights возвращает блоки с высотами из [from, to) в порядке возрастания высоты
+func (d *Database) GetBlocksByHeights(from, to int64) ([]*golosovaniepb.Block, error) {
+	return d.getBlocks(
+		`SELECT b.blockId, b.blockHash, pb.blockHash, b.merkleTree, b.proposerPkey, b.Timestamp
+		FROM block as b LEFT JOIN block as pb ON pb.blockId = b.prevBlockId 
+		WHERE b.height >= $1 and b.height < $2 ORDER BY b.height`,
+		[]interface{}{from, to},
+	)
+}
+
+// rows MUST be with columns: blockId, blockHash, prevBlockHash, merkleTree, proposerPkey, timestamp
+func (d *Database) getBlocks(blocksQuery string, blockQueryArgs []interface{}) ([]*golosovaniepb.Block, error) {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
 	blockRows, err := dbTx.Query(blocksQuery, blockQueryArgs...)
 	if err != nil {
 		_ = dbTx.Rollback()
@@ -303,7 +546,7 @@ func (d *Database) GetBlocksByHashes(blockHashes [][]byte) ([]*golosovaniepb.Blo
 
 	for i, b := range blocks {
 		txRows, err := dbTx.Query(
-			`SELECT txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature 
+			`SELECT `+txColumns+` 
 			FROM Transaction WHERE Transaction.blockId = $1 ORDER BY Transaction.Index`,
 			blockIds[i],
 		)
@@ -362,7 +605,7 @@ func (d *Database) GetTxsByHashes(txHashes [][]byte) ([]*golosovaniepb.Transacti
 	if err != nil {
 		return nil, err
 	}
-	txQuery := "SELECT txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature " +
+	txQuery := "SELECT " + txColumns + " " +
 		"FROM Transaction WHERE Transaction.txHash in (" + buildInLookup(1, len(txHashes)+1) + ")"
 	txQueryArgs := make([]interface{}, len(txHashes))
 	for i := range txHashes {
@@ -387,18 +630,22 @@ func (d *Database) GetTxsByHashes(txHashes [][]byte) ([]*golosovaniepb.Transacti
 }
 
 func (d *Database) GetTxAndTimeByHash(hash []byte) (*golosovaniepb.Transaction, uint64, error) {
+	return d.GetTxAndTimeByHashAtHeight(hash, DbHeightLatest)
+}
+
+// GetTxAndTimeByHashAtHeight ищет транзакцию только в блоках с высотой не больше height
+func (d *Database) GetTxAndTimeByHashAtHeight(hash []byte, height int64) (*golosovaniepb.Transaction, uint64, error) {
 	dbTx, err := d.db.Begin()
 	if err != nil {
 		return nil, 0, err
 	}
-	// txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature
 	txRow, err := dbTx.Query(
 		`
-		SELECT block.timestamp, transaction.txid, transaction.txHash, transaction.hashLink, transaction.valueType,
-		       transaction.voteType, transaction.duration, transaction.senderEphemeralPkey,
-		       transaction.votersSumPkey, transaction.signature
-		FROM block, transaction WHERE Transaction.txHash = $1 and block.blockId = transaction.blockId`,
+		SELECT `+txColumns+`, block.timestamp
+		FROM block, transaction WHERE Transaction.txHash = $1 and block.blockId = transaction.blockId 
+			and block.height <= $2`,
 		hash,
+		height,
 	)
 
 	if err != nil {
@@ -408,31 +655,19 @@ func (d *Database) GetTxAndTimeByHash(hash []byte) (*golosovaniepb.Transaction,
 
 	if txRow.Next() {
 		var timestamp uint64
-		var txId int
-		var txBody golosovaniepb.TxBody
 		var tx golosovaniepb.Transaction
-		err := txRow.Scan(
-			&timestamp,
-			&txId,
-			&tx.Hash,
-			&txBody.HashLink,
-			&txBody.ValueType,
-			&txBody.VoteType,
-			&txBody.Duration,
-			&txBody.SenderEphemeralPkey,
-			&txBody.VotersSumPkey,
-			&tx.Sig,
-		)
+		txId, txBody, txHash, sig, err := scanTx(txRow, &timestamp)
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, 0, err
 		}
+		tx.Hash, tx.Sig = txHash, sig
 		err = txRow.Close()
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, 0, err
 		}
-		txBody.Inputs, txBody.Outputs, err = getTxInputsAndOutputs(dbTx, txId)
+		err = getTxParts(dbTx, txId, txBody)
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, 0, err
@@ -442,7 +677,7 @@ func (d *Database) GetTxAndTimeByHash(hash []byte) (*golosovaniepb.Transaction,
 			_ = dbTx.Rollback()
 			return nil, 0, err
 		}
-		bodyBytes, err := proto.Marshal(&txBody)
+		bodyBytes, err := proto.Marshal(txBody)
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, 0, err
@@ -456,17 +691,41 @@ func (d *Database) GetTxAndTimeByHash(hash []byte) (*golosovaniepb.Transaction,
 }
 
 func (d *Database) GetTxByHashLink(hashLink []byte) (*golosovaniepb.Transaction, error) {
+	return d.GetTxByHashLinkAtHeight(hashLink, DbHeightLatest)
+}
+
+// GetTxByHashLinkAtHeight ищет транзакцию только в блоках с высотой не больше height
+func (d *Database) GetTxByHashLinkAtHeight(hashLink []byte, height int64) (*golosovaniepb.Transaction, error) {
+	return d.getTxByConditionAtHeight("transaction.hashLink = $1", hashLink, height)
+}
+
+func (d *Database) GetInitVoteTx(votingHash []byte) (*golosovaniepb.Transaction, error) {
+	return d.GetInitVoteTxAtHeight(votingHash, DbHeightLatest)
+}
+
+// GetInitVoteTxAtHeight ищет транзакцию инициализации анонимного голосования votingHash
+// только в блоках с высотой не больше height
+func (d *Database) GetInitVoteTxAtHeight(votingHash []byte, height int64) (*golosovaniepb.Transaction, error) {
+	return d.getTxByConditionAtHeight(
+		"transaction.valueType = $1 and transaction.senderEphemeralPkey IS NOT NULL",
+		votingHash,
+		height,
+	)
+}
+
+// getTxByConditionAtHeight возвращает первую транзакцию, удовлетворяющую condition с параметром $1 = key
+func (d *Database) getTxByConditionAtHeight(condition string, key []byte, height int64) (*golosovaniepb.Transaction, error) {
 	dbTx, err := d.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	txRow, err := dbTx.Query(
 		`
-		SELECT transaction.txid, transaction.txHash, transaction.hashLink, transaction.valueType,
-		       transaction.voteType, transaction.duration, transaction.senderEphemeralPkey,
-		       transaction.votersSumPkey, transaction.signature
-		FROM transaction WHERE transaction.hashLink = $1`,
-		hashLink,
+		SELECT `+txColumns+`
+		FROM transaction JOIN block ON block.blockId = transaction.blockId 
+		WHERE `+condition+` and block.height <= $2`,
+		key,
+		height,
 	)
 
 	if err != nil {
@@ -475,30 +734,19 @@ func (d *Database) GetTxByHashLink(hashLink []byte) (*golosovaniepb.Transaction,
 	}
 
 	if txRow.Next() {
-		var txId int
-		var txBody golosovaniepb.TxBody
 		var tx golosovaniepb.Transaction
-		err := txRow.Scan(
-			&txId,
-			&tx.Hash,
-			&txBody.HashLink,
-			&txBody.ValueType,
-			&txBody.VoteType,
-			&txBody.Duration,
-			&txBody.SenderEphemeralPkey,
-			&txBody.VotersSumPkey,
-			&tx.Sig,
-		)
+		txId, txBody, txHash, sig, err := scanTx(txRow)
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, err
 		}
+		tx.Hash, tx.Sig = txHash, sig
 		err = txRow.Close()
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, err
 		}
-		txBody.Inputs, txBody.Outputs, err = getTxInputsAndOutputs(dbTx, txId)
+		err = getTxParts(dbTx, txId, txBody)
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, err
@@ -508,7 +756,7 @@ func (d *Database) GetTxByHashLink(hashLink []byte) (*golosovaniepb.Transaction,
 			_ = dbTx.Rollback()
 			return nil, err
 		}
-		bodyBytes, err := proto.Marshal(&txBody)
+		bodyBytes, err := proto.Marshal(txBody)
 		if err != nil {
 			_ = dbTx.Rollback()
 			return nil, err
@@ -526,16 +774,15 @@ func (d *Database) GetTxsByPubKey(pkey []byte) ([]*golosovaniepb.Transaction, er
 	if err != nil {
 		return nil, err
 	}
-	// txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature
 	txRows, err := dbTx.Query(
-		`SELECT txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature 
+		`SELECT `+txColumns+` 
 		FROM Transaction 
 		WHERE EXISTS(
 	   		SELECT * FROM output 
 	   		WHERE output.txid = transaction.txid and (output.receiverSpendPkey = $1 or output.receiverScanPkey = $1)
 		) 
 		union 
-		SELECT txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature
+		SELECT `+txColumns+`
 		FROM Transaction 
 		WHERE Transaction.txid IN (
 	   		SELECT isspentbytx from output 
@@ -601,7 +848,22 @@ func (d *Database) getUTXOS(sqlQuery string, params []interface{}) ([]*golosovan
 	return utxos, nil
 }
 
+// unspentAtHeight условие того, что выход был непотрачен после блока с высотой $2,
+// потративший выход блок может быть новее. Выход, потраченный транзакцией, которой нет в бд после восстановления
+// из снапшота, потрачен на любой высоте. Условие не бывает null, поэтому его можно отрицать
+const unspentAtHeight = `(NOT output.isSpent OR coalesce((
+		SELECT spendingBlock.height FROM transaction as spendingTx 
+		JOIN block as spendingBlock ON spendingBlock.blockId = spendingTx.blockId
+		WHERE spendingTx.txId = output.isSpentByTx
+	) > $2, false))`
+
+// GetUTXOSByPkey выходы упорядочены по хешу транзакции и индексу, чтобы ответ клиенту не зависел от узла
 func (d *Database) GetUTXOSByPkey(pkey []byte) ([]*golosovaniepb.Utxo, error) {
+	return d.GetUTXOSByPkeyAtHeight(pkey, DbHeightLatest)
+}
+
+// GetUTXOSByPkeyAtHeight возвращает выходы, которые были непотрачены после блока с высотой height
+func (d *Database) GetUTXOSByPkeyAtHeight(pkey []byte, height int64) ([]*golosovaniepb.Utxo, error) {
 	// условие transaction.voteType = 0 нужно, чтобы не выбрать valueType транзы создания голосования, который всегда нулевой
 	// valueType выходов транзы создания голосования - её хеш, для этого нужен второй селект после union
 	return d.getUTXOS(
@@ -610,15 +872,18 @@ func (d *Database) GetUTXOSByPkey(pkey []byte) ([]*golosovaniepb.Utxo, error) {
 			from block,transaction, output  
 			WHERE transaction.voteType = 0 
 				and transaction.txid = output.txid and block.blockId = transaction.blockId 
-				and (output.receiverSpendPkey = $1 or output.receiverScanPkey = $1) and output.isspentbytx is null
+				and (output.receiverSpendPkey = $1 or output.receiverScanPkey = $1) 
+				and block.height <= $2 and `+unspentAtHeight+`
 			UNION
 			SELECT block.timestamp, transaction.txHash, transaction.txHash, 
 			output.Index, output.Value, output.receiverSpendPkey, output.receiverScanPkey
 			from block, transaction, output
 			WHERE transaction.voteType != 0 
 				and transaction.txid = output.txid and block.blockId = transaction.blockId
-				and (output.receiverSpendPkey = $1 or output.receiverScanPkey = $1) and output.isspentbytx is null`,
-		[]interface{}{pkey},
+				and (output.receiverSpendPkey = $1 or output.receiverScanPkey = $1) 
+				and block.height <= $2 and `+unspentAtHeight+`
+			ORDER BY 3, 4`,
+		[]interface{}{pkey, height},
 	)
 }
 
@@ -629,27 +894,46 @@ func (d *Database) GetUtxosByTxHash(txHash []byte) ([]*golosovaniepb.Utxo, error
 			from block,transaction, output  
 			WHERE transaction.voteType = 0 
 				and transaction.txid = output.txid and block.blockId = transaction.blockId 
-				and transaction.txHash = $1 and output.isspentbytx is null
+				and transaction.txHash = $1 and NOT output.isSpent
 			UNION
 			SELECT block.timestamp, transaction.txHash, transaction.txHash, 
 			output.Index, output.Value, output.receiverSpendPkey, output.receiverScanPkey
 			from block, transaction, output
 			WHERE transaction.voteType != 0 
 				and transaction.txid = output.txid and block.blockId = transaction.blockId
-				and transaction.txHash = $1 and output.isspentbytx is null`,
+				and transaction.txHash = $1 and NOT output.isSpent`,
 		[]interface{}{txHash},
 	)
 }
 
 func (d *Database) GetUTXOSByTypeValue(typeValue []byte) ([]*golosovaniepb.Utxo, error) {
+	return d.GetUTXOSByTypeValueAtHeight(typeValue, DbHeightLatest)
+}
+
+// GetUTXOSByTypeValueAtHeight возвращает выходы, которые были непотрачены после блока с высотой height
+func (d *Database) GetUTXOSByTypeValueAtHeight(typeValue []byte, height int64) ([]*golosovaniepb.Utxo, error) {
 	return d.getUTXOS(
 		`SELECT block.timestamp, transaction.valueType, transaction.txHash, 
 			output.Index, output.Value, output.receiverSpendPkey, output.receiverScanPkey 
 			from block,transaction, output  
 			WHERE transaction.voteType = 0 
 				and transaction.txid = output.txid and block.blockId = transaction.blockId 
-				and transaction.valueType = $1 and output.isspentbytx is null`,
-		[]interface{}{typeValue},
+				and transaction.valueType = $1 and block.height <= $2 and `+unspentAtHeight,
+		[]interface{}{typeValue, height},
+	)
+}
+
+// GetParticipantUtxosAtHeight возвращает выходы участников голосования votingHash из транзакции создания
+// голосования и транзакций дополнения, которые были непотрачены после блока с высотой height
+func (d *Database) GetParticipantUtxosAtHeight(votingHash []byte, height int64) ([]*golosovaniepb.Utxo, error) {
+	return d.getUTXOS(
+		`SELECT block.timestamp, transaction.valueType, transaction.txHash, 
+			output.Index, output.Value, output.receiverSpendPkey, output.receiverScanPkey 
+			from block,transaction, output  
+			WHERE transaction.txid = output.txid and block.blockId = transaction.blockId 
+				and (transaction.txHash = $1 or transaction.valueType = $1 and length(transaction.hashLink) != 0)
+				and block.height <= $2 and `+unspentAtHeight,
+		[]interface{}{votingHash, height},
 	)
 }
 
@@ -704,7 +988,7 @@ func (d *Database) GetBlockAfter(blockHash []byte) (*golosovaniepb.Block, error)
 		return nil, err
 	}
 	txRows, err := dbTx.Query(
-		`SELECT txId, txHash, hashLink, valueType, voteType, duration,  senderEphemeralPkey, votersSumPkey, signature 
+		`SELECT `+txColumns+` 
 			FROM Transaction WHERE Transaction.blockId = $1 ORDER BY Transaction.Index`,
 		blockId,
 	)
@@ -730,3 +1014,1000 @@ func (d *Database) GetBlockAfter(blockHash []byte) (*golosovaniepb.Block, error)
 	}
 	return &block, nil
 }
+
+// GetUnspentUtxo возвращает выход index транзакции txHash, nil, nil если выход потрачен или его нет
+func (d *Database) GetUnspentUtxo(txHash []byte, index uint32) (*golosovaniepb.Utxo, error) {
+	utxos, err := d.getUTXOS(
+		`SELECT block.timestamp, 
+				CASE WHEN transaction.voteType = 0 THEN transaction.valueType ELSE transaction.txHash END, 
+				transaction.txHash, output.Index, output.Value, output.receiverSpendPkey, output.receiverScanPkey
+			FROM block, transaction, output
+			WHERE transaction.txid = output.txid and block.blockId = transaction.blockId
+				and transaction.txHash = $1 and output.index = $2 and NOT output.isSpent`,
+		[]interface{}{txHash, index},
+	)
+	if err != nil || len(utxos) == 0 {
+		return nil, err
+	}
+	return utxos[0], nil
+}
+
+// GetStateNode возвращает nil, nil, если узла нет
+func (d *Database) GetStateNode(hash []byte) (*StateNode, error) {
+	var n StateNode
+	err := d.db.QueryRow(
+		`SELECT leftHash, rightHash, keyHash, valueHash FROM state_node WHERE nodeHash = $1`,
+		hash,
+	).Scan(&n.LeftHash, &n.RightHash, &n.KeyHash, &n.ValueHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// SaveState сохраняет новые узлы дерева состояния и его корень после блока blockHash
+func (d *Database) SaveState(blockHash []byte, root []byte, nodes []*StateNode) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = saveStateNodes(dbTx, nodes)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	res, err := dbTx.Exec(
+		`INSERT INTO state_root (blockId, rootHash) SELECT block.blockId, $2 FROM block WHERE block.blockHash = $1`,
+		blockHash,
+		root,
+	)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil || inserted != 1 {
+		_ = dbTx.Rollback()
+		return fmt.Errorf("cannot save state root for block %x, err %v", blockHash, err)
+	}
+	return dbTx.Commit()
+}
+
+func saveStateNodes(dbTx *sql.Tx, nodes []*StateNode) error {
+	for _, n := range nodes {
+		_, err := dbTx.Exec(
+			`INSERT INTO state_node (nodeHash, leftHash, rightHash, keyHash, valueHash) 
+				VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+			n.Hash(),
+			n.LeftHash,
+			n.RightHash,
+			n.KeyHash,
+			n.ValueHash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveGenesis сохраняет app state из genesis, genesis блок с высотой -1, если он есть,
+// и узлы дерева состояния до genesis блока. Всё сохраняется в одной транзакции бд
+func (d *Database) SaveGenesis(appState []byte, block *golosovaniepb.Block, nodes []*StateNode) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`INSERT INTO genesis (appState) VALUES ($1)`, appState)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	if block != nil {
+		var blockId int
+		err = dbTx.QueryRow(
+			`INSERT INTO block (height, blockHash, MerkleTree, proposerPkey, Timestamp) 
+				VALUES (-1, $1, $2, $3, $4) RETURNING blockId`,
+			block.Hash,
+			block.BlockHeader.MerkleTree,
+			block.BlockHeader.ProposerPkey,
+			block.BlockHeader.Timestamp,
+		).Scan(&blockId)
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+		err = saveBlockTxs(dbTx, blockId, block.BlockHeader.Timestamp, block.Transactions)
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+	}
+	err = saveStateNodes(dbTx, nodes)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// GetGenesis возвращает app state из genesis, nil если цепочка создана без него
+func (d *Database) GetGenesis() ([]byte, error) {
+	var appState []byte
+	err := d.db.QueryRow(`SELECT genesis.appState FROM genesis`).Scan(&appState)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return appState, err
+}
+
+// GetGenesisBlockHash возвращает хеш genesis блока, nil если в genesis нет транзакций
+func (d *Database) GetGenesisBlockHash() ([]byte, error) {
+	var blockHash []byte
+	err := d.db.QueryRow(`SELECT block.blockHash FROM block WHERE block.height = -1`).Scan(&blockHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return blockHash, err
+}
+
+// GetStateRoot возвращает корень дерева состояния после блока blockHash,
+// nil, nil если состояние для блока не сохранено
+func (d *Database) GetStateRoot(blockHash []byte) ([]byte, error) {
+	var root []byte
+	err := d.db.QueryRow(
+		`SELECT state_root.rootHash FROM state_root JOIN block ON block.blockId = state_root.blockId 
+			WHERE block.blockHash = $1`,
+		blockHash,
+	).Scan(&root)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (d *Database) getByteColumn(sqlQuery string, args ...interface{}) ([][]byte, error) {
+	rows, err := d.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0)
+	for rows.Next() {
+		var value []byte
+		err = rows.Scan(&value)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Close()
+}
+
+// GetAllOutpoints возвращает хеш транзакции и индекс в big endian всех выходов, включая потраченные
+func (d *Database) GetAllOutpoints() ([][]byte, error) {
+	return d.getByteColumn(
+		`SELECT transaction.txHash || int4send(output.index) FROM output 
+			JOIN transaction ON transaction.txId = output.txId`,
+	)
+}
+
+func (d *Database) GetAllTxHashes() ([][]byte, error) {
+	return d.getByteColumn(`SELECT txHash FROM transaction`)
+}
+
+// GetVotingsEndedInBlock возвращает хеши голосований, у которых между предыдущим блоком и блоком blockHash
+// закончилось голосование или фаза раскрытия голосования commit-reveal, если она есть
+func (d *Database) GetVotingsEndedInBlock(blockHash []byte) ([][]byte, error) {
+	return d.getByteColumn(
+		`SELECT transaction.txHash FROM transaction 
+			JOIN block b ON b.blockHash = $1
+			LEFT JOIN block p ON p.blockId = b.prevBlockId
+			WHERE transaction.revealEndTime <= b.timestamp and transaction.revealEndTime > coalesce(p.timestamp, 0)`,
+		blockHash,
+	)
+}
+
+// GetBlockTimestampAtHeight возвращает время последнего блока с высотой не больше height, 0 если блоков нет
+func (d *Database) GetBlockTimestampAtHeight(height int64) (uint64, error) {
+	var timestamp uint64
+	err := d.db.QueryRow(
+		`SELECT timestamp FROM block WHERE height <= $1 ORDER BY height DESC LIMIT 1`,
+		height,
+	).Scan(&timestamp)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return timestamp, nil
+}
+
+// GetStateRootByHeight возвращает корень дерева состояния после блока с высотой height,
+// nil, nil если состояние для блока не сохранено
+func (d *Database) GetStateRootByHeight(height int64) ([]byte, error) {
+	var root []byte
+	err := d.db.QueryRow(
+		`SELECT state_root.rootHash FROM state_root JOIN block ON block.blockId = state_root.blockId 
+			WHERE block.height = $1`,
+		height,
+	).Scan(&root)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// GetTxSenderPkey возвращает владельца выходов, потраченных транзакцией, nil для coinbase транзакций
+func (d *Database) GetTxSenderPkey(txHash []byte) ([]byte, error) {
+	var pkey []byte
+	err := d.db.QueryRow(
+		`SELECT output.receiverSpendPkey FROM output JOIN transaction ON output.isSpentByTx = transaction.txId 
+			WHERE transaction.txHash = $1 LIMIT 1`,
+		txHash,
+	).Scan(&pkey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pkey, nil
+}
+
+// HasVotes проверяет, есть ли транзакции с голосами голосования votingHash, кроме транзакций дополнения голосования
+// и транзакций валидаторов зашифрованного голосования
+func (d *Database) HasVotes(votingHash []byte) (bool, error) {
+	var exists bool
+	err := d.db.QueryRow(
+		`SELECT EXISTS(
+			SELECT 1 FROM transaction 
+			WHERE transaction.valueType = $1 and transaction.voteType = 0 
+				and (transaction.hashLink IS NULL or length(transaction.hashLink) = 0)
+				and transaction.keyShare IS NULL and transaction.partialDecryption IS NULL
+		)`,
+		votingHash,
+	).Scan(&exists)
+	return exists, err
+}
+
+// GetTxProposerPkey возвращает ключ создателя блока, в который попала транзакция, nil если транзакции нет
+func (d *Database) GetTxProposerPkey(txHash []byte) ([]byte, error) {
+	var pkey []byte
+	err := d.db.QueryRow(
+		`SELECT block.proposerPkey FROM block JOIN transaction ON block.blockId = transaction.blockId 
+			WHERE transaction.txHash = $1`,
+		txHash,
+	).Scan(&pkey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pkey, nil
+}
+
+func (d *Database) GetBallots(votingHash []byte) ([]*VoteBallot, error) {
+	return d.GetBallotsAtHeight(votingHash, DbHeightLatest)
+}
+
+// GetBallotsAtHeight возвращает бюллетени голосования votingHash в порядке их попадания в блокчейн
+// только из блоков с высотой не больше height
+func (d *Database) GetBallotsAtHeight(votingHash []byte, height int64) ([]*VoteBallot, error) {
+	rows, err := d.db.Query(
+		`SELECT transaction.txHash, transaction.ballot, block.timestamp,
+				(SELECT sum(output.value) FROM output WHERE output.txId = transaction.txId),
+				(SELECT spent.receiverSpendPkey FROM output spent WHERE spent.isSpentByTx = transaction.txId LIMIT 1),
+				NOT EXISTS(SELECT 1 FROM output WHERE output.txId = transaction.txId and `+unspentAtHeight+`)
+			FROM transaction JOIN block ON block.blockId = transaction.blockId 
+			WHERE transaction.valueType = $1 and transaction.ballot IS NOT NULL and block.height <= $2
+			ORDER BY block.height, transaction.index`,
+		votingHash,
+		height,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ballots := make([]*VoteBallot, 0)
+	for rows.Next() {
+		var b VoteBallot
+		var ballot []byte
+		err = rows.Scan(&b.TxHash, &ballot, &b.Timestamp, &b.Value, &b.Sender, &b.Replaced)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		b.Ballot = new(golosovaniepb.Ballot)
+		err = proto.Unmarshal(ballot, b.Ballot)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		ballots = append(ballots, &b)
+	}
+	return ballots, rows.Close()
+}
+
+func (d *Database) GetKeyShares(votingHash []byte) ([]*golosovaniepb.KeyShare, error) {
+	return d.GetKeySharesAtHeight(votingHash, DbHeightLatest)
+}
+
+// GetKeySharesAtHeight возвращает вклады валидаторов в ключ голосования votingHash в порядке их попадания в блокчейн
+// только из блоков с высотой не больше height
+func (d *Database) GetKeySharesAtHeight(votingHash []byte, height int64) ([]*golosovaniepb.KeyShare, error) {
+	columns, err := d.getVotingColumnAtHeight("keyShare", votingHash, height)
+	if err != nil {
+		return nil, err
+	}
+	keyShares := make([]*golosovaniepb.KeyShare, len(columns))
+	for i, column := range columns {
+		keyShares[i] = new(golosovaniepb.KeyShare)
+		err = proto.Unmarshal(column, keyShares[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keyShares, nil
+}
+
+// GetKeyShareTxsAtHeight возвращает транзакции с вкладами валидаторов в ключ голосования votingHash
+// в порядке их попадания в блокчейн только из блоков с высотой не больше height
+func (d *Database) GetKeyShareTxsAtHeight(votingHash []byte, height int64) ([]*golosovaniepb.Transaction, error) {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	txRows, err := dbTx.Query(
+		`SELECT `+txColumns+`
+			FROM transaction JOIN block ON block.blockId = transaction.blockId 
+			WHERE transaction.valueType = $1 and transaction.keyShare IS NOT NULL and block.height <= $2
+			ORDER BY block.height, transaction.index`,
+		votingHash,
+		height,
+	)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return nil, err
+	}
+	txs, err := scanTxs(txRows, dbTx)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return nil, err
+	}
+	err = dbTx.Commit()
+	if err != nil {
+		_ = dbTx.Rollback()
+		return nil, err
+	}
+	return txs, nil
+}
+
+func (d *Database) GetPartialDecryptions(votingHash []byte) ([]*golosovaniepb.PartialDecryption, error) {
+	return d.GetPartialDecryptionsAtHeight(votingHash, DbHeightLatest)
+}
+
+// GetPartialDecryptionsAtHeight возвращает частичные расшифровки результата голосования votingHash
+// в порядке их попадания в блокчейн только из блоков с высотой не больше height
+func (d *Database) GetPartialDecryptionsAtHeight(votingHash []byte, height int64) ([]*golosovaniepb.PartialDecryption, error) {
+	columns, err := d.getVotingColumnAtHeight("partialDecryption", votingHash, height)
+	if err != nil {
+		return nil, err
+	}
+	decryptions := make([]*golosovaniepb.PartialDecryption, len(columns))
+	for i, column := range columns {
+		decryptions[i] = new(golosovaniepb.PartialDecryption)
+		err = proto.Unmarshal(column, decryptions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decryptions, nil
+}
+
+// SaveEncryptedTally сохраняет расшифрованный результат голосования votingHash, вычисленный в блоке blockHash.
+// Повторное сохранение ничего не меняет: результат вычисляется один раз
+func (d *Database) SaveEncryptedTally(votingHash []byte, blockHash []byte, tally *golosovaniepb.EncryptedTally) error {
+	tallyBytes, err := proto.Marshal(tally)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(
+		`INSERT INTO encrypted_tally (votingTxId, blockId, tally)
+			SELECT transaction.txId, block.blockId, $3 FROM transaction, block 
+			WHERE transaction.txHash = $1 and block.blockHash = $2
+			ON CONFLICT DO NOTHING`,
+		votingHash,
+		blockHash,
+		tallyBytes,
+	)
+	return err
+}
+
+// GetEncryptedTallyAtHeight возвращает результат голосования votingHash, если он расшифрован в блоке
+// с высотой не больше height, иначе nil, nil
+func (d *Database) GetEncryptedTallyAtHeight(votingHash []byte, height int64) (*golosovaniepb.EncryptedTally, error) {
+	var tallyBytes []byte
+	err := d.db.QueryRow(
+		`SELECT encrypted_tally.tally FROM encrypted_tally 
+			JOIN transaction ON transaction.txId = encrypted_tally.votingTxId
+			JOIN block ON block.blockId = encrypted_tally.blockId
+			WHERE transaction.txHash = $1 and block.height <= $2`,
+		votingHash,
+		height,
+	).Scan(&tallyBytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tally golosovaniepb.EncryptedTally
+	err = proto.Unmarshal(tallyBytes, &tally)
+	if err != nil {
+		return nil, err
+	}
+	return &tally, nil
+}
+
+// SaveVoteResult сохраняет окончательный результат голосования votingHash, подсчитанный в блоке blockHash.
+// Повторное сохранение ничего не меняет: результат подсчитывается один раз
+func (d *Database) SaveVoteResult(votingHash []byte, blockHash []byte, res *golosovaniepb.ResponseVoteResult) error {
+	resBytes, err := proto.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(
+		`INSERT INTO vote_result (votingTxId, blockId, result)
+			SELECT transaction.txId, block.blockId, $3 FROM transaction, block 
+			WHERE transaction.txHash = $1 and block.blockHash = $2
+			ON CONFLICT DO NOTHING`,
+		votingHash,
+		blockHash,
+		resBytes,
+	)
+	return err
+}
+
+// GetSavedVoteResultAtHeight возвращает окончательный результат голосования votingHash, если он подсчитан
+// в блоке с высотой не больше height, иначе nil, nil
+func (d *Database) GetSavedVoteResultAtHeight(votingHash []byte, height int64) (*golosovaniepb.ResponseVoteResult, error) {
+	var resBytes []byte
+	err := d.db.QueryRow(
+		`SELECT vote_result.result FROM vote_result 
+			JOIN transaction ON transaction.txId = vote_result.votingTxId
+			JOIN block ON block.blockId = vote_result.blockId
+			WHERE transaction.txHash = $1 and block.height <= $2`,
+		votingHash,
+		height,
+	).Scan(&resBytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res golosovaniepb.ResponseVoteResult
+	err = proto.Unmarshal(resBytes, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GetVotingsDecryptedInBlock возвращает хеши голосований, результат которых расшифрован в блоке blockHash
+func (d *Database) GetVotingsDecryptedInBlock(blockHash []byte) ([][]byte, error) {
+	return d.getByteColumn(
+		`SELECT transaction.txHash FROM encrypted_tally 
+			JOIN transaction ON transaction.txId = encrypted_tally.votingTxId
+			JOIN block ON block.blockId = encrypted_tally.blockId
+			WHERE block.blockHash = $1`,
+		blockHash,
+	)
+}
+
+// GetVotingsCountedInBlock возвращает хеши голосований, окончательный результат которых подсчитан в блоке blockHash
+func (d *Database) GetVotingsCountedInBlock(blockHash []byte) ([][]byte, error) {
+	return d.getByteColumn(
+		`SELECT transaction.txHash FROM vote_result 
+			JOIN transaction ON transaction.txId = vote_result.votingTxId
+			JOIN block ON block.blockId = vote_result.blockId
+			WHERE block.blockHash = $1`,
+		blockHash,
+	)
+}
+
+// GetAllCountedVotings возвращает хеши голосований с окончательным результатом
+func (d *Database) GetAllCountedVotings() ([][]byte, error) {
+	return d.getByteColumn(
+		`SELECT transaction.txHash FROM vote_result JOIN transaction ON transaction.txId = vote_result.votingTxId`,
+	)
+}
+
+// GetEncryptedTalliesAtHeight возвращает строки encrypted_tally из блоков с высотой не больше height для снапшота
+func (d *Database) GetEncryptedTalliesAtHeight(height int64) ([]*golosovaniepb.SnapshotVotingRow, error) {
+	return d.getVotingRowsAtHeight("encrypted_tally", "tally", height)
+}
+
+// GetVoteResultsAtHeight возвращает строки vote_result из блоков с высотой не больше height для снапшота
+func (d *Database) GetVoteResultsAtHeight(height int64) ([]*golosovaniepb.SnapshotVotingRow, error) {
+	return d.getVotingRowsAtHeight("vote_result", "result", height)
+}
+
+// getVotingRowsAtHeight возвращает строки таблицы результатов голосований в порядке голосований
+func (d *Database) getVotingRowsAtHeight(table, column string, height int64) ([]*golosovaniepb.SnapshotVotingRow, error) {
+	rows, err := d.db.Query(
+		`SELECT transaction.txHash, block.blockHash, `+table+`.`+column+` FROM `+table+` 
+			JOIN transaction ON transaction.txId = `+table+`.votingTxId
+			JOIN block ON block.blockId = `+table+`.blockId
+			WHERE block.height <= $1 ORDER BY `+table+`.votingTxId`,
+		height,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*golosovaniepb.SnapshotVotingRow, 0)
+	for rows.Next() {
+		var row golosovaniepb.SnapshotVotingRow
+		err = rows.Scan(&row.VotingHash, &row.BlockHash, &row.Data)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		res = append(res, &row)
+	}
+	return res, rows.Close()
+}
+
+// snapshotTxsQuery выбирает транзакции из блоков с высотой не больше $1, без которых нельзя продолжить цепочку
+// после восстановления из снапшота: транзакции с непотраченными выходами, транзакции создания голосований,
+// все транзакции голосований без окончательного результата, постоянные делегирования и транзакции,
+// выходы которых они потратили, по ним определяется отправитель
+var snapshotTxsQuery = `WITH visible AS (
+		SELECT transaction.* FROM transaction JOIN block ON block.blockId = transaction.blockId WHERE block.height <= $1
+	), open_voting AS (
+		SELECT visible.txHash FROM visible WHERE visible.voteType != 0 and NOT EXISTS(
+			SELECT 1 FROM vote_result JOIN block ON block.blockId = vote_result.blockId 
+			WHERE vote_result.votingTxId = visible.txId and block.height <= $1
+		)
+	), live AS (
+		SELECT visible.txId FROM visible 
+		WHERE visible.voteType != 0 
+			or visible.valueType IN (SELECT open_voting.txHash FROM open_voting)
+			or visible.delegation IS NOT NULL and (visible.delegationVoting IS NULL 
+				or length(visible.delegationVoting) = 0 
+				or visible.delegationVoting IN (SELECT open_voting.txHash FROM open_voting))
+			or EXISTS(SELECT 1 FROM output WHERE output.txId = visible.txId and ` + unspentAtHeightParam("$1") + `)
+	)
+	SELECT live.txId FROM live 
+	UNION SELECT input.prevTxId FROM input JOIN live ON live.txId = input.txId WHERE input.prevTxId IS NOT NULL`
+
+// unspentAtHeightParam возвращает условие unspentAtHeight с высотой в параметре param
+func unspentAtHeightParam(param string) string {
+	return strings.ReplaceAll(unspentAtHeight, "$2", param)
+}
+
+// ForEachSnapshotBlock передает fn в порядке высоты блоки снапшота на высоте height: блоки с транзакциями
+// из snapshotTxsQuery или с вычисленными в них результатами голосований и блок с высотой height.
+// В блоках только транзакции снапшота, в блоке с высотой height - все транзакции
+func (d *Database) ForEachSnapshotBlock(height int64, fn func(b *golosovaniepb.SnapshotBlock) error) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`CREATE TEMP TABLE snapshot_tx (txId integer primary key) ON COMMIT DROP`)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	_, err = dbTx.Exec(`INSERT INTO snapshot_tx `+snapshotTxsQuery, height)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	// блоки читаются частями, в транзакции бд нельзя читать транзакции блока, пока открыт список блоков
+	after := int64(-1)
+	for {
+		blocks, blockIds, err := getSnapshotBlocks(dbTx, height, after)
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+		for i, b := range blocks {
+			txRows, err := dbTx.Query(
+				`SELECT `+txColumns+` FROM transaction 
+					WHERE transaction.blockId = $1 
+						and ($2 or transaction.txId IN (SELECT snapshot_tx.txId FROM snapshot_tx))
+					ORDER BY transaction.index`,
+				blockIds[i],
+				b.Height == height,
+			)
+			if err != nil {
+				_ = dbTx.Rollback()
+				return err
+			}
+			b.Block.Transactions, err = scanTxs(txRows, dbTx)
+			if err != nil {
+				_ = dbTx.Rollback()
+				return err
+			}
+			err = fn(b)
+			if err != nil {
+				_ = dbTx.Rollback()
+				return err
+			}
+		}
+		after = blocks[len(blocks)-1].Height
+	}
+	return dbTx.Commit()
+}
+
+// getSnapshotBlocks возвращает заголовки не больше SnapshotBlockBatch блоков снапшота с высотой больше after.
+// Не откатывает транзу при ошибке
+func getSnapshotBlocks(dbTx *sql.Tx, height int64, after int64) ([]*golosovaniepb.SnapshotBlock, []int, error) {
+	rows, err := dbTx.Query(
+		`SELECT b.blockId, b.height, b.blockHash, pb.blockHash, b.merkleTree, b.proposerPkey, b.timestamp 
+			FROM block as b LEFT JOIN block as pb ON pb.blockId = b.prevBlockId 
+			WHERE b.height > $2 and b.height <= $1 and (b.height = $1 
+				or EXISTS(SELECT 1 FROM transaction JOIN snapshot_tx ON snapshot_tx.txId = transaction.txId 
+					WHERE transaction.blockId = b.blockId)
+				or EXISTS(SELECT 1 FROM vote_result WHERE vote_result.blockId = b.blockId)
+				or EXISTS(SELECT 1 FROM encrypted_tally WHERE encrypted_tally.blockId = b.blockId))
+			ORDER BY b.height LIMIT $3`,
+		height,
+		after,
+		SnapshotBlockBatch,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	blocks := make([]*golosovaniepb.SnapshotBlock, 0)
+	blockIds := make([]int, 0)
+	for rows.Next() {
+		var header golosovaniepb.BlockHeader
+		block := golosovaniepb.SnapshotBlock{Block: &golosovaniepb.Block{BlockHeader: &header}}
+		var blockId int
+		err = rows.Scan(
+			&blockId,
+			&block.Height,
+			&block.Block.Hash,
+			&header.PrevBlockHash,
+			&header.MerkleTree,
+			&header.ProposerPkey,
+			&header.Timestamp,
+		)
+		if err != nil {
+			_ = rows.Close()
+			return nil, nil, err
+		}
+		blocks = append(blocks, &block)
+		blockIds = append(blockIds, blockId)
+	}
+	return blocks, blockIds, rows.Close()
+}
+
+// ForEachStateKeyAtHeight передает fn хеши транзакций и потраченные выходы после блока с высотой height
+// в виде ключей состояния, включая ключи, сохраненные при восстановлении из снапшота
+func (d *Database) ForEachStateKeyAtHeight(height int64, fn func(key []byte) error) error {
+	err := d.forEachRow(
+		func(rows *sql.Rows) error {
+			var txHash []byte
+			err := rows.Scan(&txHash)
+			if err != nil {
+				return err
+			}
+			return fn(StateKey(StateKeyTx, txHash))
+		},
+		`SELECT transaction.txHash FROM transaction JOIN block ON block.blockId = transaction.blockId 
+			WHERE block.height <= $1`,
+		height,
+	)
+	if err != nil {
+		return err
+	}
+	err = d.forEachRow(
+		func(rows *sql.Rows) error {
+			var txHash []byte
+			var index uint32
+			err := rows.Scan(&txHash, &index)
+			if err != nil {
+				return err
+			}
+			return fn(UtxoStateKey(txHash, index))
+		},
+		`SELECT transaction.txHash, output.index FROM output 
+			JOIN transaction ON transaction.txId = output.txId 
+			JOIN block ON block.blockId = transaction.blockId 
+			WHERE block.height <= $1 and NOT `+unspentAtHeightParam("$1"),
+		height,
+	)
+	if err != nil {
+		return err
+	}
+	return d.forEachRow(
+		func(rows *sql.Rows) error {
+			var key []byte
+			err := rows.Scan(&key)
+			if err != nil {
+				return err
+			}
+			return fn(key)
+		},
+		`SELECT state_key.key FROM state_key`,
+	)
+}
+
+// forEachRow вызывает fn для каждой строки запроса, не загружая все строки в память
+func (d *Database) forEachRow(fn func(rows *sql.Rows) error, sqlQuery string, args ...interface{}) error {
+	rows, err := d.db.Query(sqlQuery, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		err = fn(rows)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+	}
+	return rows.Close()
+}
+
+// GetStateKeys возвращает ключи состояния, сохраненные при восстановлении из снапшота
+func (d *Database) GetStateKeys() ([][]byte, error) {
+	return d.getByteColumn(`SELECT state_key.key FROM state_key`)
+}
+
+// SaveSnapshotBlock сохраняет блок снапшота с высотой height. Предыдущих блоков цепочки может не быть в бд,
+// поэтому блок ссылается на последний сохраненный блок
+func (d *Database) SaveSnapshotBlock(height int64, block *golosovaniepb.Block) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	var blockId int
+	err = dbTx.QueryRow(
+		`INSERT INTO block (height, blockHash, prevBlockId, merkleTree, proposerPkey, timestamp) 
+			VALUES ($1, $2, (SELECT block.blockId FROM block ORDER BY block.height DESC LIMIT 1), $3, $4, $5) 
+			RETURNING blockId`,
+		height,
+		block.Hash,
+		block.BlockHeader.MerkleTree,
+		block.BlockHeader.ProposerPkey,
+		block.BlockHeader.Timestamp,
+	).Scan(&blockId)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	err = saveBlockTxs(dbTx, blockId, block.BlockHeader.Timestamp, block.Transactions)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// SaveStateKeys сохраняет ключи состояния из снапшота: хеши транзакций и потраченные выходы.
+// Выходы, которые есть в бд, помечаются потраченными, ключи сохраненных транзакций и выходов не сохраняются
+func (d *Database) SaveStateKeys(txHashes [][]byte, spent []outpoint) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, txHash := range txHashes {
+		_, err = dbTx.Exec(
+			`INSERT INTO state_key (key) 
+				SELECT $1 WHERE NOT EXISTS(SELECT 1 FROM transaction WHERE transaction.txHash = $2) 
+				ON CONFLICT DO NOTHING`,
+			StateKey(StateKeyTx, txHash),
+			txHash,
+		)
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+	}
+	for _, op := range spent {
+		res, err := dbTx.Exec(
+			`UPDATE output SET isSpent = true FROM transaction 
+				WHERE transaction.txId = output.txId and transaction.txHash = $1 and output.index = $2`,
+			op.txHash[:],
+			op.index,
+		)
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+		if updated != 0 {
+			continue
+		}
+		_, err = dbTx.Exec(
+			`INSERT INTO state_key (key) VALUES ($1) ON CONFLICT DO NOTHING`,
+			UtxoStateKey(op.txHash[:], op.index),
+		)
+		if err != nil {
+			_ = dbTx.Rollback()
+			return err
+		}
+	}
+	return dbTx.Commit()
+}
+
+// SaveSnapshotChunk сохраняет часть снапшота, части сохраняются раньше самого снапшота
+func (d *Database) SaveSnapshotChunk(height uint64, index uint32, chunk []byte) error {
+	_, err := d.db.Exec(
+		`INSERT INTO snapshot_chunk (height, index, chunk) VALUES ($1, $2, $3) 
+			ON CONFLICT (height, index) DO UPDATE SET chunk = excluded.chunk`,
+		height,
+		index,
+		chunk,
+	)
+	return err
+}
+
+// SaveSnapshot сохраняет снапшот, все части которого уже сохранены,
+// и удаляет старые снапшоты, кроме keepRecent последних
+func (d *Database) SaveSnapshot(snapshot *abcitypes.Snapshot, keepRecent int) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(
+		`INSERT INTO snapshot (height, format, chunks, hash, metadata) VALUES ($1, $2, $3, $4, $5) 
+			ON CONFLICT DO NOTHING`,
+		snapshot.Height,
+		snapshot.Format,
+		snapshot.Chunks,
+		snapshot.Hash,
+		snapshot.Metadata,
+	)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	_, err = dbTx.Exec(
+		`DELETE FROM snapshot WHERE snapshot.height NOT IN (
+			SELECT recent.height FROM snapshot as recent ORDER BY recent.height DESC LIMIT $1
+		)`,
+		keepRecent,
+	)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	_, err = dbTx.Exec(`DELETE FROM snapshot_chunk WHERE snapshot_chunk.height NOT IN (SELECT height FROM snapshot)`)
+	if err != nil {
+		_ = dbTx.Rollback()
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// GetSnapshots возвращает сохраненные снапшоты в порядке высоты
+func (d *Database) GetSnapshots() ([]*abcitypes.Snapshot, error) {
+	rows, err := d.db.Query(
+		`SELECT snapshot.height, snapshot.format, snapshot.chunks, snapshot.hash, snapshot.metadata 
+			FROM snapshot ORDER BY snapshot.height`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*abcitypes.Snapshot, 0)
+	for rows.Next() {
+		var snapshot abcitypes.Snapshot
+		err = rows.Scan(&snapshot.Height, &snapshot.Format, &snapshot.Chunks, &snapshot.Hash, &snapshot.Metadata)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, rows.Close()
+}
+
+// GetSnapshotChunk возвращает часть index снапшота на высоте height, nil, nil если её нет
+func (d *Database) GetSnapshotChunk(height uint64, index uint32) ([]byte, error) {
+	var chunk []byte
+	err := d.db.QueryRow(
+		`SELECT snapshot_chunk.chunk FROM snapshot_chunk 
+			WHERE snapshot_chunk.height = $1 and snapshot_chunk.index = $2`,
+		height,
+		index,
+	).Scan(&chunk)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return chunk, err
+}
+
+// getVotingColumnAtHeight возвращает непустые значения столбца column транзакций с valueType = votingHash
+// в порядке попадания транзакций в блокчейн
+func (d *Database) getVotingColumnAtHeight(column string, votingHash []byte, height int64) ([][]byte, error) {
+	rows, err := d.db.Query(
+		`SELECT transaction.`+column+`
+			FROM transaction JOIN block ON block.blockId = transaction.blockId 
+			WHERE transaction.valueType = $1 and transaction.`+column+` IS NOT NULL and block.height <= $2
+			ORDER BY block.height, transaction.index`,
+		votingHash,
+		height,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, 0)
+	for rows.Next() {
+		var value []byte
+		err = rows.Scan(&value)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		res = append(res, value)
+	}
+	return res, rows.Close()
+}
+
+// GetDelegationsAtHeight возвращает постоянные делегирования и делегирования голосования votingHash из блоков
+// с временем меньше before и высотой не больше height в порядке их попадания в блокчейн
+func (d *Database) GetDelegationsAtHeight(
+	votingHash []byte,
+	before uint64,
+	height int64,
+) (standing, voting []*golosovaniepb.Delegation, err error) {
+	rows, err := d.db.Query(
+		`SELECT transaction.delegation, transaction.delegationVoting
+			FROM transaction JOIN block ON block.blockId = transaction.blockId 
+			WHERE transaction.delegation IS NOT NULL and transaction.voteType = 0 
+				and (transaction.hashLink IS NULL or length(transaction.hashLink) = 0)
+				and (transaction.valueType IS NULL or length(transaction.valueType) = 0)
+				and (transaction.delegationVoting = $1 or transaction.delegationVoting IS NULL 
+					or length(transaction.delegationVoting) = 0)
+				and block.timestamp < $2 and block.height <= $3
+			ORDER BY block.height, transaction.index`,
+		votingHash,
+		before,
+		height,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var delegationBytes, votingHash []byte
+		err = rows.Scan(&delegationBytes, &votingHash)
+		if err != nil {
+			_ = rows.Close()
+			return nil, nil, err
+		}
+		delegation := new(golosovaniepb.Delegation)
+		err = proto.Unmarshal(delegationBytes, delegation)
+		if err != nil {
+			_ = rows.Close()
+			return nil, nil, err
+		}
+		if len(votingHash) == 0 {
+			standing = append(standing, delegation)
+		} else {
+			voting = append(voting, delegation)
+		}
+	}
+	return standing, voting, rows.Close()
+}
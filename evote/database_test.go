@@ -317,6 +317,29 @@ func TestDatabase(t *testing.T) {
 		assert.Nil(t, err)
 		utxosMatch(t, utxosExpected, utxosReceived)
 	})
+	t.Run("get_utxos_by_pkey_at_height", func(t *testing.T) {
+		// output of Block0 is spent in Block1, but it is still unspent after Block0
+		var body golosovaniepb.TxBody
+		err := proto.Unmarshal(Block0.Transactions[0].TxBody, &body)
+		assert.Nil(t, err)
+		pkey := body.Outputs[0].ReceiverSpendPkey
+		tx := Block0.Transactions[0]
+		utxosExpected := []*golosovaniepb.Utxo{
+			{
+				TxHash:            tx.Hash,
+				Index:             0,
+				Value:             body.Outputs[0].Value,
+				ReceiverSpendPkey: pkey,
+				Timestamp:         Block0.BlockHeader.Timestamp,
+			},
+		}
+		utxosReceived, err := db.GetUTXOSByPkeyAtHeight(pkey, 1)
+		assert.Nil(t, err)
+		utxosMatch(t, utxosExpected, utxosReceived)
+		utxosReceived, err = db.GetUTXOSByPkeyAtHeight(pkey, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(utxosReceived))
+	})
 	t.Run("get_utxos_by_txid_1", func(t *testing.T) {
 		tx0 := Block1.Transactions[0]
 		var body0 golosovaniepb.TxBody
@@ -593,6 +616,19 @@ func TestDatabase(t *testing.T) {
 		assert.Nil(t, err)
 		utxosMatch(t, utxosExpected, utxosReceived)
 	})
+	t.Run("has_votes_0", func(t *testing.T) {
+		hasVotes, err := db.HasVotes(Block3.Transactions[1].Hash)
+		assert.Nil(t, err)
+		assert.False(t, hasVotes)
+	})
+	t.Run("get_tx_sender_pkey", func(t *testing.T) {
+		sender, err := db.GetTxSenderPkey(Block3.Transactions[1].Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, keyPairs[1].pub, sender)
+		sender, err = db.GetTxSenderPkey(Block3.Transactions[0].Hash)
+		assert.Nil(t, err)
+		assert.Nil(t, sender)
+	})
 	t.Run("insert_block_4_with_more_optional_fields", func(t *testing.T) {
 		err := db.SaveNextBlock(Block4)
 		assert.Nil(t, err)
@@ -610,6 +646,51 @@ func TestDatabase(t *testing.T) {
 		assert.Equal(t, blocksReceived[0].Hash, blockHash(blocksReceived[0]))
 		assert.Equal(t, Block4.Hash, blockHash(blocksReceived[0]))
 	})
+	t.Run("has_votes_1", func(t *testing.T) {
+		hasVotes, err := db.HasVotes(Block3.Transactions[1].Hash)
+		assert.Nil(t, err)
+		assert.True(t, hasVotes)
+	})
+	t.Run("get_last_block", func(t *testing.T) {
+		hash, height, err := db.GetLastBlockHashAndHeight()
+		assert.Nil(t, err)
+		assert.Equal(t, Block4.Hash, hash)
+		assert.Equal(t, int64(5), height) // BlockZ has height 0
+	})
+	t.Run("get_blocks_by_heights", func(t *testing.T) {
+		blocksReceived, err := db.GetBlocksByHeights(1, 3)
+		assert.Nil(t, err)
+		// order of blocks matters, so blocksMatch is not used
+		assert.Zero(t, cmp.Diff(
+			[]*golosovaniepb.Block{Block0, Block1},
+			blocksReceived,
+			protocmp.Transform(),
+		))
+	})
+	t.Run("save_state", func(t *testing.T) {
+		root, err := db.GetStateRoot(Block4.Hash)
+		assert.Nil(t, err)
+		assert.Nil(t, root)
+		tree := NewStateTree(&db)
+		root, err = tree.Set(EmptyStateRoot, StateKey(StateKeyTx, Block4.Hash), Block4.Hash)
+		assert.Nil(t, err)
+		root, err = tree.Set(root, StateKey(StateKeyTx, Block3.Hash), Block3.Hash)
+		assert.Nil(t, err)
+		nodes := tree.Flush(root)
+		assert.Equal(t, 3, len(nodes))
+		assert.Nil(t, db.SaveState(Block4.Hash, root, nodes))
+		savedRoot, err := db.GetStateRoot(Block4.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, root, savedRoot)
+		for _, n := range nodes {
+			savedNode, err := db.GetStateNode(n.Hash())
+			assert.Nil(t, err)
+			assert.Equal(t, n.Hash(), savedNode.Hash())
+		}
+		// the same root is not saved twice
+		assert.NotNil(t, db.SaveState(Block4.Hash, root, nil))
+	})
+
 	t.Run("get_utxo_by_pkey_with_typeValue_1", func(t *testing.T) {
 		tx := Block4.Transactions[1]
 		var body golosovaniepb.TxBody
@@ -697,6 +778,26 @@ func TestDatabase(t *testing.T) {
 		assert.Nil(t, err)
 		utxosMatch(t, utxosExpected, utxosReceived)
 	})
+	t.Run("append_tx_double_spending", func(t *testing.T) {
+		executor := NewTxExecutor(&db)
+		spend := func(op *golosovaniepb.Input) uint32 {
+			data, err := proto.Marshal(tx(&golosovaniepb.TxBody{
+				Inputs:  []*golosovaniepb.Input{op},
+				Outputs: []*golosovaniepb.Output{{Value: 1, ReceiverSpendPkey: keyPairs[0].pub}},
+			}))
+			assert.Nil(t, err)
+			return executor.AppendTx(data, false)
+		}
+		// выход потрачен в Block1
+		assert.Equal(t, uint32(CodeDoubleSpending), spend(&golosovaniepb.Input{
+			PrevTxHash: TxsBlock0[0].Hash, OutputIndex: 0,
+		}))
+		// выхода нет в бд
+		assert.Equal(t, uint32(CodeDoubleSpending), spend(&golosovaniepb.Input{
+			PrevTxHash: randHash(), OutputIndex: 0,
+		}))
+		assert.Equal(t, 0, len(executor.Transactions))
+	})
 	err = db.Close()
 	assert.Nil(t, err)
 }
@@ -0,0 +1,147 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CreateDelegationTx creates tx, which delegates votes of keys to delegate. Votes are delegated only in the voting
+// votingHash or, if votingHash is nil, in all votings as a standing delegation. nil delegate revokes the delegation.
+// The fee is paid with coins from utxos of keys, the rest of inputs returns to keys
+func CreateDelegationTx(
+	delegate []byte,
+	votingHash []byte,
+	utxos []*golosovaniepb.Utxo,
+	fee uint64,
+	keys *CryptoKeysData,
+) (*golosovaniepb.Transaction, error) {
+	body := golosovaniepb.TxBody{
+		Delegation: &golosovaniepb.Delegation{
+			DelegatorPkey: keys.PkeyByte[:],
+			DelegatePkey:  delegate,
+			Nonce:         uint64(time.Now().UnixNano()),
+			VotingHash:    votingHash,
+		},
+	}
+	tx, _, err := createTx(utxos, nil, &body, nil, false, fee, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.Inputs) == 0 {
+		return nil, fmt.Errorf("no coins to pay the fee")
+	}
+	return tx, nil
+}
+
+// effectiveDelegations returns delegate of each delegator: the last delegation for the voting or,
+// if there is none, the last standing delegation. Delegation without delegate means, that votes are not delegated
+func effectiveDelegations(standing, voting []*golosovaniepb.Delegation) map[[PkeySize]byte][PkeySize]byte {
+	delegates := make(map[[PkeySize]byte][PkeySize]byte)
+	// делегирования голосования применяются после постоянных и заменяют их
+	for _, delegations := range [][]*golosovaniepb.Delegation{standing, voting} {
+		for _, d := range delegations {
+			if len(d.DelegatePkey) == 0 {
+				delete(delegates, SliceToPkey(d.DelegatorPkey))
+			} else {
+				delegates[SliceToPkey(d.DelegatorPkey)] = SliceToPkey(d.DelegatePkey)
+			}
+		}
+	}
+	return delegates
+}
+
+// resolveDelegations returns ballots of participants, who haven't voted themselves, cast by their delegates:
+// copies of counted ballots of delegates with votes of the participants. weights - votes, which are left with
+// participants. Delegation chain is followed until a delegate with a counted ballot, votes of chains without
+// such delegate and of cycles are not counted. delegated - votes received by each delegate
+func resolveDelegations(
+	counted []*VoteBallot,
+	weights map[[PkeySize]byte]uint64,
+	delegates map[[PkeySize]byte][PkeySize]byte,
+) (ballots []*VoteBallot, delegated map[[PkeySize]byte]uint64) {
+	voted := make(map[[PkeySize]byte]*VoteBallot, len(counted))
+	for _, b := range counted {
+		sender := SliceToPkey(b.Sender)
+		if _, ok := voted[sender]; !ok {
+			voted[sender] = b
+		}
+	}
+	// участники по возрастанию ключа, чтобы порядок бюллетеней не зависел от обхода map
+	participants := make([][PkeySize]byte, 0, len(weights))
+	for pkey := range weights {
+		participants = append(participants, pkey)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return bytes.Compare(participants[i][:], participants[j][:]) < 0
+	})
+	delegated = make(map[[PkeySize]byte]uint64)
+	for _, pkey := range participants {
+		// участник, который проголосовал сам, не передает оставшиеся голоса
+		if _, ok := voted[pkey]; ok || weights[pkey] == 0 {
+			continue
+		}
+		visited := map[[PkeySize]byte]bool{pkey: true}
+		current := pkey
+		for {
+			next, ok := delegates[current]
+			if !ok || visited[next] {
+				break
+			}
+			if b, ok := voted[next]; ok {
+				ballots = append(ballots, &VoteBallot{
+					TxHash:    b.TxHash,
+					Sender:    append([]byte(nil), pkey[:]...),
+					Ballot:    b.Ballot,
+					Value:     weights[pkey],
+					Timestamp: b.Timestamp,
+				})
+				delegated[next] += weights[pkey]
+				break
+			}
+			visited[next] = true
+			current = next
+		}
+	}
+	return ballots, delegated
+}
+
+// CountedBallotsAtHeight returns ballots of the voting, which are counted after the block with height in DB.
+// After the end of the voting ballots of delegates are also counted for participants, who haven't voted,
+// delegated contains votes received by each delegate. Anonymous votings have no delegations:
+// all votes of participants are moved to one-time addresses
+func CountedBallotsAtHeight(
+	db *Database,
+	votingHash []byte,
+	body *golosovaniepb.TxBody,
+	timeStart uint64,
+	height int64,
+	ended bool,
+) (counted []*VoteBallot, delegated map[[PkeySize]byte]uint64, err error) {
+	endTime := VotingEndTime(timeStart, body.Duration)
+	ballots, err := db.GetBallotsAtHeight(votingHash, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	// бюллетени голосования commit-reveal учитываются до окончания фазы раскрытия
+	counted = CountedBallots(ballots, RevealEndTime(endTime, body.VotingParams))
+	if !ended || IsAnonymousVoting(body) {
+		return counted, nil, nil
+	}
+	utxos, err := db.GetParticipantUtxosAtHeight(votingHash, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	weights := make(map[[PkeySize]byte]uint64)
+	for _, utxo := range utxos {
+		weights[SliceToPkey(utxo.ReceiverSpendPkey)] += utxo.Value
+	}
+	standing, voting, err := db.GetDelegationsAtHeight(votingHash, endTime, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	ballots, delegated = resolveDelegations(counted, weights, effectiveDelegations(standing, voting))
+	return append(counted, ballots...), delegated, nil
+}
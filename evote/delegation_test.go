@@ -0,0 +1,86 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDelegation(t *testing.T) {
+	a, b, c, d := sortedCandidates()
+	alice, bob, carol, dave, eve := randPkey(), randPkey(), randPkey(), randPkey(), randPkey()
+	delegation := func(delegator, delegate []byte) *golosovaniepb.Delegation {
+		return &golosovaniepb.Delegation{DelegatorPkey: delegator, DelegatePkey: delegate}
+	}
+	ballot := func(sender []byte, value uint64, candidate []byte) *VoteBallot {
+		b := rankedBallot(value, candidate)
+		b.Sender = sender
+		return b
+	}
+	delegates := func(pairs ...[]byte) map[[PkeySize]byte][PkeySize]byte {
+		res := make(map[[PkeySize]byte][PkeySize]byte)
+		for i := 0; i < len(pairs); i += 2 {
+			res[SliceToPkey(pairs[i])] = SliceToPkey(pairs[i+1])
+		}
+		return res
+	}
+	weights := func(pairs ...interface{}) map[[PkeySize]byte]uint64 {
+		res := make(map[[PkeySize]byte]uint64)
+		for i := 0; i < len(pairs); i += 2 {
+			res[SliceToPkey(pairs[i].([]byte))] = pairs[i+1].(uint64)
+		}
+		return res
+	}
+
+	t.Run("effective", func(t *testing.T) {
+		standing := []*golosovaniepb.Delegation{
+			delegation(alice, bob), delegation(carol, bob), delegation(dave, bob), delegation(alice, carol),
+			delegation(eve, bob), delegation(eve, nil),
+		}
+		voting := []*golosovaniepb.Delegation{delegation(carol, alice), delegation(dave, nil)}
+		// последнее делегирование заменяет предыдущее, делегирование голосования заменяет постоянное
+		assert.Equal(t, delegates(alice, carol, carol, alice), effectiveDelegations(standing, voting))
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		counted := []*VoteBallot{ballot(carol, 5, a), ballot(eve, 1, b)}
+		// alice -> bob -> carol, bob не голосовал и не делегирует свои голоса дальше carol
+		ballots, delegated := resolveDelegations(counted, weights(alice, uint64(2), bob, uint64(3)),
+			delegates(alice, bob, bob, carol))
+		assert.Equal(t, weights(carol, uint64(5)), delegated)
+		assert.Len(t, ballots, 2)
+		for _, b := range ballots {
+			assert.Equal(t, counted[0].Ballot, b.Ballot)
+		}
+		res := TallyBallots(append(counted, ballots...), PercentVoteType, nil)
+		assert.Equal(t, uint64(11), res.Turnout)
+		assert.Equal(t, [][]byte{a}, res.Elected)
+		// в голосовании "один участник - один голос" за представителя считается каждый доверитель
+		res = TallyBallots(append(counted, ballots...), OneVoteType, nil)
+		assert.Equal(t, []*golosovaniepb.ResponseVoteResult_PkeyValue{{Pkey: a, Value: 3}, {Pkey: b, Value: 1}}, res.Res)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		// alice проголосовала сама, оставшиеся у неё голоса не передаются
+		counted := []*VoteBallot{ballot(alice, 1, c), ballot(bob, 1, d)}
+		ballots, delegated := resolveDelegations(counted, weights(alice, uint64(4)), delegates(alice, bob))
+		assert.Empty(t, ballots)
+		assert.Empty(t, delegated)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		counted := []*VoteBallot{ballot(eve, 1, a)}
+		// цикл alice -> bob -> carol -> alice без проголосовавших, dave делегирует в цикл
+		ballots, delegated := resolveDelegations(counted, weights(alice, uint64(1), bob, uint64(1), dave, uint64(1)),
+			delegates(alice, bob, bob, carol, carol, alice, dave, alice))
+		assert.Empty(t, ballots)
+		assert.Empty(t, delegated)
+	})
+
+	t.Run("dead_end", func(t *testing.T) {
+		counted := []*VoteBallot{ballot(eve, 1, a)}
+		ballots, delegated := resolveDelegations(counted, weights(alice, uint64(3)), delegates(alice, bob))
+		assert.Empty(t, ballots)
+		assert.Empty(t, delegated)
+	})
+}
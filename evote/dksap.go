@@ -0,0 +1,200 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/protobuf/proto"
+	"math/big"
+)
+
+// Анонимное голосование по схеме DKSAP (dual-key stealth address protocol), см. utils/test_secp256k1_dksap.
+// У избирателя две пары ключей: spend (b, B = b·G) и scan (a, A = a·G). Выходы транзакции создания
+// голосования содержат B и A избирателей. Валидатор, в блок которого попала транзакция создания голосования,
+// выбирает разовый ключ r и в транзакции инициализации переводит голоса на одноразовые адреса
+// P = H(r·A)·G + B в случайном порядке, публикуя R = r·G в sender_ephemeral_pkey.
+// Избиратель находит свой выход, вычисляя H(a·R)·G + B, и голосует ключом H(a·R) + b,
+// поэтому по голосам нельзя узнать, кто их отправил.
+// voters_sum_pkey = Σ H(r·A)·G, значит ΣP = voters_sum_pkey + ΣB, по этому равенству валидаторы проверяют,
+// что одноразовые адреса получены из ключей участников голосования
+//
+// Допущение о доверии: равенство проверяет только сумму, а не то, что каждый P получен как H(r·A)·G + B,
+// поэтому тот, кто инициализирует голосование, может перераспределить слагаемые H(r·A)·G между адресами
+// и оставить себе ключ от чужого голоса, а также знает r и может связать адреса с избирателями.
+// Поэтому инициализацию принимают только от одного валидатора - создателя блока с транзакцией создания
+// голосования, избиратели доверяют ему так же, как доверяют созданию блока. Если он не отправит
+// транзакцию инициализации, голосование не начнется: другим валидаторам инициализировать его нельзя
+
+const scanKeySalt = "golosovanie scan key"
+
+// ScanKeys returns scan keys of the voter, they are derived from the main (spend) keys,
+// so voter does not have to store another private key
+func (keys *CryptoKeysData) ScanKeys() *CryptoKeysData {
+	var scan CryptoKeysData
+	scan.SetupKeys(Hash(append([]byte(scanKeySalt), crypto.FromECDSA(keys.PrivateKey)...)))
+	return &scan
+}
+
+// IsAnonymousVoting returns true, if body is create vote tx of anonymous voting, outputs of such tx have scan keys
+func IsAnonymousVoting(body *golosovaniepb.TxBody) bool {
+	return body.VoteType != 0 && len(body.Outputs) != 0 && len(body.Outputs[0].ReceiverScanPkey) != 0
+}
+
+// ecSum is a sum of points of secp256k1 curve. BitCurve.Add does not handle doubling
+// and the point at infinity, which is represented by nil coordinates here
+type ecSum struct {
+	x, y *big.Int
+}
+
+func (s *ecSum) add(x, y *big.Int) {
+	curve := crypto.S256()
+	switch {
+	case s.x == nil:
+		s.x, s.y = x, y
+	case s.x.Cmp(x) != 0:
+		s.x, s.y = curve.Add(s.x, s.y, x, y)
+	case s.y.Cmp(y) == 0:
+		s.x, s.y = curve.Double(x, y)
+	default:
+		s.x, s.y = nil, nil
+	}
+}
+
+func (s *ecSum) addPkey(pkey []byte) error {
+	p, err := crypto.DecompressPubkey(pkey)
+	if err != nil {
+		return err
+	}
+	s.add(p.X, p.Y)
+	return nil
+}
+
+func (s *ecSum) equal(other *ecSum) bool {
+	if s.x == nil || other.x == nil {
+		return s.x == nil && other.x == nil
+	}
+	return s.x.Cmp(other.x) == 0 && s.y.Cmp(other.y) == 0
+}
+
+func compressPoint(x, y *big.Int) []byte {
+	return crypto.CompressPubkey(&ecdsa.PublicKey{Curve: crypto.S256(), X: x, Y: y})
+}
+
+// dksapSecret returns H(prv·pub) as a scalar, sender and receiver get the same secret: H(r·A) = H(a·R)
+func dksapSecret(prv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) *big.Int {
+	curve := crypto.S256()
+	x, _ := curve.ScalarMult(pub.X, pub.Y, math.PaddedBigBytes(prv.D, 32))
+	secret := new(big.Int).SetBytes(Hash(math.PaddedBigBytes(x, 32)))
+	return secret.Mod(secret, curve.Params().N)
+}
+
+// CreateInitVoteTx creates transaction, which moves votes of anonymous voting to one-time addresses.
+// It spends all outputs of create vote tx and is signed by the validator, which proposed block with create vote tx
+func CreateInitVoteTx(createVoteTx *golosovaniepb.Transaction, keys *CryptoKeysData) (*golosovaniepb.Transaction, error) {
+	var createVoteBody golosovaniepb.TxBody
+	err := proto.Unmarshal(createVoteTx.TxBody, &createVoteBody)
+	if err != nil {
+		return nil, err
+	}
+	if !IsAnonymousVoting(&createVoteBody) {
+		return nil, fmt.Errorf("voting is not anonymous")
+	}
+	ephemeral, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	curve := crypto.S256()
+	var votersSum ecSum
+	body := golosovaniepb.TxBody{
+		ValueType:           createVoteTx.Hash,
+		SenderEphemeralPkey: crypto.CompressPubkey(&ephemeral.PublicKey),
+	}
+	for i, out := range createVoteBody.Outputs {
+		scan, err := crypto.DecompressPubkey(out.ReceiverScanPkey)
+		if err != nil {
+			return nil, err
+		}
+		spend, err := crypto.DecompressPubkey(out.ReceiverSpendPkey)
+		if err != nil {
+			return nil, err
+		}
+		secretX, secretY := curve.ScalarBaseMult(math.PaddedBigBytes(dksapSecret(ephemeral, scan), 32))
+		votersSum.add(secretX, secretY)
+		var stealth ecSum
+		stealth.add(secretX, secretY)
+		stealth.add(spend.X, spend.Y)
+		body.Inputs = append(body.Inputs, &golosovaniepb.Input{
+			PrevTxHash:  createVoteTx.Hash,
+			OutputIndex: uint32(i),
+		})
+		body.Outputs = append(body.Outputs, newOutput(compressPoint(stealth.x, stealth.y), nil, out.Value))
+	}
+	body.VotersSumPkey = compressPoint(votersSum.x, votersSum.y)
+	// порядок выходов не должен совпадать с порядком участников, иначе одноразовые адреса легко сопоставить с ними
+	for i := len(body.Outputs) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		body.Outputs[i], body.Outputs[j.Int64()] = body.Outputs[j.Int64()], body.Outputs[i]
+	}
+	txBytes, err := proto.Marshal(&body)
+	if err != nil {
+		return nil, err
+	}
+	return &golosovaniepb.Transaction{
+		TxBody: txBytes,
+		Sig:    keys.Sign(txBytes),
+		Hash:   Hash(txBytes),
+	}, nil
+}
+
+// VerifyVotersSumPkey checks, that one-time addresses of init vote tx are derived from spend keys of participants:
+// sum of outputs of init vote tx must be equal to votersSumPkey plus sum of outputs of create vote tx
+func VerifyVotersSumPkey(createVoteOutputs, initVoteOutputs []*golosovaniepb.Output, votersSumPkey []byte) bool {
+	var expected, actual ecSum
+	if expected.addPkey(votersSumPkey) != nil {
+		return false
+	}
+	for _, out := range createVoteOutputs {
+		if expected.addPkey(out.ReceiverSpendPkey) != nil {
+			return false
+		}
+	}
+	for _, out := range initVoteOutputs {
+		if actual.addPkey(out.ReceiverSpendPkey) != nil {
+			return false
+		}
+	}
+	return expected.equal(&actual)
+}
+
+// FindStealthKeys scans outputs of init vote tx with scan key of the voter,
+// returns keys of the one-time address of the voter or nil, if the voter has no output in the tx
+func FindStealthKeys(keys *CryptoKeysData, initVoteTx *golosovaniepb.Transaction) (*CryptoKeysData, error) {
+	var body golosovaniepb.TxBody
+	err := proto.Unmarshal(initVoteTx.TxBody, &body)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := crypto.DecompressPubkey(body.SenderEphemeralPkey)
+	if err != nil {
+		return nil, err
+	}
+	curve := crypto.S256()
+	secret := dksapSecret(keys.ScanKeys().PrivateKey, ephemeral)
+	d := new(big.Int).Add(secret, keys.PrivateKey.D)
+	d.Mod(d, curve.Params().N)
+	var stealthKeys CryptoKeysData
+	stealthKeys.SetupKeys(math.PaddedBigBytes(d, 32))
+	for _, out := range body.Outputs {
+		if bytes.Equal(out.ReceiverSpendPkey, stealthKeys.PkeyByte[:]) {
+			return &stealthKeys, nil
+		}
+	}
+	return nil, nil
+}
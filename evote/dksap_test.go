@@ -0,0 +1,70 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDksap(t *testing.T) {
+	var voters []*CryptoKeysData
+	createVoteBody := golosovaniepb.TxBody{VoteType: OneVoteType, Duration: 100}
+	for i := 0; i < 5; i++ {
+		voter := randKeys()
+		voters = append(voters, voter)
+		createVoteBody.Outputs = append(createVoteBody.Outputs, &golosovaniepb.Output{
+			ReceiverSpendPkey: voter.PkeyByte[:],
+			ReceiverScanPkey:  voter.ScanKeys().PkeyByte[:],
+			Value:             uint64(i + 1),
+		})
+	}
+	createVoteTx := tx(&createVoteBody)
+	validator := randKeys()
+	initTx, err := CreateInitVoteTx(createVoteTx, validator)
+	assert.Nil(t, err)
+	var initBody golosovaniepb.TxBody
+	assert.Nil(t, proto.Unmarshal(initTx.TxBody, &initBody))
+	assert.True(t, VerifyData(initTx.TxBody, initTx.Sig, validator.PkeyByte[:]))
+
+	t.Run("voters_sum_pkey", func(t *testing.T) {
+		assert.True(t, VerifyVotersSumPkey(createVoteBody.Outputs, initBody.Outputs, initBody.VotersSumPkey))
+		other := randKeys()
+		assert.False(t, VerifyVotersSumPkey(createVoteBody.Outputs, initBody.Outputs, other.PkeyByte[:]))
+		replaced := append([]*golosovaniepb.Output{}, initBody.Outputs...)
+		replaced[0] = &golosovaniepb.Output{ReceiverSpendPkey: other.PkeyByte[:], Value: replaced[0].Value}
+		assert.False(t, VerifyVotersSumPkey(createVoteBody.Outputs, replaced, initBody.VotersSumPkey))
+	})
+
+	t.Run("find_stealth_keys", func(t *testing.T) {
+		found := make(map[[PkeySize]byte]bool)
+		for i, voter := range voters {
+			stealthKeys, err := FindStealthKeys(voter, initTx)
+			assert.Nil(t, err)
+			if assert.NotNil(t, stealthKeys) {
+				for _, out := range initBody.Outputs {
+					if SliceToPkey(out.ReceiverSpendPkey) == stealthKeys.PkeyByte {
+						assert.Equal(t, uint64(i+1), out.Value)
+					}
+				}
+				found[stealthKeys.PkeyByte] = true
+			}
+		}
+		assert.Equal(t, len(voters), len(found))
+		stealthKeys, err := FindStealthKeys(randKeys(), initTx)
+		assert.Nil(t, err)
+		assert.Nil(t, stealthKeys)
+	})
+
+	t.Run("same_participant_twice", func(t *testing.T) {
+		body := golosovaniepb.TxBody{
+			VoteType: OneVoteType,
+			Outputs:  []*golosovaniepb.Output{createVoteBody.Outputs[0], createVoteBody.Outputs[0]},
+		}
+		initTx, err := CreateInitVoteTx(tx(&body), validator)
+		assert.Nil(t, err)
+		var initBody golosovaniepb.TxBody
+		assert.Nil(t, proto.Unmarshal(initTx.TxBody, &initBody))
+		assert.True(t, VerifyVotersSumPkey(body.Outputs, initBody.Outputs, initBody.VotersSumPkey))
+	})
+}
@@ -0,0 +1,744 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/protobuf/proto"
+	"math/big"
+	"sort"
+)
+
+// Голосование с зашифрованными бюллетенями (EncryptedVoteType).
+// Общий ключ голосования Y создается распределенной генерацией ключа (Joint-Feldman DKG): после создания голосования
+// каждый валидатор i выбирает многочлен f_i степени t - 1 (t = DecryptionThreshold) и публикует KeyShare
+// с C_ik = a_ik·G и долями f_i(j), зашифрованными для каждого валидатора j. Y = Σ C_i0, доля ключа валидатора
+// x_j = Σ f_i(j), а X_j = x_j·G вычисляется любым узлом из C_ik. Бюллетень содержит шифртексты экспоненциального
+// ElGamal (a, b) = (r·G, m·G + r·Y), m = 1 для выбранного варианта и 0 для остальных. Шифртексты складываются
+// с весами бюллетеней, после окончания голосования валидаторы публикуют D_j = x_j·A с доказательством
+// Чаума-Педерсена. Любые t частичных расшифровок дают x·A = Σ λ_j·D_j (интерполяция Лагранжа),
+// сумма голосов m находится из m·G = B - x·A перебором, ни один бюллетень при этом не расшифровывается.
+// Содержимое бюллетеня проверить нельзя, поэтому он содержит доказательства с нулевым разглашением: каждый
+// шифртекст содержит 0 или 1 (BitProof), а их сумма - ровно 1. Вес бюллетеня - сумма выходов транзакции,
+// он применяется только при сложении шифртекстов, так что избиратель не может отдать больше своих голосов
+
+const electionKeySalt = "golosovanie election key"
+
+var curveN = crypto.S256().Params().N
+
+// DecryptionThreshold returns number of validators, which are enough to decrypt the tally
+func DecryptionThreshold(validators int) int {
+	return validators/2 + 1
+}
+
+// SortedValidatorPkeys returns pkeys of validators in ascending order, x coordinate of the validator
+// in the key generation is its index in this list plus one
+func SortedValidatorPkeys(validators []*ValidatorNode) [][]byte {
+	pkeys := make([][]byte, len(validators))
+	for i, v := range validators {
+		pkey := v.Pkey
+		pkeys[i] = pkey[:]
+	}
+	sort.Slice(pkeys, func(i, j int) bool {
+		return bytes.Compare(pkeys[i], pkeys[j]) < 0
+	})
+	return pkeys
+}
+
+func validatorIndex(validators [][]byte, pkey []byte) int {
+	for i, v := range validators {
+		if bytes.Equal(v, pkey) {
+			return i
+		}
+	}
+	return -1
+}
+
+func decodePoint(b []byte) (ecSum, error) {
+	p, err := crypto.DecompressPubkey(b)
+	if err != nil {
+		return ecSum{}, err
+	}
+	return ecSum{p.X, p.Y}, nil
+}
+
+func (s ecSum) bytes() []byte {
+	if s.x == nil {
+		return nil
+	}
+	return compressPoint(s.x, s.y)
+}
+
+func (s ecSum) plus(other ecSum) ecSum {
+	if other.x == nil {
+		return s
+	}
+	res := s
+	res.add(other.x, other.y)
+	return res
+}
+
+func (s ecSum) neg() ecSum {
+	if s.x == nil {
+		return s
+	}
+	return ecSum{s.x, new(big.Int).Sub(crypto.S256().Params().P, s.y)}
+}
+
+// mul returns k·s, ScalarMult does not handle zero scalar and the point at infinity
+func (s ecSum) mul(k *big.Int) ecSum {
+	k = new(big.Int).Mod(k, curveN)
+	if s.x == nil || k.Sign() == 0 {
+		return ecSum{}
+	}
+	x, y := crypto.S256().ScalarMult(s.x, s.y, math.PaddedBigBytes(k, 32))
+	return ecSum{x, y}
+}
+
+func baseMul(k *big.Int) ecSum {
+	k = new(big.Int).Mod(k, curveN)
+	if k.Sign() == 0 {
+		return ecSum{}
+	}
+	x, y := crypto.S256().ScalarBaseMult(math.PaddedBigBytes(k, 32))
+	return ecSum{x, y}
+}
+
+// hashScalar returns hash of context and points as a scalar, the point at infinity is hashed as a zero byte
+func hashScalar(context []byte, points ...ecSum) *big.Int {
+	data := append([]byte{}, context...)
+	for _, p := range points {
+		if p.x == nil {
+			data = append(data, 0)
+		} else {
+			data = append(data, p.bytes()...)
+		}
+	}
+	h := new(big.Int).SetBytes(Hash(data))
+	return h.Mod(h, curveN)
+}
+
+// parseScalar returns scalar from 32 bytes, false if the scalar is not less than the curve order
+func parseScalar(b []byte) (*big.Int, bool) {
+	if len(b) != 32 {
+		return nil, false
+	}
+	k := new(big.Int).SetBytes(b)
+	return k, k.Cmp(curveN) < 0
+}
+
+func randomScalar() (*big.Int, error) {
+	for {
+		k, err := rand.Int(rand.Reader, curveN)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+}
+
+// electionSecret returns secret of the validator for the voting. Secrets are derived from the private key,
+// so validator does not store anything between key generation and decryption
+func electionSecret(keys *CryptoKeysData, votingHash []byte, label string, index int) *big.Int {
+	data := append([]byte(electionKeySalt), crypto.FromECDSA(keys.PrivateKey)...)
+	data = append(data, votingHash...)
+	data = append(data, label...)
+	data = append(data, big.NewInt(int64(index)).Bytes()...)
+	k := new(big.Int).SetBytes(Hash(data))
+	return k.Mod(k, curveN)
+}
+
+// evalCommitments returns f(x)·G = Σ C_k·x^k
+func evalCommitments(commitments []ecSum, x int64) ecSum {
+	var res ecSum
+	power := big.NewInt(1)
+	for _, c := range commitments {
+		res = res.plus(c.mul(power))
+		power = new(big.Int).Mul(power, big.NewInt(x))
+	}
+	return res
+}
+
+// shareMask returns key for encryption of the share, dealer and receiver get the same key: H(r·P) = H(p·R)
+func shareMask(prv *big.Int, pub ecSum) []byte {
+	return Hash(math.PaddedBigBytes(pub.mul(prv).x, 32))
+}
+
+func xorBytes(a, b []byte) []byte {
+	res := make([]byte, len(a))
+	for i := range a {
+		res[i] = a[i] ^ b[i]
+	}
+	return res
+}
+
+// CreateKeyShareTx creates contribution of the validator to the election key of the voting,
+// validators are pkeys of all validators in ascending order
+func CreateKeyShareTx(votingHash []byte, validators [][]byte, keys *CryptoKeysData) (*golosovaniepb.Transaction, error) {
+	threshold := DecryptionThreshold(len(validators))
+	coefficients := make([]*big.Int, threshold)
+	keyShare := golosovaniepb.KeyShare{ValidatorPkey: keys.PkeyByte[:]}
+	for k := range coefficients {
+		coefficients[k] = electionSecret(keys, votingHash, "coefficient", k)
+		keyShare.Commitments = append(keyShare.Commitments, baseMul(coefficients[k]).bytes())
+	}
+	ephemeral := electionSecret(keys, votingHash, "ephemeral", 0)
+	keyShare.EphemeralPkey = baseMul(ephemeral).bytes()
+	for j, pkey := range validators {
+		// f(j + 1) по схеме Горнера
+		share := new(big.Int)
+		for k := threshold - 1; k >= 0; k-- {
+			share.Mul(share, big.NewInt(int64(j+1)))
+			share.Add(share, coefficients[k])
+			share.Mod(share, curveN)
+		}
+		p, err := decodePoint(pkey)
+		if err != nil {
+			return nil, err
+		}
+		keyShare.EncryptedShares = append(keyShare.EncryptedShares,
+			xorBytes(math.PaddedBigBytes(share, 32), shareMask(ephemeral, p)))
+	}
+	return signBareTx(&golosovaniepb.TxBody{ValueType: votingHash, KeyShare: &keyShare}, keys)
+}
+
+// signBareTx signs tx without inputs and outputs by keys of its author
+func signBareTx(body *golosovaniepb.TxBody, keys *CryptoKeysData) (*golosovaniepb.Transaction, error) {
+	txBytes, err := proto.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &golosovaniepb.Transaction{
+		TxBody: txBytes,
+		Sig:    keys.Sign(txBytes),
+		Hash:   Hash(txBytes),
+	}, nil
+}
+
+// checkKeyShare checks sizes and points of the key share, the shares themselves can be checked only by their receivers
+func checkKeyShare(keyShare *golosovaniepb.KeyShare, validators [][]byte) bool {
+	if len(keyShare.Commitments) != DecryptionThreshold(len(validators)) ||
+		len(keyShare.EncryptedShares) != len(validators) {
+		return false
+	}
+	for _, c := range keyShare.Commitments {
+		if _, err := decodePoint(c); err != nil {
+			return false
+		}
+	}
+	if _, err := decodePoint(keyShare.EphemeralPkey); err != nil {
+		return false
+	}
+	for _, share := range keyShare.EncryptedShares {
+		if len(share) != 32 {
+			return false
+		}
+	}
+	return true
+}
+
+func keyShareCommitments(keyShare *golosovaniepb.KeyShare) ([]ecSum, error) {
+	commitments := make([]ecSum, len(keyShare.Commitments))
+	for k, c := range keyShare.Commitments {
+		var err error
+		commitments[k], err = decodePoint(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return commitments, nil
+}
+
+// ElectionKey returns joint key of the voting from key shares of the validators
+func ElectionKey(keyShares []*golosovaniepb.KeyShare) ([]byte, error) {
+	var key ecSum
+	for _, keyShare := range keyShares {
+		c, err := decodePoint(keyShare.Commitments[0])
+		if err != nil {
+			return nil, err
+		}
+		key = key.plus(c)
+	}
+	if key.x == nil {
+		return nil, fmt.Errorf("no election key")
+	}
+	return key.bytes(), nil
+}
+
+// publicShare returns X_j = x_j·G of the validator with index j
+func publicShare(keyShares []*golosovaniepb.KeyShare, j int) (ecSum, error) {
+	var res ecSum
+	for _, keyShare := range keyShares {
+		commitments, err := keyShareCommitments(keyShare)
+		if err != nil {
+			return ecSum{}, err
+		}
+		res = res.plus(evalCommitments(commitments, int64(j+1)))
+	}
+	return res, nil
+}
+
+// secretShare decrypts shares of the validator with index j from all key shares and returns x_j
+func secretShare(keyShares []*golosovaniepb.KeyShare, j int, keys *CryptoKeysData) (*big.Int, error) {
+	res := new(big.Int)
+	for _, keyShare := range keyShares {
+		ephemeral, err := decodePoint(keyShare.EphemeralPkey)
+		if err != nil {
+			return nil, err
+		}
+		share := new(big.Int).SetBytes(xorBytes(keyShare.EncryptedShares[j], shareMask(keys.PrivateKey.D, ephemeral)))
+		commitments, err := keyShareCommitments(keyShare)
+		if err != nil {
+			return nil, err
+		}
+		// доля должна лежать на многочлене, к которому валидатор-дилер опубликовал обязательства
+		expected := evalCommitments(commitments, int64(j+1))
+		actual := baseMul(share)
+		if !actual.equal(&expected) {
+			return nil, fmt.Errorf("invalid share from validator %x", keyShare.ValidatorPkey)
+		}
+		res.Add(res, share)
+	}
+	return res.Mod(res, curveN), nil
+}
+
+// ciphertextSum - сумма шифртекстов варианта ответа с весами бюллетеней
+type ciphertextSum struct {
+	a, b ecSum
+}
+
+// aggregateBallots sums ciphertexts of each option of encrypted ballots multiplied by weights of the ballots
+func aggregateBallots(ballots []*VoteBallot, options int) ([]ciphertextSum, error) {
+	sums := make([]ciphertextSum, options)
+	for _, ballot := range ballots {
+		if len(ballot.Ballot.Encrypted) != options {
+			return nil, fmt.Errorf("invalid number of ciphertexts in ballot %x", ballot.TxHash)
+		}
+		weight := new(big.Int).SetUint64(ballot.Value)
+		for k, c := range ballot.Ballot.Encrypted {
+			a, err := decodePoint(c.A)
+			if err != nil {
+				return nil, err
+			}
+			b, err := decodePoint(c.B)
+			if err != nil {
+				return nil, err
+			}
+			sums[k].a = sums[k].a.plus(a.mul(weight))
+			sums[k].b = sums[k].b.plus(b.mul(weight))
+		}
+	}
+	return sums, nil
+}
+
+// EncryptBallot encrypts vote for the option with index choice of the voting with options under electionKey.
+// Proofs of the ballot are bound to the voter, who sends the ballot and owns the inputs of the vote tx
+func EncryptBallot(electionKey []byte, options int, choice int, voter []byte) (*golosovaniepb.Ballot, error) {
+	if choice < 0 || choice >= options {
+		return nil, fmt.Errorf("invalid option")
+	}
+	key, err := decodePoint(electionKey)
+	if err != nil {
+		return nil, err
+	}
+	ballot := golosovaniepb.Ballot{ElectionKey: electionKey}
+	var sumA, sumB ecSum
+	sumR := new(big.Int)
+	for k := 0; k < options; k++ {
+		r, err := randomScalar()
+		if err != nil {
+			return nil, err
+		}
+		bit := 0
+		if k == choice {
+			bit = 1
+		}
+		a := baseMul(r)
+		b := key.mul(r).plus(baseMul(big.NewInt(int64(bit))))
+		proof, err := proveBit(voter, key, a, b, r, bit)
+		if err != nil {
+			return nil, err
+		}
+		ballot.Encrypted = append(ballot.Encrypted, &golosovaniepb.Ciphertext{A: a.bytes(), B: b.bytes()})
+		ballot.BitProofs = append(ballot.BitProofs, proof)
+		sumA, sumB = sumA.plus(a), sumB.plus(b)
+		sumR.Add(sumR, r)
+	}
+	// сумма шифртекстов (R·G, G + R·Y): log_G (ΣA) = log_Y (ΣB - G)
+	ballot.SumProof, err = proveDleq(voter, sumR.Mod(sumR, curveN), sumA, key, sumB.plus(baseMul(big.NewInt(1)).neg()))
+	if err != nil {
+		return nil, err
+	}
+	return &ballot, nil
+}
+
+// verifyEncryptedBallot checks proofs, that the ballot of the voter encrypts exactly one vote for one option.
+// Sizes of the ballot and its points must be checked before
+func verifyEncryptedBallot(ballot *golosovaniepb.Ballot, voter []byte) bool {
+	key, err := decodePoint(ballot.ElectionKey)
+	if err != nil || len(ballot.BitProofs) != len(ballot.Encrypted) {
+		return false
+	}
+	var sumA, sumB ecSum
+	for k, c := range ballot.Encrypted {
+		a, err := decodePoint(c.A)
+		if err != nil {
+			return false
+		}
+		b, err := decodePoint(c.B)
+		if err != nil {
+			return false
+		}
+		if !verifyBit(voter, ballot.BitProofs[k], key, a, b) {
+			return false
+		}
+		sumA, sumB = sumA.plus(a), sumB.plus(b)
+	}
+	return verifyDleq(voter, ballot.SumProof, sumA, key, sumB.plus(baseMul(big.NewInt(1)).neg()))
+}
+
+// bitTargets returns b - m·G for m = 0 and m = 1, for encryption of m it equals r·Y
+func bitTargets(b ecSum) [2]ecSum {
+	return [2]ecSum{b, b.plus(baseMul(big.NewInt(1)).neg())}
+}
+
+// proveBit proves, that (a, b) = (r·G, bit·G + r·Y) with bit 0 or 1. Proof for the other bit is simulated
+func proveBit(context []byte, key, a, b ecSum, r *big.Int, bit int) (*golosovaniepb.BitProof, error) {
+	targets := bitTargets(b)
+	var c, z [2]*big.Int
+	var t1, t2 [2]ecSum
+	fake := 1 - bit
+	var err error
+	c[fake], err = randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	z[fake], err = randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	t1[fake] = baseMul(z[fake]).plus(a.mul(c[fake]).neg())
+	t2[fake] = key.mul(z[fake]).plus(targets[fake].mul(c[fake]).neg())
+	w, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	t1[bit], t2[bit] = baseMul(w), key.mul(w)
+	challenge := hashScalar(context, key, a, b, t1[0], t2[0], t1[1], t2[1])
+	c[bit] = new(big.Int).Sub(challenge, c[fake])
+	c[bit].Mod(c[bit], curveN)
+	z[bit] = new(big.Int).Mul(c[bit], r)
+	z[bit].Add(z[bit], w)
+	z[bit].Mod(z[bit], curveN)
+	return &golosovaniepb.BitProof{
+		C0: math.PaddedBigBytes(c[0], 32),
+		C1: math.PaddedBigBytes(c[1], 32),
+		Z0: math.PaddedBigBytes(z[0], 32),
+		Z1: math.PaddedBigBytes(z[1], 32),
+	}, nil
+}
+
+func verifyBit(context []byte, proof *golosovaniepb.BitProof, key, a, b ecSum) bool {
+	if proof == nil {
+		return false
+	}
+	var c, z [2]*big.Int
+	var ok [4]bool
+	c[0], ok[0] = parseScalar(proof.C0)
+	c[1], ok[1] = parseScalar(proof.C1)
+	z[0], ok[2] = parseScalar(proof.Z0)
+	z[1], ok[3] = parseScalar(proof.Z1)
+	if !ok[0] || !ok[1] || !ok[2] || !ok[3] {
+		return false
+	}
+	targets := bitTargets(b)
+	var t1, t2 [2]ecSum
+	for i := range targets {
+		t1[i] = baseMul(z[i]).plus(a.mul(c[i]).neg())
+		t2[i] = key.mul(z[i]).plus(targets[i].mul(c[i]).neg())
+	}
+	sum := new(big.Int).Add(c[0], c[1])
+	return sum.Mod(sum, curveN).Cmp(hashScalar(context, key, a, b, t1[0], t2[0], t1[1], t2[1])) == 0
+}
+
+// proveDleq proves, that X = x·G and D = x·A
+func proveDleq(context []byte, x *big.Int, X, A, D ecSum) (*golosovaniepb.DleqProof, error) {
+	w, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	c := hashScalar(context, X, A, D, baseMul(w), A.mul(w))
+	z := new(big.Int).Mul(c, x)
+	z.Add(z, w)
+	z.Mod(z, curveN)
+	return &golosovaniepb.DleqProof{
+		Challenge: math.PaddedBigBytes(c, 32),
+		Response:  math.PaddedBigBytes(z, 32),
+	}, nil
+}
+
+func verifyDleq(context []byte, proof *golosovaniepb.DleqProof, X, A, D ecSum) bool {
+	if proof == nil {
+		return false
+	}
+	c, cOk := parseScalar(proof.Challenge)
+	z, zOk := parseScalar(proof.Response)
+	if !cOk || !zOk {
+		return false
+	}
+	// w·G = z·G - c·X, w·A = z·A - c·D
+	t1 := baseMul(z).plus(X.mul(c).neg())
+	t2 := A.mul(z).plus(D.mul(c).neg())
+	return hashScalar(context, X, A, D, t1, t2).Cmp(c) == 0
+}
+
+// CreatePartialDecryptionTx creates partial decryption of the tally of the voting by the validator with keys.
+// ballots are counted ballots of the voting, options is the number of options
+func CreatePartialDecryptionTx(
+	votingHash []byte,
+	keyShares []*golosovaniepb.KeyShare,
+	ballots []*VoteBallot,
+	options int,
+	validators [][]byte,
+	keys *CryptoKeysData,
+) (*golosovaniepb.Transaction, error) {
+	j := validatorIndex(validators, keys.PkeyByte[:])
+	if j < 0 {
+		return nil, fmt.Errorf("not a validator")
+	}
+	if len(ballots) == 0 {
+		return nil, fmt.Errorf("no ballots to decrypt")
+	}
+	x, err := secretShare(keyShares, j, keys)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := aggregateBallots(ballots, options)
+	if err != nil {
+		return nil, err
+	}
+	X := baseMul(x)
+	decryption := golosovaniepb.PartialDecryption{ValidatorPkey: keys.PkeyByte[:], ValidatorIndex: uint32(j)}
+	for _, sum := range sums {
+		D := sum.a.mul(x)
+		proof, err := proveDleq(nil, x, X, sum.a, D)
+		if err != nil {
+			return nil, err
+		}
+		decryption.Shares = append(decryption.Shares, D.bytes())
+		decryption.Proofs = append(decryption.Proofs, proof)
+	}
+	return signBareTx(&golosovaniepb.TxBody{ValueType: votingHash, PartialDecryption: &decryption}, keys)
+}
+
+// verifyPartialDecryption checks proofs of the partial decryption against the public share of the validator
+func verifyPartialDecryption(
+	decryption *golosovaniepb.PartialDecryption,
+	keyShares []*golosovaniepb.KeyShare,
+	sums []ciphertextSum,
+	validators [][]byte,
+) bool {
+	j := validatorIndex(validators, decryption.ValidatorPkey)
+	if j < 0 || j != int(decryption.ValidatorIndex) || len(decryption.Shares) != len(sums) || len(decryption.Proofs) != len(sums) {
+		return false
+	}
+	X, err := publicShare(keyShares, j)
+	if err != nil {
+		return false
+	}
+	for k, sum := range sums {
+		D, err := decodePoint(decryption.Shares[k])
+		if err != nil || !verifyDleq(nil, decryption.Proofs[k], X, sum.a, D) {
+			return false
+		}
+	}
+	return true
+}
+
+// lagrangeAtZero returns coefficient λ_j of the interpolation at zero over x coordinates xs
+func lagrangeAtZero(xs []int64, j int) *big.Int {
+	num, den := big.NewInt(1), big.NewInt(1)
+	for m, xm := range xs {
+		if m == j {
+			continue
+		}
+		num.Mul(num, big.NewInt(xm))
+		num.Mod(num, curveN)
+		den.Mul(den, big.NewInt(xm-xs[j]))
+		den.Mod(den, curveN)
+	}
+	return num.Mul(num, new(big.Int).ModInverse(den, curveN)).Mod(num, curveN)
+}
+
+// babySteps is a table of baby-step giant-step search of discrete logarithms up to bound,
+// built once and used for every option of the voting
+type babySteps struct {
+	step  uint64
+	table map[string]uint64 // i·G -> i for i from 1 to step
+}
+
+func newBabySteps(bound uint64) *babySteps {
+	step := new(big.Int).Sqrt(new(big.Int).SetUint64(bound)).Uint64() + 1
+	b := &babySteps{step: step, table: make(map[string]uint64, step)}
+	var cur ecSum
+	g := baseMul(big.NewInt(1))
+	for i := uint64(1); i <= step; i++ {
+		cur = cur.plus(g)
+		b.table[string(cur.bytes())] = i
+	}
+	return b
+}
+
+// discreteLog finds m <= step^2, such that m·G = p
+func (b *babySteps) discreteLog(p ecSum) (uint64, error) {
+	giant := baseMul(new(big.Int).SetUint64(b.step)).neg()
+	cur := p
+	for j := uint64(0); j <= b.step; j++ {
+		if cur.x == nil {
+			return j * b.step, nil
+		}
+		if i, ok := b.table[string(cur.bytes())]; ok {
+			return j*b.step + i, nil
+		}
+		cur = cur.plus(giant)
+	}
+	return 0, fmt.Errorf("tally is greater than %v", b.step*b.step)
+}
+
+// tallyBound returns the maximum sum of votes for one option: each ballot adds at most its weight
+// to one option. The bound is capped by MaxEncryptedTally, so the search takes limited time and memory
+func tallyBound(counted []*VoteBallot) uint64 {
+	var maxWeight uint64
+	for _, b := range counted {
+		if b.Value > maxWeight {
+			maxWeight = b.Value
+		}
+	}
+	if maxWeight != 0 && uint64(len(counted)) > MaxEncryptedTally/maxWeight {
+		return MaxEncryptedTally
+	}
+	return uint64(len(counted)) * maxWeight
+}
+
+// decryptTally combines threshold verified partial decryptions and returns sums of votes for each option.
+// bound is the maximum sum, see tallyBound
+func decryptTally(
+	sums []ciphertextSum,
+	decryptions []*golosovaniepb.PartialDecryption,
+	threshold int,
+	bound uint64,
+) ([]uint64, error) {
+	if len(decryptions) < threshold {
+		return nil, fmt.Errorf("not enough partial decryptions")
+	}
+	decryptions = decryptions[:threshold]
+	xs := make([]int64, threshold)
+	for i, d := range decryptions {
+		xs[i] = int64(d.ValidatorIndex) + 1
+	}
+	steps := newBabySteps(bound)
+	res := make([]uint64, len(sums))
+	for k, sum := range sums {
+		var xA ecSum
+		for i, d := range decryptions {
+			D, err := decodePoint(d.Shares[k])
+			if err != nil {
+				return nil, err
+			}
+			xA = xA.plus(D.mul(lagrangeAtZero(xs, i)))
+		}
+		m, err := steps.discreteLog(sum.b.plus(xA.neg()))
+		if err != nil {
+			return nil, err
+		}
+		res[k] = m
+	}
+	return res, nil
+}
+
+// computeEncryptedTally decrypts the result of the encrypted voting with the last committed block,
+// nil if the voting has not enough partial decryptions yet. Decryption errors don't stop the chain:
+// partial decryptions are verified before they get into blocks, so the only possible error is the tally
+// greater than the search bound, then the result is saved as undecryptable
+func computeEncryptedTally(db *Database, votingHash []byte) (*golosovaniepb.EncryptedTally, error) {
+	keyShares, err := db.GetKeyShares(votingHash)
+	if err != nil || len(keyShares) == 0 {
+		return nil, err
+	}
+	decryptions, err := db.GetPartialDecryptions(votingHash)
+	if err != nil {
+		return nil, err
+	}
+	threshold := len(keyShares[0].Commitments)
+	if len(decryptions) < threshold {
+		return nil, nil
+	}
+	t, timeStart, err := db.GetTxAndTimeByHash(votingHash)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no voting with hash %x", votingHash)
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(t.TxBody, &body)
+	if err != nil {
+		return nil, err
+	}
+	// расшифровки принимаются после окончания голосования, учтенные бюллетени уже не меняются
+	counted, _, err := CountedBallotsAtHeight(db, votingHash, &body, timeStart, DbHeightLatest, true)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := aggregateBallots(counted, len(body.VotingParams.GetOptions()))
+	if err != nil {
+		return nil, err
+	}
+	counts, err := decryptTally(sums, decryptions, threshold, tallyBound(counted))
+	if err != nil {
+		fmt.Println("cannot decrypt tally of voting", hex.EncodeToString(votingHash), err)
+		return &golosovaniepb.EncryptedTally{Undecryptable: true}, nil
+	}
+	return &golosovaniepb.EncryptedTally{Counts: counts}, nil
+}
+
+// saveEncryptedTallies decrypts results of encrypted votings, which got enough partial decryptions
+// in already saved block b. Each result is computed once, so the state doesn't depend on decryption
+func saveEncryptedTallies(db *Database, b *golosovaniepb.Block) error {
+	done := make(map[[HashSize]byte]bool)
+	for _, tx := range b.Transactions {
+		var body golosovaniepb.TxBody
+		err := proto.Unmarshal(tx.TxBody, &body)
+		if err != nil {
+			return err
+		}
+		if body.PartialDecryption == nil || done[SliceToHash(body.ValueType)] {
+			continue
+		}
+		done[SliceToHash(body.ValueType)] = true
+		saved, err := db.GetEncryptedTallyAtHeight(body.ValueType, DbHeightLatest)
+		if err != nil {
+			return err
+		}
+		if saved != nil {
+			continue
+		}
+		tally, err := computeEncryptedTally(db, body.ValueType)
+		if err != nil {
+			return err
+		}
+		if tally != nil {
+			err = db.SaveEncryptedTally(body.ValueType, b.Hash, tally)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
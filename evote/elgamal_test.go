@@ -0,0 +1,166 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestElgamal(t *testing.T) {
+	votingHash := Hash([]byte("voting"))
+	var validatorKeys []*CryptoKeysData
+	var nodes []*ValidatorNode
+	for i := 0; i < 4; i++ {
+		keys := randKeys()
+		validatorKeys = append(validatorKeys, keys)
+		nodes = append(nodes, &ValidatorNode{Pkey: keys.PkeyByte})
+	}
+	validators := SortedValidatorPkeys(nodes)
+	threshold := DecryptionThreshold(len(validators))
+	assert.Equal(t, 3, threshold)
+
+	var keyShares []*golosovaniepb.KeyShare
+	for _, keys := range validatorKeys {
+		tx, err := CreateKeyShareTx(votingHash, validators, keys)
+		assert.Nil(t, err)
+		assert.True(t, VerifyData(tx.TxBody, tx.Sig, keys.PkeyByte[:]))
+		var body golosovaniepb.TxBody
+		assert.Nil(t, proto.Unmarshal(tx.TxBody, &body))
+		assert.True(t, checkKeyShare(body.KeyShare, validators))
+		keyShares = append(keyShares, body.KeyShare)
+	}
+	electionKey, err := ElectionKey(keyShares)
+	assert.Nil(t, err)
+
+	t.Run("shares", func(t *testing.T) {
+		// доли ключа лежат на общем многочлене: интерполяция любых threshold долей дает секрет общего ключа
+		xs := make([]int64, threshold)
+		secret := new(big.Int)
+		for i, keys := range validatorKeys[:threshold] {
+			j := validatorIndex(validators, keys.PkeyByte[:])
+			xs[i] = int64(j + 1)
+			share, err := secretShare(keyShares, j, keys)
+			assert.Nil(t, err)
+			public, err := publicShare(keyShares, j)
+			assert.Nil(t, err)
+			expected := baseMul(share)
+			assert.True(t, expected.equal(&public))
+		}
+		for i, keys := range validatorKeys[:threshold] {
+			share, _ := secretShare(keyShares, validatorIndex(validators, keys.PkeyByte[:]), keys)
+			secret.Add(secret, share.Mul(share, lagrangeAtZero(xs, i)))
+		}
+		assert.Equal(t, electionKey, baseMul(secret).bytes())
+	})
+
+	t.Run("invalid_share", func(t *testing.T) {
+		keys := validatorKeys[0]
+		j := validatorIndex(validators, keys.PkeyByte[:])
+		corrupted := proto.Clone(keyShares[1]).(*golosovaniepb.KeyShare)
+		corrupted.EncryptedShares[j] = xorBytes(corrupted.EncryptedShares[j], append(make([]byte, 31), 1))
+		_, err := secretShare([]*golosovaniepb.KeyShare{keyShares[0], corrupted}, j, keys)
+		assert.NotNil(t, err)
+	})
+
+	const options = 3
+	choices := []int{0, 2, 2, 1, 2}
+	var ballots []*VoteBallot
+	for i, choice := range choices {
+		voter := randPkey()
+		ballot, err := EncryptBallot(electionKey, options, choice, voter)
+		assert.Nil(t, err)
+		assert.True(t, verifyEncryptedBallot(ballot, voter))
+		ballots = append(ballots, &VoteBallot{Ballot: ballot, Value: uint64(i + 1), Sender: voter})
+	}
+
+	t.Run("ballot_proofs", func(t *testing.T) {
+		key, _ := decodePoint(electionKey)
+		voter := ballots[0].Sender
+		// шифртекст с m голосов за первый вариант вместо выбранного в бюллетене
+		forge := func(m int64) *golosovaniepb.Ballot {
+			forged := proto.Clone(ballots[0].Ballot).(*golosovaniepb.Ballot)
+			r, _ := randomScalar()
+			forged.Encrypted[0] = &golosovaniepb.Ciphertext{
+				A: baseMul(r).bytes(),
+				B: key.mul(r).plus(baseMul(big.NewInt(m))).bytes(),
+			}
+			return forged
+		}
+		assert.False(t, verifyEncryptedBallot(forge(1000), voter))
+		assert.False(t, verifyEncryptedBallot(forge(-1), voter))
+		// бюллетень нельзя выдать за свой
+		assert.False(t, verifyEncryptedBallot(ballots[0].Ballot, randPkey()))
+		// каждый шифртекст содержит 0 или 1, но выбрано два варианта
+		first, _ := EncryptBallot(electionKey, options, 0, voter)
+		second, _ := EncryptBallot(electionKey, options, 1, voter)
+		twoVotes := proto.Clone(first).(*golosovaniepb.Ballot)
+		twoVotes.Encrypted[1], twoVotes.BitProofs[1] = second.Encrypted[1], second.BitProofs[1]
+		assert.False(t, verifyEncryptedBallot(twoVotes, voter))
+		noProofs := proto.Clone(first).(*golosovaniepb.Ballot)
+		noProofs.BitProofs = nil
+		assert.False(t, verifyEncryptedBallot(noProofs, voter))
+		noSumProof := proto.Clone(first).(*golosovaniepb.Ballot)
+		noSumProof.SumProof = nil
+		assert.False(t, verifyEncryptedBallot(noSumProof, voter))
+	})
+	sums, err := aggregateBallots(ballots, options)
+	assert.Nil(t, err)
+
+	var decryptions []*golosovaniepb.PartialDecryption
+	for _, keys := range validatorKeys {
+		tx, err := CreatePartialDecryptionTx(votingHash, keyShares, ballots, options, validators, keys)
+		assert.Nil(t, err)
+		var body golosovaniepb.TxBody
+		assert.Nil(t, proto.Unmarshal(tx.TxBody, &body))
+		assert.True(t, verifyPartialDecryption(body.PartialDecryption, keyShares, sums, validators))
+		decryptions = append(decryptions, body.PartialDecryption)
+	}
+
+	t.Run("decrypt", func(t *testing.T) {
+		expected := []uint64{1, 4, 2 + 3 + 5}
+		res, err := decryptTally(sums, decryptions, threshold, 15)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, res)
+		// результат не зависит от того, какие валидаторы расшифровали сумму
+		res, err = decryptTally(sums, decryptions[1:], threshold, 15)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, res)
+		_, err = decryptTally(sums, decryptions[:threshold-1], threshold, 15)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid_decryption", func(t *testing.T) {
+		forged := proto.Clone(decryptions[0]).(*golosovaniepb.PartialDecryption)
+		forged.Shares[0] = forged.Shares[1]
+		assert.False(t, verifyPartialDecryption(forged, keyShares, sums, validators))
+		forged = proto.Clone(decryptions[0]).(*golosovaniepb.PartialDecryption)
+		forged.ValidatorPkey = decryptions[1].ValidatorPkey
+		assert.False(t, verifyPartialDecryption(forged, keyShares, sums, validators))
+		forged.ValidatorIndex = decryptions[1].ValidatorIndex
+		assert.False(t, verifyPartialDecryption(forged, keyShares, sums, validators))
+	})
+
+	t.Run("discrete_log", func(t *testing.T) {
+		steps := newBabySteps(100)
+		for _, m := range []uint64{0, 1, 7, 99, 100} {
+			res, err := steps.discreteLog(baseMul(new(big.Int).SetUint64(m)))
+			assert.Nil(t, err)
+			assert.Equal(t, m, res)
+		}
+		_, err := steps.discreteLog(baseMul(big.NewInt(1000)))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("tally_bound", func(t *testing.T) {
+		ballot := func(value uint64) *VoteBallot {
+			return &VoteBallot{Value: value}
+		}
+		assert.Equal(t, uint64(0), tallyBound(nil))
+		assert.Equal(t, uint64(21), tallyBound([]*VoteBallot{ballot(1), ballot(7), ballot(3)}))
+		// вес бюллетеня ограничен только MaxAmount, поэтому граница не больше MaxEncryptedTally
+		assert.Equal(t, uint64(MaxEncryptedTally), tallyBound([]*VoteBallot{ballot(MaxAmount), ballot(1)}))
+		assert.Equal(t, uint64(MaxEncryptedTally), tallyBound([]*VoteBallot{ballot(MaxEncryptedTally), ballot(1)}))
+	})
+}
@@ -0,0 +1,350 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"time"
+)
+
+// Genesis app state is the JSON app_state of tendermint genesis.json, it is passed to InitChain
+// in AppStateBytes. Every validator gets the same bytes, so they are saved into DB as is and
+// become the value of the state key StateKeyGenesis. Chains without app state use defaults.
+// The app state is generated by utils/gen_genesis.
+//
+//	{
+//	  "genesis_time": "2021-05-01T12:00:00Z",
+//	  "reward": {"initial": 1000, "halving_interval": 100000, "max_supply": 200000000},
+//	  "params": {"max_tx_size": 524288, "min_tx_fee": 1},
+//	  "balances": [{"pkey": "<hex>", "value": 5000}],
+//	  "votings": [{
+//	    "vote_type": 1, "duration": 86400, "title": "...", "description": "...",
+//	    "candidates": [{"pkey": "<hex>", "label": "..."}],
+//	    "voters": [{"pkey": "<hex>", "value": 1}]
+//	  }]
+//	}
+//
+// Balances and votings become transactions of the genesis block, see GenesisBlock.
+
+// GenesisState is the parsed genesis app state
+type GenesisState struct {
+	GenesisTime *time.Time       `json:"genesis_time,omitempty"` // time of the genesis block, votings start at it
+	Reward      *RewardSchedule  `json:"reward,omitempty"`
+	Params      *ChainParams     `json:"params,omitempty"`
+	Balances    []*GenesisOutput `json:"balances,omitempty"` // initial coins, at most one output per pkey
+	Votings     []*GenesisVoting `json:"votings,omitempty"`
+	allocation  uint64           // sum of outputs of the genesis block: balances and votes of votings
+}
+
+// ChainParams are limits of transactions, which are not part of the consensus rules of blocks
+type ChainParams struct {
+	MaxTxSize int    `json:"max_tx_size"` // max size of tx body, not greater than MaxTxSize
+	MinTxFee  uint64 `json:"min_tx_fee"`  // minimal fee of transactions, which spend coins, checked in CheckTx
+}
+
+type GenesisOutput struct {
+	Pkey  string `json:"pkey"` // hex
+	Value uint64 `json:"value"`
+}
+
+type GenesisCandidate struct {
+	Pkey  string `json:"pkey"` // hex
+	Label string `json:"label"`
+}
+
+// GenesisVoting is an open voting, created in the genesis block. Anonymous and encrypted votings
+// need validator transactions after creation, so they cannot be pre-registered
+type GenesisVoting struct {
+	VoteType     uint32              `json:"vote_type"`
+	Duration     uint32              `json:"duration"`        // in seconds from genesis_time
+	Seats        uint32              `json:"seats,omitempty"` // for StvVoteType
+	Title        string              `json:"title"`
+	Description  string              `json:"description,omitempty"`
+	DocumentHash string              `json:"document_hash,omitempty"` // hex
+	Candidates   []*GenesisCandidate `json:"candidates,omitempty"`
+	Voters       []*GenesisOutput    `json:"voters"` // votes of participants
+}
+
+// RewardSchedule defines reward for every block, the reward goes to the block proposer with fees
+type RewardSchedule struct {
+	Initial         uint64 `json:"initial"`          // reward for blocks of the first halving interval
+	HalvingInterval int64  `json:"halving_interval"` // reward is halved every HalvingInterval blocks, 0 - never
+	MaxSupply       uint64 `json:"max_supply"`       // limit of all coins with genesis outputs, 0 - only MaxAmount
+	genesis         uint64 // outputs of the genesis block, they are a part of MaxSupply
+}
+
+// DefaultRewardSchedule - награда за блок до перехода на расписание из genesis, выпуск монет не ограничен
+func DefaultRewardSchedule() *RewardSchedule {
+	return &RewardSchedule{Initial: RewardCoins}
+}
+
+// DefaultChainParams - параметры до перехода на параметры из genesis
+func DefaultChainParams() *ChainParams {
+	return &ChainParams{MaxTxSize: MaxTxSize, MinTxFee: MinTxFee}
+}
+
+// isEmptyAppState returns true, if genesis.json has no app_state
+func isEmptyAppState(appState []byte) bool {
+	appState = bytes.TrimSpace(appState)
+	return len(appState) == 0 || bytes.Equal(appState, []byte("null")) || bytes.Equal(appState, []byte(`""`))
+}
+
+// ParseGenesisState parses and checks genesis app state, empty app state gives defaults
+func ParseGenesisState(appState []byte) (*GenesisState, error) {
+	// отсутствующие в app state параметры остаются по умолчанию
+	genesis := GenesisState{Params: DefaultChainParams()}
+	if !isEmptyAppState(appState) {
+		decoder := json.NewDecoder(bytes.NewReader(appState))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&genesis)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis app state: %v", err)
+		}
+	}
+	if genesis.Reward == nil {
+		genesis.Reward = DefaultRewardSchedule()
+	}
+	if genesis.Params == nil {
+		genesis.Params = DefaultChainParams()
+	}
+	err := genesis.Reward.check()
+	if err != nil {
+		return nil, err
+	}
+	err = genesis.Params.check()
+	if err != nil {
+		return nil, err
+	}
+	// транзакции genesis блока проверяются при их создании
+	_, genesis.allocation, err = genesis.transactions()
+	if err != nil {
+		return nil, err
+	}
+	// награды выпускаются только до max_supply вместе с выходами genesis блока
+	if genesis.allocation > genesis.Reward.supplyLimit() {
+		return nil, fmt.Errorf("genesis outputs %d exceed max supply %d", genesis.allocation, genesis.Reward.supplyLimit())
+	}
+	genesis.Reward.genesis = genesis.allocation
+	return &genesis, nil
+}
+
+// Allocation returns the sum of outputs of the genesis block
+func (g *GenesisState) Allocation() uint64 {
+	return g.allocation
+}
+
+func (p *ChainParams) check() error {
+	if p.MaxTxSize <= 0 || p.MaxTxSize > MaxTxSize {
+		return fmt.Errorf("max tx size must be from 1 to %d", MaxTxSize)
+	}
+	if p.MinTxFee > MaxAmount {
+		return fmt.Errorf("min tx fee must not be greater than %d", MaxAmount)
+	}
+	return nil
+}
+
+func decodePkey(pkey string) ([]byte, error) {
+	b, err := hex.DecodeString(pkey)
+	if err != nil || len(b) != PkeySize {
+		return nil, fmt.Errorf("invalid pkey %q, expected %d bytes in hex", pkey, PkeySize)
+	}
+	return b, nil
+}
+
+// genesisOutputs converts balances into outputs, each pkey must appear once. total is increased by the sum of outputs
+func genesisOutputs(balances []*GenesisOutput, total *uint64) ([]*golosovaniepb.Output, error) {
+	outputs := make([]*golosovaniepb.Output, 0, len(balances))
+	pkeys := make(map[[PkeySize]byte]bool, len(balances))
+	for _, balance := range balances {
+		pkey, err := decodePkey(balance.Pkey)
+		if err != nil {
+			return nil, err
+		}
+		if pkeys[SliceToPkey(pkey)] {
+			return nil, fmt.Errorf("pkey %v is repeated", balance.Pkey)
+		}
+		pkeys[SliceToPkey(pkey)] = true
+		if balance.Value == 0 {
+			return nil, fmt.Errorf("zero value for pkey %v", balance.Pkey)
+		}
+		var ok bool
+		*total, ok = AddAmounts(*total, balance.Value)
+		if !ok {
+			return nil, fmt.Errorf("sum of genesis coins exceeds %d", MaxAmount)
+		}
+		outputs = append(outputs, newOutput(pkey, nil, balance.Value))
+	}
+	return outputs, nil
+}
+
+// txBody returns body of create vote tx of the voting
+func (v *GenesisVoting) txBody(total *uint64) (*golosovaniepb.TxBody, error) {
+	if v.VoteType == EncryptedVoteType || v.VoteType == MultiQuestionVoteType || !IsKnownVoteType(v.VoteType) {
+		return nil, fmt.Errorf("vote type %v cannot be used in genesis", v.VoteType)
+	}
+	if v.Duration == 0 {
+		return nil, fmt.Errorf("voting %q has zero duration", v.Title)
+	}
+	if len(v.Voters) == 0 || len(v.Voters) > MaxVoteTxOutputs {
+		return nil, fmt.Errorf("voting %q must have from 1 to %d voters", v.Title, MaxVoteTxOutputs)
+	}
+	outputs, err := genesisOutputs(v.Voters, total)
+	if err != nil {
+		return nil, fmt.Errorf("voting %q: %v", v.Title, err)
+	}
+	metadata := &golosovaniepb.VotingMetadata{Title: v.Title, Description: v.Description}
+	if v.DocumentHash != "" {
+		metadata.DocumentHash, err = hex.DecodeString(v.DocumentHash)
+		if err != nil {
+			return nil, fmt.Errorf("voting %q: invalid document hash", v.Title)
+		}
+	}
+	for _, candidate := range v.Candidates {
+		pkey, err := decodePkey(candidate.Pkey)
+		if err != nil {
+			return nil, fmt.Errorf("voting %q: %v", v.Title, err)
+		}
+		metadata.Candidates = append(metadata.Candidates, &golosovaniepb.CandidateLabel{Pkey: pkey, Label: candidate.Label})
+	}
+	body := &golosovaniepb.TxBody{
+		Outputs:        outputs,
+		VoteType:       v.VoteType,
+		Duration:       v.Duration,
+		VotingMetadata: metadata,
+	}
+	if v.Seats != 0 {
+		body.VotingParams = &golosovaniepb.VotingParams{Seats: v.Seats}
+	}
+	// те же проверки, что и у транзакции создания голосования
+	if checkVotingParams(body) != CodeOk || checkVotingMetadata(body.VotingMetadata) != CodeOk {
+		return nil, fmt.Errorf("voting %q has invalid params or description", v.Title)
+	}
+	return body, nil
+}
+
+// transactions returns transactions of the genesis block: balances, split into txs of MaxVoteTxOutputs
+// outputs, and create vote txs of votings, and the sum of their outputs. Transactions have no inputs and signatures
+func (g *GenesisState) transactions() ([]*golosovaniepb.Transaction, uint64, error) {
+	if len(g.Votings) != 0 && g.GenesisTime == nil {
+		return nil, 0, fmt.Errorf("genesis_time is required for votings")
+	}
+	var total uint64
+	outputs, err := genesisOutputs(g.Balances, &total)
+	if err != nil {
+		return nil, 0, err
+	}
+	var bodies []*golosovaniepb.TxBody
+	for start := 0; start < len(outputs); start += MaxVoteTxOutputs {
+		end := start + MaxVoteTxOutputs
+		if end > len(outputs) {
+			end = len(outputs)
+		}
+		bodies = append(bodies, &golosovaniepb.TxBody{Outputs: outputs[start:end]})
+	}
+	for _, voting := range g.Votings {
+		body, err := voting.txBody(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+		bodies = append(bodies, body)
+	}
+	txs := make([]*golosovaniepb.Transaction, 0, len(bodies))
+	hashes := make(map[[HashSize]byte]bool, len(bodies))
+	for _, body := range bodies {
+		txBytes, err := proto.Marshal(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		tx := &golosovaniepb.Transaction{TxBody: txBytes, Hash: Hash(txBytes)}
+		if hashes[SliceToHash(tx.Hash)] {
+			// одинаковые голосования
+			return nil, 0, fmt.Errorf("genesis transactions are repeated")
+		}
+		hashes[SliceToHash(tx.Hash)] = true
+		txs = append(txs, tx)
+	}
+	return txs, total, nil
+}
+
+// GenesisBlock returns the block, which precedes the first block of the chain, nil if genesis has no
+// balances and votings. The block is built from app state, so it is the same on every validator.
+// Its height in DB is -1, the first block of tendermint references it as the previous block
+func GenesisBlock(genesis *GenesisState) (*golosovaniepb.Block, error) {
+	txs, _, err := genesis.transactions()
+	if err != nil || len(txs) == 0 {
+		return nil, err
+	}
+	timestamp := time.Unix(0, 0)
+	if genesis.GenesisTime != nil {
+		timestamp = *genesis.GenesisTime
+	}
+	return CreateBlock(txs, nil, timestamp, ZeroArrayPkey)
+}
+
+func (s *RewardSchedule) check() error {
+	if s.Initial > MaxAmount || s.MaxSupply > MaxAmount {
+		return fmt.Errorf("reward and max supply must not be greater than %d", MaxAmount)
+	}
+	if s.HalvingInterval < 0 {
+		return fmt.Errorf("halving interval must not be negative")
+	}
+	return nil
+}
+
+// supplyLimit returns the limit of all coins, rewards are limited by supplyLimit() - s.genesis
+func (s *RewardSchedule) supplyLimit() uint64 {
+	if s.MaxSupply == 0 {
+		return MaxAmount
+	}
+	return s.MaxSupply
+}
+
+// scheduledReward returns reward for the block height without the supply limit
+func (s *RewardSchedule) scheduledReward(height int64) uint64 {
+	if s.HalvingInterval == 0 {
+		return s.Initial
+	}
+	halvings := height / s.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return s.Initial >> uint(halvings)
+}
+
+// SupplyBefore returns coins issued by rewards for blocks with heights from 0 to height-1
+func (s *RewardSchedule) SupplyBefore(height int64) uint64 {
+	var supply uint64
+	limit := s.supplyLimit() - s.genesis
+	// награда одинакова в пределах интервала между уменьшениями, поэтому суммируется по интервалам
+	for start := int64(0); start < height; {
+		end := height
+		if s.HalvingInterval != 0 && start+s.HalvingInterval < end {
+			end = start + s.HalvingInterval
+		}
+		reward := s.scheduledReward(start)
+		if reward == 0 {
+			break
+		}
+		blocks := uint64(end - start)
+		if blocks > (limit-supply)/reward {
+			return limit
+		}
+		supply += blocks * reward
+		start = end
+	}
+	return supply
+}
+
+// RewardAt returns reward for the block height, height in DB starts from 0. The last reward
+// is reduced, so the sum of rewards and genesis outputs does not exceed the supply limit, after that rewards are 0
+func (s *RewardSchedule) RewardAt(height int64) uint64 {
+	left := s.supplyLimit() - s.genesis - s.SupplyBefore(height)
+	reward := s.scheduledReward(height)
+	if reward > left {
+		return left
+	}
+	return reward
+}
@@ -0,0 +1,150 @@
+package evote
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRewardSchedule(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		for _, appState := range []string{"", "null", `""`, "{}"} {
+			genesis, err := ParseGenesisState([]byte(appState))
+			assert.Nil(t, err)
+			assert.Equal(t, DefaultRewardSchedule(), genesis.Reward)
+		}
+		rewards := DefaultRewardSchedule()
+		assert.Equal(t, uint64(RewardCoins), rewards.RewardAt(1000000))
+		assert.Equal(t, uint64(RewardCoins*10), rewards.SupplyBefore(10))
+	})
+
+	t.Run("halving", func(t *testing.T) {
+		rewards := &RewardSchedule{Initial: 100, HalvingInterval: 10}
+		assert.Equal(t, uint64(100), rewards.RewardAt(9))
+		assert.Equal(t, uint64(50), rewards.RewardAt(10))
+		assert.Equal(t, uint64(25), rewards.RewardAt(25))
+		assert.Equal(t, uint64(0), rewards.RewardAt(70))
+		assert.Equal(t, uint64(100*10+50*5), rewards.SupplyBefore(15))
+		// 100 + 50 + 25 + 12 + 6 + 3 + 1 за интервал
+		assert.Equal(t, uint64(197*10), rewards.SupplyBefore(1000000))
+	})
+
+	t.Run("max_supply", func(t *testing.T) {
+		rewards := &RewardSchedule{Initial: 100, MaxSupply: 250}
+		assert.Equal(t, uint64(100), rewards.RewardAt(1))
+		// последняя награда уменьшается до ограничения выпуска
+		assert.Equal(t, uint64(50), rewards.RewardAt(2))
+		assert.Equal(t, uint64(0), rewards.RewardAt(3))
+		assert.Equal(t, uint64(250), rewards.SupplyBefore(1000000))
+
+		unlimited := &RewardSchedule{Initial: MaxAmount}
+		assert.Equal(t, MaxAmount, unlimited.RewardAt(0))
+		assert.Equal(t, uint64(0), unlimited.RewardAt(1))
+	})
+
+	t.Run("max_supply_with_genesis", func(t *testing.T) {
+		pkey := hex.EncodeToString(keyPairs[0].pub)
+		genesis, err := ParseGenesisState([]byte(`{"reward": {"initial": 100, "max_supply": 250},
+			"balances": [{"pkey": "` + pkey + `", "value": 200}]}`))
+		assert.Nil(t, err)
+		// max_supply ограничивает награды вместе с выходами genesis блока
+		assert.Equal(t, uint64(50), genesis.Reward.RewardAt(0))
+		assert.Equal(t, uint64(0), genesis.Reward.RewardAt(1))
+		assert.Equal(t, uint64(50), genesis.Reward.SupplyBefore(1000000))
+		_, err = ParseGenesisState([]byte(`{"reward": {"initial": 100, "max_supply": 250},
+			"balances": [{"pkey": "` + pkey + `", "value": 251}]}`))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		genesis, err := ParseGenesisState([]byte(`{"reward": {"initial": 50, "halving_interval": 1000, "max_supply": 90000}}`))
+		assert.Nil(t, err)
+		assert.Equal(t, &RewardSchedule{Initial: 50, HalvingInterval: 1000, MaxSupply: 90000}, genesis.Reward)
+		_, err = ParseGenesisState([]byte(`{"reward": {"initial": 50, "halving_interval": -1}}`))
+		assert.NotNil(t, err)
+		_, err = ParseGenesisState([]byte(`{"rewards": {"initial": 50}}`))
+		assert.NotNil(t, err)
+		_, err = ParseGenesisState([]byte(`{"reward": {"initial": 9223372036854775808}}`))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGenesisBlock(t *testing.T) {
+	voter := hex.EncodeToString(keyPairs[0].pub)
+	candidate := hex.EncodeToString(keyPairs[1].pub)
+	appState := `{
+		"genesis_time": "2021-05-26T12:00:00Z",
+		"params": {"min_tx_fee": 5},
+		"balances": [{"pkey": "` + voter + `", "value": 100}, {"pkey": "` + candidate + `", "value": 200}],
+		"votings": [{"vote_type": 1, "duration": 60, "title": "Выборы",
+			"candidates": [{"pkey": "` + candidate + `", "label": "Иванов"}],
+			"voters": [{"pkey": "` + voter + `", "value": 3}]}]
+	}`
+
+	t.Run("valid", func(t *testing.T) {
+		genesis, err := ParseGenesisState([]byte(appState))
+		assert.Nil(t, err)
+		assert.Equal(t, &ChainParams{MaxTxSize: MaxTxSize, MinTxFee: 5}, genesis.Params)
+		b, err := GenesisBlock(genesis)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(b.BlockHeader.PrevBlockHash))
+		assert.Equal(t, uint64(genesis.GenesisTime.UnixNano()), b.BlockHeader.Timestamp)
+		assert.Equal(t, 2, len(b.Transactions))
+		balances := parseBody(t, b.Transactions[0])
+		assert.Equal(t, 2, len(balances.Outputs))
+		assert.Equal(t, uint64(200), balances.Outputs[1].Value)
+		voting := parseBody(t, b.Transactions[1])
+		assert.Equal(t, uint32(OneVoteType), voting.VoteType)
+		assert.Equal(t, "Иванов", voting.VotingMetadata.Candidates[0].Label)
+		assert.Equal(t, keyPairs[0].pub, voting.Outputs[0].ReceiverSpendPkey)
+		// блок одинаков у всех валидаторов
+		same, err := GenesisBlock(genesis)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash, same.Hash)
+	})
+	t.Run("supply", func(t *testing.T) {
+		genesis, err := ParseGenesisState([]byte(appState))
+		assert.Nil(t, err)
+		// балансы и голоса участников голосования
+		assert.Equal(t, uint64(100+200+3), genesis.Allocation())
+		code, err, resp := OnGetSupply(genesis.Reward, genesis.Allocation(), 9)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeOk), code)
+		assert.Equal(t, uint64(100+200+3), resp.GetSupply().Genesis)
+		assert.Equal(t, uint64(100+200+3+RewardCoins*10), resp.GetSupply().Issued)
+	})
+	t.Run("no_transactions", func(t *testing.T) {
+		genesis, err := ParseGenesisState([]byte(`{"reward": {"initial": 5}}`))
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultChainParams(), genesis.Params)
+		b, err := GenesisBlock(genesis)
+		assert.Nil(t, err)
+		assert.Nil(t, b)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		invalid := []string{
+			`{"params": {"max_tx_size": 0}}`,
+			`{"params": {"max_tx_size": 1000000000}}`,
+			`{"balances": [{"pkey": "00", "value": 1}]}`,
+			`{"balances": [{"pkey": "` + voter + `", "value": 0}]}`,
+			`{"balances": [{"pkey": "` + voter + `", "value": 1}, {"pkey": "` + voter + `", "value": 2}]}`,
+			`{"balances": [{"pkey": "` + voter + `", "value": 9223372036854775807}, {"pkey": "` + candidate + `", "value": 1}]}`,
+			// голосованию нужно время начала
+			`{"votings": [{"vote_type": 1, "duration": 60, "title": "a", "voters": [{"pkey": "` + voter + `", "value": 1}]}]}`,
+			`{"genesis_time": "2021-05-26T12:00:00Z", "votings": [{"vote_type": 7, "duration": 60, "title": "a",
+				"voters": [{"pkey": "` + voter + `", "value": 1}]}]}`,
+			`{"genesis_time": "2021-05-26T12:00:00Z", "votings": [{"vote_type": 5, "duration": 60, "title": "a",
+				"voters": [{"pkey": "` + voter + `", "value": 1}]}]}`,
+			`{"genesis_time": "2021-05-26T12:00:00Z", "votings": [{"vote_type": 1, "duration": 60, "title": "",
+				"voters": [{"pkey": "` + voter + `", "value": 1}]}]}`,
+			`{"genesis_time": "2021-05-26T12:00:00Z", "votings": [{"vote_type": 1, "duration": 60, "title": "a", "voters": []}]}`,
+		}
+		for _, appState := range invalid {
+			_, err := ParseGenesisState([]byte(appState))
+			assert.NotNil(t, err, appState)
+		}
+		voting := `{"vote_type": 1, "duration": 60, "title": "a", "voters": [{"pkey": "` + voter + `", "value": 1}]}`
+		_, err := ParseGenesisState([]byte(`{"genesis_time": "2021-05-26T12:00:00Z", "votings": [` + voting + `, ` + voting + `]}`))
+		assert.NotNil(t, err)
+	})
+}
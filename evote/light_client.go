@@ -0,0 +1,108 @@
+package evote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	"github.com/tendermint/tendermint/light"
+	"github.com/tendermint/tendermint/light/store/db"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+	rpchttp "github.com/tendermint/tendermint/rpc/client/http"
+	"github.com/tendermint/tendermint/types"
+	dbm "github.com/tendermint/tm-db"
+	"time"
+)
+
+// LightVerifier checks validator responses against block headers, verified by tendermint light client.
+// So client does not need to trust the validator it is connected to, only the trusted header
+// (usually taken from a block explorer or from another validator operator) and 2/3 of validators.
+type LightVerifier struct {
+	lc  *light.Client
+	prt *merkle.ProofRuntime
+}
+
+func rpcAddr(host string) string {
+	return "http://" + host
+}
+
+// NewLightVerifier creates light client, hosts are tendermint rpc addresses of validators,
+// the first one is used as primary, others as witnesses
+func NewLightVerifier(hosts []string, trustHeight int64, trustHash []byte) (*LightVerifier, error) {
+	if len(hosts) < 2 {
+		return nil, fmt.Errorf("light client needs at least 2 validators, got %v", len(hosts))
+	}
+	rpc, err := rpchttp.New(rpcAddr(hosts[0]), "/websocket")
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	status, err := rpc.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// chain id is not trusted, but the trusted header won't match a header from another chain
+	chainID := status.NodeInfo.Network
+	var witnesses []string
+	for _, host := range hosts[1:] {
+		witnesses = append(witnesses, rpcAddr(host))
+	}
+	lc, err := light.NewHTTPClient(
+		ctx,
+		chainID,
+		light.TrustOptions{
+			Period: LightTrustingPeriod,
+			Height: trustHeight,
+			Hash:   trustHash,
+		},
+		rpcAddr(hosts[0]),
+		witnesses,
+		db.New(dbm.NewMemDB(), chainID),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &LightVerifier{
+		lc:  lc,
+		prt: NewStateProofRuntime(),
+	}, nil
+}
+
+// AppHash returns verified app hash after block height. It is stored in the header of the next block,
+// which may be not created yet, so function blocks thread until it appears
+func (v *LightVerifier) AppHash(height int64) ([]byte, error) {
+	var err error
+	for round := 0; round < LightBlockWaitRounds; round++ {
+		var lb *types.LightBlock
+		lb, err = v.lc.VerifyLightBlockAtHeight(context.Background(), height+1, time.Now())
+		if err == nil {
+			return lb.AppHash, nil
+		}
+		time.Sleep(LightBlockWaitDelay)
+	}
+	return nil, fmt.Errorf("cannot verify header at height %v, err %v", height+1, err)
+}
+
+// Verify checks proof operation number opIndex from the response. Empty value can be proven both by
+// the value proof and by the absence proof, for a client these are the same
+func (v *LightVerifier) Verify(resp *abcitypes.ResponseQuery, opIndex int, key []byte, value []byte) error {
+	if resp.ProofOps == nil || opIndex >= len(resp.ProofOps.Ops) {
+		return fmt.Errorf("validator response has no proof")
+	}
+	op := resp.ProofOps.Ops[opIndex]
+	if !bytes.Equal(op.Key, key) {
+		return fmt.Errorf("proof is for another key")
+	}
+	appHash, err := v.AppHash(resp.Height)
+	if err != nil {
+		return err
+	}
+	proofOps := &tmcrypto.ProofOps{Ops: []tmcrypto.ProofOp{op}}
+	keyPath := StateKeyPath(key)
+	err = v.prt.VerifyValue(proofOps, appHash, keyPath, value)
+	if err != nil && len(value) == 0 {
+		err = v.prt.VerifyAbsence(proofOps, appHash, keyPath)
+	}
+	return err
+}
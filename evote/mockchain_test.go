@@ -3,6 +3,7 @@ package evote
 import (
 	"GO_LOSOVANIE/evote/golosovaniepb"
 	"crypto/rand"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/golang/protobuf/proto"
 	"time"
 )
@@ -39,6 +40,17 @@ func randSig() []byte {
 	return res
 }
 
+// randKeys creates real keys for transactions, which signatures are checked
+func randKeys() *CryptoKeysData {
+	prv, err := crypto.GenerateKey()
+	if err != nil {
+		panic(err)
+	}
+	var keys CryptoKeysData
+	keys.SetupKeys(crypto.FromECDSA(prv))
+	return &keys
+}
+
 var keyPairs = []keyPair{
 	{
 		pub:  randPkey(),
@@ -82,7 +94,7 @@ func tx(txBody *golosovaniepb.TxBody) *golosovaniepb.Transaction {
 	}
 }
 
-func makeCoinbaseTx(val uint32, pkeyTo []byte, rewardForBlock []byte) *golosovaniepb.Transaction {
+func makeCoinbaseTx(val uint64, pkeyTo []byte, height uint64) *golosovaniepb.Transaction {
 	txBody := golosovaniepb.TxBody{
 		Inputs: nil,
 		Outputs: []*golosovaniepb.Output{
@@ -91,12 +103,7 @@ func makeCoinbaseTx(val uint32, pkeyTo []byte, rewardForBlock []byte) *golosovan
 				ReceiverSpendPkey: pkeyTo[:],
 			},
 		},
-		HashLink:            rewardForBlock,
-		ValueType:           nil,
-		VoteType:            0,
-		Duration:            0,
-		SenderEphemeralPkey: nil,
-		VotersSumPkey:       nil,
+		Coinbase: &golosovaniepb.Coinbase{Height: height},
 	}
 	return tx(&txBody)
 }
@@ -133,7 +140,7 @@ var BlockZ = block(
 )
 
 var TxsBlock0 = []*golosovaniepb.Transaction{
-	makeCoinbaseTx(1000, keyPairs[0].pub, BlockZ.Hash),
+	makeCoinbaseTx(1000, keyPairs[0].pub, 1),
 }
 
 var Block0 = block(
@@ -144,7 +151,7 @@ var Block0 = block(
 )
 
 var TxsBlock1 = []*golosovaniepb.Transaction{
-	makeCoinbaseTx(2000, keyPairs[0].pub, Block0.Hash),
+	makeCoinbaseTx(2000, keyPairs[0].pub, 2),
 	tx(&golosovaniepb.TxBody{
 		Inputs: []*golosovaniepb.Input{
 			{
@@ -179,7 +186,7 @@ var Block1 = block(
 )
 
 var TxsBlock2 = []*golosovaniepb.Transaction{
-	makeCoinbaseTx(3000, keyPairs[1].pub, Block1.Hash),
+	makeCoinbaseTx(3000, keyPairs[1].pub, 3),
 	tx(&golosovaniepb.TxBody{
 		Inputs: []*golosovaniepb.Input{
 			{
@@ -218,7 +225,7 @@ var Block2 = block(
 )
 
 var TxsBlock3 = []*golosovaniepb.Transaction{
-	makeCoinbaseTx(3000, keyPairs[1].pub, Block2.Hash),
+	makeCoinbaseTx(3000, keyPairs[1].pub, 4),
 	tx(&golosovaniepb.TxBody{ // транза создания голосования
 		Inputs: []*golosovaniepb.Input{
 			{
@@ -246,6 +253,14 @@ var TxsBlock3 = []*golosovaniepb.Transaction{
 		Duration:            100,
 		SenderEphemeralPkey: nil,
 		VotersSumPkey:       nil,
+		VotingMetadata: &golosovaniepb.VotingMetadata{
+			Title: "Выборы старосты",
+			Candidates: []*golosovaniepb.CandidateLabel{
+				{Pkey: keyPairs[4].pub, Label: "Иванов"},
+				{Pkey: keyPairs[3].pub, Label: "Петров"},
+			},
+			DocumentHash: randHash(),
+		},
 	}),
 }
 
@@ -257,7 +272,7 @@ var Block3 = block(
 )
 
 var TxsBlock4 = []*golosovaniepb.Transaction{
-	makeCoinbaseTx(3000, keyPairs[6].pub, Block3.Hash),
+	makeCoinbaseTx(3000, keyPairs[6].pub, 5),
 	tx(&golosovaniepb.TxBody{ // транзакция отправки голоса
 		Inputs: []*golosovaniepb.Input{
 			{
@@ -286,3 +301,42 @@ var Block4 = block(
 	time.Now().Add(30*time.Second),
 	keyPairs[6].pub,
 )
+
+// анонимное голосование, транзакции не сохраняются в бд и используются для проверки транзакции инициализации
+
+var ValidatorKeys = randKeys()
+
+var AnonymousVoters = []*CryptoKeysData{randKeys(), randKeys(), randKeys()}
+
+func makeAnonymousCreateVoteTx(voters []*CryptoKeysData) *golosovaniepb.Transaction {
+	txBody := golosovaniepb.TxBody{
+		Inputs: []*golosovaniepb.Input{
+			{
+				PrevTxHash:  randHash(),
+				OutputIndex: 0,
+			},
+		},
+		VoteType: OneVoteType,
+		Duration: 100,
+	}
+	for i, voter := range voters {
+		txBody.Outputs = append(txBody.Outputs, &golosovaniepb.Output{
+			Value:             uint64(i + 1),
+			ReceiverSpendPkey: voter.PkeyByte[:],
+			ReceiverScanPkey:  voter.ScanKeys().PkeyByte[:],
+		})
+	}
+	return tx(&txBody)
+}
+
+var TxAnonymousCreateVote = makeAnonymousCreateVoteTx(AnonymousVoters)
+
+func makeInitVoteTx(createVoteTx *golosovaniepb.Transaction, keys *CryptoKeysData) *golosovaniepb.Transaction {
+	initTx, err := CreateInitVoteTx(createVoteTx, keys)
+	if err != nil {
+		panic(err)
+	}
+	return initTx
+}
+
+var TxAnonymousInitVote = makeInitVoteTx(TxAnonymousCreateVote, ValidatorKeys)
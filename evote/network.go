@@ -2,22 +2,25 @@ package evote
 
 import (
 	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmjson "github.com/tendermint/tendermint/libs/json"
 	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 )
 
 type Network struct {
 	workingHosts []string
 	allHosts     []string
 	curHost      string
+	verifier     *LightVerifier // if set, responses are checked against light client headers
 }
 
 // Copy used to make separate instance for working in another thread
@@ -30,6 +33,7 @@ func (n *Network) Copy() *Network {
 		workingHosts,
 		allHosts,
 		n.curHost,
+		n.verifier,
 	}
 }
 
@@ -105,6 +109,17 @@ func (n *Network) SelectNextHost() {
 	n.curHost = n.workingHosts[rand.Int()%len(n.workingHosts)]
 }
 
+// selectNextHostNoPing switches to the next host from all hosts, never pings and never panics
+func (n *Network) selectNextHostNoPing() {
+	for i, host := range n.allHosts {
+		if host == n.curHost {
+			n.curHost = n.allHosts[(i+1)%len(n.allHosts)]
+			return
+		}
+	}
+	n.curHost = n.allHosts[rand.Int()%len(n.allHosts)]
+}
+
 func (n *Network) createWorkingHosts() {
 	n.workingHosts = n.pingHosts(n.allHosts)
 	fmt.Println(len(n.workingHosts), "validators online")
@@ -123,6 +138,17 @@ func (n *Network) PingAll() {
 	n.createWorkingHosts()
 }
 
+// EnableVerification turns on checking of proofs in responses for balances, transactions and vote results,
+// trustHeight and trustHash identify a header, which is trusted by the user
+func (n *Network) EnableVerification(trustHeight int64, trustHash []byte) error {
+	verifier, err := NewLightVerifier(n.allHosts, trustHeight, trustHash)
+	if err != nil {
+		return err
+	}
+	n.verifier = verifier
+	return nil
+}
+
 func toRpcResp(respRaw []byte, err error) (*rpctypes.RPCResponse, error) {
 	if err != nil {
 		return nil, err
@@ -160,7 +186,8 @@ func toResponseQuery(result []byte, err error) (*abcitypes.ResponseQuery, error)
 	}
 	var wrappedResponse WrappedResponse
 	//fmt.Println("result", string(result))
-	err = json.Unmarshal(result, &wrappedResponse)
+	// tendermint encodes int64 fields (like height) as strings, so its own json package is needed
+	err = tmjson.Unmarshal(result, &wrappedResponse)
 	//fmt.Println("toResponseQuery", err)
 	if err != nil {
 		return nil, err
@@ -168,15 +195,18 @@ func toResponseQuery(result []byte, err error) (*abcitypes.ResponseQuery, error)
 	return wrappedResponse.Response, err
 }
 
-func (n *Network) abciQueryResponse(path string, data []byte) (*abcitypes.ResponseQuery, error) {
+// abciQueryResponse height is the height of the requested state, 0 means the latest
+func (n *Network) abciQueryResponse(path string, data []byte, prove bool, height int64) (*abcitypes.ResponseQuery, error) {
 	//fmt.Println("request to path", path, "with binary data", data)
 	return toResponseQuery(
 		toRpcResult(
 			n.makeGetRequest(
 				n.curHost, "/abci_query",
 				url.Values{
-					"path": {"\"" + path + "\""},
-					"data": {"0x" + hex.EncodeToString(data)},
+					"path":   {"\"" + path + "\""},
+					"data":   {"0x" + hex.EncodeToString(data)},
+					"prove":  {strconv.FormatBool(prove)},
+					"height": {strconv.FormatInt(height, 10)},
 				},
 			),
 		),
@@ -184,32 +214,65 @@ func (n *Network) abciQueryResponse(path string, data []byte) (*abcitypes.Respon
 }
 
 // this function is used server does not send error codes, so we can just use value
-func (n *Network) abciQueryValue(path string, data []byte) ([]byte, error) {
-	resp, err := n.abciQueryResponse(path, data)
+func (n *Network) abciQueryValue(path string, data []byte, prove bool, height int64) (*abcitypes.ResponseQuery, error) {
+	resp, err := n.abciQueryResponse(path, data, prove, height)
 	if err != nil {
 		return nil, err
 	}
 	if resp.Code != 0 {
 		return nil, fmt.Errorf("validator answered with not null code %d, log %v", resp.Code, resp.Log)
 	}
-	return resp.Value, err
+	if height != 0 && resp.Height != height {
+		return nil, fmt.Errorf("validator answered for height %v instead of %v", resp.Height, height)
+	}
+	return resp, err
 }
 
 func (n *Network) abciQueryValueProto(path string, req *golosovaniepb.Request) (*golosovaniepb.Response, error) {
+	resp, _, err := n.abciQueryProto(path, req, false, 0)
+	return resp, err
+}
+
+// abciQueryProvedProto requests proofs, if verification is enabled. Raw response is returned for verification
+func (n *Network) abciQueryProvedProto(path string, req *golosovaniepb.Request, height int64) (*golosovaniepb.Response, *abcitypes.ResponseQuery, error) {
+	return n.abciQueryProto(path, req, n.verifier != nil, height)
+}
+
+func (n *Network) abciQueryProto(path string, req *golosovaniepb.Request, prove bool, height int64) (*golosovaniepb.Response, *abcitypes.ResponseQuery, error) {
 	reqBytes, err := proto.Marshal(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	data, err := n.abciQueryValue(path, reqBytes)
+	rawResp, err := n.abciQueryValue(path, reqBytes, prove, height)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	var resp golosovaniepb.Response
-	err = proto.Unmarshal(data, &resp)
+	err = proto.Unmarshal(rawResp.Value, &resp)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return &resp, rawResp, nil
+}
+
+// verifyMessage checks, that msg is the value of key in the state, if verification is enabled
+func (n *Network) verifyMessage(rawResp *abcitypes.ResponseQuery, opIndex int, key []byte, msg proto.Message) error {
+	if n.verifier == nil {
+		return nil
+	}
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		return err
 	}
-	return &resp, nil
+	return n.verifier.Verify(rawResp, opIndex, key, value)
+}
+
+// verifyAbsence checks, that the key has no value in the state, if verification is enabled
+func (n *Network) verifyAbsence(rawResp *abcitypes.ResponseQuery, opIndex int, key []byte) error {
+	if n.verifier == nil {
+		return nil
+	}
+	return n.verifier.Verify(rawResp, opIndex, key, nil)
 }
 
 func (n *Network) BroadcastTxSync(tx []byte) ([]byte, error) {
@@ -231,11 +294,36 @@ func (n *Network) GetTxsByHashes(hashes [][]byte) ([]*golosovaniepb.Transaction,
 			},
 		},
 	}
-	resp, err := n.abciQueryValueProto("getTxs", &req)
+	resp, rawResp, err := n.abciQueryProvedProto("getTxs", &req, 0)
 	if err != nil {
 		return nil, err
 	}
-	return resp.GetTxsByHashes().GetTxs(), nil
+	txs := resp.GetTxsByHashes().GetTxs()
+	if n.verifier != nil {
+		found := make(map[[HashSize]byte]bool)
+		for _, tx := range txs {
+			if !bytes.Equal(Hash(tx.TxBody), tx.Hash) {
+				return nil, fmt.Errorf("transaction body does not match its hash")
+			}
+			found[SliceToHash(tx.Hash)] = true
+		}
+		// state stores tx hash by tx hash, nil value is checked as absence of the tx
+		for i, hash := range hashes {
+			var value []byte
+			if found[SliceToHash(hash)] {
+				value = hash
+			}
+			err = n.verifier.Verify(rawResp, i, StateKey(StateKeyTx, hash), value)
+			if err != nil {
+				return nil, err
+			}
+			delete(found, SliceToHash(hash))
+		}
+		if len(found) != 0 {
+			return nil, fmt.Errorf("validator answered with not requested transactions")
+		}
+	}
+	return txs, nil
 }
 
 func (n *Network) GetTxsByPkey(pkey []byte) ([]*golosovaniepb.Transaction, error) {
@@ -254,6 +342,11 @@ func (n *Network) GetTxsByPkey(pkey []byte) ([]*golosovaniepb.Transaction, error
 }
 
 func (n *Network) GetUtxosByPkey(pkey []byte) ([]*golosovaniepb.Utxo, error) {
+	return n.GetUtxosByPkeyAtHeight(pkey, 0)
+}
+
+// GetUtxosByPkeyAtHeight returns outputs, that were unspent after block height, 0 means the latest block
+func (n *Network) GetUtxosByPkeyAtHeight(pkey []byte, height int64) ([]*golosovaniepb.Utxo, error) {
 	req := golosovaniepb.Request{
 		Data: &golosovaniepb.Request_UtxosByPkey{
 			UtxosByPkey: &golosovaniepb.RequestUtxosByPkey{
@@ -261,11 +354,22 @@ func (n *Network) GetUtxosByPkey(pkey []byte) ([]*golosovaniepb.Utxo, error) {
 			},
 		},
 	}
-	resp, err := n.abciQueryValueProto("getUtxosByPubKey", &req)
+	resp, rawResp, err := n.abciQueryProvedProto("getUtxosByPubKey", &req, height)
 	if err != nil {
 		return nil, err
 	}
-	return resp.GetUtxosByPkey().GetUtxos(), nil
+	// каждый выход проверяется отдельно, поэтому валидатор может скрыть выходы, но не подделать их
+	utxos := resp.GetUtxosByPkey().GetUtxos()
+	for i, utxo := range utxos {
+		if !bytes.Equal(utxo.ReceiverSpendPkey, pkey) && !bytes.Equal(utxo.ReceiverScanPkey, pkey) {
+			return nil, fmt.Errorf("validator returned utxo of another pkey")
+		}
+		err = n.verifyMessage(rawResp, i, UtxoStateKey(utxo.TxHash, utxo.Index), utxo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return utxos, nil
 }
 
 func (n *Network) SubmitTx(tx []byte) error {
@@ -273,7 +377,7 @@ func (n *Network) SubmitTx(tx []byte) error {
 	return err
 }
 
-func (n *Network) Faucet(amount uint32, pkey []byte) error {
+func (n *Network) Faucet(amount uint64, pkey []byte) error {
 	req := golosovaniepb.Request{
 		Data: &golosovaniepb.Request_Faucet{
 			Faucet: &golosovaniepb.RequestFaucet{
@@ -286,22 +390,185 @@ func (n *Network) Faucet(amount uint32, pkey []byte) error {
 	return err
 }
 
-func (n *Network) VoteResults(hash []byte) (map[[PkeySize]byte]uint32, error) {
+// GetSupply returns coins of the genesis block and coins issued by block rewards, the response is not verified
+func (n *Network) GetSupply() (*golosovaniepb.ResponseSupply, error) {
 	req := golosovaniepb.Request{
-		Data: &golosovaniepb.Request_VoteResult{
-			VoteResult: &golosovaniepb.RequestVoteResult{
+		Data: &golosovaniepb.Request_Supply{
+			Supply: &golosovaniepb.RequestSupply{},
+		},
+	}
+	resp, err := n.abciQueryValueProto("getSupply", &req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetSupply(), nil
+}
+
+// GetInitVoteTx returns init tx of anonymous voting, nil if voting is not initialized yet
+func (n *Network) GetInitVoteTx(hash []byte) (*golosovaniepb.Transaction, error) {
+	req := golosovaniepb.Request{
+		Data: &golosovaniepb.Request_InitVoteTx{
+			InitVoteTx: &golosovaniepb.RequestInitVoteTx{
+				VoteTxHash: hash,
+			},
+		},
+	}
+	resp, err := n.abciQueryValueProto("getInitVoteTx", &req)
+	if err != nil {
+		return nil, err
+	}
+	tx := resp.GetInitVoteTx().GetTx()
+	if tx == nil {
+		return nil, nil
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(tx.TxBody, &body)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(Hash(tx.TxBody), tx.Hash) || !bytes.Equal(body.ValueType, hash) {
+		return nil, fmt.Errorf("validator answered with another transaction")
+	}
+	if n.verifier != nil {
+		// the query has no proof, but the transaction itself can be proven
+		txs, err := n.GetTxsByHashes([][]byte{tx.Hash})
+		if err != nil {
+			return nil, err
+		}
+		if len(txs) != 1 {
+			return nil, fmt.Errorf("init vote tx is not in the blockchain")
+		}
+	}
+	return tx, nil
+}
+
+// GetVoteInfo returns create vote tx with continuation txs and voting time
+func (n *Network) GetVoteInfo(hash []byte) (*golosovaniepb.ResponseVoteInfo, error) {
+	req := golosovaniepb.Request{
+		Data: &golosovaniepb.Request_VoteInfo{
+			VoteInfo: &golosovaniepb.RequestVoteInfo{
 				VoteTxHash: hash,
 			},
 		},
 	}
-	resp, err := n.abciQueryValueProto("getVoteResult", &req)
+	resp, err := n.abciQueryValueProto("getVoteInfo", &req)
+	if err != nil {
+		return nil, err
+	}
+	info := resp.GetVoteInfo()
+	if info.GetTx() == nil || !bytes.Equal(info.Tx.Hash, hash) {
+		return nil, fmt.Errorf("validator answered with another transaction")
+	}
+	// транзакции цепочки должны ссылаться друг на друга, начиная с транзакции создания
+	hashes := [][]byte{hash}
+	prevHash := hash
+	for _, tx := range append([]*golosovaniepb.Transaction{info.Tx}, info.ChainTxs...) {
+		var body golosovaniepb.TxBody
+		err = proto.Unmarshal(tx.TxBody, &body)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(Hash(tx.TxBody), tx.Hash) {
+			return nil, fmt.Errorf("validator answered with invalid transaction")
+		}
+		if tx == info.Tx {
+			if body.VoteType == 0 {
+				return nil, fmt.Errorf("transaction is not a voting")
+			}
+		} else {
+			if !bytes.Equal(body.HashLink, prevHash) || !bytes.Equal(body.ValueType, hash) {
+				return nil, fmt.Errorf("validator answered with invalid vote chain")
+			}
+			hashes = append(hashes, tx.Hash)
+		}
+		prevHash = tx.Hash
+	}
+	for _, tx := range info.KeyShareTxs {
+		var body golosovaniepb.TxBody
+		err = proto.Unmarshal(tx.TxBody, &body)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(Hash(tx.TxBody), tx.Hash) || body.KeyShare == nil || !bytes.Equal(body.ValueType, hash) {
+			return nil, fmt.Errorf("validator answered with invalid key share")
+		}
+		hashes = append(hashes, tx.Hash)
+	}
+	if n.verifier != nil {
+		// the query has no proof, but the transactions themselves can be proven
+		txs, err := n.GetTxsByHashes(hashes)
+		if err != nil {
+			return nil, err
+		}
+		if len(txs) != len(hashes) {
+			return nil, fmt.Errorf("voting transactions are not in the blockchain")
+		}
+	}
+	return info, nil
+}
+
+// GetElectionKey returns election key of encrypted voting, which is used to encrypt ballots
+func (n *Network) GetElectionKey(hash []byte) ([]byte, error) {
+	info, err := n.GetVoteInfo(hash)
+	if err != nil {
+		return nil, err
+	}
+	var keyShares []*golosovaniepb.KeyShare
+	for _, tx := range info.KeyShareTxs {
+		var body golosovaniepb.TxBody
+		err = proto.Unmarshal(tx.TxBody, &body)
+		if err != nil {
+			return nil, err
+		}
+		keyShares = append(keyShares, body.KeyShare)
+	}
+	if len(keyShares) == 0 || len(keyShares) < DecryptionThreshold(len(keyShares[0].EncryptedShares)) {
+		return nil, fmt.Errorf("election key is not generated by validators yet, try later")
+	}
+	return ElectionKey(keyShares)
+}
+
+func (n *Network) VoteResults(hash []byte) (map[[PkeySize]byte]uint64, error) {
+	return n.VoteResultsAtHeight(hash, 0)
+}
+
+// VoteResultsAtHeight returns results of the voting after block height, 0 means the latest block
+func (n *Network) VoteResultsAtHeight(hash []byte, height int64) (map[[PkeySize]byte]uint64, error) {
+	res, err := n.VoteResultAtHeight(hash, height)
 	if err != nil {
 		return nil, err
 	}
-	results := make(map[[PkeySize]byte]uint32)
-	for _, v := range resp.GetVoteResult().GetRes() {
+	results := make(map[[PkeySize]byte]uint64)
+	for _, v := range res.GetRes() {
 		pkey := SliceToPkey(v.Pkey)
 		results[pkey] = v.Value
 	}
 	return results, nil
 }
+
+// VoteResultAtHeight returns full results of the voting after block height, including instant-runoff rounds
+func (n *Network) VoteResultAtHeight(hash []byte, height int64) (*golosovaniepb.ResponseVoteResult, error) {
+	req := golosovaniepb.Request{
+		Data: &golosovaniepb.Request_VoteResult{
+			VoteResult: &golosovaniepb.RequestVoteResult{
+				VoteTxHash: hash,
+			},
+		},
+	}
+	resp, rawResp, err := n.abciQueryProvedProto("getVoteResult", &req, height)
+	if err != nil {
+		return nil, err
+	}
+	res := resp.GetVoteResult()
+	if res.GetOpen() && !res.GetUndecryptable() {
+		// в состоянии только окончательные результаты, для открытого голосования проверяется,
+		// что результат еще не окончательный, а сами голоса не проверяются
+		err = n.verifyAbsence(rawResp, 0, StateKey(StateKeyVoteResult, hash))
+	} else {
+		err = n.verifyMessage(rawResp, 0, StateKey(StateKeyVoteResult, hash), res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
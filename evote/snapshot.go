@@ -0,0 +1,493 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"sync"
+)
+
+// Snapshots are used by tendermint state sync, so a new validator can download the state
+// instead of replaying the whole chain.
+//
+// Snapshot at height H contains the state after block H (H-1 in DB) as a stream of SnapshotRecord:
+//  1. genesis app state
+//  2. blocks with height up to H-1 in DB, that contain transactions, which are needed to continue the chain
+//     (see snapshotTxsQuery): transactions with unspent outputs, create vote transactions, all transactions
+//     of votings without final result, standing delegations and transactions, spent by them. The last block
+//     is complete, other blocks contain only these transactions and are not verified
+//  3. state keys of the transactions and spent outputs, which are not in the snapshot: the state tree
+//     contains every transaction hash and every spent output, so they are needed to rebuild the tree
+//  4. rows of encrypted_tally and vote_result, so votings are not counted and decrypted again
+//
+// The stream is cut into chunks of SnapshotChunkSize bytes, a record can continue in the next chunk,
+// so a chunk never exceeds the tendermint limit, however large a block is.
+// Restoring a snapshot is just saving rows into an empty DB, no transactions are executed and
+// no signatures are checked. State sync skips InitChain, so the genesis block is built from genesis
+// app state. After the last chunk the state tree is built from the saved tables, its root must match
+// the trusted app hash, so every output, transaction hash and final result is checked.
+// The tree is not in the snapshot, because it is built from the tables anyway to check them.
+// The restored node has no history before H: blocks and transactions, that are not in the snapshot,
+// are not returned by client queries.
+//
+// Chunks are saved into DB, when the snapshot is taken, and are kept with the list of snapshots
+// until SnapshotKeepRecent newer snapshots are taken, so snapshots are available after restart.
+
+// SnapshotStore keeps the list of recent snapshots, safe for concurrent use
+type SnapshotStore struct {
+	db        *Database
+	mu        sync.Mutex
+	snapshots []*abcitypes.Snapshot // recent snapshots, the latest is the last one
+}
+
+// NewSnapshotStore loads the list of snapshots, saved in DB
+func NewSnapshotStore(db *Database) (*SnapshotStore, error) {
+	snapshots, err := db.GetSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{
+		db:        db,
+		snapshots: snapshots,
+	}, nil
+}
+
+// snapshotWriter cuts the stream of records into chunks and passes them to save
+type snapshotWriter struct {
+	chunkSize   int
+	chunk       []byte
+	chunkHashes [][]byte
+	save        func(index uint32, chunk []byte) error
+}
+
+func (w *snapshotWriter) write(record *golosovaniepb.SnapshotRecord) error {
+	framed, err := frameSnapshotRecord(record)
+	if err != nil {
+		return err
+	}
+	for len(framed) != 0 {
+		n := w.chunkSize - len(w.chunk)
+		if n > len(framed) {
+			n = len(framed)
+		}
+		w.chunk = append(w.chunk, framed[:n]...)
+		framed = framed[n:]
+		if len(w.chunk) == w.chunkSize {
+			err = w.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush saves the last chunk, if it is not empty
+func (w *snapshotWriter) flush() error {
+	if len(w.chunk) == 0 {
+		return nil
+	}
+	err := w.save(uint32(len(w.chunkHashes)), w.chunk)
+	if err != nil {
+		return err
+	}
+	w.chunkHashes = append(w.chunkHashes, Hash(w.chunk))
+	w.chunk = make([]byte, 0, w.chunkSize)
+	return nil
+}
+
+// writeSnapshotRecords writes records of the snapshot at height, see the order at the top of the file
+func writeSnapshotRecords(db *Database, height int64, w *snapshotWriter) error {
+	// heights in DB start from 0
+	dbHeight := height - 1
+	genesis, err := db.GetGenesis()
+	if err != nil {
+		return err
+	}
+	err = w.write(&golosovaniepb.SnapshotRecord{Data: &golosovaniepb.SnapshotRecord_Genesis{Genesis: genesis}})
+	if err != nil {
+		return err
+	}
+	err = db.ForEachSnapshotBlock(dbHeight, func(b *golosovaniepb.SnapshotBlock) error {
+		return w.write(&golosovaniepb.SnapshotRecord{Data: &golosovaniepb.SnapshotRecord_Block{Block: b}})
+	})
+	if err != nil {
+		return err
+	}
+	var keys [][]byte
+	err = db.ForEachStateKeyAtHeight(dbHeight, func(key []byte) error {
+		keys = append(keys, key)
+		if len(keys) < SnapshotKeyBatch {
+			return nil
+		}
+		record := &golosovaniepb.SnapshotRecord{
+			Data: &golosovaniepb.SnapshotRecord_StateKeys{StateKeys: &golosovaniepb.SnapshotStateKeys{Keys: keys}},
+		}
+		keys = nil
+		return w.write(record)
+	})
+	if err != nil {
+		return err
+	}
+	if len(keys) != 0 {
+		err = w.write(&golosovaniepb.SnapshotRecord{
+			Data: &golosovaniepb.SnapshotRecord_StateKeys{StateKeys: &golosovaniepb.SnapshotStateKeys{Keys: keys}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	tallies, err := db.GetEncryptedTalliesAtHeight(dbHeight)
+	if err != nil {
+		return err
+	}
+	for _, tally := range tallies {
+		err = w.write(&golosovaniepb.SnapshotRecord{
+			Data: &golosovaniepb.SnapshotRecord_EncryptedTally{EncryptedTally: tally},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	results, err := db.GetVoteResultsAtHeight(dbHeight)
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		err = w.write(&golosovaniepb.SnapshotRecord{
+			Data: &golosovaniepb.SnapshotRecord_VoteResult{VoteResult: res},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+// frameSnapshotRecord returns the record with its length prefix
+func frameSnapshotRecord(record *golosovaniepb.SnapshotRecord) ([]byte, error) {
+	data, err := proto.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, binary.MaxVarintLen64)
+	return append(prefix[:binary.PutUvarint(prefix, uint64(len(data)))], data...), nil
+}
+
+// nextSnapshotRecord parses the first record in data and returns it with the number of bytes read.
+// If the record is not complete, returns nil and 0
+func nextSnapshotRecord(data []byte) (*golosovaniepb.SnapshotRecord, int, error) {
+	size, n := binary.Uvarint(data)
+	if n == 0 {
+		// длина записи продолжается в следующей части
+		return nil, 0, nil
+	}
+	if n < 0 || size > SnapshotMaxRecordSize {
+		return nil, 0, fmt.Errorf("invalid snapshot record size")
+	}
+	if uint64(len(data)-n) < size {
+		return nil, 0, nil
+	}
+	var record golosovaniepb.SnapshotRecord
+	err := proto.Unmarshal(data[n:n+int(size)], &record)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &record, n + int(size), nil
+}
+
+func snapshotHash(chunkHashes [][]byte) []byte {
+	return Hash(bytes.Join(chunkHashes, nil))
+}
+
+// Take creates snapshot of the chain with height blocks and saves it into DB, function blocks thread
+func (s *SnapshotStore) Take(height int64) error {
+	w := &snapshotWriter{
+		chunkSize: SnapshotChunkSize,
+		save: func(index uint32, chunk []byte) error {
+			return s.db.SaveSnapshotChunk(uint64(height), index, chunk)
+		},
+	}
+	err := writeSnapshotRecords(s.db, height, w)
+	if err != nil {
+		return err
+	}
+	metadataBytes, err := proto.Marshal(&golosovaniepb.SnapshotMetadata{ChunkHashes: w.chunkHashes})
+	if err != nil {
+		return err
+	}
+	snapshot := &abcitypes.Snapshot{
+		Height:   uint64(height),
+		Format:   SnapshotFormat,
+		Chunks:   uint32(len(w.chunkHashes)),
+		Hash:     snapshotHash(w.chunkHashes),
+		Metadata: metadataBytes,
+	}
+	err = s.db.SaveSnapshot(snapshot, SnapshotKeepRecent)
+	if err != nil {
+		return err
+	}
+	snapshots, err := s.db.GetSnapshots()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = snapshots
+	return nil
+}
+
+func (s *SnapshotStore) List() []*abcitypes.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*abcitypes.Snapshot(nil), s.snapshots...)
+}
+
+// LoadChunk returns nil, if there is no such snapshot or chunk
+func (s *SnapshotStore) LoadChunk(height uint64, format uint32, index uint32) []byte {
+	found := false
+	s.mu.Lock()
+	for _, snapshot := range s.snapshots {
+		if snapshot.Height == height && snapshot.Format == format && index < snapshot.Chunks {
+			found = true
+		}
+	}
+	s.mu.Unlock()
+	if !found {
+		return nil
+	}
+	chunk, err := s.db.GetSnapshotChunk(height, index)
+	if err != nil {
+		fmt.Println("cannot load snapshot chunk", err)
+		return nil
+	}
+	return chunk
+}
+
+// phases of the snapshot stream, records of every phase go after records of the previous one
+const (
+	snapshotPhaseGenesis = iota
+	snapshotPhaseBlocks
+	snapshotPhaseStateKeys
+	snapshotPhaseEncryptedTally
+	snapshotPhaseVoteResult
+)
+
+// SnapshotRestore is a snapshot, that is being applied to the empty DB
+type SnapshotRestore struct {
+	snapshot      *abcitypes.Snapshot
+	appHash       []byte // trusted app hash, received from the light client
+	chunkHashes   [][]byte
+	nextChunk     uint32
+	lastBlockHash []byte
+	lastHeight    int64  // height in DB of the last saved block
+	records       int64  // number of applied records
+	phase         int    // phase of the last applied record
+	pending       []byte // start of the record, which continues in the next chunk
+}
+
+func NewSnapshotRestore(snapshot *abcitypes.Snapshot, appHash []byte) (*SnapshotRestore, error) {
+	if snapshot.Format != SnapshotFormat {
+		return nil, fmt.Errorf("unknown snapshot format %v", snapshot.Format)
+	}
+	var metadata golosovaniepb.SnapshotMetadata
+	err := proto.Unmarshal(snapshot.Metadata, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(metadata.ChunkHashes)) != snapshot.Chunks || snapshot.Chunks == 0 {
+		return nil, fmt.Errorf("invalid chunks count %v", snapshot.Chunks)
+	}
+	if !bytes.Equal(snapshotHash(metadata.ChunkHashes), snapshot.Hash) {
+		return nil, fmt.Errorf("snapshot hash does not match chunk hashes")
+	}
+	return &SnapshotRestore{
+		snapshot:    snapshot,
+		appHash:     appHash,
+		chunkHashes: metadata.ChunkHashes,
+		lastHeight:  -1,
+	}, nil
+}
+
+// ApplyChunk saves records from the chunk into DB. Tendermint applies chunks strictly in order
+func (r *SnapshotRestore) ApplyChunk(db *Database, index uint32, chunk []byte, sender string) abcitypes.ResponseApplySnapshotChunk {
+	if index != r.nextChunk {
+		fmt.Println("err: unexpected snapshot chunk", index, "expected", r.nextChunk)
+		return abcitypes.ResponseApplySnapshotChunk{
+			Result: abcitypes.ResponseApplySnapshotChunk_RETRY_SNAPSHOT,
+		}
+	}
+	if !bytes.Equal(Hash(chunk), r.chunkHashes[index]) {
+		fmt.Println("err: snapshot chunk hash mismatch", index)
+		return abcitypes.ResponseApplySnapshotChunk{
+			Result:        abcitypes.ResponseApplySnapshotChunk_RETRY,
+			RefetchChunks: []uint32{index},
+			RejectSenders: []string{sender},
+		}
+	}
+	// части снапшота приходят от недоверенных узлов, поэтому любая ошибка - отказ от снапшота, а не паника
+	reject := abcitypes.ResponseApplySnapshotChunk{
+		Result:        abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT,
+		RejectSenders: []string{sender},
+	}
+	r.pending = append(r.pending, chunk...)
+	for {
+		record, n, err := nextSnapshotRecord(r.pending)
+		if err != nil {
+			fmt.Println("err: cannot parse snapshot record", err)
+			return reject
+		}
+		if record == nil {
+			break
+		}
+		r.pending = r.pending[n:]
+		err = r.applyRecord(db, record)
+		if err != nil {
+			fmt.Println("err: invalid record", r.records-1, "in snapshot:", err)
+			return reject
+		}
+	}
+	r.pending = append([]byte(nil), r.pending...)
+	r.nextChunk++
+	if r.Done() && (len(r.pending) != 0 || r.lastHeight != int64(r.snapshot.Height)-1) {
+		fmt.Println("err: snapshot ends before its last block or in the middle of a record")
+		return reject
+	}
+	return abcitypes.ResponseApplySnapshotChunk{
+		Result: abcitypes.ResponseApplySnapshotChunk_ACCEPT,
+	}
+}
+
+// applyRecord saves the record into DB, returns error, if the record is invalid, out of order or cannot be saved
+func (r *SnapshotRestore) applyRecord(db *Database, record *golosovaniepb.SnapshotRecord) error {
+	first := r.records == 0
+	r.records++
+	phase := snapshotPhaseGenesis
+	switch record.Data.(type) {
+	case *golosovaniepb.SnapshotRecord_Block:
+		phase = snapshotPhaseBlocks
+	case *golosovaniepb.SnapshotRecord_StateKeys:
+		phase = snapshotPhaseStateKeys
+	case *golosovaniepb.SnapshotRecord_EncryptedTally:
+		phase = snapshotPhaseEncryptedTally
+	case *golosovaniepb.SnapshotRecord_VoteResult:
+		phase = snapshotPhaseVoteResult
+	}
+	if phase < r.phase || first != (phase == snapshotPhaseGenesis) {
+		return fmt.Errorf("record is out of order")
+	}
+	// state keys, tallies and results refer to the last block
+	if phase > snapshotPhaseBlocks && r.lastHeight != int64(r.snapshot.Height)-1 {
+		return fmt.Errorf("record before the last block")
+	}
+	r.phase = phase
+	switch data := record.Data.(type) {
+	case *golosovaniepb.SnapshotRecord_Genesis:
+		if len(data.Genesis) == 0 {
+			return nil
+		}
+		_, err := ParseGenesisState(data.Genesis)
+		if err != nil {
+			return err
+		}
+		// узлы дерева состояния с genesis сохраняются при построении состояния после последней части
+		_, err = saveGenesis(db, data.Genesis, nil)
+		return err
+	case *golosovaniepb.SnapshotRecord_Block:
+		return r.applyBlock(db, data.Block)
+	case *golosovaniepb.SnapshotRecord_StateKeys:
+		var txHashes [][]byte
+		var spent []outpoint
+		for _, key := range data.StateKeys.Keys {
+			switch {
+			case len(key) == 1+HashSize && key[0] == StateKeyTx:
+				txHashes = append(txHashes, key[1:])
+			case len(key) == 1+HashSize+Int32Size && key[0] == StateKeyUtxo:
+				spent = append(spent, outpoint{
+					txHash: SliceToHash(key[1 : 1+HashSize]),
+					index:  binary.BigEndian.Uint32(key[1+HashSize:]),
+				})
+			default:
+				return fmt.Errorf("invalid state key")
+			}
+		}
+		return db.SaveStateKeys(txHashes, spent)
+	case *golosovaniepb.SnapshotRecord_EncryptedTally:
+		var tally golosovaniepb.EncryptedTally
+		err := proto.Unmarshal(data.EncryptedTally.Data, &tally)
+		if err != nil {
+			return err
+		}
+		return db.SaveEncryptedTally(data.EncryptedTally.VotingHash, data.EncryptedTally.BlockHash, &tally)
+	case *golosovaniepb.SnapshotRecord_VoteResult:
+		// окончательные результаты входят в состояние, поэтому проверяются хешем состояния
+		var res golosovaniepb.ResponseVoteResult
+		err := proto.Unmarshal(data.VoteResult.Data, &res)
+		if err != nil {
+			return err
+		}
+		return db.SaveVoteResult(data.VoteResult.VotingHash, data.VoteResult.BlockHash, &res)
+	}
+	return fmt.Errorf("unknown record")
+}
+
+// applyBlock saves the block of the snapshot. Only the last block contains all its transactions,
+// so only its header is verified. Transactions of other blocks are checked by the state hash:
+// it contains hashes of all transactions and all outputs
+func (r *SnapshotRestore) applyBlock(db *Database, b *golosovaniepb.SnapshotBlock) error {
+	if b.Block == nil || b.Block.BlockHeader == nil || b.Height <= r.lastHeight || b.Height >= int64(r.snapshot.Height) {
+		return fmt.Errorf("block is invalid or out of order")
+	}
+	if b.Height == int64(r.snapshot.Height)-1 && !verifyBlock(b.Block, b.Block.BlockHeader.PrevBlockHash) {
+		return fmt.Errorf("last block does not match its header")
+	}
+	for _, tx := range b.Block.Transactions {
+		if !bytes.Equal(Hash(tx.TxBody), tx.Hash) || !isCanonicalTxBody(tx.TxBody) {
+			return fmt.Errorf("invalid transaction in block")
+		}
+	}
+	err := db.SaveSnapshotBlock(b.Height, b.Block)
+	if err != nil {
+		return err
+	}
+	r.lastHeight = b.Height
+	r.lastBlockHash = b.Block.Hash
+	return nil
+}
+
+// isCanonicalTxBody checks, that the body is serialized as it will be serialized, when it is read from DB
+func isCanonicalTxBody(txBody []byte) bool {
+	var body golosovaniepb.TxBody
+	err := proto.Unmarshal(txBody, &body)
+	if err != nil {
+		return false
+	}
+	proto.DiscardUnknown(&body)
+	canonical, err := proto.Marshal(&body)
+	return err == nil && bytes.Equal(canonical, txBody)
+}
+
+// verifyBlock checks, that block is next after prevBlockHash and contains exactly transactions from its header
+func verifyBlock(b *golosovaniepb.Block, prevBlockHash []byte) bool {
+	if b.BlockHeader == nil || !bytes.Equal(b.BlockHeader.PrevBlockHash, prevBlockHash) {
+		return false
+	}
+	headerBytes, err := proto.Marshal(b.BlockHeader)
+	if err != nil || !bytes.Equal(Hash(headerBytes), b.Hash) {
+		return false
+	}
+	for _, tx := range b.Transactions {
+		if !bytes.Equal(Hash(tx.TxBody), tx.Hash) {
+			return false
+		}
+	}
+	merkleTree := BuildMerkleTreeTxs(b.Transactions)
+	return bytes.Equal(merkleTree[:], b.BlockHeader.MerkleTree)
+}
+
+func (r *SnapshotRestore) Done() bool {
+	return r.nextChunk == r.snapshot.Chunks
+}
@@ -0,0 +1,180 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"encoding/binary"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"testing"
+)
+
+func TestVerifyBlock(t *testing.T) {
+	t.Run("chained_blocks", func(t *testing.T) {
+		assert.True(t, verifyBlock(BlockZ, nil))
+		assert.True(t, verifyBlock(Block0, BlockZ.Hash))
+		assert.True(t, verifyBlock(Block3, Block2.Hash))
+	})
+	t.Run("wrong_prev_block", func(t *testing.T) {
+		assert.False(t, verifyBlock(Block1, BlockZ.Hash))
+		assert.False(t, verifyBlock(Block0, nil))
+	})
+	t.Run("tx_body_replaced", func(t *testing.T) {
+		b := proto.Clone(Block1).(*golosovaniepb.Block)
+		b.Transactions[1].TxBody = Block2.Transactions[1].TxBody
+		assert.False(t, verifyBlock(b, Block0.Hash))
+	})
+	t.Run("tx_removed", func(t *testing.T) {
+		b := proto.Clone(Block1).(*golosovaniepb.Block)
+		b.Transactions = b.Transactions[:1]
+		assert.False(t, verifyBlock(b, Block0.Hash))
+	})
+}
+
+func TestSnapshotRecords(t *testing.T) {
+	records := []*golosovaniepb.SnapshotRecord{
+		{Data: &golosovaniepb.SnapshotRecord_Genesis{}},
+		{Data: &golosovaniepb.SnapshotRecord_Block{Block: &golosovaniepb.SnapshotBlock{Height: 0, Block: Block0}}},
+		{Data: &golosovaniepb.SnapshotRecord_Block{Block: &golosovaniepb.SnapshotBlock{Height: 1, Block: Block1}}},
+		{Data: &golosovaniepb.SnapshotRecord_StateKeys{StateKeys: &golosovaniepb.SnapshotStateKeys{
+			Keys: [][]byte{StateKey(StateKeyTx, randHash()), UtxoStateKey(randHash(), 1)},
+		}}},
+		{Data: &golosovaniepb.SnapshotRecord_VoteResult{VoteResult: &golosovaniepb.SnapshotVotingRow{
+			VotingHash: randHash(), BlockHash: Block1.Hash, Data: []byte{1, 2, 3},
+		}}},
+	}
+	var stream []byte
+	for _, record := range records {
+		framed, err := frameSnapshotRecord(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, framed...)
+	}
+
+	t.Run("split_into_chunks", func(t *testing.T) {
+		// записи, разрезанные на части любого размера, читаются целиком
+		for _, chunkSize := range []int{1, 2, 7, 100, len(stream)} {
+			var pending []byte
+			var parsed []*golosovaniepb.SnapshotRecord
+			for start := 0; start < len(stream); start += chunkSize {
+				end := start + chunkSize
+				if end > len(stream) {
+					end = len(stream)
+				}
+				pending = append(pending, stream[start:end]...)
+				for {
+					record, n, err := nextSnapshotRecord(pending)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if record == nil {
+						break
+					}
+					pending = pending[n:]
+					parsed = append(parsed, record)
+				}
+			}
+			assert.Empty(t, pending, "chunk size %v", chunkSize)
+			if assert.Len(t, parsed, len(records), "chunk size %v", chunkSize) {
+				for i := range records {
+					assert.True(t, proto.Equal(records[i], parsed[i]), "chunk size %v, record %v", chunkSize, i)
+				}
+			}
+		}
+	})
+
+	t.Run("too_large", func(t *testing.T) {
+		prefix := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(prefix, SnapshotMaxRecordSize+1)
+		_, _, err := nextSnapshotRecord(prefix[:n])
+		assert.Error(t, err)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		framed, err := frameSnapshotRecord(records[3])
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, n, err := nextSnapshotRecord(framed[:len(framed)-1])
+		assert.NoError(t, err)
+		assert.Nil(t, record)
+		assert.Zero(t, n)
+	})
+}
+
+func TestSnapshotWriter(t *testing.T) {
+	record := &golosovaniepb.SnapshotRecord{Data: &golosovaniepb.SnapshotRecord_Block{
+		Block: &golosovaniepb.SnapshotBlock{Height: 1, Block: Block1},
+	}}
+	framed, err := frameSnapshotRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks [][]byte
+	w := &snapshotWriter{
+		chunkSize: 10,
+		save: func(index uint32, chunk []byte) error {
+			assert.Equal(t, uint32(len(chunks)), index)
+			chunks = append(chunks, chunk)
+			return nil
+		},
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// все части, кроме последней, полного размера, и вместе дают поток записей
+	assert.Len(t, chunks, (3*len(framed)+9)/10)
+	var stream []byte
+	for i, chunk := range chunks {
+		if i != len(chunks)-1 {
+			assert.Len(t, chunk, 10)
+		}
+		assert.Equal(t, Hash(chunk), w.chunkHashes[i])
+		stream = append(stream, chunk...)
+	}
+	assert.Equal(t, bytes.Repeat(framed, 3), stream)
+}
+
+func TestSnapshotRestoreOrder(t *testing.T) {
+	restore := func() *SnapshotRestore {
+		return &SnapshotRestore{snapshot: &abcitypes.Snapshot{Height: 2}, lastHeight: -1}
+	}
+	genesis := &golosovaniepb.SnapshotRecord{Data: &golosovaniepb.SnapshotRecord_Genesis{}}
+
+	t.Run("genesis_not_first", func(t *testing.T) {
+		r := restore()
+		assert.NoError(t, r.applyRecord(nil, genesis))
+		assert.Error(t, r.applyRecord(nil, genesis))
+	})
+	t.Run("state_keys_before_last_block", func(t *testing.T) {
+		r := restore()
+		assert.NoError(t, r.applyRecord(nil, genesis))
+		assert.Error(t, r.applyRecord(nil, &golosovaniepb.SnapshotRecord{
+			Data: &golosovaniepb.SnapshotRecord_StateKeys{StateKeys: &golosovaniepb.SnapshotStateKeys{}},
+		}))
+	})
+	t.Run("block_after_snapshot_height", func(t *testing.T) {
+		r := restore()
+		assert.NoError(t, r.applyRecord(nil, genesis))
+		assert.Error(t, r.applyRecord(nil, &golosovaniepb.SnapshotRecord{Data: &golosovaniepb.SnapshotRecord_Block{
+			Block: &golosovaniepb.SnapshotBlock{Height: 2, Block: Block2},
+		}}))
+	})
+	t.Run("last_block_with_tx_removed", func(t *testing.T) {
+		r := restore()
+		b := proto.Clone(Block1).(*golosovaniepb.Block)
+		b.Transactions = b.Transactions[:1]
+		assert.NoError(t, r.applyRecord(nil, genesis))
+		assert.Error(t, r.applyRecord(nil, &golosovaniepb.SnapshotRecord{Data: &golosovaniepb.SnapshotRecord_Block{
+			Block: &golosovaniepb.SnapshotBlock{Height: 1, Block: b},
+		}}))
+	})
+}
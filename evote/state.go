@@ -0,0 +1,367 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+)
+
+// Keys of the state tree. Value of every key is the part of the client query response,
+// so a client can check the response against the app hash:
+//
+//	StateKeyUtxo + txHash + index -> Utxo of the output, empty after the output is spent
+//	StateKeyTx + txHash           -> txHash, transaction is committed
+//	StateKeyVoteResult + hash     -> final ResponseVoteResult of the voting, no key while the result can change
+//	StateKeyGenesis               -> genesis app state, only if the chain has it
+//
+// A key is added, when it first appears in a block, and is never removed. A block changes only keys of
+// its transactions, outputs, spent outputs and votings counted in it, so the work per block doesn't
+// depend on the number of outputs of a pkey or on the number of votings.
+// Genesis key is set before the first block and never changes.
+const (
+	StateKeyUtxo       = 'o'
+	StateKeyTx         = 't'
+	StateKeyVoteResult = 'v'
+	StateKeyGenesis    = 'g'
+)
+
+func StateKey(prefix byte, data []byte) []byte {
+	return append([]byte{prefix}, data...)
+}
+
+// UtxoStateKey returns key of the output index of the transaction txHash
+func UtxoStateKey(txHash []byte, index uint32) []byte {
+	outpoint := make([]byte, len(txHash)+Int32Size)
+	copy(outpoint, txHash)
+	binary.BigEndian.PutUint32(outpoint[len(txHash):], index)
+	return StateKey(StateKeyUtxo, outpoint)
+}
+
+// stateValue reads current value of the key from DB
+func stateValue(db *Database, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty state key")
+	}
+	data := key[1:]
+	switch key[0] {
+	case StateKeyUtxo:
+		if len(data) != HashSize+Int32Size {
+			return nil, fmt.Errorf("invalid outpoint length %v", len(data))
+		}
+		utxo, err := db.GetUnspentUtxo(data[:HashSize], binary.BigEndian.Uint32(data[HashSize:]))
+		if err != nil {
+			return nil, err
+		}
+		if utxo == nil {
+			return []byte{}, nil
+		}
+		return proto.Marshal(utxo)
+	case StateKeyTx:
+		return data, nil
+	case StateKeyVoteResult:
+		res, err := db.GetSavedVoteResultAtHeight(data, DbHeightLatest)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			return nil, fmt.Errorf("voting %x has no final result", data)
+		}
+		return proto.Marshal(res)
+	case StateKeyGenesis:
+		return db.GetGenesis()
+	}
+	return nil, fmt.Errorf("unknown state key prefix %v", key[0])
+}
+
+type stateKeySet map[string]bool
+
+func (s stateKeySet) add(prefix byte, data []byte) {
+	if len(data) != 0 {
+		s[string(StateKey(prefix, data))] = true
+	}
+}
+
+func (s stateKeySet) addUtxo(txHash []byte, index uint32) {
+	s[string(UtxoStateKey(txHash, index))] = true
+}
+
+// isFinalVoteResult returns true, if the result cannot change in next blocks
+func isFinalVoteResult(res *golosovaniepb.ResponseVoteResult) bool {
+	return !res.Open || res.Undecryptable
+}
+
+// saveVoteResults counts final results of votings, which ended or were decrypted in already saved block b.
+// Each result is counted once, next queries and the state use the saved result
+func saveVoteResults(db *Database, b *golosovaniepb.Block) error {
+	// результаты зашифрованных голосований расшифровываются раньше, чтобы подсчитать их в этом же блоке
+	err := saveEncryptedTallies(db, b)
+	if err != nil {
+		return err
+	}
+	ended, err := db.GetVotingsEndedInBlock(b.Hash)
+	if err != nil {
+		return err
+	}
+	decrypted, err := db.GetVotingsDecryptedInBlock(b.Hash)
+	if err != nil {
+		return err
+	}
+	for _, votingHash := range append(ended, decrypted...) {
+		saved, err := db.GetSavedVoteResultAtHeight(votingHash, DbHeightLatest)
+		if err != nil {
+			return err
+		}
+		if saved != nil {
+			continue
+		}
+		res, _, err := GetVoteResult(db, votingHash)
+		if err != nil {
+			return err
+		}
+		// зашифрованное голосование без расшифровки подсчитывается в блоке расшифровки
+		if isFinalVoteResult(res) {
+			err = db.SaveVoteResult(votingHash, b.Hash, res)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changedStateKeys returns keys, which values could be changed by already saved block
+func changedStateKeys(db *Database, b *golosovaniepb.Block) (stateKeySet, error) {
+	keys := make(stateKeySet)
+	for _, tx := range b.Transactions {
+		keys.add(StateKeyTx, tx.Hash)
+		var txBody golosovaniepb.TxBody
+		err := proto.Unmarshal(tx.TxBody, &txBody)
+		if err != nil {
+			return nil, err
+		}
+		for i := range txBody.Outputs {
+			keys.addUtxo(tx.Hash, uint32(i))
+		}
+		for _, input := range txBody.Inputs {
+			keys.addUtxo(input.PrevTxHash, input.OutputIndex)
+		}
+	}
+	counted, err := db.GetVotingsCountedInBlock(b.Hash)
+	if err != nil {
+		return nil, err
+	}
+	for _, voteHash := range counted {
+		keys.add(StateKeyVoteResult, voteHash)
+	}
+	return keys, nil
+}
+
+// allStateKeys returns every key of the state, used to build the tree from scratch
+func allStateKeys(db *Database) (stateKeySet, error) {
+	keys := make(stateKeySet)
+	outpoints, err := db.GetAllOutpoints()
+	if err != nil {
+		return nil, err
+	}
+	for _, outpoint := range outpoints {
+		keys.add(StateKeyUtxo, outpoint)
+	}
+	txHashes, err := db.GetAllTxHashes()
+	if err != nil {
+		return nil, err
+	}
+	for _, txHash := range txHashes {
+		keys.add(StateKeyTx, txHash)
+	}
+	voteHashes, err := db.GetAllCountedVotings()
+	if err != nil {
+		return nil, err
+	}
+	for _, voteHash := range voteHashes {
+		keys.add(StateKeyVoteResult, voteHash)
+	}
+	// ключи транзакций и потраченных выходов, которых нет в бд после восстановления из снапшота
+	restored, err := db.GetStateKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range restored {
+		keys[string(key)] = true
+	}
+	genesis, err := db.GetGenesis()
+	if err != nil {
+		return nil, err
+	}
+	if genesis != nil {
+		keys[string(StateKey(StateKeyGenesis, nil))] = true
+	}
+	return keys, nil
+}
+
+// genesisState returns the state with only genesis app state or the empty state and nodes of its tree
+func genesisState(db *Database, appState []byte) ([]byte, []*StateNode, error) {
+	if appState == nil {
+		return EmptyStateRoot, nil, nil
+	}
+	tree := NewStateTree(db)
+	root, err := tree.Set(EmptyStateRoot, StateKey(StateKeyGenesis, nil), appState)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, tree.Flush(root), nil
+}
+
+// saveGenesis saves genesis app state with the genesis block, built from it, and nodes of the tree
+// before the genesis block. Returns hash of the genesis block, nil if genesis has no transactions
+func saveGenesis(db *Database, appState []byte, nodes []*StateNode) ([]byte, error) {
+	genesis, err := ParseGenesisState(appState)
+	if err != nil {
+		return nil, err
+	}
+	block, err := GenesisBlock(genesis)
+	if err != nil {
+		return nil, err
+	}
+	err = db.SaveGenesis(appState, block, nodes)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	return block.Hash, nil
+}
+
+// InitState saves genesis app state, if it is not empty, and returns hash of the genesis block
+// with the state after it. If there is no genesis block, the hash is nil and the state contains only app state.
+// InitChain is called again, if the validator has stopped before the first block, then genesis is already saved
+func InitState(db *Database, appState []byte) ([]byte, []byte, error) {
+	if isEmptyAppState(appState) {
+		appState = nil
+	}
+	saved, err := db.GetGenesis()
+	if err != nil {
+		return nil, nil, err
+	}
+	if saved != nil || appState == nil {
+		if !bytes.Equal(saved, appState) {
+			return nil, nil, fmt.Errorf("genesis app state differs from the saved one")
+		}
+	} else {
+		_, nodes, err := genesisState(db, appState)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = saveGenesis(db, appState, nodes)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	blockHash, err := db.GetGenesisBlockHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	// состояние после genesis блока вычисляется так же, как после обычного блока,
+	// и вычисляется заново, если валидатор остановился до его сохранения
+	root, err := LoadState(db, blockHash)
+	return blockHash, root, err
+}
+
+// appStateRoot returns the state before the first block in DB, it contains only genesis app state or is empty
+func appStateRoot(db *Database) ([]byte, error) {
+	genesis, err := db.GetGenesis()
+	if err != nil {
+		return nil, err
+	}
+	root, _, err := genesisState(db, genesis)
+	return root, err
+}
+
+// initialState returns the state before the block with height 0 in DB, i.e. after the genesis block, if it exists.
+// nil, if the state of the genesis block is unknown, e.g. the chain was restored from a snapshot
+func initialState(db *Database) ([]byte, error) {
+	blockHash, err := db.GetGenesisBlockHash()
+	if err != nil {
+		return nil, err
+	}
+	if blockHash == nil {
+		return appStateRoot(db)
+	}
+	return db.GetStateRoot(blockHash)
+}
+
+// updateState sets values of keys in the tree with prevRoot and saves the result as the state after blockHash
+func updateState(db *Database, blockHash []byte, prevRoot []byte, keys stateKeySet) ([]byte, error) {
+	tree := NewStateTree(db)
+	root := prevRoot
+	for key := range keys {
+		value, err := stateValue(db, []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		root, err = tree.Set(root, []byte(key), value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := db.SaveState(blockHash, root, tree.Flush(root))
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// CommitState updates state after saving block b, prevRoot is the state before the block
+func CommitState(db *Database, b *golosovaniepb.Block, prevRoot []byte) ([]byte, error) {
+	// окончательные результаты голосований входят в состояние, поэтому подсчитываются раньше
+	err := saveVoteResults(db, b)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := changedStateKeys(db, b)
+	if err != nil {
+		return nil, err
+	}
+	return updateState(db, b.Hash, prevRoot, keys)
+}
+
+// RebuildState builds the state after the last block from scratch, when previous states are unknown
+func RebuildState(db *Database, lastBlockHash []byte) ([]byte, error) {
+	keys, err := allStateKeys(db)
+	if err != nil {
+		return nil, err
+	}
+	return updateState(db, lastBlockHash, EmptyStateRoot, keys)
+}
+
+// LoadState returns the state after block with hash lastBlockHash. If the validator has stopped
+// after saving the block, but before saving the state, the state is computed again
+func LoadState(db *Database, lastBlockHash []byte) ([]byte, error) {
+	if lastBlockHash == nil {
+		return initialState(db)
+	}
+	root, err := db.GetStateRoot(lastBlockHash)
+	if err != nil || root != nil {
+		return root, err
+	}
+	b, err := db.GetBlockByHash(lastBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.BlockHeader.PrevBlockHash) == 0 {
+		root, err := appStateRoot(db)
+		if err != nil {
+			return nil, err
+		}
+		return CommitState(db, b, root)
+	}
+	prevRoot, err := db.GetStateRoot(b.BlockHeader.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if prevRoot == nil {
+		// state was never computed for the chain, e.g. it was restored from a snapshot and
+		// the validator stopped before the state was built
+		return RebuildState(db, lastBlockHash)
+	}
+	return CommitState(db, b, prevRoot)
+}
@@ -0,0 +1,135 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// ProofOpState is type of tendermint proof operation, which proves value of a key in the state tree
+const ProofOpState = "golosovanie:state"
+
+// Prove returns proof of the value of key in the tree with root, or proof of its absence
+func (t *StateTree) Prove(root []byte, key []byte) (*golosovaniepb.StateProof, error) {
+	keyHash := Hash(key)
+	var proof golosovaniepb.StateProof
+	nodeHash := root
+	for depth := 0; !isEmptyStateNode(nodeHash); depth++ {
+		n, err := t.getNode(nodeHash)
+		if err != nil {
+			return nil, err
+		}
+		if n.IsLeaf() {
+			if !bytes.Equal(n.KeyHash, keyHash) {
+				proof.LeafKeyHash = n.KeyHash
+				proof.LeafValueHash = n.ValueHash
+			}
+			break
+		}
+		if stateBit(keyHash, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, n.RightHash)
+			nodeHash = n.LeftHash
+		} else {
+			proof.Siblings = append(proof.Siblings, n.LeftHash)
+			nodeHash = n.RightHash
+		}
+	}
+	return &proof, nil
+}
+
+// StateProofOp implements merkle.ProofOperator
+type StateProofOp struct {
+	key   []byte
+	proof *golosovaniepb.StateProof
+}
+
+var _ merkle.ProofOperator = (*StateProofOp)(nil)
+
+func NewStateProofOp(key []byte, proof *golosovaniepb.StateProof) *StateProofOp {
+	return &StateProofOp{key: key, proof: proof}
+}
+
+func StateProofOpDecoder(pop tmcrypto.ProofOp) (merkle.ProofOperator, error) {
+	if pop.Type != ProofOpState {
+		return nil, fmt.Errorf("unexpected proof op type %v", pop.Type)
+	}
+	var proof golosovaniepb.StateProof
+	err := proto.Unmarshal(pop.Data, &proof)
+	if err != nil {
+		return nil, err
+	}
+	return NewStateProofOp(pop.Key, &proof), nil
+}
+
+func (op *StateProofOp) GetKey() []byte {
+	return op.key
+}
+
+func (op *StateProofOp) ProofOp() tmcrypto.ProofOp {
+	data, err := proto.Marshal(op.proof)
+	if err != nil {
+		panic(err)
+	}
+	return tmcrypto.ProofOp{
+		Type: ProofOpState,
+		Key:  op.key,
+		Data: data,
+	}
+}
+
+// Run takes the value of the key or nothing for absence proof, returns root of the state tree
+func (op *StateProofOp) Run(args [][]byte) ([][]byte, error) {
+	keyHash := Hash(op.key)
+	siblings := op.proof.Siblings
+	if len(siblings) > stateTreeDepth {
+		return nil, fmt.Errorf("state proof is too long")
+	}
+	var nodeHash []byte
+	switch len(args) {
+	case 0:
+		if len(op.proof.LeafKeyHash) == 0 {
+			nodeHash = EmptyStateRoot
+			break
+		}
+		if len(op.proof.LeafKeyHash) != HashSize || bytes.Equal(op.proof.LeafKeyHash, keyHash) {
+			return nil, fmt.Errorf("invalid leaf in absence proof")
+		}
+		// the other leaf must lie on the path of the key
+		for depth := range siblings {
+			if stateBit(keyHash, depth) != stateBit(op.proof.LeafKeyHash, depth) {
+				return nil, fmt.Errorf("leaf in absence proof is not on the path of the key")
+			}
+		}
+		nodeHash = stateLeafHash(op.proof.LeafKeyHash, op.proof.LeafValueHash)
+	case 1:
+		if len(op.proof.LeafKeyHash) != 0 {
+			return nil, fmt.Errorf("unexpected leaf in value proof")
+		}
+		nodeHash = stateLeafHash(keyHash, Hash(args[0]))
+	default:
+		return nil, fmt.Errorf("expected one value, got %v", len(args))
+	}
+	for depth := len(siblings) - 1; depth >= 0; depth-- {
+		if stateBit(keyHash, depth) == 0 {
+			nodeHash = stateInnerHash(nodeHash, siblings[depth])
+		} else {
+			nodeHash = stateInnerHash(siblings[depth], nodeHash)
+		}
+	}
+	return [][]byte{nodeHash}, nil
+}
+
+// NewStateProofRuntime returns proof runtime, that can verify proofs from validator responses
+func NewStateProofRuntime() *merkle.ProofRuntime {
+	prt := merkle.NewProofRuntime()
+	prt.RegisterOpDecoder(ProofOpState, StateProofOpDecoder)
+	return prt
+}
+
+// StateKeyPath converts state key into the key path for merkle.ProofRuntime
+func StateKeyPath(key []byte) string {
+	return merkle.KeyPath{}.AppendKey(key, merkle.KeyEncodingHex).String()
+}
@@ -0,0 +1,116 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+	"testing"
+)
+
+func TestStateProof(t *testing.T) {
+	values := make(map[string]string)
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%v", i)
+		keys = append(keys, key)
+		values[key] = fmt.Sprintf("value%v", i)
+	}
+	saved := make(memStateNodes)
+	root := buildStateTree(t, saved, EmptyStateRoot, keys, values)
+	tree := NewStateTree(saved)
+	prt := NewStateProofRuntime()
+
+	prove := func(t *testing.T, root []byte, key string) *tmcrypto.ProofOps {
+		proof, err := tree.Prove(root, []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		op := NewStateProofOp([]byte(key), proof).ProofOp()
+		return &tmcrypto.ProofOps{Ops: []tmcrypto.ProofOp{op}}
+	}
+
+	t.Run("value", func(t *testing.T) {
+		for _, key := range keys {
+			err := prt.VerifyValue(prove(t, root, key), root, StateKeyPath([]byte(key)), []byte(values[key]))
+			if err != nil {
+				t.Errorf("proof of %v is invalid: %v", key, err)
+			}
+		}
+	})
+
+	t.Run("wrong_value", func(t *testing.T) {
+		err := prt.VerifyValue(prove(t, root, "key1"), root, StateKeyPath([]byte("key1")), []byte("value2"))
+		if err == nil {
+			t.Error("proof of wrong value is valid")
+		}
+	})
+
+	t.Run("absence", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("absent%v", i)
+			err := prt.VerifyAbsence(prove(t, root, key), root, StateKeyPath([]byte(key)))
+			if err != nil {
+				t.Errorf("absence proof of %v is invalid: %v", key, err)
+			}
+		}
+	})
+
+	t.Run("absence_of_existing_key", func(t *testing.T) {
+		err := prt.VerifyAbsence(prove(t, root, "key1"), root, StateKeyPath([]byte("key1")))
+		if err == nil {
+			t.Error("absence proof of existing key is valid")
+		}
+	})
+
+	t.Run("empty_tree", func(t *testing.T) {
+		err := prt.VerifyAbsence(prove(t, EmptyStateRoot, "key1"), EmptyStateRoot, StateKeyPath([]byte("key1")))
+		if err != nil {
+			t.Errorf("absence proof in empty tree is invalid: %v", err)
+		}
+	})
+}
+
+func TestStateKeysOfQuery(t *testing.T) {
+	t.Run("utxos_by_outpoint", func(t *testing.T) {
+		txHash := randHash()
+		resp := golosovaniepb.Response{
+			Data: &golosovaniepb.Response_UtxosByPkey{UtxosByPkey: &golosovaniepb.ResponseUtxosByPkey{
+				Utxos: []*golosovaniepb.Utxo{{TxHash: txHash, Index: 0}, {TxHash: txHash, Index: 1}},
+			}},
+		}
+		value, err := proto.Marshal(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := stateKeysOfQuery("getUtxosByPubKey", &golosovaniepb.Request{}, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || !bytes.Equal(keys[0], UtxoStateKey(txHash, 0)) || !bytes.Equal(keys[1], UtxoStateKey(txHash, 1)) {
+			t.Errorf("keys %x don't match utxos", keys)
+		}
+		if len(keys[0]) != 1+HashSize+Int32Size || bytes.Equal(keys[0], keys[1]) {
+			t.Errorf("invalid outpoint keys %x", keys)
+		}
+	})
+
+	t.Run("not_provable", func(t *testing.T) {
+		if queryProvable("getTxsByPubKey") || queryProvable("faucet") {
+			t.Error("query without state keys is provable")
+		}
+	})
+
+	t.Run("final_vote_result", func(t *testing.T) {
+		if isFinalVoteResult(&golosovaniepb.ResponseVoteResult{Open: true}) {
+			t.Error("open result is final")
+		}
+		if !isFinalVoteResult(&golosovaniepb.ResponseVoteResult{}) {
+			t.Error("closed result is not final")
+		}
+		if !isFinalVoteResult(&golosovaniepb.ResponseVoteResult{Open: true, Hidden: true, Undecryptable: true}) {
+			t.Error("undecryptable result is not final")
+		}
+	})
+}
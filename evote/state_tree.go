@@ -0,0 +1,186 @@
+package evote
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// State of the application is authenticated by a sparse merkle tree, its root is the app hash.
+// Every leaf is stored at the path Hash(key), where key is one of the state keys (see state.go).
+//
+// The tree is compact: a subtree with a single leaf is stored as the leaf itself, so the depth
+// of the tree is about log2(number of leaves). Structure of the tree depends only on the set of
+// leaves, not on the order of insertions, so every validator gets the same root.
+//
+//	empty subtree hash = ZeroArrayHash
+//	leaf hash          = Hash(0x00 || keyHash || valueHash)
+//	inner node hash    = Hash(0x01 || leftHash || rightHash)
+//
+// Leaves are never removed, nodes are never changed, new version of the tree shares
+// untouched nodes with the previous one. So any committed version can be read by its root.
+
+const (
+	stateLeafPrefix  = 0x00
+	stateInnerPrefix = 0x01
+	stateTreeDepth   = HashSize * 8
+)
+
+var EmptyStateRoot = ZeroArrayHash[:]
+
+// StateNode is a node of the state tree, either leaf (KeyHash and ValueHash are set) or inner node
+type StateNode struct {
+	LeftHash  []byte
+	RightHash []byte
+	KeyHash   []byte
+	ValueHash []byte
+}
+
+func (n *StateNode) IsLeaf() bool {
+	return n.KeyHash != nil
+}
+
+func stateLeafHash(keyHash, valueHash []byte) []byte {
+	return Hash(append(append([]byte{stateLeafPrefix}, keyHash...), valueHash...))
+}
+
+func stateInnerHash(leftHash, rightHash []byte) []byte {
+	return Hash(append(append([]byte{stateInnerPrefix}, leftHash...), rightHash...))
+}
+
+func (n *StateNode) Hash() []byte {
+	if n.IsLeaf() {
+		return stateLeafHash(n.KeyHash, n.ValueHash)
+	}
+	return stateInnerHash(n.LeftHash, n.RightHash)
+}
+
+func isEmptyStateNode(hash []byte) bool {
+	return bytes.Equal(hash, EmptyStateRoot)
+}
+
+// stateBit returns bit of the path at the depth, the first bit is the most significant bit of the first byte
+func stateBit(keyHash []byte, depth int) byte {
+	return (keyHash[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+// StateNodeReader is a storage of already saved nodes, implemented by Database
+type StateNodeReader interface {
+	// GetStateNode returns nil, nil if there is no such node
+	GetStateNode(hash []byte) (*StateNode, error)
+}
+
+// StateTree accumulates changes of the tree, new nodes are kept in memory until Flush
+type StateTree struct {
+	saved   StateNodeReader
+	pending map[[HashSize]byte]*StateNode
+}
+
+func NewStateTree(saved StateNodeReader) *StateTree {
+	return &StateTree{
+		saved:   saved,
+		pending: make(map[[HashSize]byte]*StateNode),
+	}
+}
+
+func (t *StateTree) getNode(hash []byte) (*StateNode, error) {
+	if n, ok := t.pending[SliceToHash(hash)]; ok {
+		return n, nil
+	}
+	n, err := t.saved.GetStateNode(hash)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("state node %x not found", hash)
+	}
+	return n, nil
+}
+
+func (t *StateTree) putNode(n *StateNode) []byte {
+	hash := n.Hash()
+	t.pending[SliceToHash(hash)] = n
+	return hash
+}
+
+func (t *StateTree) putInner(leftHash, rightHash []byte) []byte {
+	return t.putNode(&StateNode{LeftHash: leftHash, RightHash: rightHash})
+}
+
+// Set puts value by key into the tree with root, returns new root
+func (t *StateTree) Set(root []byte, key []byte, value []byte) ([]byte, error) {
+	return t.set(root, 0, Hash(key), Hash(value))
+}
+
+func (t *StateTree) set(nodeHash []byte, depth int, keyHash, valueHash []byte) ([]byte, error) {
+	if isEmptyStateNode(nodeHash) {
+		return t.putNode(&StateNode{KeyHash: keyHash, ValueHash: valueHash}), nil
+	}
+	n, err := t.getNode(nodeHash)
+	if err != nil {
+		return nil, err
+	}
+	if n.IsLeaf() {
+		newLeaf := t.putNode(&StateNode{KeyHash: keyHash, ValueHash: valueHash})
+		if bytes.Equal(n.KeyHash, keyHash) {
+			return newLeaf, nil
+		}
+		return t.split(depth, nodeHash, n.KeyHash, newLeaf, keyHash)
+	}
+	if stateBit(keyHash, depth) == 0 {
+		left, err := t.set(n.LeftHash, depth+1, keyHash, valueHash)
+		if err != nil {
+			return nil, err
+		}
+		return t.putInner(left, n.RightHash), nil
+	}
+	right, err := t.set(n.RightHash, depth+1, keyHash, valueHash)
+	if err != nil {
+		return nil, err
+	}
+	return t.putInner(n.LeftHash, right), nil
+}
+
+// split creates inner nodes down to the depth, where paths of two leaves diverge
+func (t *StateTree) split(depth int, oldLeaf, oldKeyHash, newLeaf, newKeyHash []byte) ([]byte, error) {
+	if depth >= stateTreeDepth {
+		return nil, fmt.Errorf("state tree is too deep")
+	}
+	oldBit := stateBit(oldKeyHash, depth)
+	newBit := stateBit(newKeyHash, depth)
+	if oldBit == newBit {
+		child, err := t.split(depth+1, oldLeaf, oldKeyHash, newLeaf, newKeyHash)
+		if err != nil {
+			return nil, err
+		}
+		if oldBit == 0 {
+			return t.putInner(child, EmptyStateRoot), nil
+		}
+		return t.putInner(EmptyStateRoot, child), nil
+	}
+	if newBit == 0 {
+		return t.putInner(newLeaf, oldLeaf), nil
+	}
+	return t.putInner(oldLeaf, newLeaf), nil
+}
+
+// Flush returns new nodes of the tree with root, that must be saved. Nodes,
+// which were created by Set, but are not reachable from root anymore, are dropped
+func (t *StateTree) Flush(root []byte) []*StateNode {
+	var nodes []*StateNode
+	var walk func(hash []byte)
+	walk = func(hash []byte) {
+		n, ok := t.pending[SliceToHash(hash)]
+		if !ok {
+			// empty or already saved subtree
+			return
+		}
+		nodes = append(nodes, n)
+		if !n.IsLeaf() {
+			walk(n.LeftHash)
+			walk(n.RightHash)
+		}
+	}
+	walk(root)
+	t.pending = make(map[[HashSize]byte]*StateNode)
+	return nodes
+}
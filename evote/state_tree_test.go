@@ -0,0 +1,99 @@
+package evote
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+type memStateNodes map[[HashSize]byte]*StateNode
+
+func (m memStateNodes) GetStateNode(hash []byte) (*StateNode, error) {
+	return m[SliceToHash(hash)], nil
+}
+
+func (m memStateNodes) save(nodes []*StateNode) {
+	for _, n := range nodes {
+		m[SliceToHash(n.Hash())] = n
+	}
+}
+
+func buildStateTree(t *testing.T, saved memStateNodes, root []byte, keys []string, values map[string]string) []byte {
+	tree := NewStateTree(saved)
+	var err error
+	for _, key := range keys {
+		root, err = tree.Set(root, []byte(key), []byte(values[key]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved.save(tree.Flush(root))
+	return root
+}
+
+func TestStateTree(t *testing.T) {
+	values := make(map[string]string)
+	var keys []string
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%v", i)
+		keys = append(keys, key)
+		values[key] = fmt.Sprintf("value%v", i)
+	}
+
+	root := buildStateTree(t, make(memStateNodes), EmptyStateRoot, keys, values)
+	if bytes.Equal(root, EmptyStateRoot) {
+		t.Fatal("root of non empty tree is empty")
+	}
+
+	t.Run("insertion_order", func(t *testing.T) {
+		shuffled := make([]string, len(keys))
+		copy(shuffled, keys)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		// half of the keys are saved, then the rest is added to the saved tree
+		saved := make(memStateNodes)
+		half := buildStateTree(t, saved, EmptyStateRoot, shuffled[:100], values)
+		otherRoot := buildStateTree(t, saved, half, shuffled[100:], values)
+		if !bytes.Equal(root, otherRoot) {
+			t.Errorf("root depends on insertion order: %x != %x", root, otherRoot)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		saved := make(memStateNodes)
+		root := buildStateTree(t, saved, EmptyStateRoot, keys, values)
+		changed := map[string]string{"key10": "other value"}
+		newRoot := buildStateTree(t, saved, root, []string{"key10"}, changed)
+		if bytes.Equal(root, newRoot) {
+			t.Error("root not changed after update")
+		}
+		oldRoot := buildStateTree(t, saved, newRoot, []string{"key10"}, values)
+		if !bytes.Equal(root, oldRoot) {
+			t.Error("root differs after value restore")
+		}
+	})
+
+	t.Run("single_leaf", func(t *testing.T) {
+		root := buildStateTree(t, make(memStateNodes), EmptyStateRoot, []string{"key"}, map[string]string{"key": "value"})
+		if !bytes.Equal(root, stateLeafHash(Hash([]byte("key")), Hash([]byte("value")))) {
+			t.Error("tree with one leaf must have the leaf as root")
+		}
+	})
+
+	t.Run("flush_drops_unreachable", func(t *testing.T) {
+		tree := NewStateTree(make(memStateNodes))
+		root, err := tree.Set(EmptyStateRoot, []byte("a"), []byte("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err = tree.Set(root, []byte("a"), []byte("2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes := tree.Flush(root); len(nodes) != 1 {
+			t.Errorf("expected 1 node, got %v", len(nodes))
+		}
+	})
+}
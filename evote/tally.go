@@ -0,0 +1,375 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"math/big"
+	"sort"
+)
+
+// VoteBallot - бюллетень из транзакции отправки голоса
+type VoteBallot struct {
+	TxHash    []byte
+	Sender    []byte // владелец входов транзакции
+	Ballot    *golosovaniepb.Ballot
+	Value     uint64 // вес бюллетеня - сумма выходов транзакции
+	Timestamp uint64 // время блока с транзакцией
+	Replaced  bool   // в голосовании с изменением голоса выход бюллетеня потрачен следующим голосом или отзывом
+}
+
+// IsBallotVoting returns true, if vote txs of the voting carry ballots. In revotable and commit-reveal votings
+// votes of any type are sent with ballots
+func IsBallotVoting(body *golosovaniepb.TxBody) bool {
+	return IsBallotVoteType(body.VoteType) || body.VotingParams.GetRevotable() ||
+		body.VotingParams.GetRevealDuration() != 0
+}
+
+// pkeyValues converts candidate counts to the sorted by pkey list
+func pkeyValues(counts map[[PkeySize]byte]uint64) []*golosovaniepb.ResponseVoteResult_PkeyValue {
+	res := make([]*golosovaniepb.ResponseVoteResult_PkeyValue, 0, len(counts))
+	for pkey, val := range counts {
+		p := pkey
+		res = append(res, &golosovaniepb.ResponseVoteResult_PkeyValue{
+			Pkey:  p[:],
+			Value: val,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].Pkey, res[j].Pkey) < 0
+	})
+	return res
+}
+
+// InstantRunoff подсчитывает ранжированные бюллетени. В каждом раунде бюллетень отдается первому
+// в нем не выбывшему кандидату. Если у кандидата больше половины голосов раунда, он побеждает,
+// иначе выбывают все кандидаты с наименьшим числом голосов. Если наименьшее число голосов у всех
+// оставшихся кандидатов, победителя нет. Res результата - голоса последнего раунда
+func InstantRunoff(ballots []*VoteBallot) *golosovaniepb.ResponseVoteResult {
+	active := make(map[[PkeySize]byte]bool)
+	for _, b := range ballots {
+		for _, candidate := range b.Ballot.Ranking {
+			active[SliceToPkey(candidate)] = true
+		}
+	}
+	var res golosovaniepb.ResponseVoteResult
+	for len(active) != 0 {
+		counts := make(map[[PkeySize]byte]uint64, len(active))
+		for pkey := range active {
+			counts[pkey] = 0
+		}
+		// бюллетени, в которых все кандидаты выбыли, не учитываются
+		var total uint64
+		for _, b := range ballots {
+			for _, candidate := range b.Ballot.Ranking {
+				pkey := SliceToPkey(candidate)
+				if active[pkey] {
+					counts[pkey] += b.Value
+					total += b.Value
+					break
+				}
+			}
+		}
+		round := &golosovaniepb.ResponseVoteResult_Round{Counts: pkeyValues(counts)}
+		res.Rounds = append(res.Rounds, round)
+		res.Res = round.Counts
+
+		minValue := round.Counts[0].Value
+		for _, count := range round.Counts {
+			if count.Value*2 > total {
+				round.Elected = [][]byte{count.Pkey}
+				res.Elected = round.Elected
+				return &res
+			}
+			if count.Value < minValue {
+				minValue = count.Value
+			}
+		}
+		var eliminated [][]byte
+		for _, count := range round.Counts {
+			if count.Value == minValue {
+				eliminated = append(eliminated, count.Pkey)
+			}
+		}
+		if len(eliminated) == len(active) {
+			// ничья между всеми оставшимися кандидатами
+			break
+		}
+		round.Eliminated = eliminated
+		for _, pkey := range eliminated {
+			delete(active, SliceToPkey(pkey))
+		}
+	}
+	return &res
+}
+
+// ApprovalTally подсчитывает бюллетени одобрительного голосования: каждый одобренный кандидат
+// получает весь вес бюллетеня. Побеждает кандидат с наибольшим числом голосов, при ничьей победителя нет
+func ApprovalTally(ballots []*VoteBallot) *golosovaniepb.ResponseVoteResult {
+	counts := make(map[[PkeySize]byte]uint64)
+	for _, b := range ballots {
+		for _, candidate := range b.Ballot.Approved {
+			counts[SliceToPkey(candidate)] += b.Value
+		}
+	}
+	res := golosovaniepb.ResponseVoteResult{Res: pkeyValues(counts)}
+	res.Elected = topCandidate(res.Res)
+	return &res
+}
+
+// topCandidate возвращает кандидата с наибольшим числом голосов, nil при ничьей или без голосов
+func topCandidate(counts []*golosovaniepb.ResponseVoteResult_PkeyValue) [][]byte {
+	var winner []byte
+	var maxValue uint64
+	for _, count := range counts {
+		if count.Value > maxValue {
+			winner, maxValue = count.Pkey, count.Value
+		} else if count.Value == maxValue {
+			winner = nil
+		}
+	}
+	if winner == nil {
+		return nil
+	}
+	return [][]byte{winner}
+}
+
+// stvBallot - бюллетень с текущим весом, который уменьшается при передаче излишка избранного кандидата
+type stvBallot struct {
+	ranking [][]byte
+	weight  *big.Rat
+}
+
+// StvTally подсчитывает бюллетени single transferable vote с квотой Друпа floor(total / (seats + 1)) + 1.
+// В каждом раунде бюллетень отдается первому в нем не избранному и не выбывшему кандидату.
+// Кандидаты, набравшие квоту, избираются, а их излишек передается дальше с весом излишек / голоса кандидата
+// (метод Грегори). Если квоту никто не набрал, выбывают кандидаты с наименьшим числом голосов.
+// Если оставшихся кандидатов не больше, чем свободных мест, они избираются все.
+// Веса считаются точно в рациональных числах, голоса раунда округляются вниз
+func StvTally(ballots []*VoteBallot, seats uint32) *golosovaniepb.ResponseVoteResult {
+	hopeful := make(map[[PkeySize]byte]bool)
+	stvBallots := make([]*stvBallot, 0, len(ballots))
+	var total uint64
+	for _, b := range ballots {
+		for _, candidate := range b.Ballot.Ranking {
+			hopeful[SliceToPkey(candidate)] = true
+		}
+		stvBallots = append(stvBallots, &stvBallot{
+			ranking: b.Ballot.Ranking,
+			weight:  new(big.Rat).SetInt(new(big.Int).SetUint64(b.Value)),
+		})
+		total += b.Value
+	}
+	quota := new(big.Rat).SetInt(new(big.Int).SetUint64(total/(uint64(seats)+1) + 1))
+
+	var res golosovaniepb.ResponseVoteResult
+	for len(hopeful) != 0 && uint32(len(res.Elected)) < seats {
+		counts := make(map[[PkeySize]byte]*big.Rat, len(hopeful))
+		for pkey := range hopeful {
+			counts[pkey] = new(big.Rat)
+		}
+		// бюллетени, отданные каждому кандидату в этом раунде
+		assigned := make(map[[PkeySize]byte][]*stvBallot)
+		for _, b := range stvBallots {
+			for _, candidate := range b.ranking {
+				pkey := SliceToPkey(candidate)
+				if hopeful[pkey] {
+					counts[pkey].Add(counts[pkey], b.weight)
+					assigned[pkey] = append(assigned[pkey], b)
+					break
+				}
+			}
+		}
+		roundCounts := make(map[[PkeySize]byte]uint64, len(counts))
+		for pkey, count := range counts {
+			roundCounts[pkey] = new(big.Int).Quo(count.Num(), count.Denom()).Uint64()
+		}
+		round := &golosovaniepb.ResponseVoteResult_Round{Counts: pkeyValues(roundCounts)}
+		res.Rounds = append(res.Rounds, round)
+		res.Res = round.Counts
+
+		if uint32(len(hopeful)) <= seats-uint32(len(res.Elected)) {
+			for _, count := range round.Counts {
+				round.Elected = append(round.Elected, count.Pkey)
+			}
+			res.Elected = append(res.Elected, round.Elected...)
+			break
+		}
+		for _, count := range round.Counts {
+			pkey := SliceToPkey(count.Pkey)
+			if counts[pkey].Cmp(quota) >= 0 && uint32(len(res.Elected)+len(round.Elected)) < seats {
+				round.Elected = append(round.Elected, count.Pkey)
+			}
+		}
+		if len(round.Elected) != 0 {
+			for _, elected := range round.Elected {
+				pkey := SliceToPkey(elected)
+				// излишек сверх квоты передается следующим кандидатам в бюллетенях
+				transfer := new(big.Rat).Sub(counts[pkey], quota)
+				transfer.Quo(transfer, counts[pkey])
+				for _, b := range assigned[pkey] {
+					b.weight.Mul(b.weight, transfer)
+				}
+				delete(hopeful, pkey)
+			}
+			res.Elected = append(res.Elected, round.Elected...)
+			continue
+		}
+		minValue := counts[SliceToPkey(round.Counts[0].Pkey)]
+		for _, count := range counts {
+			if count.Cmp(minValue) < 0 {
+				minValue = count
+			}
+		}
+		var eliminated [][]byte
+		for _, count := range round.Counts {
+			if counts[SliceToPkey(count.Pkey)].Cmp(minValue) == 0 {
+				eliminated = append(eliminated, count.Pkey)
+			}
+		}
+		if len(eliminated) == len(hopeful) {
+			// ничья между всеми оставшимися кандидатами, оставшиеся места не заполняются
+			break
+		}
+		round.Eliminated = eliminated
+		for _, pkey := range eliminated {
+			delete(hopeful, SliceToPkey(pkey))
+		}
+	}
+	return &res
+}
+
+// ChoiceTally подсчитывает ответы на вопрос с типом OneVoteType или PercentVoteType,
+// ranking ответа содержит один выбранный вариант
+func ChoiceTally(ballots []*VoteBallot, voteType uint32) *golosovaniepb.ResponseVoteResult {
+	counts := make(map[[PkeySize]byte]uint64)
+	for _, b := range ballots {
+		for _, option := range b.Ballot.Ranking {
+			counts[SliceToPkey(option)] += getVoteValue(b.Value, voteType)
+		}
+	}
+	res := golosovaniepb.ResponseVoteResult{Res: pkeyValues(counts)}
+	res.Elected = topCandidate(res.Res)
+	return &res
+}
+
+// CountedBallots returns ballots, which are sent before endTime and are not replaced by later votes
+func CountedBallots(ballots []*VoteBallot, endTime uint64) []*VoteBallot {
+	var counted []*VoteBallot
+	for _, b := range ballots {
+		if b.Timestamp < endTime && !b.Replaced {
+			counted = append(counted, b)
+		}
+	}
+	return counted
+}
+
+// TallyBallots подсчитывает бюллетени голосования или ответы на вопрос с типом voteType,
+// явка - суммарный вес бюллетеней
+func TallyBallots(ballots []*VoteBallot, voteType uint32, params *golosovaniepb.VotingParams) *golosovaniepb.ResponseVoteResult {
+	var res *golosovaniepb.ResponseVoteResult
+	switch voteType {
+	case RankedVoteType:
+		res = InstantRunoff(ballots)
+	case ApprovalVoteType:
+		res = ApprovalTally(ballots)
+	case StvVoteType:
+		res = StvTally(ballots, params.GetSeats())
+	case MultiQuestionVoteType:
+		res = MultiQuestionTally(ballots, params.GetQuestions())
+	default:
+		res = ChoiceTally(ballots, voteType)
+	}
+	for _, b := range ballots {
+		res.Turnout += b.Value
+	}
+	return res
+}
+
+// MultiQuestionTally подсчитывает ответы на каждый вопрос отдельно, вес ответа - вес бюллетеня
+func MultiQuestionTally(ballots []*VoteBallot, questions []*golosovaniepb.Question) *golosovaniepb.ResponseVoteResult {
+	var res golosovaniepb.ResponseVoteResult
+	for i, q := range questions {
+		answers := make([]*VoteBallot, 0, len(ballots))
+		for _, b := range ballots {
+			if i >= len(b.Ballot.Answers) {
+				continue
+			}
+			answer := *b
+			answer.Ballot = b.Ballot.Answers[i]
+			answers = append(answers, &answer)
+		}
+		params := &golosovaniepb.VotingParams{Seats: q.Seats}
+		res.Questions = append(res.Questions, TallyBallots(answers, q.VoteType, params))
+	}
+	return &res
+}
+
+// reachesFraction проверяет, что value / total >= f (> f, если strict). Для f == nil всегда true
+func reachesFraction(value uint64, total uint64, f *golosovaniepb.Fraction, strict bool) bool {
+	if f == nil {
+		return true
+	}
+	left := new(big.Int).Mul(new(big.Int).SetUint64(value), big.NewInt(int64(f.Denominator)))
+	right := new(big.Int).Mul(new(big.Int).SetUint64(total), big.NewInt(int64(f.Numerator)))
+	if strict {
+		return left.Cmp(right) > 0
+	}
+	return left.Cmp(right) >= 0
+}
+
+// decideOutcome заполняет кворум и итог голосования по подсчитанным голосам.
+// Turnout и Eligible результата уже должны быть заданы
+func decideOutcome(res *golosovaniepb.ResponseVoteResult, voteType uint32, params *golosovaniepb.VotingParams, open bool) {
+	res.Open = open
+	res.QuorumMet = reachesFraction(res.Turnout, res.Eligible, params.GetQuorum(), false)
+	switch {
+	case open:
+		res.Outcome = golosovaniepb.Outcome_UNDECIDED
+	case !res.QuorumMet:
+		res.Outcome = golosovaniepb.Outcome_NO_QUORUM
+	default:
+		res.Outcome = questionOutcome(res, voteType, params)
+	}
+}
+
+// questionOutcome определяет итог закончившегося голосования с кворумом или вопроса такого голосования.
+// Голосование с несколькими вопросами принято, только если приняты все вопросы
+func questionOutcome(res *golosovaniepb.ResponseVoteResult, voteType uint32, params *golosovaniepb.VotingParams) golosovaniepb.Outcome {
+	if voteType == MultiQuestionVoteType {
+		outcome := golosovaniepb.Outcome_PASSED
+		for i, q := range params.GetQuestions() {
+			if i >= len(res.Questions) {
+				return golosovaniepb.Outcome_FAILED
+			}
+			questionRes := res.Questions[i]
+			questionRes.Outcome = questionOutcome(questionRes, q.VoteType, params)
+			if questionRes.Outcome != golosovaniepb.Outcome_PASSED {
+				outcome = golosovaniepb.Outcome_FAILED
+			}
+		}
+		return outcome
+	}
+	if len(res.Elected) == 0 {
+		return golosovaniepb.Outcome_FAILED
+	}
+	if voteType == StvVoteType {
+		return golosovaniepb.Outcome_PASSED
+	}
+	// в одобрительном голосовании бюллетень отдается нескольким кандидатам,
+	// поэтому доля считается от всех отданных голосов
+	var total uint64
+	if voteType == ApprovalVoteType {
+		total = res.Turnout
+	} else {
+		for _, count := range res.Res {
+			total += count.Value
+		}
+	}
+	for _, count := range res.Res {
+		if bytes.Equal(count.Pkey, res.Elected[0]) &&
+			reachesFraction(count.Value, total, params.GetThreshold(), params.GetStrictThreshold()) {
+			return golosovaniepb.Outcome_PASSED
+		}
+	}
+	return golosovaniepb.Outcome_FAILED
+}
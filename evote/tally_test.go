@@ -0,0 +1,310 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// sortedCandidates returns random pkeys in the order, in which they are listed in results
+func sortedCandidates() (a, b, c, d []byte) {
+	candidates := [][]byte{randPkey(), randPkey(), randPkey(), randPkey()}
+	sort.Slice(candidates, func(i, j int) bool {
+		return bytes.Compare(candidates[i], candidates[j]) < 0
+	})
+	return candidates[0], candidates[1], candidates[2], candidates[3]
+}
+
+func rankedBallot(value uint64, ranking ...[]byte) *VoteBallot {
+	return &VoteBallot{
+		TxHash: randHash(),
+		Ballot: &golosovaniepb.Ballot{Ranking: ranking},
+		Value:  value,
+	}
+}
+
+func roundCounts(round *golosovaniepb.ResponseVoteResult_Round) map[string]uint64 {
+	res := make(map[string]uint64)
+	for _, v := range round.Counts {
+		res[string(v.Pkey)] = v.Value
+	}
+	return res
+}
+
+func TestInstantRunoff(t *testing.T) {
+	a, b, c, d := sortedCandidates()
+	ballot, counts := rankedBallot, roundCounts
+
+	t.Run("first_round_majority", func(t *testing.T) {
+		res := InstantRunoff([]*VoteBallot{
+			ballot(3, a, b),
+			ballot(2, b, a),
+		})
+		assert.Equal(t, 1, len(res.Rounds))
+		assert.Equal(t, [][]byte{a}, res.Elected)
+		assert.Equal(t, map[string]uint64{string(a): 3, string(b): 2}, counts(res.Rounds[0]))
+		assert.Equal(t, res.Rounds[0].Counts, res.Res)
+	})
+	t.Run("runoff", func(t *testing.T) {
+		res := InstantRunoff([]*VoteBallot{
+			ballot(4, a),
+			ballot(3, b, c),
+			ballot(2, c, b),
+			ballot(1, d, c, b),
+		})
+		assert.Equal(t, 3, len(res.Rounds))
+		assert.Equal(t, [][]byte{d}, res.Rounds[0].Eliminated)
+		assert.Equal(t, map[string]uint64{string(a): 4, string(b): 3, string(c): 3}, counts(res.Rounds[1]))
+		// у b и c поровну голосов, выбывают оба
+		assert.Equal(t, [][]byte{b, c}, res.Rounds[1].Eliminated)
+		assert.Equal(t, map[string]uint64{string(a): 4}, counts(res.Rounds[2]))
+		assert.Equal(t, [][]byte{a}, res.Elected)
+	})
+	t.Run("transfer_wins", func(t *testing.T) {
+		res := InstantRunoff([]*VoteBallot{
+			ballot(4, a),
+			ballot(3, b),
+			ballot(2, c, b),
+		})
+		assert.Equal(t, 2, len(res.Rounds))
+		assert.Equal(t, [][]byte{c}, res.Rounds[0].Eliminated)
+		assert.Equal(t, map[string]uint64{string(a): 4, string(b): 5}, counts(res.Rounds[1]))
+		assert.Equal(t, [][]byte{b}, res.Elected)
+	})
+	t.Run("candidate_without_first_choices", func(t *testing.T) {
+		res := InstantRunoff([]*VoteBallot{
+			ballot(2, a, c),
+			ballot(2, b, c),
+			ballot(1, b, a),
+		})
+		assert.Equal(t, map[string]uint64{string(a): 2, string(b): 3, string(c): 0}, counts(res.Rounds[0]))
+		assert.Equal(t, [][]byte{b}, res.Elected)
+	})
+	t.Run("tie", func(t *testing.T) {
+		res := InstantRunoff([]*VoteBallot{
+			ballot(2, a, b),
+			ballot(2, b, a),
+		})
+		assert.Equal(t, 1, len(res.Rounds))
+		assert.Empty(t, res.Rounds[0].Eliminated)
+		assert.Empty(t, res.Elected)
+	})
+	t.Run("no_ballots", func(t *testing.T) {
+		res := InstantRunoff(nil)
+		assert.Empty(t, res.Rounds)
+		assert.Empty(t, res.Res)
+		assert.Empty(t, res.Elected)
+	})
+}
+
+func TestApprovalTally(t *testing.T) {
+	a, b, c, _ := sortedCandidates()
+	ballot := func(value uint64, approved ...[]byte) *VoteBallot {
+		return &VoteBallot{
+			TxHash: randHash(),
+			Ballot: &golosovaniepb.Ballot{Approved: approved},
+			Value:  value,
+		}
+	}
+
+	t.Run("winner", func(t *testing.T) {
+		res := ApprovalTally([]*VoteBallot{
+			ballot(2, a, b),
+			ballot(1, b, c),
+			ballot(1, c),
+		})
+		assert.Equal(t, []*golosovaniepb.ResponseVoteResult_PkeyValue{
+			{Pkey: a, Value: 2},
+			{Pkey: b, Value: 3},
+			{Pkey: c, Value: 2},
+		}, res.Res)
+		assert.Equal(t, [][]byte{b}, res.Elected)
+		assert.Empty(t, res.Rounds)
+	})
+	t.Run("tie", func(t *testing.T) {
+		res := ApprovalTally([]*VoteBallot{
+			ballot(1, a, b),
+			ballot(1, c),
+		})
+		assert.Empty(t, res.Elected)
+	})
+	t.Run("no_ballots", func(t *testing.T) {
+		res := ApprovalTally(nil)
+		assert.Empty(t, res.Res)
+		assert.Empty(t, res.Elected)
+	})
+}
+
+func TestStvTally(t *testing.T) {
+	a, b, c, d := sortedCandidates()
+	ballot, counts := rankedBallot, roundCounts
+
+	t.Run("surplus_transfer", func(t *testing.T) {
+		// квота 12 / 3 + 1 = 5
+		res := StvTally([]*VoteBallot{
+			ballot(6, a, b),
+			ballot(2, b),
+			ballot(3, c),
+			ballot(1, d, c),
+		}, 2)
+		assert.Equal(t, 4, len(res.Rounds))
+		assert.Equal(t, [][]byte{a}, res.Rounds[0].Elected)
+		// излишек a равен 1, он передается b
+		assert.Equal(t, map[string]uint64{string(b): 3, string(c): 3, string(d): 1}, counts(res.Rounds[1]))
+		assert.Equal(t, [][]byte{d}, res.Rounds[1].Eliminated)
+		assert.Equal(t, map[string]uint64{string(b): 3, string(c): 4}, counts(res.Rounds[2]))
+		assert.Equal(t, [][]byte{b}, res.Rounds[2].Eliminated)
+		assert.Equal(t, [][]byte{c}, res.Rounds[3].Elected)
+		assert.Equal(t, [][]byte{a, c}, res.Elected)
+	})
+	t.Run("several_elected_in_round", func(t *testing.T) {
+		// квота 9 / 4 + 1 = 3
+		res := StvTally([]*VoteBallot{
+			ballot(3, a),
+			ballot(3, b),
+			ballot(2, c),
+			ballot(1, d),
+		}, 3)
+		assert.Equal(t, [][]byte{a, b}, res.Rounds[0].Elected)
+		assert.Equal(t, [][]byte{d}, res.Rounds[1].Eliminated)
+		assert.Equal(t, [][]byte{a, b, c}, res.Elected)
+	})
+	t.Run("fewer_candidates_than_seats", func(t *testing.T) {
+		res := StvTally([]*VoteBallot{
+			ballot(1, a, b),
+		}, 3)
+		assert.Equal(t, 1, len(res.Rounds))
+		assert.Equal(t, [][]byte{a, b}, res.Elected)
+	})
+	t.Run("tie", func(t *testing.T) {
+		res := StvTally([]*VoteBallot{
+			ballot(1, a),
+			ballot(1, b),
+			ballot(1, c),
+		}, 1)
+		assert.Equal(t, 1, len(res.Rounds))
+		assert.Empty(t, res.Elected)
+	})
+}
+
+func TestMultiQuestionTally(t *testing.T) {
+	a, b, c, d := sortedCandidates()
+	questions := []*golosovaniepb.Question{
+		{VoteType: OneVoteType, Options: [][]byte{a, b}},
+		{VoteType: PercentVoteType, Options: [][]byte{a, b}},
+		{VoteType: RankedVoteType, Options: [][]byte{c, d}},
+	}
+	ballot := func(value uint64, answers ...*golosovaniepb.Ballot) *VoteBallot {
+		return &VoteBallot{
+			TxHash: randHash(),
+			Ballot: &golosovaniepb.Ballot{Answers: answers},
+			Value:  value,
+		}
+	}
+	ranking := func(pkeys ...[]byte) *golosovaniepb.Ballot {
+		return &golosovaniepb.Ballot{Ranking: pkeys}
+	}
+
+	res := MultiQuestionTally([]*VoteBallot{
+		ballot(3, ranking(a), ranking(a), ranking(c, d)),
+		ballot(2, ranking(b), ranking(a), ranking(d, c)),
+		ballot(2, ranking(b), ranking(b), ranking(d)),
+	}, questions)
+	assert.Empty(t, res.Res)
+	assert.Equal(t, 3, len(res.Questions))
+	// голос OneVoteType учитывается как 1 независимо от веса бюллетеня
+	assert.Equal(t, []*golosovaniepb.ResponseVoteResult_PkeyValue{
+		{Pkey: a, Value: 1},
+		{Pkey: b, Value: 2},
+	}, res.Questions[0].Res)
+	assert.Equal(t, []*golosovaniepb.ResponseVoteResult_PkeyValue{
+		{Pkey: a, Value: 5},
+		{Pkey: b, Value: 2},
+	}, res.Questions[1].Res)
+	assert.Equal(t, [][]byte{d}, res.Questions[2].Elected)
+	assert.Equal(t, 1, len(res.Questions[2].Rounds))
+}
+
+func TestDecideOutcome(t *testing.T) {
+	a, b, c, _ := sortedCandidates()
+	half := &golosovaniepb.Fraction{Numerator: 1, Denominator: 2}
+	twoThirds := &golosovaniepb.Fraction{Numerator: 2, Denominator: 3}
+	ballots := []*VoteBallot{
+		rankedBallot(4, a),
+		rankedBallot(2, b),
+	}
+	decide := func(params *golosovaniepb.VotingParams, eligible uint64, open bool) *golosovaniepb.ResponseVoteResult {
+		res := TallyBallots(ballots, PercentVoteType, params)
+		res.Eligible = eligible
+		decideOutcome(res, PercentVoteType, params, open)
+		return res
+	}
+
+	res := decide(nil, 10, false)
+	assert.Equal(t, uint64(6), res.Turnout)
+	assert.True(t, res.QuorumMet)
+	assert.Equal(t, [][]byte{a}, res.Elected)
+	assert.Equal(t, golosovaniepb.Outcome_PASSED, res.Outcome)
+
+	res = decide(&golosovaniepb.VotingParams{Quorum: twoThirds}, 10, false)
+	assert.False(t, res.QuorumMet)
+	assert.Equal(t, golosovaniepb.Outcome_NO_QUORUM, res.Outcome)
+	res = decide(&golosovaniepb.VotingParams{Quorum: twoThirds}, 9, false)
+	assert.True(t, res.QuorumMet)
+	assert.Equal(t, golosovaniepb.Outcome_PASSED, res.Outcome)
+
+	res = decide(&golosovaniepb.VotingParams{Quorum: twoThirds}, 10, true)
+	assert.True(t, res.Open)
+	assert.Equal(t, golosovaniepb.Outcome_UNDECIDED, res.Outcome)
+
+	// у a ровно две трети голосов
+	res = decide(&golosovaniepb.VotingParams{Threshold: twoThirds}, 10, false)
+	assert.Equal(t, golosovaniepb.Outcome_PASSED, res.Outcome)
+	res = decide(&golosovaniepb.VotingParams{Threshold: twoThirds, StrictThreshold: true}, 10, false)
+	assert.Equal(t, golosovaniepb.Outcome_FAILED, res.Outcome)
+
+	// ничья - победителя нет
+	ballots = append(ballots, rankedBallot(2, b))
+	res = decide(nil, 10, false)
+	assert.Empty(t, res.Elected)
+	assert.Equal(t, golosovaniepb.Outcome_FAILED, res.Outcome)
+
+	t.Run("approval", func(t *testing.T) {
+		approval := func(value uint64, approved ...[]byte) *VoteBallot {
+			return &VoteBallot{Ballot: &golosovaniepb.Ballot{Approved: approved}, Value: value}
+		}
+		params := &golosovaniepb.VotingParams{Threshold: half, StrictThreshold: true}
+		// доля одобрения считается от всех бюллетеней, а не от суммы голосов кандидатов
+		res := TallyBallots([]*VoteBallot{approval(2, a, b), approval(1, a, c), approval(1, c)}, ApprovalVoteType, params)
+		decideOutcome(res, ApprovalVoteType, params, false)
+		assert.Equal(t, [][]byte{a}, res.Elected)
+		assert.Equal(t, uint64(4), res.Turnout)
+		assert.Equal(t, golosovaniepb.Outcome_PASSED, res.Outcome)
+	})
+
+	t.Run("multi_question", func(t *testing.T) {
+		params := &golosovaniepb.VotingParams{
+			Threshold: half,
+			Questions: []*golosovaniepb.Question{
+				{VoteType: OneVoteType, Options: [][]byte{a, b}},
+				{VoteType: OneVoteType, Options: [][]byte{a, b}},
+			},
+		}
+		answers := func(first, second []byte) *VoteBallot {
+			return &VoteBallot{
+				Ballot: &golosovaniepb.Ballot{Answers: []*golosovaniepb.Ballot{
+					{Ranking: [][]byte{first}},
+					{Ranking: [][]byte{second}},
+				}},
+				Value: 1,
+			}
+		}
+		res := TallyBallots([]*VoteBallot{answers(a, a), answers(a, b), answers(b, c)}, MultiQuestionVoteType, params)
+		decideOutcome(res, MultiQuestionVoteType, params, false)
+		assert.Equal(t, golosovaniepb.Outcome_PASSED, res.Questions[0].Outcome)
+		assert.Equal(t, golosovaniepb.Outcome_FAILED, res.Questions[1].Outcome)
+		assert.Equal(t, golosovaniepb.Outcome_FAILED, res.Outcome)
+	})
+}
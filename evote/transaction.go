@@ -3,103 +3,305 @@ package evote
 import (
 	"GO_LOSOVANIE/evote/golosovaniepb"
 	"bytes"
+	"crypto/rand"
 	"fmt"
 	"github.com/golang/protobuf/proto"
+	"sort"
 )
 
+// CreateTx creates transfer tx. fee is paid to the block proposer, it must be 0 for transfer of votes
 func CreateTx(
 	inputs []*golosovaniepb.Utxo,
-	outputs map[[PkeySize]byte]uint32,
+	outputs map[[PkeySize]byte]uint64,
 	valueType []byte,
 	keys *CryptoKeysData,
 	voteType uint32,
 	duration uint32,
 	ignoreTypeValue bool,
+	fee uint64,
 ) (*golosovaniepb.Transaction, error) {
 	if len(inputs) == 0 || len(outputs) == 0 {
 		return nil, fmt.Errorf("at least one output or input required")
 	}
-
-	var t golosovaniepb.TxBody
-
-	var maxValInputs uint32 = 0
-	var maxValOutputs uint32 = 0
+	var outs []*golosovaniepb.Output
 	for pkey, val := range outputs {
 		p := pkey
-		t.Outputs = append(t.Outputs,
-			&golosovaniepb.Output{
-				ReceiverSpendPkey: p[:],
-				ReceiverScanPkey:  nil,
-				Value:             val,
-			})
-		maxValOutputs += val
+		outs = append(outs, newOutput(p[:], nil, val))
+	}
+	body := golosovaniepb.TxBody{
+		ValueType: valueType,
+		VoteType:  voteType,
+		Duration:  duration,
+	}
+	tx, _, err := createTx(inputs, outs, &body, valueType, ignoreTypeValue, fee, keys)
+	return tx, err
+}
+
+// createTx fills inputs and outputs of the body template and signs it, inputs cover outputs and the fee.
+// Returns inputs, which were not used in the transaction
+func createTx(
+	inputs []*golosovaniepb.Utxo,
+	outputs []*golosovaniepb.Output,
+	t *golosovaniepb.TxBody,
+	inputsValueType []byte,
+	ignoreTypeValue bool,
+	fee uint64,
+	keys *CryptoKeysData,
+) (*golosovaniepb.Transaction, []*golosovaniepb.Utxo, error) {
+	var maxValInputs uint64 = 0
+	var maxValOutputs uint64 = 0
+	var ok bool
+	for _, out := range outputs {
+		t.Outputs = append(t.Outputs, out)
+		maxValOutputs, ok = AddAmounts(maxValOutputs, out.Value)
+		if !ok {
+			return nil, nil, fmt.Errorf("sum of outputs exceeds %d", MaxAmount)
+		}
+	}
+	// комиссия - часть входов, которая не переводится выходами
+	maxValOutputs, ok = AddAmounts(maxValOutputs, fee)
+	if !ok {
+		return nil, nil, fmt.Errorf("sum of outputs exceeds %d", MaxAmount)
 	}
 
+	var unused []*golosovaniepb.Utxo
 	for _, in := range inputs {
-		if (ignoreTypeValue || bytes.Equal(in.ValueType, valueType)) && maxValInputs < maxValOutputs {
+		if (ignoreTypeValue || bytes.Equal(in.ValueType, inputsValueType)) && maxValInputs < maxValOutputs {
 			t.Inputs = append(t.Inputs,
 				&golosovaniepb.Input{
 					PrevTxHash:  in.TxHash,
 					OutputIndex: in.Index,
 				})
-			maxValInputs += in.Value
+			maxValInputs, ok = AddAmounts(maxValInputs, in.Value)
+			if !ok {
+				return nil, nil, fmt.Errorf("sum of inputs exceeds %d", MaxAmount)
+			}
+		} else {
+			unused = append(unused, in)
 		}
 	}
 
 	if maxValInputs < maxValOutputs {
-		return nil, fmt.Errorf("insufficient balance")
+		return nil, nil, fmt.Errorf("insufficient balance")
 	}
 
 	if maxValInputs > maxValOutputs {
-		t.Outputs = append(t.Outputs,
-			&golosovaniepb.Output{
-				ReceiverSpendPkey: inputs[0].ReceiverSpendPkey,
-				Value:             maxValInputs - maxValOutputs,
-			})
-	}
-	t.ValueType = valueType
-	t.VoteType = voteType
-	t.Duration = duration
+		change := newOutput(inputs[0].ReceiverSpendPkey, nil, maxValInputs-maxValOutputs)
+		// у всех выходов анонимного голосования есть scan ключи, сдача становится голосами создателя
+		if len(outputs) != 0 && len(outputs[0].ReceiverScanPkey) != 0 {
+			change.ReceiverScanPkey = keys.ScanKeys().PkeyByte[:]
+		}
+		t.Outputs = append(t.Outputs, change)
+	}
 	// other values are nil
-	txBytes, err := proto.Marshal(&t)
+	txBytes, err := proto.Marshal(t)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	sig := keys.Sign(txBytes)
 	return &golosovaniepb.Transaction{
 		TxBody: txBytes,
 		Sig:    sig,
 		Hash:   Hash(txBytes),
-	}, nil
+	}, unused, nil
 }
 
-func CreateMiningReward(keys *CryptoKeysData, rewardForBlock []byte) (*golosovaniepb.Transaction, error) {
-	// reward for block is created after that block
-	tOut := golosovaniepb.Output{
-		ReceiverSpendPkey: keys.PkeyByte[:],
-		ReceiverScanPkey:  nil,
-		Value:             RewardCoins,
+// CreateVoteTxs creates voting with participants from outputs. If participants don't fit into one
+// transaction, the list is continued by vote chain transactions, each one has HashLink of the previous tx
+// and ValueType of create vote tx. Transactions must be sent in the returned order.
+// scanPkeys are scan keys of participants for anonymous voting, nil for open voting.
+// params and metadata are set in create vote tx, they may be nil. Each transaction pays the fee
+func CreateVoteTxs(
+	inputs []*golosovaniepb.Utxo,
+	outputs map[[PkeySize]byte]uint64,
+	scanPkeys map[[PkeySize]byte][]byte,
+	keys *CryptoKeysData,
+	voteType uint32,
+	duration uint32,
+	params *golosovaniepb.VotingParams,
+	metadata *golosovaniepb.VotingMetadata,
+	fee uint64,
+) ([]*golosovaniepb.Transaction, error) {
+	if len(inputs) == 0 || len(outputs) == 0 {
+		return nil, fmt.Errorf("at least one output or input required")
+	}
+	if scanPkeys != nil && len(outputs) >= MaxVoteTxOutputs {
+		// все участники анонимного голосования должны поместиться в транзакцию инициализации
+		return nil, fmt.Errorf("anonymous voting has more than %v participants", MaxVoteTxOutputs-1)
+	}
+	var outs []*golosovaniepb.Output
+	for pkey, val := range outputs {
+		p := pkey
+		var scanPkey []byte
+		if scanPkeys != nil {
+			scanPkey = scanPkeys[pkey]
+			if len(scanPkey) != PkeySize {
+				return nil, fmt.Errorf("no scan key for participant %x", p)
+			}
+		}
+		outs = append(outs, newOutput(p[:], scanPkey, val))
 	}
+	sort.Slice(outs, func(i, j int) bool {
+		return bytes.Compare(outs[i].ReceiverSpendPkey, outs[j].ReceiverSpendPkey) < 0
+	})
+
+	var txs []*golosovaniepb.Transaction
+	var rootHash []byte
+	for start := 0; start < len(outs); start += MaxVoteTxOutputs {
+		end := start + MaxVoteTxOutputs
+		if end > len(outs) {
+			end = len(outs)
+		}
+		var body golosovaniepb.TxBody
+		if rootHash == nil {
+			body.VoteType = voteType
+			body.Duration = duration
+			body.VotingParams = params
+			body.VotingMetadata = metadata
+		} else {
+			body.HashLink = txs[len(txs)-1].Hash
+			body.ValueType = rootHash
+		}
+		// сдача транзакций голосования становится голосами, поэтому каждая транзакция
+		// оплачивается своими монетами, а не сдачей предыдущей
+		tx, unused, err := createTx(inputs, outs[start:end], &body, nil, false, fee, keys)
+		if err != nil {
+			return nil, err
+		}
+		inputs = unused
+		if rootHash == nil {
+			rootHash = tx.Hash
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// CreateBallotTx creates vote tx of the voting with ballots, all votes of the voting valueType are sent to the ballot box.
+// In revotable voting votes are sent back to the voter, nil ballot revokes the previous vote
+func CreateBallotTx(
+	inputs []*golosovaniepb.Utxo,
+	ballot *golosovaniepb.Ballot,
+	valueType []byte,
+	revotable bool,
+	keys *CryptoKeysData,
+) (*golosovaniepb.Transaction, error) {
+	body := golosovaniepb.TxBody{Ballot: ballot}
+	receiver := BallotBoxPkey
+	if revotable {
+		receiver = keys.PkeyByte
+	}
+	return createVotesTx(inputs, &body, valueType, receiver, keys)
+}
+
+// createVotesTx sends all votes of the voting valueType to the receiver
+func createVotesTx(
+	inputs []*golosovaniepb.Utxo,
+	body *golosovaniepb.TxBody,
+	valueType []byte,
+	receiver [PkeySize]byte,
+	keys *CryptoKeysData,
+) (*golosovaniepb.Transaction, error) {
+	var value uint64
+	for _, in := range inputs {
+		if bytes.Equal(in.ValueType, valueType) {
+			var ok bool
+			value, ok = AddAmounts(value, in.Value)
+			if !ok {
+				return nil, fmt.Errorf("sum of votes exceeds %d", MaxAmount)
+			}
+		}
+	}
+	if value == 0 {
+		return nil, fmt.Errorf("no votes")
+	}
+	body.ValueType = valueType
+	outputs := []*golosovaniepb.Output{newOutput(receiver[:], nil, value)}
+	tx, _, err := createTx(inputs, outputs, body, valueType, false, 0, keys)
+	return tx, err
+}
+
+// CommitmentHash returns commitment to the ballot of commit-reveal voting. Ballot is serialized deterministically,
+// so the executor gets the same hash from the ballot of reveal tx
+func CommitmentHash(ballot *golosovaniepb.Ballot, salt []byte) ([]byte, error) {
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	err := buf.Marshal(ballot)
+	if err != nil {
+		return nil, err
+	}
+	return Hash(append(buf.Bytes(), salt...)), nil
+}
+
+// CreateCommitTx creates vote tx of commit-reveal voting, votes stay with the voter until the reveal.
+// Commitment is a hash of the ballot and the salt, which must be kept until the reveal phase.
+// nil ballot revokes the previous vote
+func CreateCommitTx(
+	inputs []*golosovaniepb.Utxo,
+	ballot *golosovaniepb.Ballot,
+	valueType []byte,
+	keys *CryptoKeysData,
+) (tx *golosovaniepb.Transaction, salt []byte, err error) {
+	var body golosovaniepb.TxBody
+	if ballot != nil {
+		salt = make([]byte, SaltSize)
+		_, err = rand.Read(salt)
+		if err != nil {
+			return nil, nil, err
+		}
+		body.Commitment, err = CommitmentHash(ballot, salt)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	tx, err = createVotesTx(inputs, &body, valueType, keys.PkeyByte, keys)
+	return tx, salt, err
+}
+
+// CreateRevealTx creates tx, which reveals the ballot of commit-reveal voting committed by commitTx
+// and sends its votes to the ballot box
+func CreateRevealTx(
+	commitTx *golosovaniepb.Transaction,
+	ballot *golosovaniepb.Ballot,
+	salt []byte,
+	valueType []byte,
+	keys *CryptoKeysData,
+) (*golosovaniepb.Transaction, error) {
+	var commitBody golosovaniepb.TxBody
+	err := proto.Unmarshal(commitTx.TxBody, &commitBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(commitBody.Outputs) != 1 {
+		return nil, fmt.Errorf("not a commit tx")
+	}
+	inputs := []*golosovaniepb.Utxo{{
+		TxHash:            commitTx.Hash,
+		ValueType:         valueType,
+		Index:             0,
+		Value:             commitBody.Outputs[0].Value,
+		ReceiverSpendPkey: commitBody.Outputs[0].ReceiverSpendPkey,
+	}}
+	body := golosovaniepb.TxBody{
+		Ballot: ballot,
+		Salt:   salt,
+	}
+	return createVotesTx(inputs, &body, valueType, BallotBoxPkey, keys)
+}
+
+// CreateCoinbaseTx creates coinbase tx of the block height, which sends value to the block proposer.
+// The tx has no inputs and no signature, see TxExecutor.AppendReward
+func CreateCoinbaseTx(proposer []byte, height int64, value uint64) (*golosovaniepb.Transaction, error) {
 	t := golosovaniepb.TxBody{
-		Inputs: nil,
-		Outputs: []*golosovaniepb.Output{
-			&tOut,
-		},
-		HashLink:            rewardForBlock[:],
-		ValueType:           nil,
-		VoteType:            0,
-		Duration:            0,
-		SenderEphemeralPkey: nil,
-		VotersSumPkey:       nil,
+		Outputs:  []*golosovaniepb.Output{newOutput(proposer, nil, value)},
+		Coinbase: &golosovaniepb.Coinbase{Height: uint64(height)},
 	}
 	txBytes, err := proto.Marshal(&t)
 	if err != nil {
 		return nil, err
 	}
-	sig := keys.Sign(txBytes)
 	return &golosovaniepb.Transaction{
 		TxBody: txBytes,
 		Hash:   Hash(txBytes),
-		Sig:    sig,
 	}, nil
 }
@@ -5,26 +5,74 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/golang/protobuf/proto"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// outpoint identifies output of a transaction
+type outpoint struct {
+	txHash [HashSize]byte
+	index  uint32
+}
+
+// TxExecutor checks transactions against committed state in DB and transactions, which are
+// already accepted into the current block (or into mempool for CheckTx), but not committed yet
 type TxExecutor struct {
 	Transactions   []*golosovaniepb.Transaction
-	Timestamp      time.Time
+	Timestamp      time.Time // time of the block being executed, for CheckTx time of the last committed block
 	BlockProposer  [PkeySize]byte
+	MinFee         uint64 // minimal fee of transactions, which spend coins, 0 for DeliverTx
+	MaxTxSize      int    // max size of tx body, from genesis params
+	Fees           uint64 // sum of fees of accepted transactions, goes to the block proposer with the reward
 	db             *Database
 	processedTrans map[[HashSize]byte]bool
+	spentOutputs   map[outpoint]bool                // outputs spent by pending transactions
+	createdOutputs map[outpoint]*golosovaniepb.Utxo // outputs created by pending transactions
+	pendingTxs     map[[HashSize]byte]*pendingTx
+	usedHashLinks  map[[HashSize]byte]bool // hash links of pending vote chain transactions
+	startedVotings map[[HashSize]byte]bool // votings, which have pending vote transactions
+	// key shares and partial decryptions of encrypted votings in pending transactions, in order of acceptance
+	pendingKeyShares   map[[HashSize]byte][]*golosovaniepb.KeyShare
+	pendingDecryptions map[[HashSize]byte][]*golosovaniepb.PartialDecryption
+	validators         [][]byte // pkeys of validators in ascending order, see SortedValidatorPkeys
+	rewards            *RewardSchedule
+}
+
+type pendingTx struct {
+	body   *golosovaniepb.TxBody
+	sender []byte // owner of inputs, nil for coinbase
 }
 
 func NewTxExecutor(db *Database) *TxExecutor {
-	return &TxExecutor{db: db}
+	t := &TxExecutor{db: db, MaxTxSize: MaxTxSize, rewards: DefaultRewardSchedule()}
+	t.Reset()
+	return t
 }
 
 func (t *TxExecutor) Reset() {
 	// TODO: reset database CheckTxState or DeliverTxState
 	t.Transactions = nil
 	t.BlockProposer = ZeroArrayPkey
+	t.Fees = 0
 	t.processedTrans = make(map[[HashSize]byte]bool)
+	t.spentOutputs = make(map[outpoint]bool)
+	t.createdOutputs = make(map[outpoint]*golosovaniepb.Utxo)
+	t.pendingTxs = make(map[[HashSize]byte]*pendingTx)
+	t.usedHashLinks = make(map[[HashSize]byte]bool)
+	t.startedVotings = make(map[[HashSize]byte]bool)
+	t.pendingKeyShares = make(map[[HashSize]byte][]*golosovaniepb.KeyShare)
+	t.pendingDecryptions = make(map[[HashSize]byte][]*golosovaniepb.PartialDecryption)
+}
+
+// SetValidators sets validators, which generate keys and decrypt results of encrypted votings
+func (t *TxExecutor) SetValidators(validators []*ValidatorNode) {
+	t.validators = SortedValidatorPkeys(validators)
+}
+
+// SetRewardSchedule sets schedule of block rewards from genesis
+func (t *TxExecutor) SetRewardSchedule(rewards *RewardSchedule) {
+	t.rewards = rewards
 }
 
 func (t *TxExecutor) BeginBlock(timestamp time.Time, blockProposer [PkeySize]byte) {
@@ -32,10 +80,36 @@ func (t *TxExecutor) BeginBlock(timestamp time.Time, blockProposer [PkeySize]byt
 	t.BlockProposer = blockProposer
 }
 
+// AppendReward adds coinbase tx with the reward for the block height and fees of the block transactions
+// to the beginning of the block. The tx goes to the block proposer, every validator creates the same tx,
+// so it is not signed. Nothing is added, if there is neither reward nor fees
+func (t *TxExecutor) AppendReward(height int64) error {
+	value, ok := AddAmounts(t.rewards.RewardAt(height), t.Fees)
+	if !ok {
+		return fmt.Errorf("reward %v with fees %v exceeds max amount at height %v",
+			t.rewards.RewardAt(height), t.Fees, height)
+	}
+	if value == 0 {
+		return nil
+	}
+	tx, err := CreateCoinbaseTx(t.BlockProposer[:], height, value)
+	if err != nil {
+		return err
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(tx.TxBody, &body)
+	if err != nil {
+		return err
+	}
+	t.Transactions = append([]*golosovaniepb.Transaction{tx}, t.Transactions...)
+	t.processedTrans[SliceToHash(tx.Hash)] = true
+	t.addPending(tx, &body, nil)
+	return nil
+}
+
 // AppendTx used in DeliverTx and CheckTx abci methods
 // ignoreDuplicates=true tells to approve transactions, that have already been approved
 // TODO: check duplicate handling rules for tendermint. Should i use flags in request from tendermint?
-// TODO: implement double spending check inside the same block
 func (t *TxExecutor) AppendTx(data []byte, ignoreDuplicates bool) (code uint32) {
 	var tx golosovaniepb.Transaction
 	err := proto.Unmarshal(data, &tx)
@@ -43,7 +117,7 @@ func (t *TxExecutor) AppendTx(data []byte, ignoreDuplicates bool) (code uint32)
 		fmt.Println("parse tx err: ", err)
 		return CodeParseErr
 	}
-	if len(tx.TxBody) > MaxTxSize {
+	if len(tx.TxBody) > t.MaxTxSize {
 		fmt.Println("err: tx too large")
 		return CodeTxTooLarge
 	}
@@ -53,6 +127,12 @@ func (t *TxExecutor) AppendTx(data []byte, ignoreDuplicates bool) (code uint32)
 		fmt.Println("parse txBody err: ", err)
 		return CodeParseErr
 	}
+	// бд хранит транзакции по полям, и тело при чтении блоков сериализуется заново, поэтому принимается
+	// только каноническая сериализация без неизвестных полей, иначе хэш восстановленного тела будет другим
+	if !isCanonicalTxBody(tx.TxBody) {
+		fmt.Println("err: tx body is not canonical")
+		return CodeNonCanonicalTx
+	}
 
 	if !bytes.Equal(tx.Hash, Hash(tx.TxBody)) {
 		fmt.Println("hashes not equal")
@@ -66,117 +146,112 @@ func (t *TxExecutor) AppendTx(data []byte, ignoreDuplicates bool) (code uint32)
 		}
 	}
 
-	if len(body.Outputs) == 0 {
+	if body.KeyShare != nil || body.PartialDecryption != nil {
+		// транзакции валидаторов зашифрованного голосования не переводят монеты
+		return t.appendValidatorTx(&tx, &body, hashBytes)
+	}
+	// у делегирования может не быть сдачи, если входы ушли на комиссию
+	if len(body.Outputs) == 0 && body.Delegation == nil {
 		fmt.Println("err: no outputs")
 		return CodeNoOutputs
 	}
 
 	if len(body.HashLink) != 0 && body.VoteType != 0 {
-		// coinbase transactions have non-zero hashlink, pointing on a block, for which reward is being distributed
 		fmt.Println("err: trans with non-zero HashLink has incorrect TypeValue/TypeVote fields")
 		return CodeHashLinkAndTypeVoteTogether
 	}
 
-	if len(body.HashLink) != 0 && len(body.Inputs) == 0 {
-		if len(body.HashLink) != HashSize {
-			fmt.Println("err: invalid hash size")
-			return CodeHashLinkInvalidLen
-		}
-		// this is coinbase tx, need to check receiver and double spending
-		if len(body.Outputs) != 1 {
-			fmt.Println("err: coinbase tx has incorrect number of outputs")
-			return CodeCoinbaseTxNoOutput
-		}
-		if body.Duration != 0 {
-			fmt.Println("err: unexpected duration in coinbase tx")
-			return CodeCoinbaseUnexpectedDuration
-		}
-		if len(body.SenderEphemeralPkey) != 0 {
-			fmt.Println("err: unexpected sender ephemeral pkey in coinbase tx")
-			return CodeCoinbaseUnexpectedSenderEphemeralPkey
-		}
-		if len(body.VotersSumPkey) != 0 {
-			fmt.Println("err: unexpected voters sum pkey in coinbase tx")
-			return CodeCoinbaseUnexpectedVotersSumPkey
-		}
+	// транзакция инициализации анонимного голосования тратит выходы разных участников,
+	// поэтому её подписывает валидатор, а не владелец входов
+	isInitVoteTx := body.VoteType == 0 && len(body.ValueType) != 0 &&
+		(len(body.SenderEphemeralPkey) != 0 || len(body.VotersSumPkey) != 0)
+	// бюллетень может быть только в транзакции отправки голоса, его тип проверяется ниже
+	isVoteTx := body.VoteType == 0 && len(body.HashLink) == 0 && len(body.ValueType) != 0 && !isInitVoteTx
+	if body.Ballot != nil && !isVoteTx {
+		fmt.Println("err: unexpected ballot")
+		return CodeUnexpectedBallot
+	}
+	if (len(body.Commitment) != 0 || len(body.Salt) != 0) && !isVoteTx {
+		fmt.Println("err: unexpected commitment")
+		return CodeUnexpectedCommitment
+	}
+	if body.VotingParams != nil && body.VoteType == 0 {
+		fmt.Println("err: unexpected voting params")
+		return CodeUnexpectedVotingParams
+	}
+	if body.VotingMetadata != nil && body.VoteType == 0 {
+		fmt.Println("err: unexpected voting metadata")
+		return CodeUnexpectedVotingMetadata
+	}
+	// делегирование только в транзакции перевода, другие транзакции не проверяют, что его подписал делегирующий
+	if body.Delegation != nil && (body.VoteType != 0 || len(body.HashLink) != 0 || len(body.ValueType) != 0) {
+		fmt.Println("err: delegation in tx, which is not delegation tx")
+		return CodeInvalidDelegation
+	}
 
-		pkey := body.Outputs[0].ReceiverSpendPkey
-		if len(body.Outputs[0].ReceiverScanPkey) != 0 {
-			fmt.Println("err: unexpected scan key in coinbase tx")
-			return CodeUnexpectedScanKey
-		}
-		rewardValue := body.Outputs[0].Value
-		rewardBlock := body.HashLink
-		duplicate, err := t.db.GetTxByHashLink(rewardBlock)
-		if err != nil {
-			panic(err)
-		}
-		if duplicate != nil {
-			fmt.Println("err: already has coinbase tx for the same block")
-			return CodeDoubleCoinbaseForSameBlock
-		}
-		block, err := t.db.GetBlockByHash(rewardBlock)
-		if err != nil {
-			panic(err)
-		}
-		if block == nil {
-			fmt.Println("err: no block for coinbase tx")
-			return CodeCoinbaseNoBlock
-		}
-		if !bytes.Equal(block.BlockHeader.ProposerPkey, pkey) {
-			fmt.Println("err: coinbase tx proposer mismatch")
-			return CodeCoinbaseProposerMismatch
-		}
-		if rewardValue != RewardCoins {
-			fmt.Println("err: incorrect reward")
-			return CodeCoinbaseIncorrectReward
-		}
-		return t.verifySigAndAppend(&tx, hashBytes, pkey)
+	if body.Coinbase != nil || len(body.Inputs) == 0 {
+		// награду за блок добавляет в блок каждый валидатор, см. AppendReward
+		fmt.Println("err: coinbase tx is created by the application")
+		return CodeUnexpectedCoinbase
 	}
 
-	var inputsSum, outputsSum uint32
+	var inputsSum, outputsSum uint64
+	var ok bool
 	var pkey []byte
+	spentInTx := make(map[outpoint]bool)
 	for i, input := range body.Inputs {
-		var correspondingUtxo *golosovaniepb.Utxo
-		utxos, err := t.db.GetUtxosByTxHash(input.PrevTxHash)
+		op := outpoint{SliceToHash(input.PrevTxHash), input.OutputIndex}
+		if spentInTx[op] || t.spentOutputs[op] {
+			fmt.Println("err: output is already spent in this block")
+			return CodeDoubleSpending
+		}
+		spentInTx[op] = true
+		// проверка, что вход - непотраченный выход дургой транзы
+		correspondingUtxo, err := t.findUtxo(op)
 		if err != nil {
 			fmt.Println("database failed", err)
 			return CodeDatabaseFailed
 		}
-		// проверка, что вход - непотраченный выход дургой транзы
-		for _, utxo := range utxos {
-			if utxo.Index == input.OutputIndex {
-				correspondingUtxo = utxo
-				if i == 0 {
-					pkey = correspondingUtxo.ReceiverSpendPkey
-				} else {
-					if !bytes.Equal(pkey, correspondingUtxo.ReceiverSpendPkey) {
-						fmt.Println("err: input not owned by a sender")
-						return CodeInputNotOwn
-					}
-				}
-				break
-			}
-		}
 		if correspondingUtxo == nil {
 			fmt.Println("err: double spending in tx")
 			return CodeDoubleSpending
 		}
-		inputsSum += correspondingUtxo.Value
+		// выходы анонимного голосования тратятся только транзакцией инициализации
+		if len(correspondingUtxo.ReceiverScanPkey) != 0 && !isInitVoteTx {
+			fmt.Println("err: anonymous voting is not initialized")
+			return CodeAnonymousVoteNotInitialized
+		}
+		if i == 0 {
+			pkey = correspondingUtxo.ReceiverSpendPkey
+		} else if !isInitVoteTx {
+			if !bytes.Equal(pkey, correspondingUtxo.ReceiverSpendPkey) {
+				fmt.Println("err: input not owned by a sender")
+				return CodeInputNotOwn
+			}
+		}
+		inputsSum, ok = AddAmounts(inputsSum, correspondingUtxo.Value)
+		if !ok {
+			fmt.Println("err: inputs sum overflow")
+			return CodeInvalidValue
+		}
 		// проверка, что в одной транзе не смешиваются разные typeValue
 		if len(body.HashLink) == 0 && body.VoteType == 0 && !bytes.Equal(correspondingUtxo.ValueType, body.ValueType) {
 			fmt.Println("err: incorrect typeValue in input", input)
 			return CodeMixingTypeValue
 		}
-		// проверка, что в транзакции создания голосования не используются голоса
-		if body.VoteType != 0 && len(correspondingUtxo.ValueType) != 0 {
+		// проверка, что в транзакции создания или дополнения голосования не используются голоса
+		if (body.VoteType != 0 || len(body.HashLink) != 0) && len(correspondingUtxo.ValueType) != 0 {
 			fmt.Println("err: cannot use votes as funding for creating new voting")
 			return CodeVotesUsedAsFunding
 		}
 	}
 	var outputsWithScanKey int
 	for _, output := range body.Outputs {
-		outputsSum += output.Value
+		outputsSum, ok = AddAmounts(outputsSum, output.Value)
+		if !ok {
+			fmt.Println("err: outputs sum overflow")
+			return CodeInvalidValue
+		}
 		if len(output.ReceiverScanPkey) != 0 {
 			outputsWithScanKey += 1
 		}
@@ -185,15 +260,41 @@ func (t *TxExecutor) AppendTx(data []byte, ignoreDuplicates bool) (code uint32)
 		fmt.Println("err: outputs have both nil and not nil scan keys")
 		return CodeOutputsHaveBothNilAndNotNilScanKeys
 	}
-	if outputsSum != inputsSum {
+	// монеты, не переведенные выходами, - комиссия создателю блока. Голоса не сжигаются,
+	// поэтому комиссию платят только транзакции, которые тратят монеты: перевод, создание и дополнение голосования
+	paysFee := len(body.ValueType) == 0 || body.VoteType != 0 || len(body.HashLink) != 0
+	if outputsSum > inputsSum || !paysFee && outputsSum != inputsSum {
 		fmt.Printf("err: outputs sum %v is not matching than inputs sum %v\n", outputsSum, inputsSum)
 		return CodeInputsNotMatchOutputs
 	}
+	fee := inputsSum - outputsSum
+	if paysFee && fee < t.MinFee {
+		fmt.Printf("err: fee %v is less than minimal fee %v\n", fee, t.MinFee)
+		return CodeInsufficientFee
+	}
+	// комиссии блока вместе с наградой попадают в один выход coinbase
+	fees, ok := AddAmounts(t.Fees, fee)
+	if !ok {
+		fmt.Println("err: fees sum overflow")
+		return CodeInvalidValue
+	}
 	if body.VoteType != 0 {
 		// транзакция создания голосования
 		// проверка что HashLink == nil выше
 		// valueType не нужно проверять, в цикле по инпутам есть проверка,
 		// что он нулевой у всех инпутов и одинаковый с телом транзакции
+		if !IsKnownVoteType(body.VoteType) {
+			fmt.Println("err: unknown vote type", body.VoteType)
+			return CodeUnknownVoteType
+		}
+		code = checkVotingParams(&body)
+		if code != CodeOk {
+			return code
+		}
+		code = checkVotingMetadata(body.VotingMetadata)
+		if code != CodeOk {
+			return code
+		}
 		if len(body.SenderEphemeralPkey) != 0 {
 			fmt.Println("err: create voting tx has unexpected sender ephemeral pkey")
 			return CodeCreateVoteTxUnexpectedSenderEphemeralPkey
@@ -204,63 +305,848 @@ func (t *TxExecutor) AppendTx(data []byte, ignoreDuplicates bool) (code uint32)
 		}
 	} else if len(body.HashLink) != 0 {
 		// транзакция дополнения голосования
-		// или дополнения инициализации голосования
-		fmt.Println("err: not supported")
-		return CodeNotSupported
+		code = t.checkVoteChainTx(&body, pkey)
+		if code != CodeOk {
+			return code
+		}
 	} else {
 		if len(body.ValueType) != 0 {
 			// транзакция отправки голоса или инициализации голосования
-			if len(body.SenderEphemeralPkey) != 0 && len(body.VotersSumPkey) != 0 {
-				// транзакция инициализации голосования
-				if len(body.SenderEphemeralPkey) != PkeySize {
-					fmt.Println("err: invalid sender ephemeral pkey len")
-					return CodeInvalidSenderEphemeralPkeyLen
-				}
-				if len(body.VotersSumPkey) != PkeySize {
-					fmt.Println("err: invalid voters sum pkey len")
-					return CodeInvalidVotersSumPkeyLen
-				}
-				if outputsWithScanKey != 0 {
-					fmt.Println("err: init vote tx must have nil scan pkeys")
-					return CodeInitVoteMustHaveNilScanPkeys
+			createVoteBody, createVoteTimestamp, err := t.getTxBody(body.ValueType)
+			if err != nil {
+				fmt.Println("database failed", err)
+				return CodeDatabaseFailed
+			}
+			if createVoteBody == nil {
+				fmt.Println("err: no create vote tx, to which valueType points")
+				return CodeValueTypeInvalid
+			}
+			// ValueType должен ссылаться на транзакцию создания голосования
+			if createVoteBody.VoteType == 0 {
+				fmt.Println("err: create vote tx, to which valueType points, is not create vote tx")
+				return CodeValueTypeInvalid
+			}
+			var isRevealTx bool
+			isRevealTx, code = t.checkVotingOpen(createVoteBody, createVoteTimestamp, isVoteTx)
+			if code != CodeOk {
+				return code
+			}
+			if isRevealTx {
+				code = t.checkReveal(&body, createVoteBody)
+				if code != CodeOk {
+					return code
 				}
-				createVoteTx, err := t.db.GetTxByHash(body.ValueType)
+			} else if isInitVoteTx {
+				// транзакция инициализации голосования
+				pkey, err = t.db.GetTxProposerPkey(body.ValueType)
 				if err != nil {
 					fmt.Println("database failed", err)
 					return CodeDatabaseFailed
 				}
-				var createVoteBody golosovaniepb.TxBody
-				err = proto.Unmarshal(createVoteTx.TxBody, &createVoteBody)
-				if err != nil {
-					fmt.Println("parse create vote body error", err)
-					return CodeParseErr
-				}
-				// ValueType должен ссылаться на транзакцию создания голосования
-				if createVoteBody.VoteType == 0 {
-					fmt.Println("err: create vote tx, to which valueType points, is not create vote tx")
+				if pkey == nil {
+					fmt.Println("err: create vote tx is not committed yet")
 					return CodeValueTypeInvalid
 				}
-				// проверка, что число избирателей сохранилось во время инициализации
-				// вообще проверка не нужна, так как покрывается проверкой voters_sum_pkey
-				// однако для более читаемых ошибок она присутствует
-				if len(createVoteBody.Outputs) != len(body.Outputs) {
-					fmt.Println("err: init vote tx participant number differs from one in create vote tx")
-					return CodeInvalidVoteParticipantNumber
+				code = checkInitVoteTx(&tx, &body, createVoteBody, pkey)
+				if code != CodeOk {
+					return code
+				}
+			} else {
+				code = checkBallot(&body, createVoteBody, pkey)
+				if code != CodeOk {
+					return code
+				}
+				if createVoteBody.VoteType == EncryptedVoteType && body.Ballot != nil {
+					code = t.checkElectionKey(body.Ballot, body.ValueType)
+					if code != CodeOk {
+						return code
+					}
 				}
-				// проверить, что sign соответствует публичному ключу валидатора,
-				// в блок предложенный которым попала транзакция создания голосования
+			}
+		} else if body.Delegation != nil {
+			code = t.checkDelegation(&body, pkey)
+			if code != CodeOk {
+				return code
+			}
+		}
+	}
+
+	code = t.verifySigAndAppend(&tx, &body, hashBytes, pkey)
+	if code == CodeOk {
+		t.Fees = fees
+	}
+	return code
+}
+
+// checkVotingOpen checks, that the voting accepts transactions at the block time, isRevealTx is true
+// for vote transactions during the reveal phase of commit-reveal voting
+func (t *TxExecutor) checkVotingOpen(
+	createVoteBody *golosovaniepb.TxBody, createVoteTimestamp uint64, isVoteTx bool,
+) (isRevealTx bool, code uint32) {
+	// после окончания голосования голоса нельзя перемещать, иначе они попадут в блокчейн,
+	// но не будут учтены при подсчете результатов. Исключение - раскрытие бюллетеней commit-reveal
+	now := uint64(t.Timestamp.UnixNano())
+	endTime := VotingEndTime(createVoteTimestamp, createVoteBody.Duration)
+	isRevealTx = isVoteTx && createVoteBody.VotingParams.GetRevealDuration() != 0 && now >= endTime
+	if now >= RevealEndTime(endTime, createVoteBody.VotingParams) || now >= endTime && !isRevealTx {
+		fmt.Println("err: voting is closed")
+		return false, CodeVotingClosed
+	}
+	return isRevealTx, CodeOk
+}
 
-				// проверить voters_sum_pkey
+// checkSeats checks number of seats of the voting or the question with voteType
+func checkSeats(voteType, seats uint32) uint32 {
+	if voteType == StvVoteType {
+		if seats == 0 || seats > MaxBallotCandidates {
+			fmt.Println("err: invalid number of seats", seats)
+			return CodeInvalidVotingParams
+		}
+	} else if seats != 0 {
+		fmt.Println("err: seats are set for voting, which is not stv")
+		return CodeInvalidVotingParams
+	}
+	return CodeOk
+}
+
+// checkVotingParams checks parameters of create vote tx, which depend on the vote type
+func checkVotingParams(body *golosovaniepb.TxBody) uint32 {
+	code := checkSeats(body.VoteType, body.VotingParams.GetSeats())
+	if code != CodeOk {
+		return code
+	}
+	if !isValidFraction(body.VotingParams.GetQuorum()) || !isValidFraction(body.VotingParams.GetThreshold()) {
+		fmt.Println("err: invalid quorum or threshold fraction")
+		return CodeInvalidVotingParams
+	}
+	if body.VoteType == StvVoteType && body.VotingParams.GetThreshold() != nil {
+		fmt.Println("err: threshold is set for stv voting")
+		return CodeInvalidVotingParams
+	}
+	if body.VotingParams.GetRevealDuration() > MaxRevealDuration {
+		fmt.Println("err: reveal phase is too long")
+		return CodeInvalidVotingParams
+	}
+	options := body.VotingParams.GetOptions()
+	if body.VoteType == EncryptedVoteType {
+		if len(options) == 0 || len(options) > MaxBallotCandidates || optionSet(options) == nil {
+			fmt.Println("err: invalid options of encrypted voting")
+			return CodeInvalidVotingParams
+		}
+		// бюллетень и так скрыт до подсчета, а раскрытие зашифрованного бюллетеня не предусмотрено
+		if body.VotingParams.GetRevealDuration() != 0 {
+			fmt.Println("err: encrypted voting cannot be commit-reveal")
+			return CodeInvalidVotingParams
+		}
+	} else if len(options) != 0 {
+		fmt.Println("err: options are set for voting, which is not encrypted")
+		return CodeInvalidVotingParams
+	}
+	questions := body.VotingParams.GetQuestions()
+	if body.VoteType != MultiQuestionVoteType {
+		if len(questions) != 0 {
+			fmt.Println("err: questions are set for voting, which is not multi question")
+			return CodeInvalidVotingParams
+		}
+		return CodeOk
+	}
+	if len(questions) == 0 || len(questions) > MaxQuestions {
+		fmt.Println("err: invalid number of questions", len(questions))
+		return CodeInvalidVotingParams
+	}
+	for _, q := range questions {
+		if !IsKnownVoteType(q.VoteType) || q.VoteType == MultiQuestionVoteType || q.VoteType == EncryptedVoteType {
+			fmt.Println("err: invalid vote type of question", q.VoteType)
+			return CodeInvalidVotingParams
+		}
+		code = checkSeats(q.VoteType, q.Seats)
+		if code != CodeOk {
+			return code
+		}
+		if len(q.Options) == 0 || len(q.Options) > MaxBallotCandidates {
+			fmt.Println("err: invalid number of options in question")
+			return CodeInvalidVotingParams
+		}
+		if optionSet(q.Options) == nil {
+			fmt.Println("err: invalid options of question")
+			return CodeInvalidVotingParams
+		}
+	}
+	return CodeOk
+}
+
+// isValidFraction checks that the fraction is not greater than 1, nil fraction is valid
+func isValidFraction(f *golosovaniepb.Fraction) bool {
+	return f == nil || f.Denominator != 0 && f.Numerator <= f.Denominator
+}
+
+// checkVotingMetadata checks sizes of the voting description, nil metadata is valid
+func checkVotingMetadata(metadata *golosovaniepb.VotingMetadata) uint32 {
+	if metadata == nil {
+		return CodeOk
+	}
+	if len(metadata.Title) == 0 || len(metadata.Title) > MaxVotingTitleSize || !isDbText(metadata.Title) {
+		fmt.Println("err: invalid voting title")
+		return CodeInvalidVotingMetadata
+	}
+	if len(metadata.Description) > MaxVotingDescriptionSize || !isDbText(metadata.Description) {
+		fmt.Println("err: invalid voting description")
+		return CodeInvalidVotingMetadata
+	}
+	if len(metadata.DocumentHash) != 0 && len(metadata.DocumentHash) != HashSize {
+		fmt.Println("err: invalid document hash len")
+		return CodeInvalidVotingMetadata
+	}
+	if len(metadata.Candidates) > MaxCandidateLabels {
+		fmt.Println("err: too many candidate labels")
+		return CodeInvalidVotingMetadata
+	}
+	pkeys := make([][]byte, len(metadata.Candidates))
+	for i, candidate := range metadata.Candidates {
+		if len(candidate.Label) == 0 || len(candidate.Label) > MaxCandidateLabelSize || !isDbText(candidate.Label) {
+			fmt.Println("err: invalid candidate label")
+			return CodeInvalidVotingMetadata
+		}
+		pkeys[i] = candidate.Pkey
+	}
+	if optionSet(pkeys) == nil {
+		fmt.Println("err: invalid candidate pkeys in labels")
+		return CodeInvalidVotingMetadata
+	}
+	return CodeOk
+}
+
+// isDbText returns true, if s can be stored in postgres text column: it is valid utf-8 without null characters
+func isDbText(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+// optionSet returns set of pkeys, nil if some pkey has invalid length or is repeated
+func optionSet(pkeys [][]byte) map[[PkeySize]byte]bool {
+	set := make(map[[PkeySize]byte]bool, len(pkeys))
+	for _, pkey := range pkeys {
+		if len(pkey) != PkeySize || set[SliceToPkey(pkey)] {
+			return nil
+		}
+		set[SliceToPkey(pkey)] = true
+	}
+	return set
+}
+
+// checkBallot checks, that vote tx has ballot if and only if the voting needs ballots,
+// and that the ballot is well-formed. sender is the owner of inputs of the vote tx
+func checkBallot(body *golosovaniepb.TxBody, createVoteBody *golosovaniepb.TxBody, sender []byte) uint32 {
+	if !IsBallotVoting(createVoteBody) {
+		if body.Ballot != nil {
+			fmt.Println("err: unexpected ballot in vote tx")
+			return CodeUnexpectedBallot
+		}
+		if len(body.Commitment) != 0 || len(body.Salt) != 0 {
+			fmt.Println("err: unexpected commitment in vote tx")
+			return CodeUnexpectedCommitment
+		}
+		return CodeOk
+	}
+	if createVoteBody.VotingParams.GetRevealDuration() != 0 {
+		return checkCommitment(body, sender)
+	}
+	if len(body.Commitment) != 0 || len(body.Salt) != 0 {
+		fmt.Println("err: unexpected commitment in vote tx")
+		return CodeUnexpectedCommitment
+	}
+	if createVoteBody.VotingParams.GetRevotable() {
+		// голос остается у избирателя, чтобы следующая транзакция могла его изменить или отозвать
+		if len(body.Outputs) != 1 || !bytes.Equal(body.Outputs[0].ReceiverSpendPkey, sender) ||
+			len(body.Outputs[0].ReceiverScanPkey) != 0 {
+			fmt.Println("err: vote tx of revotable voting must have one output to the sender")
+			return CodeBallotInvalid
+		}
+		if body.Ballot == nil {
+			// отзыв голоса
+			return CodeOk
+		}
+	} else {
+		if body.Ballot == nil {
+			fmt.Println("err: vote tx has no ballot")
+			return CodeBallotInvalid
+		}
+		// голос с бюллетенем не переводится кандидату, а остается в урне, его вес - сумма выходов
+		if len(body.Outputs) != 1 || !bytes.Equal(body.Outputs[0].ReceiverSpendPkey, BallotBoxPkey[:]) {
+			fmt.Println("err: vote tx with ballot must have one output to ballot box")
+			return CodeBallotInvalid
+		}
+	}
+	if createVoteBody.VoteType == EncryptedVoteType {
+		return checkEncryptedBallot(body.Ballot, len(createVoteBody.VotingParams.GetOptions()), sender)
+	}
+	return checkBallotAnswers(body.Ballot, createVoteBody)
+}
+
+// checkBallotAnswers checks candidates of the ballot of the voting createVoteBody
+func checkBallotAnswers(ballot *golosovaniepb.Ballot, createVoteBody *golosovaniepb.TxBody) uint32 {
+	if createVoteBody.VoteType != MultiQuestionVoteType {
+		return checkAnswer(ballot, createVoteBody.VoteType, nil)
+	}
+	// ответы на вопросы лежат в answers, сам бюллетень кандидатов не содержит
+	questions := createVoteBody.VotingParams.GetQuestions()
+	if len(ballot.Ranking) != 0 || len(ballot.Approved) != 0 || len(ballot.Answers) != len(questions) {
+		fmt.Println("err: ballot must have answers to all questions")
+		return CodeBallotInvalid
+	}
+	for i, q := range questions {
+		code := checkAnswer(ballot.Answers[i], q.VoteType, optionSet(q.Options))
+		if code != CodeOk {
+			return code
+		}
+	}
+	return CodeOk
+}
+
+// checkCommitment checks vote tx of commit-reveal voting before the end of the voting.
+// Such tx has only commitment, tx without commitment revokes the vote
+func checkCommitment(body *golosovaniepb.TxBody, sender []byte) uint32 {
+	if body.Ballot != nil || len(body.Salt) != 0 {
+		fmt.Println("err: ballot of commit-reveal voting is sent before the reveal phase")
+		return CodeUnexpectedBallot
+	}
+	if len(body.Commitment) != 0 && len(body.Commitment) != HashSize {
+		fmt.Println("err: invalid commitment len")
+		return CodeInvalidCommitment
+	}
+	// голос остается у избирателя, чтобы раскрыть его после окончания голосования
+	if len(body.Outputs) != 1 || !bytes.Equal(body.Outputs[0].ReceiverSpendPkey, sender) ||
+		len(body.Outputs[0].ReceiverScanPkey) != 0 {
+		fmt.Println("err: vote tx of commit-reveal voting must have one output to the sender")
+		return CodeInvalidCommitment
+	}
+	return CodeOk
+}
+
+// checkReveal checks reveal tx of commit-reveal voting. It spends output of the vote tx with commitment
+// and sends its votes to the ballot box with the ballot, which matches the commitment
+func (t *TxExecutor) checkReveal(body *golosovaniepb.TxBody, createVoteBody *golosovaniepb.TxBody) uint32 {
+	if len(body.Inputs) != 1 {
+		fmt.Println("err: reveal tx must spend one vote tx")
+		return CodeInvalidReveal
+	}
+	commitBody, _, err := t.getTxBody(body.Inputs[0].PrevTxHash)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	if commitBody == nil || len(commitBody.Commitment) == 0 {
+		fmt.Println("err: reveal tx input is not a commitment")
+		return CodeInvalidReveal
+	}
+	if body.Ballot == nil || len(body.Salt) != SaltSize || len(body.Commitment) != 0 {
+		fmt.Println("err: reveal tx must have ballot and salt")
+		return CodeInvalidReveal
+	}
+	commitment, err := CommitmentHash(body.Ballot, body.Salt)
+	if err != nil || !bytes.Equal(commitment, commitBody.Commitment) {
+		fmt.Println("err: ballot does not match the commitment")
+		return CodeInvalidReveal
+	}
+	if len(body.Outputs) != 1 || !bytes.Equal(body.Outputs[0].ReceiverSpendPkey, BallotBoxPkey[:]) {
+		fmt.Println("err: reveal tx must have one output to ballot box")
+		return CodeBallotInvalid
+	}
+	return checkBallotAnswers(body.Ballot, createVoteBody)
+}
+
+// checkAnswer checks candidates of the ballot or of the answer to the question with voteType.
+// options are allowed candidates, nil means any candidate
+func checkAnswer(answer *golosovaniepb.Ballot, voteType uint32, options map[[PkeySize]byte]bool) uint32 {
+	if len(answer.Answers) != 0 {
+		fmt.Println("err: unexpected nested answers in ballot")
+		return CodeBallotInvalid
+	}
+	if len(answer.Encrypted) != 0 || len(answer.ElectionKey) != 0 {
+		fmt.Println("err: unexpected encrypted answer in ballot")
+		return CodeBallotInvalid
+	}
+	// в одобрительном голосовании кандидаты перечисляются в approved, в остальных - в ranking
+	candidates, other := answer.Ranking, answer.Approved
+	if voteType == ApprovalVoteType {
+		candidates, other = other, candidates
+	}
+	if len(other) != 0 {
+		fmt.Println("err: ballot has candidates list of another vote type")
+		return CodeBallotInvalid
+	}
+	maxCandidates := MaxBallotCandidates
+	if !IsBallotVoteType(voteType) {
+		// ответ на вопрос без бюллетеня - один выбранный вариант
+		maxCandidates = 1
+	}
+	if len(candidates) == 0 || len(candidates) > maxCandidates {
+		fmt.Println("err: invalid number of candidates in ballot")
+		return CodeBallotInvalid
+	}
+	unique := make(map[[PkeySize]byte]bool, len(candidates))
+	for _, candidate := range candidates {
+		if len(candidate) != PkeySize {
+			fmt.Println("err: invalid candidate pkey len in ballot")
+			return CodeBallotInvalid
+		}
+		pkey := SliceToPkey(candidate)
+		if unique[pkey] {
+			fmt.Println("err: duplicate candidate in ballot")
+			return CodeBallotInvalid
+		}
+		if options != nil && !options[pkey] {
+			fmt.Println("err: candidate is not an option of the question")
+			return CodeBallotInvalid
+		}
+		unique[pkey] = true
+	}
+	return CodeOk
+}
+
+// checkEncryptedBallot checks, that the ballot of encrypted voting has a ciphertext for each of options
+// and proofs, that the sender votes for exactly one option. Election key of the ballot is checked by checkElectionKey
+func checkEncryptedBallot(ballot *golosovaniepb.Ballot, options int, sender []byte) uint32 {
+	if len(ballot.Ranking) != 0 || len(ballot.Approved) != 0 || len(ballot.Answers) != 0 {
+		fmt.Println("err: encrypted ballot has plain candidates")
+		return CodeBallotInvalid
+	}
+	if len(ballot.Encrypted) != options {
+		fmt.Println("err: encrypted ballot must have ciphertext for each option")
+		return CodeBallotInvalid
+	}
+	for _, c := range ballot.Encrypted {
+		if _, err := decodePoint(c.A); err != nil {
+			fmt.Println("err: invalid ciphertext in ballot")
+			return CodeBallotInvalid
+		}
+		if _, err := decodePoint(c.B); err != nil {
+			fmt.Println("err: invalid ciphertext in ballot")
+			return CodeBallotInvalid
+		}
+	}
+	// без доказательств шифртекст мог бы содержать, например, 1000 голосов за вариант или отрицательный голос
+	if !verifyEncryptedBallot(ballot, sender) {
+		fmt.Println("err: invalid proof of encrypted ballot")
+		return CodeBallotInvalid
+	}
+	return CodeOk
+}
+
+// checkElectionKey checks, that the ballot is encrypted with the election key of the voting,
+// ballots are accepted only after the key is generated
+func (t *TxExecutor) checkElectionKey(ballot *golosovaniepb.Ballot, votingHash []byte) uint32 {
+	keyShares, err := t.getKeyShares(votingHash)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	if len(t.validators) == 0 || len(keyShares) < DecryptionThreshold(len(t.validators)) {
+		fmt.Println("err: election key is not generated yet")
+		return CodeElectionKeyNotReady
+	}
+	electionKey, err := ElectionKey(keyShares)
+	if err != nil || !bytes.Equal(electionKey, ballot.ElectionKey) {
+		fmt.Println("err: ballot is not encrypted with election key")
+		return CodeBallotInvalid
+	}
+	return CodeOk
+}
+
+// appendValidatorTx checks key share or partial decryption of encrypted voting. Such tx has no inputs and outputs,
+// only the voting in value type, and is signed by the validator
+func (t *TxExecutor) appendValidatorTx(
+	tx *golosovaniepb.Transaction, body *golosovaniepb.TxBody, hashBytes [HashSize]byte,
+) uint32 {
+	invalid, pkey := uint32(CodeInvalidKeyShare), body.KeyShare.GetValidatorPkey()
+	if body.KeyShare == nil {
+		invalid, pkey = CodeInvalidPartialDecryption, body.PartialDecryption.ValidatorPkey
+	}
+	if body.KeyShare != nil && body.PartialDecryption != nil || body.Delegation != nil || hasTransferFields(body) {
+		fmt.Println("err: validator tx of encrypted voting has unexpected fields")
+		return invalid
+	}
+	// транзакция без входов не платит комиссию, поэтому подпись валидатора проверяется раньше обращений к бд,
+	// иначе мемпул можно было бы бесплатно заполнить транзакциями с чужим ключом
+	if validatorIndex(t.validators, pkey) < 0 {
+		fmt.Println("err: validator tx is not signed by validator")
+		return invalid
+	}
+	code := verifySig(tx, pkey)
+	if code != CodeOk {
+		return code
+	}
+	createVoteBody, createVoteTimestamp, err := t.getTxBody(body.ValueType)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	if createVoteBody == nil || createVoteBody.VoteType != EncryptedVoteType {
+		fmt.Println("err: validator tx does not point to encrypted voting")
+		return CodeValueTypeInvalid
+	}
+	keyShares, err := t.getKeyShares(body.ValueType)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	threshold := DecryptionThreshold(len(t.validators))
+	ended := uint64(t.Timestamp.UnixNano()) >= VotingEndTime(createVoteTimestamp, createVoteBody.Duration)
+	if body.KeyShare != nil {
+		if ended {
+			fmt.Println("err: voting is closed")
+			return CodeVotingClosed
+		}
+		// ключ собирается из первых threshold вкладов, чтобы он не менялся после начала голосования
+		if len(keyShares) >= threshold {
+			fmt.Println("err: election key is already generated")
+			return invalid
+		}
+		for _, keyShare := range keyShares {
+			if bytes.Equal(keyShare.ValidatorPkey, pkey) {
+				fmt.Println("err: duplicate key share of validator")
+				return invalid
 			}
 		}
+		if !checkKeyShare(body.KeyShare, t.validators) {
+			fmt.Println("err: invalid key share")
+			return invalid
+		}
+		t.appendVerified(tx, body, hashBytes, pkey)
+		return CodeOk
+	}
+
+	if !ended || len(keyShares) < threshold {
+		fmt.Println("err: voting is not ended or has no election key")
+		return invalid
+	}
+	decryptions, err := t.getPartialDecryptions(body.ValueType)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	for _, decryption := range decryptions {
+		if bytes.Equal(decryption.ValidatorPkey, pkey) {
+			fmt.Println("err: duplicate partial decryption of validator")
+			return invalid
+		}
+	}
+	// голоса делегированы до окончания голосования, поэтому учтенные бюллетени уже не изменятся
+	counted, _, err := CountedBallotsAtHeight(t.db, body.ValueType, createVoteBody, createVoteTimestamp, DbHeightLatest, true)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	if len(counted) == 0 {
+		fmt.Println("err: voting has no ballots to decrypt")
+		return invalid
+	}
+	sums, err := aggregateBallots(counted, len(createVoteBody.VotingParams.GetOptions()))
+	if err != nil || !verifyPartialDecryption(body.PartialDecryption, keyShares, sums, t.validators) {
+		fmt.Println("err: invalid partial decryption")
+		return invalid
+	}
+	t.appendVerified(tx, body, hashBytes, pkey)
+	return CodeOk
+}
+
+// hasTransferFields returns true, if tx has any fields except value type and fields of txs without inputs and outputs
+func hasTransferFields(body *golosovaniepb.TxBody) bool {
+	return len(body.Inputs) != 0 || len(body.Outputs) != 0 || len(body.HashLink) != 0 || body.VoteType != 0 ||
+		body.Duration != 0 || len(body.SenderEphemeralPkey) != 0 || len(body.VotersSumPkey) != 0 ||
+		body.Ballot != nil || body.VotingParams != nil || body.VotingMetadata != nil ||
+		len(body.Commitment) != 0 || len(body.Salt) != 0
+}
+
+// checkDelegation checks delegation of votes. Such tx is a transfer of coins of the delegator, which pays the fee,
+// voting hash of the delegation is set for delegation in one voting
+func (t *TxExecutor) checkDelegation(body *golosovaniepb.TxBody, sender []byte) uint32 {
+	delegation := body.Delegation
+	if body.Duration != 0 || len(body.SenderEphemeralPkey) != 0 || len(body.VotersSumPkey) != 0 {
+		fmt.Println("err: delegation tx has unexpected fields")
+		return CodeInvalidDelegation
+	}
+	if len(delegation.DelegatorPkey) != PkeySize ||
+		len(delegation.DelegatePkey) != 0 && len(delegation.DelegatePkey) != PkeySize {
+		fmt.Println("err: invalid pkey len in delegation")
+		return CodeInvalidDataLen
+	}
+	// делегировать можно только свои голоса, подпись проверяется ключом владельца входов
+	if !bytes.Equal(delegation.DelegatorPkey, sender) {
+		fmt.Println("err: delegation is not signed by the delegator")
+		return CodeInvalidDelegation
+	}
+	if bytes.Equal(delegation.DelegatorPkey, delegation.DelegatePkey) {
+		fmt.Println("err: votes cannot be delegated to the delegator")
+		return CodeInvalidDelegation
+	}
+	if len(delegation.VotingHash) != 0 {
+		createVoteBody, createVoteTimestamp, err := t.getTxBody(delegation.VotingHash)
+		if err != nil {
+			fmt.Println("database failed", err)
+			return CodeDatabaseFailed
+		}
+		if createVoteBody == nil || createVoteBody.VoteType == 0 {
+			fmt.Println("err: delegation does not point to a voting")
+			return CodeValueTypeInvalid
+		}
+		// голоса делегируются бюллетенями, а у анонимных голосований голоса участников на одноразовых адресах
+		if !IsBallotVoting(createVoteBody) || IsAnonymousVoting(createVoteBody) {
+			fmt.Println("err: votes cannot be delegated in this voting")
+			return CodeInvalidDelegation
+		}
+		if uint64(t.Timestamp.UnixNano()) >= VotingEndTime(createVoteTimestamp, createVoteBody.Duration) {
+			fmt.Println("err: voting is closed")
+			return CodeVotingClosed
+		}
+	}
+	return CodeOk
+}
+
+// checkInitVoteTx checks init tx of anonymous voting against its create vote tx. validatorPkey is the key of
+// the proposer of the block with create vote tx, only this validator can initialize the voting.
+// Inputs are checked in AppendTx, here it is only checked, that they are all outputs of create vote tx
+func checkInitVoteTx(
+	tx *golosovaniepb.Transaction,
+	body *golosovaniepb.TxBody,
+	createVoteBody *golosovaniepb.TxBody,
+	validatorPkey []byte,
+) uint32 {
+	if len(body.SenderEphemeralPkey) != PkeySize {
+		fmt.Println("err: invalid sender ephemeral pkey len")
+		return CodeInvalidSenderEphemeralPkeyLen
+	}
+	if len(body.VotersSumPkey) != PkeySize {
+		fmt.Println("err: invalid voters sum pkey len")
+		return CodeInvalidVotersSumPkeyLen
+	}
+	for _, output := range body.Outputs {
+		if len(output.ReceiverScanPkey) != 0 {
+			fmt.Println("err: init vote tx must have nil scan pkeys")
+			return CodeInitVoteMustHaveNilScanPkeys
+		}
+	}
+	// проверка, что число избирателей сохранилось во время инициализации
+	// вообще проверка не нужна, так как покрывается проверкой voters_sum_pkey
+	// однако для более читаемых ошибок она присутствует
+	if len(createVoteBody.Outputs) != len(body.Outputs) {
+		fmt.Println("err: init vote tx participant number differs from one in create vote tx")
+		return CodeInvalidVoteParticipantNumber
+	}
+	// входы - все выходы транзакции создания анонимного голосования,
+	// число входов равно числу выходов, а повторы входов проверяются при проверке входов
+	if !IsAnonymousVoting(createVoteBody) || len(body.Inputs) != len(body.Outputs) {
+		fmt.Println("err: init vote tx must spend all outputs of create anonymous voting tx")
+		return CodeInitVoteInvalidInputs
+	}
+	for _, input := range body.Inputs {
+		if !bytes.Equal(input.PrevTxHash, body.ValueType) {
+			fmt.Println("err: init vote tx must spend all outputs of create anonymous voting tx")
+			return CodeInitVoteInvalidInputs
+		}
+	}
+	// число голосов каждого участника сохраняется
+	if !sameOutputValues(createVoteBody.Outputs, body.Outputs) {
+		fmt.Println("err: init vote tx changes values of participants")
+		return CodeInitVoteValuesMismatch
+	}
+	// одноразовые адреса должны быть получены из ключей участников, иначе валидатор
+	// мог бы подменить список избирателей
+	if !VerifyVotersSumPkey(createVoteBody.Outputs, body.Outputs, body.VotersSumPkey) {
+		fmt.Println("err: voters sum pkey doesnt match participants")
+		return CodeInvalidVotersSumPkey
+	}
+	// подпись должна соответствовать публичному ключу валидатора,
+	// в блок предложенный которым попала транзакция создания голосования
+	if len(tx.Sig) != SigSize {
+		return CodeInvalidSignatureLen
+	}
+	if !VerifyData(tx.TxBody, tx.Sig, validatorPkey) {
+		fmt.Println("err: init vote tx is not signed by validator of create vote tx")
+		return CodeInitVoteNotSignedByValidator
+	}
+	return CodeOk
+}
+
+// checkVoteChainTx checks transaction, which adds participants to the voting. Participants of a big voting
+// don't fit into one transaction, so the list is continued by a chain of transactions:
+// create vote tx <- chain tx 1 <- chain tx 2 ..., every chain tx has HashLink of the previous one
+// and ValueType of create vote tx, so its outputs are votes of the voting. Chain txs are funded by coins
+// of the voting creator and can be added only until the first vote transaction of the voting
+func (t *TxExecutor) checkVoteChainTx(body *golosovaniepb.TxBody, sender []byte) uint32 {
+	if len(body.HashLink) != HashSize {
+		fmt.Println("err: invalid hash size")
+		return CodeHashLinkInvalidLen
+	}
+	if body.Duration != 0 || len(body.SenderEphemeralPkey) != 0 || len(body.VotersSumPkey) != 0 {
+		fmt.Println("err: vote chain tx has unexpected fields")
+		return CodeVoteChainInvalid
+	}
+	createVoteBody, createVoteTimestamp, err := t.getTxBody(body.ValueType)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	if createVoteBody == nil || createVoteBody.VoteType == 0 {
+		fmt.Println("err: valueType of vote chain tx is not create vote tx")
+		return CodeValueTypeInvalid
+	}
+	// все выходы анонимного голосования тратятся одной транзакцией инициализации
+	if IsAnonymousVoting(createVoteBody) {
+		fmt.Println("err: anonymous voting cannot be continued")
+		return CodeVoteChainInvalid
+	}
+	if !bytes.Equal(body.HashLink, body.ValueType) {
+		prevBody, _, err := t.getTxBody(body.HashLink)
+		if err != nil {
+			fmt.Println("database failed", err)
+			return CodeDatabaseFailed
+		}
+		if prevBody == nil {
+			fmt.Println("err: no tx, to which hash link points")
+			return CodeHashLinkTargetNotFound
+		}
+		// предыдущая транзакция должна быть дополнением того же голосования
+		if len(prevBody.HashLink) == 0 || len(prevBody.Inputs) == 0 || !bytes.Equal(prevBody.ValueType, body.ValueType) {
+			fmt.Println("err: hash link points to tx from another voting")
+			return CodeVoteChainInvalid
+		}
+	}
+	// цепочка должна быть линейной, иначе нельзя однозначно определить список участников
+	if t.usedHashLinks[SliceToHash(body.HashLink)] {
+		fmt.Println("err: vote chain fork")
+		return CodeVoteChainFork
+	}
+	// закоммиченные транзакции не ссылаются на транзакции текущего блока, их нет смысла искать в бд
+	if _, ok := t.pendingTxs[SliceToHash(body.HashLink)]; !ok {
+		next, err := t.db.GetTxByHashLink(body.HashLink)
+		if err != nil {
+			fmt.Println("database failed", err)
+			return CodeDatabaseFailed
+		}
+		if next != nil {
+			fmt.Println("err: vote chain fork")
+			return CodeVoteChainFork
+		}
+	}
+	creator, err := t.getTxSender(body.ValueType)
+	if err != nil {
+		fmt.Println("database failed", err)
+		return CodeDatabaseFailed
+	}
+	if !bytes.Equal(creator, sender) {
+		fmt.Println("err: vote chain tx sender is not the voting creator")
+		return CodeVoteChainSenderMismatch
+	}
+	started := t.startedVotings[SliceToHash(body.ValueType)]
+	if _, ok := t.pendingTxs[SliceToHash(body.ValueType)]; !ok && !started {
+		started, err = t.db.HasVotes(body.ValueType)
+		if err != nil {
+			fmt.Println("database failed", err)
+			return CodeDatabaseFailed
+		}
+	}
+	if started {
+		fmt.Println("err: voting has already started")
+		return CodeVotingStarted
+	}
+	if uint64(t.Timestamp.UnixNano()) >= VotingEndTime(createVoteTimestamp, createVoteBody.Duration) {
+		fmt.Println("err: voting is closed")
+		return CodeVotingClosed
+	}
+	return CodeOk
+}
+
+// sameOutputValues returns true, if both lists of outputs have the same values, regardless of the order
+func sameOutputValues(a, b []*golosovaniepb.Output) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[uint64]int)
+	for _, out := range a {
+		count[out.Value]++
+	}
+	for _, out := range b {
+		count[out.Value]--
+		if count[out.Value] < 0 {
+			return false
+		}
 	}
+	return true
+}
 
-	return t.verifySigAndAppend(&tx, hashBytes, pkey)
+// getTxBody returns body of pending or committed tx and timestamp of its block, nil if there is no such tx
+func (t *TxExecutor) getTxBody(hash []byte) (*golosovaniepb.TxBody, uint64, error) {
+	if pending, ok := t.pendingTxs[SliceToHash(hash)]; ok {
+		return pending.body, uint64(t.Timestamp.UnixNano()), nil
+	}
+	tx, timestamp, err := t.db.GetTxAndTimeByHash(hash)
+	if err != nil || tx == nil {
+		return nil, 0, err
+	}
+	var body golosovaniepb.TxBody
+	err = proto.Unmarshal(tx.TxBody, &body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &body, timestamp, nil
+}
+
+// getKeyShares returns committed and pending key shares of encrypted voting
+func (t *TxExecutor) getKeyShares(votingHash []byte) ([]*golosovaniepb.KeyShare, error) {
+	keyShares, err := t.db.GetKeyShares(votingHash)
+	if err != nil {
+		return nil, err
+	}
+	return append(keyShares, t.pendingKeyShares[SliceToHash(votingHash)]...), nil
+}
+
+// getPartialDecryptions returns committed and pending partial decryptions of encrypted voting
+func (t *TxExecutor) getPartialDecryptions(votingHash []byte) ([]*golosovaniepb.PartialDecryption, error) {
+	decryptions, err := t.db.GetPartialDecryptions(votingHash)
+	if err != nil {
+		return nil, err
+	}
+	return append(decryptions, t.pendingDecryptions[SliceToHash(votingHash)]...), nil
+}
+
+// getTxSender returns owner of inputs of pending or committed tx
+func (t *TxExecutor) getTxSender(hash []byte) ([]byte, error) {
+	if pending, ok := t.pendingTxs[SliceToHash(hash)]; ok {
+		return pending.sender, nil
+	}
+	return t.db.GetTxSenderPkey(hash)
+}
+
+// findUtxo returns unspent output from DB or created by pending transaction, nil if there is no such output.
+// Outputs spent by pending transactions must be checked separately
+func (t *TxExecutor) findUtxo(op outpoint) (*golosovaniepb.Utxo, error) {
+	if utxo, ok := t.createdOutputs[op]; ok {
+		return utxo, nil
+	}
+	utxos, err := t.db.GetUtxosByTxHash(op.txHash[:])
+	if err != nil {
+		return nil, err
+	}
+	for _, utxo := range utxos {
+		if utxo.Index == op.index {
+			return utxo, nil
+		}
+	}
+	return nil, nil
 }
 
 func (t *TxExecutor) verifySigAndAppend(
-	tx *golosovaniepb.Transaction, hashBytes [HashSize]byte, pkey []byte,
+	tx *golosovaniepb.Transaction, body *golosovaniepb.TxBody, hashBytes [HashSize]byte, pkey []byte,
 ) (code uint32) {
+	code = verifySig(tx, pkey)
+	if code == CodeOk {
+		t.appendVerified(tx, body, hashBytes, pkey)
+	}
+	return code
+}
+
+// verifySig checks, that tx is signed by pkey
+func verifySig(tx *golosovaniepb.Transaction, pkey []byte) uint32 {
 	if len(tx.Sig) != SigSize {
 		return CodeInvalidSignatureLen
 	}
@@ -268,7 +1154,49 @@ func (t *TxExecutor) verifySigAndAppend(
 		fmt.Println("err: signature doesnt match")
 		return CodeInvalidSignature
 	}
+	return CodeOk
+}
+
+// appendVerified adds tx with checked signature to the block
+func (t *TxExecutor) appendVerified(
+	tx *golosovaniepb.Transaction, body *golosovaniepb.TxBody, hashBytes [HashSize]byte, pkey []byte,
+) {
 	t.Transactions = append(t.Transactions, tx)
 	t.processedTrans[hashBytes] = true
-	return CodeOk
+	t.addPending(tx, body, pkey)
+}
+
+// addPending remembers accepted transaction and outputs, spent and created by it
+func (t *TxExecutor) addPending(tx *golosovaniepb.Transaction, body *golosovaniepb.TxBody, sender []byte) {
+	t.pendingTxs[SliceToHash(tx.Hash)] = &pendingTx{body: body, sender: sender}
+	if len(body.HashLink) != 0 {
+		t.usedHashLinks[SliceToHash(body.HashLink)] = true
+	} else if body.KeyShare != nil {
+		h := SliceToHash(body.ValueType)
+		t.pendingKeyShares[h] = append(t.pendingKeyShares[h], body.KeyShare)
+	} else if body.PartialDecryption != nil {
+		h := SliceToHash(body.ValueType)
+		t.pendingDecryptions[h] = append(t.pendingDecryptions[h], body.PartialDecryption)
+	} else if len(body.ValueType) != 0 {
+		t.startedVotings[SliceToHash(body.ValueType)] = true
+	}
+	for _, input := range body.Inputs {
+		t.spentOutputs[outpoint{SliceToHash(input.PrevTxHash), input.OutputIndex}] = true
+	}
+	// valueType выходов транзы создания голосования - её хеш, как в бд
+	valueType := body.ValueType
+	if body.VoteType != 0 {
+		valueType = tx.Hash
+	}
+	for i, output := range body.Outputs {
+		t.createdOutputs[outpoint{SliceToHash(tx.Hash), uint32(i)}] = &golosovaniepb.Utxo{
+			TxHash:            tx.Hash,
+			ValueType:         valueType,
+			Index:             uint32(i),
+			Value:             output.Value,
+			ReceiverSpendPkey: output.ReceiverSpendPkey,
+			ReceiverScanPkey:  output.ReceiverScanPkey,
+			Timestamp:         uint64(t.Timestamp.UnixNano()),
+		}
+	}
 }
@@ -0,0 +1,899 @@
+package evote
+
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseBody(t *testing.T, tx *golosovaniepb.Transaction) *golosovaniepb.TxBody {
+	var body golosovaniepb.TxBody
+	err := proto.Unmarshal(tx.TxBody, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &body
+}
+
+func signTx(body *golosovaniepb.TxBody, keys *CryptoKeysData) *golosovaniepb.Transaction {
+	txBytes, err := proto.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	return &golosovaniepb.Transaction{
+		TxBody: txBytes,
+		Sig:    keys.Sign(txBytes),
+		Hash:   Hash(txBytes),
+	}
+}
+
+func TestCheckInitVoteTx(t *testing.T) {
+	createVoteBody := parseBody(t, TxAnonymousCreateVote)
+	validatorPkey := ValidatorKeys.PkeyByte[:]
+
+	check := func(t *testing.T, initTx *golosovaniepb.Transaction) uint32 {
+		return checkInitVoteTx(initTx, parseBody(t, initTx), createVoteBody, validatorPkey)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeOk), check(t, TxAnonymousInitVote))
+	})
+	t.Run("not_signed_by_validator", func(t *testing.T) {
+		initTx := makeInitVoteTx(TxAnonymousCreateVote, randKeys())
+		assert.Equal(t, uint32(CodeInitVoteNotSignedByValidator), check(t, initTx))
+	})
+	t.Run("signed_by_voter", func(t *testing.T) {
+		initTx := signTx(parseBody(t, TxAnonymousInitVote), AnonymousVoters[0])
+		assert.Equal(t, uint32(CodeInitVoteNotSignedByValidator), check(t, initTx))
+	})
+	t.Run("replaced_voter", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.Outputs[0].ReceiverSpendPkey = randKeys().PkeyByte[:]
+		assert.Equal(t, uint32(CodeInvalidVotersSumPkey), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("replaced_voters_sum_pkey", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.VotersSumPkey = randKeys().PkeyByte[:]
+		assert.Equal(t, uint32(CodeInvalidVotersSumPkey), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("voters_of_another_voting", func(t *testing.T) {
+		otherCreateVoteTx := makeAnonymousCreateVoteTx([]*CryptoKeysData{randKeys(), randKeys(), randKeys()})
+		body := parseBody(t, makeInitVoteTx(otherCreateVoteTx, ValidatorKeys))
+		body.ValueType = TxAnonymousCreateVote.Hash
+		for _, input := range body.Inputs {
+			input.PrevTxHash = TxAnonymousCreateVote.Hash
+		}
+		assert.Equal(t, uint32(CodeInvalidVotersSumPkey), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("changed_values", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.Outputs[0].Value += uint64(len(body.Outputs))
+		assert.Equal(t, uint32(CodeInitVoteValuesMismatch), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("missing_participant", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.Outputs = body.Outputs[1:]
+		assert.Equal(t, uint32(CodeInvalidVoteParticipantNumber), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("input_from_another_tx", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.Inputs[0].PrevTxHash = randHash()
+		assert.Equal(t, uint32(CodeInitVoteInvalidInputs), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("open_voting", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		openCreateVoteBody := parseBody(t, TxAnonymousCreateVote)
+		for _, out := range openCreateVoteBody.Outputs {
+			out.ReceiverScanPkey = nil
+		}
+		code := checkInitVoteTx(signTx(body, ValidatorKeys), body, openCreateVoteBody, validatorPkey)
+		assert.Equal(t, uint32(CodeInitVoteInvalidInputs), code)
+	})
+	t.Run("scan_pkey_in_output", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.Outputs[0].ReceiverScanPkey = AnonymousVoters[0].ScanKeys().PkeyByte[:]
+		assert.Equal(t, uint32(CodeInitVoteMustHaveNilScanPkeys), check(t, signTx(body, ValidatorKeys)))
+	})
+	t.Run("invalid_voters_sum_pkey_len", func(t *testing.T) {
+		body := parseBody(t, TxAnonymousInitVote)
+		body.VotersSumPkey = body.VotersSumPkey[1:]
+		assert.Equal(t, uint32(CodeInvalidVotersSumPkeyLen), check(t, signTx(body, ValidatorKeys)))
+	})
+}
+
+func TestCheckBallot(t *testing.T) {
+	ranked := &golosovaniepb.TxBody{VoteType: RankedVoteType}
+	candidates := [][]byte{randPkey(), randPkey()}
+	voteBody := func(ranking ...[]byte) *golosovaniepb.TxBody {
+		return &golosovaniepb.TxBody{
+			Outputs:   []*golosovaniepb.Output{{ReceiverSpendPkey: BallotBoxPkey[:], Value: 1}},
+			ValueType: randHash(),
+			Ballot:    &golosovaniepb.Ballot{Ranking: ranking},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeOk), checkBallot(voteBody(candidates...), ranked, nil))
+	})
+	t.Run("no_ballot", func(t *testing.T) {
+		body := voteBody(candidates...)
+		body.Ballot = nil
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, nil))
+	})
+	t.Run("empty_ranking", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(), ranked, nil))
+	})
+	t.Run("duplicate_candidate", func(t *testing.T) {
+		body := voteBody(candidates[0], candidates[1], candidates[0])
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, nil))
+	})
+	t.Run("invalid_candidate_len", func(t *testing.T) {
+		body := voteBody(candidates[0], randHash())
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, nil))
+	})
+	t.Run("too_many_candidates", func(t *testing.T) {
+		var ranking [][]byte
+		for i := 0; i <= MaxBallotCandidates; i++ {
+			ranking = append(ranking, randPkey())
+		}
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(ranking...), ranked, nil))
+	})
+	t.Run("output_not_to_ballot_box", func(t *testing.T) {
+		body := voteBody(candidates...)
+		body.Outputs[0].ReceiverSpendPkey = candidates[0]
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, nil))
+	})
+	t.Run("two_outputs", func(t *testing.T) {
+		body := voteBody(candidates...)
+		body.Outputs = append(body.Outputs, body.Outputs[0])
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, nil))
+	})
+	t.Run("ballot_in_majority_voting", func(t *testing.T) {
+		majority := &golosovaniepb.TxBody{VoteType: OneVoteType}
+		assert.Equal(t, uint32(CodeUnexpectedBallot), checkBallot(voteBody(candidates...), majority, nil))
+	})
+	t.Run("approval", func(t *testing.T) {
+		approval := &golosovaniepb.TxBody{VoteType: ApprovalVoteType}
+		body := voteBody()
+		body.Ballot.Approved = candidates
+		assert.Equal(t, uint32(CodeOk), checkBallot(body, approval, nil))
+	})
+	t.Run("ranking_in_approval_voting", func(t *testing.T) {
+		approval := &golosovaniepb.TxBody{VoteType: ApprovalVoteType}
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(candidates...), approval, nil))
+	})
+	t.Run("approved_in_stv_voting", func(t *testing.T) {
+		stv := &golosovaniepb.TxBody{VoteType: StvVoteType}
+		body := voteBody(candidates...)
+		body.Ballot.Approved = candidates[:1]
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, stv, nil))
+	})
+	t.Run("majority_vote", func(t *testing.T) {
+		majority := &golosovaniepb.TxBody{VoteType: OneVoteType}
+		body := voteBody(candidates...)
+		body.Ballot = nil
+		assert.Equal(t, uint32(CodeOk), checkBallot(body, majority, nil))
+	})
+	t.Run("revotable", func(t *testing.T) {
+		revotable := &golosovaniepb.VotingParams{Revotable: true}
+		sender := randPkey()
+		revoteBody := func(ranking ...[]byte) *golosovaniepb.TxBody {
+			body := voteBody(ranking...)
+			body.Outputs[0].ReceiverSpendPkey = sender
+			return body
+		}
+		ranked := &golosovaniepb.TxBody{VoteType: RankedVoteType, VotingParams: revotable}
+		majority := &golosovaniepb.TxBody{VoteType: OneVoteType, VotingParams: revotable}
+		assert.Equal(t, uint32(CodeOk), checkBallot(revoteBody(candidates...), ranked, sender))
+		// голос за одного кандидата тоже отправляется с бюллетенем
+		assert.Equal(t, uint32(CodeOk), checkBallot(revoteBody(candidates[0]), majority, sender))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(revoteBody(candidates...), majority, sender))
+		// отзыв голоса
+		body := revoteBody()
+		body.Ballot = nil
+		assert.Equal(t, uint32(CodeOk), checkBallot(body, majority, sender))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(candidates...), ranked, sender))
+		body = revoteBody(candidates...)
+		body.Outputs = append(body.Outputs, &golosovaniepb.Output{ReceiverSpendPkey: candidates[0], Value: 1})
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, sender))
+	})
+}
+
+func TestCheckVotingParams(t *testing.T) {
+	check := func(voteType uint32, params *golosovaniepb.VotingParams) uint32 {
+		return checkVotingParams(&golosovaniepb.TxBody{VoteType: voteType, VotingParams: params})
+	}
+	assert.Equal(t, uint32(CodeOk), check(StvVoteType, &golosovaniepb.VotingParams{Seats: 3}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(StvVoteType, nil))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(StvVoteType, &golosovaniepb.VotingParams{}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(StvVoteType, &golosovaniepb.VotingParams{Seats: MaxBallotCandidates + 1}))
+	assert.Equal(t, uint32(CodeOk), check(RankedVoteType, nil))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(ApprovalVoteType, &golosovaniepb.VotingParams{Seats: 2}))
+
+	options := [][]byte{randPkey(), randPkey()}
+	questions := func(qs ...*golosovaniepb.Question) *golosovaniepb.VotingParams {
+		return &golosovaniepb.VotingParams{Questions: qs}
+	}
+	valid := &golosovaniepb.Question{VoteType: OneVoteType, Options: options}
+	stv := &golosovaniepb.Question{VoteType: StvVoteType, Options: options, Seats: 1}
+	assert.Equal(t, uint32(CodeOk), check(MultiQuestionVoteType, questions(valid, stv)))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, nil))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(RankedVoteType, questions(valid)))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, questions(
+		&golosovaniepb.Question{VoteType: MultiQuestionVoteType, Options: options},
+	)))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, questions(
+		&golosovaniepb.Question{VoteType: StvVoteType, Options: options},
+	)))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, questions(
+		&golosovaniepb.Question{VoteType: OneVoteType},
+	)))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, questions(
+		&golosovaniepb.Question{VoteType: OneVoteType, Options: [][]byte{options[0], options[0]}},
+	)))
+	var tooMany []*golosovaniepb.Question
+	for i := 0; i <= MaxQuestions; i++ {
+		tooMany = append(tooMany, valid)
+	}
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, questions(tooMany...)))
+
+	twoThirds := &golosovaniepb.Fraction{Numerator: 2, Denominator: 3}
+	assert.Equal(t, uint32(CodeOk), check(OneVoteType, &golosovaniepb.VotingParams{Quorum: twoThirds, Threshold: twoThirds}))
+	assert.Equal(t, uint32(CodeOk), check(StvVoteType, &golosovaniepb.VotingParams{Seats: 2, Quorum: twoThirds}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(StvVoteType, &golosovaniepb.VotingParams{Seats: 2, Threshold: twoThirds}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(OneVoteType, &golosovaniepb.VotingParams{
+		Quorum: &golosovaniepb.Fraction{Numerator: 1},
+	}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(OneVoteType, &golosovaniepb.VotingParams{
+		Threshold: &golosovaniepb.Fraction{Numerator: 3, Denominator: 2},
+	}))
+	assert.Equal(t, uint32(CodeOk), check(OneVoteType, &golosovaniepb.VotingParams{RevealDuration: MaxRevealDuration}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(OneVoteType, &golosovaniepb.VotingParams{RevealDuration: MaxRevealDuration + 1}))
+
+	assert.Equal(t, uint32(CodeOk), check(EncryptedVoteType, &golosovaniepb.VotingParams{Options: options}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(EncryptedVoteType, nil))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(EncryptedVoteType, &golosovaniepb.VotingParams{
+		Options: [][]byte{options[0], options[0]},
+	}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(EncryptedVoteType, &golosovaniepb.VotingParams{
+		Options: options, RevealDuration: 10,
+	}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(OneVoteType, &golosovaniepb.VotingParams{Options: options}))
+	assert.Equal(t, uint32(CodeInvalidVotingParams), check(MultiQuestionVoteType, questions(
+		&golosovaniepb.Question{VoteType: EncryptedVoteType, Options: options},
+	)))
+}
+
+func TestCheckEncryptedBallot(t *testing.T) {
+	options := [][]byte{randPkey(), randPkey(), randPkey()}
+	voting := &golosovaniepb.TxBody{
+		VoteType:     EncryptedVoteType,
+		VotingParams: &golosovaniepb.VotingParams{Options: options},
+	}
+	electionKey := randKeys().PkeyByte[:]
+	sender := randPkey()
+	voteBody := func(ballot *golosovaniepb.Ballot) *golosovaniepb.TxBody {
+		return &golosovaniepb.TxBody{
+			Outputs:   []*golosovaniepb.Output{{ReceiverSpendPkey: BallotBoxPkey[:], Value: 1}},
+			ValueType: randHash(),
+			Ballot:    ballot,
+		}
+	}
+	encrypted := func(choice int) *golosovaniepb.Ballot {
+		ballot, err := EncryptBallot(electionKey, len(options), choice, sender)
+		assert.Nil(t, err)
+		return ballot
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeOk), checkBallot(voteBody(encrypted(1)), voting, sender))
+	})
+	t.Run("missing_ciphertext", func(t *testing.T) {
+		ballot := encrypted(0)
+		ballot.Encrypted = ballot.Encrypted[1:]
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(ballot), voting, sender))
+	})
+	t.Run("another_sender", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(encrypted(1)), voting, randPkey()))
+	})
+	t.Run("two_options", func(t *testing.T) {
+		ballot := encrypted(0)
+		other := encrypted(1)
+		ballot.Encrypted[1], ballot.BitProofs[1] = other.Encrypted[1], other.BitProofs[1]
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(ballot), voting, sender))
+	})
+	t.Run("invalid_point", func(t *testing.T) {
+		ballot := encrypted(0)
+		ballot.Encrypted[2].B = randHash()
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(ballot), voting, sender))
+	})
+	t.Run("plain_candidate", func(t *testing.T) {
+		ballot := encrypted(0)
+		ballot.Ranking = [][]byte{options[0]}
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(ballot), voting, sender))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(&golosovaniepb.Ballot{Ranking: [][]byte{options[0]}}), voting, sender))
+	})
+	t.Run("encrypted_in_ranked_voting", func(t *testing.T) {
+		ballot := encrypted(0)
+		ballot.Ranking = [][]byte{options[0]}
+		ranked := &golosovaniepb.TxBody{VoteType: RankedVoteType}
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(voteBody(ballot), ranked, nil))
+	})
+}
+
+func TestCheckBallotAnswers(t *testing.T) {
+	yes, no := randPkey(), randPkey()
+	candidates := [][]byte{randPkey(), randPkey(), randPkey()}
+	multi := &golosovaniepb.TxBody{
+		VoteType: MultiQuestionVoteType,
+		VotingParams: &golosovaniepb.VotingParams{Questions: []*golosovaniepb.Question{
+			{VoteType: OneVoteType, Options: [][]byte{yes, no}},
+			{VoteType: RankedVoteType, Options: candidates},
+		}},
+	}
+	voteBody := func(answers ...*golosovaniepb.Ballot) *golosovaniepb.TxBody {
+		return &golosovaniepb.TxBody{
+			Outputs:   []*golosovaniepb.Output{{ReceiverSpendPkey: BallotBoxPkey[:], Value: 1}},
+			ValueType: randHash(),
+			Ballot:    &golosovaniepb.Ballot{Answers: answers},
+		}
+	}
+	ranking := func(pkeys ...[]byte) *golosovaniepb.Ballot {
+		return &golosovaniepb.Ballot{Ranking: pkeys}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		body := voteBody(ranking(yes), ranking(candidates[2], candidates[0]))
+		assert.Equal(t, uint32(CodeOk), checkBallot(body, multi, nil))
+	})
+	t.Run("missing_answer", func(t *testing.T) {
+		body := voteBody(ranking(yes))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, multi, nil))
+	})
+	t.Run("two_options_chosen", func(t *testing.T) {
+		body := voteBody(ranking(yes, no), ranking(candidates[0]))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, multi, nil))
+	})
+	t.Run("unknown_option", func(t *testing.T) {
+		body := voteBody(ranking(yes), ranking(candidates[0], randPkey()))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, multi, nil))
+	})
+	t.Run("candidates_outside_answers", func(t *testing.T) {
+		body := voteBody(ranking(yes), ranking(candidates[0]))
+		body.Ballot.Ranking = [][]byte{candidates[0]}
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, multi, nil))
+	})
+	t.Run("nested_answers", func(t *testing.T) {
+		nested := ranking(yes)
+		nested.Answers = []*golosovaniepb.Ballot{ranking(yes)}
+		body := voteBody(nested, ranking(candidates[0]))
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, multi, nil))
+	})
+	t.Run("answers_in_single_question_voting", func(t *testing.T) {
+		ranked := &golosovaniepb.TxBody{VoteType: RankedVoteType}
+		body := voteBody(ranking(candidates[0]))
+		body.Ballot.Ranking = [][]byte{candidates[0]}
+		assert.Equal(t, uint32(CodeBallotInvalid), checkBallot(body, ranked, nil))
+	})
+}
+
+func TestCheckVotingMetadata(t *testing.T) {
+	valid := func() *golosovaniepb.VotingMetadata {
+		return &golosovaniepb.VotingMetadata{
+			Title:       "Выборы в студсовет",
+			Description: "Очередные выборы",
+			Candidates: []*golosovaniepb.CandidateLabel{
+				{Pkey: randPkey(), Label: "Иванов"},
+				{Pkey: randPkey(), Label: "Петров"},
+			},
+			DocumentHash: randHash(),
+		}
+	}
+	long := func(size int) string {
+		return strings.Repeat("a", size)
+	}
+
+	assert.Equal(t, uint32(CodeOk), checkVotingMetadata(nil))
+	assert.Equal(t, uint32(CodeOk), checkVotingMetadata(valid()))
+	assert.Equal(t, uint32(CodeOk), checkVotingMetadata(&golosovaniepb.VotingMetadata{Title: long(MaxVotingTitleSize)}))
+
+	invalid := map[string]func(m *golosovaniepb.VotingMetadata){
+		"empty_title":          func(m *golosovaniepb.VotingMetadata) { m.Title = "" },
+		"long_title":           func(m *golosovaniepb.VotingMetadata) { m.Title = long(MaxVotingTitleSize + 1) },
+		"null_in_title":        func(m *golosovaniepb.VotingMetadata) { m.Title = "a\x00b" },
+		"long_description":     func(m *golosovaniepb.VotingMetadata) { m.Description = long(MaxVotingDescriptionSize + 1) },
+		"invalid_utf8":         func(m *golosovaniepb.VotingMetadata) { m.Description = "\xff" },
+		"short_document_hash":  func(m *golosovaniepb.VotingMetadata) { m.DocumentHash = m.DocumentHash[1:] },
+		"empty_label":          func(m *golosovaniepb.VotingMetadata) { m.Candidates[0].Label = "" },
+		"long_label":           func(m *golosovaniepb.VotingMetadata) { m.Candidates[0].Label = long(MaxCandidateLabelSize + 1) },
+		"invalid_label_pkey":   func(m *golosovaniepb.VotingMetadata) { m.Candidates[0].Pkey = randHash() },
+		"duplicate_label_pkey": func(m *golosovaniepb.VotingMetadata) { m.Candidates[1].Pkey = m.Candidates[0].Pkey },
+		"too_many_labels": func(m *golosovaniepb.VotingMetadata) {
+			for len(m.Candidates) <= MaxCandidateLabels {
+				m.Candidates = append(m.Candidates, &golosovaniepb.CandidateLabel{Pkey: randPkey(), Label: "a"})
+			}
+		},
+	}
+	for name, modify := range invalid {
+		t.Run(name, func(t *testing.T) {
+			m := valid()
+			modify(m)
+			assert.Equal(t, uint32(CodeInvalidVotingMetadata), checkVotingMetadata(m))
+		})
+	}
+}
+
+func TestCheckCommitReveal(t *testing.T) {
+	candidates := [][]byte{randPkey(), randPkey()}
+	sender := randPkey()
+	voting := &golosovaniepb.TxBody{
+		VoteType:     RankedVoteType,
+		VotingParams: &golosovaniepb.VotingParams{RevealDuration: 60},
+	}
+	ballot := &golosovaniepb.Ballot{Ranking: candidates}
+	salt := randHash()
+	commitment, err := CommitmentHash(ballot, salt)
+	assert.Nil(t, err)
+	valueType := randHash()
+	commitVoteBody := func(commitment []byte) *golosovaniepb.TxBody {
+		return &golosovaniepb.TxBody{
+			Outputs:    []*golosovaniepb.Output{{ReceiverSpendPkey: sender, Value: 1}},
+			ValueType:  valueType,
+			Commitment: commitment,
+		}
+	}
+	commitBody := commitVoteBody(commitment)
+
+	t.Run("commit", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeOk), checkBallot(commitBody, voting, sender))
+		// отзыв голоса
+		assert.Equal(t, uint32(CodeOk), checkBallot(commitVoteBody(nil), voting, sender))
+		open := commitVoteBody(commitment)
+		open.Ballot = ballot
+		assert.Equal(t, uint32(CodeUnexpectedBallot), checkBallot(open, voting, sender))
+		assert.Equal(t, uint32(CodeInvalidCommitment), checkBallot(commitVoteBody(commitment[1:]), voting, sender))
+		assert.Equal(t, uint32(CodeInvalidCommitment), checkBallot(commitBody, voting, randPkey()))
+		// обязательство в голосовании без раскрытия
+		assert.Equal(t, uint32(CodeUnexpectedCommitment), checkBallot(commitBody, &golosovaniepb.TxBody{VoteType: OneVoteType}, sender))
+	})
+
+	commitHash := randHash()
+	executor := &TxExecutor{pendingTxs: map[[HashSize]byte]*pendingTx{
+		SliceToHash(commitHash): {body: commitBody, sender: sender},
+	}}
+	revealBody := func(ballot *golosovaniepb.Ballot, salt []byte) *golosovaniepb.TxBody {
+		return &golosovaniepb.TxBody{
+			Inputs:    []*golosovaniepb.Input{{PrevTxHash: commitHash}},
+			Outputs:   []*golosovaniepb.Output{{ReceiverSpendPkey: BallotBoxPkey[:], Value: 1}},
+			ValueType: valueType,
+			Ballot:    ballot,
+			Salt:      salt,
+		}
+	}
+
+	t.Run("reveal", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeOk), executor.checkReveal(revealBody(ballot, salt), voting))
+		other := &golosovaniepb.Ballot{Ranking: [][]byte{candidates[1], candidates[0]}}
+		assert.Equal(t, uint32(CodeInvalidReveal), executor.checkReveal(revealBody(other, salt), voting))
+		assert.Equal(t, uint32(CodeInvalidReveal), executor.checkReveal(revealBody(ballot, randHash()), voting))
+		assert.Equal(t, uint32(CodeInvalidReveal), executor.checkReveal(revealBody(nil, salt), voting))
+		notToBox := revealBody(ballot, salt)
+		notToBox.Outputs[0].ReceiverSpendPkey = sender
+		assert.Equal(t, uint32(CodeBallotInvalid), executor.checkReveal(notToBox, voting))
+	})
+	t.Run("reveal_invalid_ballot", func(t *testing.T) {
+		// обязательство на бюллетень с повтором кандидата можно отправить, но не раскрыть
+		invalid := &golosovaniepb.Ballot{Ranking: [][]byte{candidates[0], candidates[0]}}
+		invalidCommitment, err := CommitmentHash(invalid, salt)
+		assert.Nil(t, err)
+		executor.pendingTxs[SliceToHash(commitHash)].body = commitVoteBody(invalidCommitment)
+		assert.Equal(t, uint32(CodeBallotInvalid), executor.checkReveal(revealBody(invalid, salt), voting))
+	})
+}
+
+func TestCheckCoinbase(t *testing.T) {
+	proposer := randKeys().PkeyByte
+
+	t.Run("reward_with_fees", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.BlockProposer = proposer
+		executor.Transactions = []*golosovaniepb.Transaction{TxAnonymousCreateVote}
+		executor.Fees = 5
+		assert.Nil(t, executor.AppendReward(7))
+		assert.Equal(t, 2, len(executor.Transactions))
+		coinbase := executor.Transactions[0]
+		body := parseBody(t, coinbase)
+		assert.Equal(t, uint64(7), body.Coinbase.Height)
+		assert.Equal(t, 0, len(body.Inputs))
+		assert.Equal(t, 0, len(coinbase.Sig))
+		assert.Equal(t, proposer[:], body.Outputs[0].ReceiverSpendPkey)
+		assert.Equal(t, uint64(RewardCoins+5), body.Outputs[0].Value)
+		// выход награды можно потратить в том же блоке
+		assert.NotNil(t, executor.createdOutputs[outpoint{SliceToHash(coinbase.Hash), 0}])
+	})
+	t.Run("no_reward", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.SetRewardSchedule(&RewardSchedule{Initial: 100, MaxSupply: 100})
+		assert.Nil(t, executor.AppendReward(1))
+		assert.Equal(t, 0, len(executor.Transactions))
+	})
+	t.Run("reward_overflow", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.Fees = MaxAmount
+		assert.NotNil(t, executor.AppendReward(1))
+		assert.Equal(t, 0, len(executor.Transactions))
+	})
+	t.Run("sent_by_client", func(t *testing.T) {
+		tx, err := CreateCoinbaseTx(proposer[:], 3, RewardCoins)
+		assert.Nil(t, err)
+		data, err := proto.Marshal(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeUnexpectedCoinbase), NewTxExecutor(nil).AppendTx(data, false))
+	})
+}
+
+func TestAppendTxSpending(t *testing.T) {
+	sender := randKeys()
+	receiver := randKeys()
+	// executor с наградой отправителю в начале блока, выход награды есть только среди созданных в блоке
+	withCoinbase := func(t *testing.T) (*TxExecutor, []byte) {
+		executor := NewTxExecutor(nil)
+		executor.BlockProposer = sender.PkeyByte
+		assert.Nil(t, executor.AppendReward(1))
+		return executor, executor.Transactions[0].Hash
+	}
+	transfer := func(inputs []*golosovaniepb.Input, value uint64) []byte {
+		data, err := proto.Marshal(signTx(&golosovaniepb.TxBody{
+			Inputs:  inputs,
+			Outputs: []*golosovaniepb.Output{newOutput(receiver.PkeyByte[:], nil, value)},
+		}, sender))
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}
+
+	t.Run("spend_output_created_in_block", func(t *testing.T) {
+		executor, coinbaseHash := withCoinbase(t)
+		data := transfer([]*golosovaniepb.Input{{PrevTxHash: coinbaseHash, OutputIndex: 0}}, RewardCoins)
+		assert.Equal(t, uint32(CodeOk), executor.AppendTx(data, false))
+		assert.Equal(t, 2, len(executor.Transactions))
+		assert.True(t, executor.spentOutputs[outpoint{SliceToHash(coinbaseHash), 0}])
+		// выход перевода тоже можно потратить в этом блоке
+		var tx golosovaniepb.Transaction
+		assert.Nil(t, proto.Unmarshal(data, &tx))
+		assert.NotNil(t, executor.createdOutputs[outpoint{SliceToHash(tx.Hash), 0}])
+	})
+	t.Run("same_outpoint_twice_in_block", func(t *testing.T) {
+		executor, coinbaseHash := withCoinbase(t)
+		input := []*golosovaniepb.Input{{PrevTxHash: coinbaseHash, OutputIndex: 0}}
+		assert.Equal(t, uint32(CodeOk), executor.AppendTx(transfer(input, RewardCoins), false))
+		// другая транзакция с тем же входом
+		assert.Equal(t, uint32(CodeDoubleSpending), executor.AppendTx(transfer(input, RewardCoins-1), false))
+		assert.Equal(t, 2, len(executor.Transactions))
+	})
+	t.Run("same_outpoint_twice_in_tx", func(t *testing.T) {
+		executor, coinbaseHash := withCoinbase(t)
+		data := transfer([]*golosovaniepb.Input{
+			{PrevTxHash: coinbaseHash, OutputIndex: 0},
+			{PrevTxHash: coinbaseHash, OutputIndex: 0},
+		}, 2*RewardCoins)
+		assert.Equal(t, uint32(CodeDoubleSpending), executor.AppendTx(data, false))
+		assert.Equal(t, 1, len(executor.Transactions))
+	})
+	t.Run("output_of_rejected_tx", func(t *testing.T) {
+		executor, coinbaseHash := withCoinbase(t)
+		// перевод больше входа отклоняется, его выход не создается
+		rejected := transfer([]*golosovaniepb.Input{{PrevTxHash: coinbaseHash, OutputIndex: 0}}, RewardCoins+1)
+		assert.Equal(t, uint32(CodeInputsNotMatchOutputs), executor.AppendTx(rejected, false))
+		var tx golosovaniepb.Transaction
+		assert.Nil(t, proto.Unmarshal(rejected, &tx))
+		assert.Nil(t, executor.createdOutputs[outpoint{SliceToHash(tx.Hash), 0}])
+	})
+}
+
+func TestAppendTxDelegation(t *testing.T) {
+	sender := randKeys()
+	victim := randKeys()
+	createVote := func(t *testing.T, delegation *golosovaniepb.Delegation) uint32 {
+		executor := NewTxExecutor(nil)
+		executor.BlockProposer = sender.PkeyByte
+		assert.Nil(t, executor.AppendReward(1))
+		data, err := proto.Marshal(signTx(&golosovaniepb.TxBody{
+			Inputs:     []*golosovaniepb.Input{{PrevTxHash: executor.Transactions[0].Hash, OutputIndex: 0}},
+			Outputs:    []*golosovaniepb.Output{newOutput(randPkey(), nil, RewardCoins-MinTxFee)},
+			VoteType:   OneVoteType,
+			Duration:   60,
+			Delegation: delegation,
+		}, sender))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executor.AppendTx(data, false)
+	}
+
+	t.Run("create_vote", func(t *testing.T) {
+		assert.Equal(t, uint32(CodeOk), createVote(t, nil))
+	})
+	t.Run("forged_delegation_in_create_vote", func(t *testing.T) {
+		// голоса victim не должны перейти к отправителю транзакции создания голосования
+		forged := &golosovaniepb.Delegation{DelegatorPkey: victim.PkeyByte[:], DelegatePkey: sender.PkeyByte[:]}
+		assert.Equal(t, uint32(CodeInvalidDelegation), createVote(t, forged))
+	})
+}
+
+func TestCheckVotingOpen(t *testing.T) {
+	start := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	voting := &golosovaniepb.TxBody{VoteType: 1, Duration: 60}
+	commitReveal := &golosovaniepb.TxBody{
+		VoteType:     1,
+		Duration:     60,
+		VotingParams: &golosovaniepb.VotingParams{RevealDuration: 30},
+	}
+	end := start.Add(60 * time.Second)
+	check := func(body *golosovaniepb.TxBody, now time.Time, isVoteTx bool) (bool, uint32) {
+		executor := NewTxExecutor(nil)
+		executor.Timestamp = now
+		return executor.checkVotingOpen(body, uint64(start.UnixNano()), isVoteTx)
+	}
+
+	t.Run("vote_just_before_end", func(t *testing.T) {
+		isReveal, code := check(voting, end.Add(-time.Nanosecond), true)
+		assert.Equal(t, uint32(CodeOk), code)
+		assert.False(t, isReveal)
+	})
+	t.Run("vote_at_end", func(t *testing.T) {
+		_, code := check(voting, end, true)
+		assert.Equal(t, uint32(CodeVotingClosed), code)
+	})
+	t.Run("vote_after_end", func(t *testing.T) {
+		_, code := check(voting, end.Add(time.Second), true)
+		assert.Equal(t, uint32(CodeVotingClosed), code)
+	})
+	t.Run("reveal_phase", func(t *testing.T) {
+		isReveal, code := check(commitReveal, end, true)
+		assert.Equal(t, uint32(CodeOk), code)
+		assert.True(t, isReveal)
+		// в фазе раскрытия голоса нельзя переводить
+		_, code = check(commitReveal, end, false)
+		assert.Equal(t, uint32(CodeVotingClosed), code)
+	})
+	t.Run("reveal_just_before_end", func(t *testing.T) {
+		isReveal, code := check(commitReveal, end.Add(30*time.Second-time.Nanosecond), true)
+		assert.Equal(t, uint32(CodeOk), code)
+		assert.True(t, isReveal)
+	})
+	t.Run("reveal_after_end", func(t *testing.T) {
+		_, code := check(commitReveal, end.Add(30*time.Second), true)
+		assert.Equal(t, uint32(CodeVotingClosed), code)
+	})
+}
+
+func TestCheckVoteChainTx(t *testing.T) {
+	creator := randKeys()
+	// голосование и транзакции дополнения из текущего блока, бд не нужна
+	addTx := func(executor *TxExecutor, body *golosovaniepb.TxBody, keys *CryptoKeysData) []byte {
+		tx := signTx(body, keys)
+		executor.addPending(tx, body, keys.PkeyByte[:])
+		return tx.Hash
+	}
+	newVoting := func(executor *TxExecutor) []byte {
+		return addTx(executor, &golosovaniepb.TxBody{
+			Inputs:   []*golosovaniepb.Input{{PrevTxHash: randHash()}},
+			Outputs:  []*golosovaniepb.Output{newOutput(randPkey(), nil, 10)},
+			VoteType: 1,
+			Duration: 60,
+		}, creator)
+	}
+	link := func(voting, hashLink []byte) *golosovaniepb.TxBody {
+		return &golosovaniepb.TxBody{
+			Inputs:    []*golosovaniepb.Input{{PrevTxHash: randHash()}},
+			Outputs:   []*golosovaniepb.Output{newOutput(randPkey(), nil, 5)},
+			ValueType: voting,
+			HashLink:  hashLink,
+		}
+	}
+	setup := func() (*TxExecutor, []byte) {
+		executor := NewTxExecutor(nil)
+		executor.Timestamp = time.Now()
+		return executor, newVoting(executor)
+	}
+	creatorPkey := creator.PkeyByte[:]
+
+	t.Run("link_to_voting", func(t *testing.T) {
+		executor, voting := setup()
+		assert.Equal(t, uint32(CodeOk), executor.checkVoteChainTx(link(voting, voting), creatorPkey))
+	})
+	t.Run("link_to_previous_link", func(t *testing.T) {
+		executor, voting := setup()
+		first := addTx(executor, link(voting, voting), creator)
+		second := addTx(executor, link(voting, first), creator)
+		assert.Equal(t, uint32(CodeOk), executor.checkVoteChainTx(link(voting, second), creatorPkey))
+	})
+	t.Run("fork", func(t *testing.T) {
+		executor, voting := setup()
+		first := addTx(executor, link(voting, voting), creator)
+		addTx(executor, link(voting, first), creator)
+		// на голосование и на первую транзакцию уже ссылаются
+		assert.Equal(t, uint32(CodeVoteChainFork), executor.checkVoteChainTx(link(voting, voting), creatorPkey))
+		assert.Equal(t, uint32(CodeVoteChainFork), executor.checkVoteChainTx(link(voting, first), creatorPkey))
+	})
+	t.Run("link_to_another_voting", func(t *testing.T) {
+		executor, voting := setup()
+		other := newVoting(executor)
+		otherLink := addTx(executor, link(other, other), creator)
+		assert.Equal(t, uint32(CodeVoteChainInvalid), executor.checkVoteChainTx(link(voting, otherLink), creatorPkey))
+	})
+	t.Run("link_to_voting_tx", func(t *testing.T) {
+		executor, voting := setup()
+		// ссылка на транзакцию отправки голоса, а не на дополнение
+		vote := addTx(executor, &golosovaniepb.TxBody{
+			Inputs:    []*golosovaniepb.Input{{PrevTxHash: randHash()}},
+			Outputs:   []*golosovaniepb.Output{newOutput(randPkey(), nil, 1)},
+			ValueType: voting,
+		}, creator)
+		executor.startedVotings = make(map[[HashSize]byte]bool)
+		assert.Equal(t, uint32(CodeVoteChainInvalid), executor.checkVoteChainTx(link(voting, vote), creatorPkey))
+	})
+	t.Run("value_type_not_voting", func(t *testing.T) {
+		executor, _ := setup()
+		transfer := addTx(executor, &golosovaniepb.TxBody{
+			Inputs:  []*golosovaniepb.Input{{PrevTxHash: randHash()}},
+			Outputs: []*golosovaniepb.Output{newOutput(randPkey(), nil, 1)},
+		}, creator)
+		assert.Equal(t, uint32(CodeValueTypeInvalid), executor.checkVoteChainTx(link(transfer, transfer), creatorPkey))
+	})
+	t.Run("not_creator", func(t *testing.T) {
+		executor, voting := setup()
+		code := executor.checkVoteChainTx(link(voting, voting), randKeys().PkeyByte[:])
+		assert.Equal(t, uint32(CodeVoteChainSenderMismatch), code)
+	})
+	t.Run("invalid_hash_link_len", func(t *testing.T) {
+		executor, voting := setup()
+		code := executor.checkVoteChainTx(link(voting, voting[:HashSize-1]), creatorPkey)
+		assert.Equal(t, uint32(CodeHashLinkInvalidLen), code)
+	})
+	t.Run("unexpected_duration", func(t *testing.T) {
+		executor, voting := setup()
+		body := link(voting, voting)
+		body.Duration = 10
+		assert.Equal(t, uint32(CodeVoteChainInvalid), executor.checkVoteChainTx(body, creatorPkey))
+	})
+	t.Run("anonymous_voting", func(t *testing.T) {
+		executor, _ := setup()
+		createVoteBody := parseBody(t, TxAnonymousCreateVote)
+		executor.addPending(TxAnonymousCreateVote, createVoteBody, creatorPkey)
+		voting := TxAnonymousCreateVote.Hash
+		assert.Equal(t, uint32(CodeVoteChainInvalid), executor.checkVoteChainTx(link(voting, voting), creatorPkey))
+	})
+	t.Run("voting_started", func(t *testing.T) {
+		executor, voting := setup()
+		executor.startedVotings[SliceToHash(voting)] = true
+		assert.Equal(t, uint32(CodeVotingStarted), executor.checkVoteChainTx(link(voting, voting), creatorPkey))
+	})
+}
+
+func TestCheckFreeTxs(t *testing.T) {
+	delegator := randKeys()
+	delegate := randKeys().PkeyByte
+
+	t.Run("delegation_pays_fee", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.BlockProposer = delegator.PkeyByte
+		assert.Nil(t, executor.AppendReward(1))
+		utxos := []*golosovaniepb.Utxo{executor.createdOutputs[outpoint{SliceToHash(executor.Transactions[0].Hash), 0}]}
+		executor.MinFee = 10
+
+		tx, err := CreateDelegationTx(delegate[:], nil, utxos, 9, delegator)
+		assert.Nil(t, err)
+		data, err := proto.Marshal(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeInsufficientFee), executor.AppendTx(data, false))
+
+		tx, err = CreateDelegationTx(delegate[:], nil, utxos, 10, delegator)
+		assert.Nil(t, err)
+		data, err = proto.Marshal(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeOk), executor.AppendTx(data, false))
+		assert.Equal(t, uint64(10), executor.Fees)
+		// сдача - монеты, а не голоса
+		change := executor.createdOutputs[outpoint{SliceToHash(tx.Hash), 0}]
+		assert.Equal(t, 0, len(change.ValueType))
+		assert.Equal(t, uint64(RewardCoins-10), change.Value)
+	})
+	t.Run("delegation_of_other_pkey", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.BlockProposer = delegator.PkeyByte
+		assert.Nil(t, executor.AppendReward(1))
+		body := &golosovaniepb.TxBody{
+			Inputs:  []*golosovaniepb.Input{{PrevTxHash: executor.Transactions[0].Hash}},
+			Outputs: []*golosovaniepb.Output{newOutput(delegator.PkeyByte[:], nil, RewardCoins-1)},
+			Delegation: &golosovaniepb.Delegation{
+				DelegatorPkey: randKeys().PkeyByte[:],
+				DelegatePkey:  delegate[:],
+			},
+		}
+		data, err := proto.Marshal(signTx(body, delegator))
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeInvalidDelegation), executor.AppendTx(data, false))
+	})
+	t.Run("delegation_without_inputs", func(t *testing.T) {
+		body := &golosovaniepb.TxBody{Delegation: &golosovaniepb.Delegation{
+			DelegatorPkey: delegator.PkeyByte[:],
+			DelegatePkey:  delegate[:],
+		}}
+		data, err := proto.Marshal(signTx(body, delegator))
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeUnexpectedCoinbase), NewTxExecutor(nil).AppendTx(data, false))
+	})
+
+	// транзакции валидаторов не платят комиссию, поэтому подпись проверяется до обращений к бд
+	validator := &ValidatorNode{Pkey: ValidatorKeys.PkeyByte}
+	keyShareTx := func(pkey []byte, keys *CryptoKeysData) []byte {
+		body := &golosovaniepb.TxBody{
+			ValueType: randHash(),
+			KeyShare:  &golosovaniepb.KeyShare{ValidatorPkey: pkey},
+		}
+		data, err := proto.Marshal(signTx(body, keys))
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}
+	t.Run("validator_tx_of_non_validator", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.SetValidators([]*ValidatorNode{validator})
+		other := randKeys()
+		assert.Equal(t, uint32(CodeInvalidKeyShare), executor.AppendTx(keyShareTx(other.PkeyByte[:], other), false))
+	})
+	t.Run("validator_tx_with_forged_sig", func(t *testing.T) {
+		executor := NewTxExecutor(nil)
+		executor.SetValidators([]*ValidatorNode{validator})
+		data := keyShareTx(ValidatorKeys.PkeyByte[:], randKeys())
+		assert.Equal(t, uint32(CodeInvalidSignature), executor.AppendTx(data, false))
+	})
+}
+
+func TestCheckCanonicalBody(t *testing.T) {
+	keys := randKeys()
+	body := &golosovaniepb.TxBody{
+		Inputs:  []*golosovaniepb.Input{{PrevTxHash: randHash(), OutputIndex: 1}},
+		Outputs: []*golosovaniepb.Output{newOutput(randPkey(), nil, 5)},
+	}
+	canonical, err := proto.Marshal(body)
+	assert.Nil(t, err)
+	appendBody := func(bodyBytes []byte) uint32 {
+		data, err := proto.Marshal(&golosovaniepb.Transaction{
+			TxBody: bodyBytes,
+			Sig:    keys.Sign(bodyBytes),
+			Hash:   Hash(bodyBytes),
+		})
+		assert.Nil(t, err)
+		return NewTxExecutor(nil).AppendTx(data, false)
+	}
+
+	t.Run("unknown_field", func(t *testing.T) {
+		// поле 1000 с varint 1
+		withUnknown := append(append([]byte{}, canonical...), 0xc0, 0x3e, 0x01)
+		assert.Equal(t, uint32(CodeNonCanonicalTx), appendBody(withUnknown))
+	})
+	t.Run("fields_out_of_order", func(t *testing.T) {
+		var inputs, outputs []byte
+		inputs, err = proto.Marshal(&golosovaniepb.TxBody{Inputs: body.Inputs})
+		assert.Nil(t, err)
+		outputs, err = proto.Marshal(&golosovaniepb.TxBody{Outputs: body.Outputs})
+		assert.Nil(t, err)
+		// тот же TxBody при разборе, но другие байты и хэш
+		assert.Equal(t, canonical, append(append([]byte{}, inputs...), outputs...))
+		assert.Equal(t, uint32(CodeNonCanonicalTx), appendBody(append(outputs, inputs...)))
+	})
+	t.Run("repeated_scalar", func(t *testing.T) {
+		// повтор скалярного поля: при разборе берется последнее значение
+		duration, err := proto.Marshal(&golosovaniepb.TxBody{Duration: 7})
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(CodeNonCanonicalTx), appendBody(append(append([]byte{}, duration...), duration...)))
+	})
+}
@@ -1,5 +1,10 @@
 package evote
 
+import (
+	"GO_LOSOVANIE/evote/golosovaniepb"
+	"time"
+)
+
 func SliceToHash(v []byte) [HashSize]byte {
 	if v == nil {
 		return [HashSize]byte{}
@@ -19,3 +24,15 @@ func SliceToPkey(v []byte) [PkeySize]byte {
 		return pkey
 	}
 }
+
+// VotingEndTime returns time in nanoseconds, after which votes are not accepted.
+// startTimestamp is the timestamp of the block with create vote tx, duration is in seconds
+func VotingEndTime(startTimestamp uint64, duration uint32) uint64 {
+	return startTimestamp + uint64(time.Second)*uint64(duration)
+}
+
+// RevealEndTime returns time in nanoseconds, after which reveals of commit-reveal voting are not accepted.
+// For other votings it is the end time of the voting
+func RevealEndTime(endTime uint64, params *golosovaniepb.VotingParams) uint64 {
+	return endTime + uint64(time.Second)*uint64(params.GetRevealDuration())
+}
@@ -8,6 +8,7 @@ require (
 	github.com/manifoldco/promptui v0.8.0
 	github.com/stretchr/testify v1.7.0
 	github.com/tendermint/tendermint v0.34.10
+	github.com/tendermint/tm-db v0.6.4
 	google.golang.org/protobuf v1.26.0
 )
 
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"GO_LOSOVANIE/evote"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// creates app_state of tendermint genesis.json, accepted by GO_LOSOVANIE app (see evote/genesis.go)
+// and writes it into genesis.json of every node. Without -t app state is printed
+
+var tendermintDir = flag.String("t", "", "directory, generated by configure.sh")
+var inputFile = flag.String("i", "", "json file with app state: reward, params, balances and votings")
+var clientBalance = flag.Uint64("b", 0, "initial coins of every client from the directory -t")
+
+// not using tendermint types, cos it requires whole tendermint to build, and i just need to replace app_state
+
+func readGenesisJson(path string) (map[string]json.RawMessage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var genesisJson map[string]json.RawMessage
+	err = json.Unmarshal(data, &genesisJson)
+	return genesisJson, err
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func main() {
+	flag.Parse()
+	if *tendermintDir == "" && *inputFile == "" {
+		fmt.Println(
+			"Usage: go run main.go [-t=<directory generated by configure.sh> [-b=<coins of every client>]] [-i=<app state json>]",
+		)
+		os.Exit(1)
+	}
+	// параметры, не заданные во входном файле, записываются со значениями по умолчанию
+	genesis := evote.GenesisState{Reward: evote.DefaultRewardSchedule(), Params: evote.DefaultChainParams()}
+	if *inputFile != "" {
+		data, err := ioutil.ReadFile(*inputFile)
+		if err != nil {
+			panic(err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&genesis)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if *tendermintDir != "" {
+		if !strings.HasSuffix(*tendermintDir, "/") {
+			*tendermintDir += "/"
+		}
+		genesisJson, err := readGenesisJson(*tendermintDir + "node0/config/genesis.json")
+		if err != nil {
+			panic(err)
+		}
+		if genesis.GenesisTime == nil {
+			// голосования из genesis начинаются одновременно с цепочкой
+			var genesisTime time.Time
+			err = json.Unmarshal(genesisJson["genesis_time"], &genesisTime)
+			if err != nil {
+				panic(err)
+			}
+			genesis.GenesisTime = &genesisTime
+		}
+		for i := 0; *clientBalance != 0 && exists(*tendermintDir+fmt.Sprintf("client%d", i)); i++ {
+			prv, err := evote.LoadPrivateKey(*tendermintDir + fmt.Sprintf("client%d/golosovanie_private_key.json", i))
+			if err != nil {
+				panic(err)
+			}
+			var keys evote.CryptoKeysData
+			keys.SetupKeys(prv)
+			genesis.Balances = append(genesis.Balances, &evote.GenesisOutput{
+				Pkey:  hex.EncodeToString(keys.PkeyByte[:]),
+				Value: *clientBalance,
+			})
+		}
+	}
+	appState, err := json.Marshal(genesis)
+	if err != nil {
+		panic(err)
+	}
+	// validators panic in InitChain on invalid app state, so it is checked here
+	_, err = evote.ParseGenesisState(appState)
+	if err != nil {
+		panic(err)
+	}
+	if *tendermintDir == "" {
+		fmt.Println(string(appState))
+		return
+	}
+	// genesis.json must be the same on all nodes
+	for i := 0; exists(*tendermintDir + fmt.Sprintf("node%d", i)); i++ {
+		path := *tendermintDir + fmt.Sprintf("node%d/config/genesis.json", i)
+		genesisJson, err := readGenesisJson(path)
+		if err != nil {
+			panic(err)
+		}
+		genesisJson["app_state"] = appState
+		data, err := json.MarshalIndent(genesisJson, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+}